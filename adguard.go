@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// AdGuard configures an AdGuard Home kept in sync through its API. tsddns
+// writes its entries as marked blocks of the custom filtering rules and
+// the upstream DNS servers, and never touches lines outside of them.
+type AdGuard struct {
+	// URL is the AdGuard Home web address, like "http://adguard:3000".
+	URL string `json:"url"`
+
+	// Username is the AdGuard Home user to log in as.
+	Username string `json:"username,omitempty"`
+
+	// PasswordEnv names the environment variable holding the user's
+	// password. Defaults to ADGUARD_PASSWORD.
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+
+	// Records are answered as DNS rewrites.
+	Records RecordSet `json:"records,omitempty"`
+
+	// Forward, if set, adds an upstream for every applied split DNS domain
+	// pointing at its nameservers.
+	Forward bool `json:"forward,omitempty"`
+}
+
+func (a *AdGuard) validate() error {
+	if a.URL == "" {
+		return fmt.Errorf("needs a url")
+	}
+	if len(a.Records) == 0 && !a.Forward {
+		return fmt.Errorf("needs records or forward")
+	}
+	return nil
+}
+
+// adGuardRules returns tsddns's block of filtering rules: an /etc/hosts
+// style rule per address, which AdGuard answers as a rewrite.
+func adGuardRules(records []record) []string {
+	var rules []string
+	for _, rec := range records {
+		rules = append(rules, rec.Value+" "+rec.Name)
+	}
+	return managedBlock(rules)
+}
+
+// adGuardUpstreams returns tsddns's block of upstreams: a domain-specific
+// upstream per split DNS nameserver.
+func adGuardUpstreams(splitDNS map[string][]string) []string {
+	var upstreams []string
+	domains, addrs := exportDomains(splitDNS)
+	for _, domain := range domains {
+		for _, addr := range addrs[domain] {
+			upstreams = append(upstreams, fmt.Sprintf("[/%s/]%s", domain, addr))
+		}
+	}
+	return managedBlock(upstreams)
+}
+
+func (a *AdGuard) forwards() bool { return a.Forward }
+
+// sync resolves the configured records and writes them, with the
+// split DNS domains if forwarding, to AdGuard Home. splitDNS is nil when
+// split DNS isn't managed.
+func (a *AdGuard) sync(ctx context.Context, r *resolver, splitDNS map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, a.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	records, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+
+	c := &adGuardClient{
+		url:      strings.TrimSuffix(a.URL, "/"),
+		username: a.Username,
+		password: os.Getenv(cmp.Or(a.PasswordEnv, "ADGUARD_PASSWORD")),
+	}
+
+	var filtering struct {
+		UserRules []string `json:"user_rules"`
+	}
+	if err := c.do(ctx, "GET", "/control/filtering/status", nil, &filtering); err != nil {
+		return fmt.Errorf("reading filtering rules: %w", err)
+	}
+	rules := replaceManagedBlock(filtering.UserRules, adGuardRules(records))
+	if !slices.Equal(rules, filtering.UserRules) {
+		slog.InfoContext(ctx, "Updating AdGuard Home rewrites", "records", len(records))
+		if err := c.do(ctx, "POST", "/control/filtering/set_rules", map[string]any{"rules": rules}, nil); err != nil {
+			return fmt.Errorf("writing filtering rules: %w", err)
+		}
+	}
+
+	var dnsInfo struct {
+		UpstreamDNS []string `json:"upstream_dns"`
+	}
+	if err := c.do(ctx, "GET", "/control/dns_info", nil, &dnsInfo); err != nil {
+		return fmt.Errorf("reading upstreams: %w", err)
+	}
+	if !a.Forward {
+		splitDNS = nil
+	}
+	upstreams := replaceManagedBlock(dnsInfo.UpstreamDNS, adGuardUpstreams(splitDNS))
+	if !slices.Equal(upstreams, dnsInfo.UpstreamDNS) {
+		slog.InfoContext(ctx, "Updating AdGuard Home upstreams", "domains", len(splitDNS))
+		if err := c.do(ctx, "POST", "/control/dns_config", map[string]any{"upstream_dns": upstreams}, nil); err != nil {
+			return fmt.Errorf("writing upstreams: %w", err)
+		}
+	}
+	return nil
+}
+
+// adGuardClient talks to the AdGuard Home API with basic auth.
+type adGuardClient struct {
+	url      string
+	username string
+	password string
+}
+
+func (c *adGuardClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, bytes.TrimSpace(msg.Bytes()))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestSyncAdGuard(t *testing.T) {
+	rules := []string{"||ads.example.com^"}
+	upstreams := []string{"https://dns.quad9.net/dns-query", managedBlockStart, "[/old.example.com/]100.64.0.9", managedBlockEnd}
+	writes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "hunter2" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /control/filtering/status":
+			json.NewEncoder(w).Encode(map[string]any{"user_rules": rules})
+		case "POST /control/filtering/set_rules":
+			var req struct{ Rules []string }
+			json.NewDecoder(r.Body).Decode(&req)
+			rules = req.Rules
+			writes++
+		case "GET /control/dns_info":
+			json.NewEncoder(w).Encode(map[string]any{"upstream_dns": upstreams})
+		case "POST /control/dns_config":
+			var req struct {
+				UpstreamDNS []string `json:"upstream_dns"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			upstreams = req.UpstreamDNS
+			writes++
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("ADGUARD_PASSWORD", "hunter2")
+
+	a := &AdGuard{
+		URL:      srv.URL,
+		Username: "admin",
+		Records:  Config{"ns.internal.example.com": {"100.64.0.1"}},
+		Forward:  true,
+	}
+	splitDNS := map[string][]string{"corp.example.com": {"100.64.0.53"}}
+	if err := a.sync(context.Background(), newResolver(nil, Defaults{}), splitDNS); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+
+	wantRules := []string{"||ads.example.com^", managedBlockStart, "100.64.0.1 ns.internal.example.com", managedBlockEnd}
+	if !slices.Equal(rules, wantRules) {
+		t.Errorf("rules = %q, want %q", rules, wantRules)
+	}
+	wantUpstreams := []string{"https://dns.quad9.net/dns-query", managedBlockStart, "[/corp.example.com/]100.64.0.53", managedBlockEnd}
+	if !slices.Equal(upstreams, wantUpstreams) {
+		t.Errorf("upstreams = %q, want %q", upstreams, wantUpstreams)
+	}
+
+	// nothing is written when both blocks are up to date
+	writes = 0
+	if err := a.sync(context.Background(), newResolver(nil, Defaults{}), splitDNS); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+	if writes != 0 {
+		t.Errorf("got %d writes for an unchanged config, want 0", writes)
+	}
+
+	t.Setenv("ADGUARD_PASSWORD", "wrong")
+	if err := a.sync(context.Background(), newResolver(nil, Defaults{}), splitDNS); err == nil {
+		t.Error("sync() succeeded with a wrong password")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lastUpdate is what the last successful split DNS update applied and the
+// last changes it made, for the admin API.
+var lastUpdate = &updateRecord{}
+
+type updateRecord struct {
+	mu        sync.Mutex
+	splitDNS  map[string][]string
+	appliedAt time.Time
+	changes   []domainChange
+	changedAt time.Time
+}
+
+// record notes a successful update. An update that changed nothing keeps
+// the changes of the last one that did.
+func (u *updateRecord) record(splitDNS map[string][]string, changes []domainChange, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.splitDNS = maps.Clone(splitDNS)
+	u.appliedAt = now
+	if len(changes) > 0 {
+		u.changes = changes
+		u.changedAt = now
+	}
+}
+
+// adminHandler serves the admin API, which lets operators steer a running
+// daemon. Every request needs the admin token as a bearer token.
+type adminHandler struct {
+	token   string
+	trigger chan<- struct{}
+	status  *health
+	config  func() *ConfigFile
+
+	// pprof serves the runtime profiles of net/http/pprof under
+	// /debug/pprof/, behind the same token.
+	pprof bool
+}
+
+// startAdminServer serves the admin API on addr. Reconcile requests send
+// on trigger, which should be buffered so repeated requests collapse into
+// one update.
+func startAdminServer(addr string, h *adminHandler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := http.Serve(ln, h); err != nil {
+			slog.Error("Admin server stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		slog.Warn("Rejected admin request", "remote", r.RemoteAddr, "path", r.URL.Path)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if h.pprof && strings.HasPrefix(r.URL.Path, "/debug/pprof/") {
+		servePprof(w, r)
+		return
+	}
+
+	method := "GET"
+	switch r.URL.Path {
+	case "/reconcile", "/pause", "/resume":
+		method = "POST"
+	case "/mapping", "/diff", "/config":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/reconcile":
+		select {
+		case h.trigger <- struct{}{}:
+		default:
+			// an update is queued already
+		}
+		slog.Info("Update requested through the admin API", "remote", r.RemoteAddr)
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "update queued")
+	case "/pause":
+		h.status.setPaused(true)
+		slog.Info("Updates paused through the admin API", "remote", r.RemoteAddr)
+		fmt.Fprintln(w, "paused")
+	case "/resume":
+		h.status.setPaused(false)
+		slog.Info("Updates resumed through the admin API", "remote", r.RemoteAddr)
+		fmt.Fprintln(w, "resumed")
+	case "/mapping":
+		lastUpdate.mu.Lock()
+		resp := struct {
+			AppliedAt *time.Time          `json:"appliedAt,omitempty"`
+			Domains   map[string][]string `json:"domains"`
+		}{optionalTime(lastUpdate.appliedAt), lastUpdate.splitDNS}
+		lastUpdate.mu.Unlock()
+		writeJSON(w, resp)
+	case "/diff":
+		lastUpdate.mu.Lock()
+		resp := struct {
+			ChangedAt *time.Time     `json:"changedAt,omitempty"`
+			Changes   []domainChange `json:"changes"`
+		}{optionalTime(lastUpdate.changedAt), lastUpdate.changes}
+		lastUpdate.mu.Unlock()
+		writeJSON(w, resp)
+	case "/config":
+		writeJSON(w, redactConfig(h.config()))
+	}
+}
+
+// servePprof serves a profile from net/http/pprof. Index serves the named
+// profiles, such as heap and goroutine, and the list of them.
+func servePprof(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, "/debug/pprof/") {
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Index(w, r)
+	}
+}
+
+// redacted replaces config values that are or may embed credentials.
+const redacted = "REDACTED"
+
+// redactConfig returns a copy of cfg with everything that usually carries
+// credentials blanked out: notification and flush hook webhook URLs, whose
+// path is often the secret as with Slack and Discord, notification headers,
+// export reload URLs, and the MQTT broker's user info.
+func redactConfig(cfg *ConfigFile) *ConfigFile {
+	out := *cfg
+	out.Notify = nil
+	for _, n := range cfg.Notify {
+		n.URL = redacted
+		if len(n.Headers) > 0 {
+			headers := make(map[string]string, len(n.Headers))
+			for k := range n.Headers {
+				headers[k] = redacted
+			}
+			n.Headers = headers
+		}
+		out.Notify = append(out.Notify, n)
+	}
+	out.FlushHooks = nil
+	for _, h := range cfg.FlushHooks {
+		if h.URL != "" {
+			h.URL = redacted
+		}
+		out.FlushHooks = append(out.FlushHooks, h)
+	}
+	out.Exports = nil
+	for _, e := range cfg.Exports {
+		if e.ReloadURL != "" {
+			e.ReloadURL = redacted
+		}
+		out.Exports = append(out.Exports, e)
+	}
+	if cfg.MQTT != nil {
+		m := *cfg.MQTT
+		if u, err := url.Parse(m.Broker); err == nil && u.User != nil {
+			u.User = nil
+			m.Broker = u.String()
+		}
+		out.MQTT = &m
+	}
+	return &out
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// appConnector is an app connector configured in the policy file's
+// nodeAttrs.
+type appConnector struct {
+	Name       string   `json:"name"`
+	Connectors []string `json:"connectors"`
+	Domains    []string `json:"domains"`
+}
+
+// appConnectors returns every app connector the policy file configures.
+func (p *policyFile) appConnectors() []appConnector {
+	var apps []appConnector
+	for _, attr := range p.NodeAttrs {
+		apps = append(apps, attr.App.AppConnectors...)
+	}
+	return apps
+}
+
+// routesDomain reports whether the app routes domain. A "*.example.com"
+// pattern covers the subdomains of example.com.
+func (a appConnector) routesDomain(domain string) bool {
+	for _, d := range a.Domains {
+		d = normalizeDomain(d)
+		if d == domain {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(d, "*"); ok && strings.HasSuffix(domain, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// findAppConnectors returns the devices acting as app connectors for
+// domain: those carrying a connector tag of an app that routes it. They
+// must have an address and are sorted by name.
+func findAppConnectors(domain string, apps []appConnector, devices []tailscale.Device) ([]tailscale.Device, error) {
+	domain = normalizeDomain(domain)
+
+	var tags []string
+	for _, app := range apps {
+		if app.routesDomain(domain) {
+			tags = append(tags, app.Connectors...)
+		}
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no app connector routes %s", domain)
+	}
+
+	var connectors []tailscale.Device
+	for _, device := range devices {
+		if slices.Contains(tags, "*") || slices.ContainsFunc(device.Tags, func(t string) bool { return slices.Contains(tags, t) }) {
+			connectors = append(connectors, device)
+		}
+	}
+	connectors = withAddresses(connectors)
+	if len(connectors) == 0 {
+		return nil, fmt.Errorf("no devices with an address are app connectors for %s", domain)
+	}
+	return connectors, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestFindAppConnectors(t *testing.T) {
+	var policy policyFile
+	err := json.Unmarshal([]byte(`{
+		"nodeAttrs": [
+			{"target": ["*"], "attr": ["funnel"]},
+			{
+				"target": ["tag:connector"],
+				"app": {"tailscale.com/app-connectors": [
+					{"name": "github", "connectors": ["tag:connector"], "domains": ["github.com", "*.github.com"]},
+					{"name": "corp", "connectors": ["tag:corp-connector"], "domains": ["corp.example.com"]}
+				]}
+			}
+		]
+	}`), &policy)
+	if err != nil {
+		t.Fatalf("parsing policy: %v", err)
+	}
+
+	devices := []tailscale.Device{
+		{Name: "connector-b.example.ts.net", Tags: []string{"tag:connector"}, Addresses: []string{"100.64.0.2"}},
+		{Name: "connector-a.example.ts.net", Tags: []string{"tag:server", "tag:connector"}, Addresses: []string{"100.64.0.1"}},
+		{Name: "corp.example.ts.net", Tags: []string{"tag:corp-connector"}, Addresses: []string{"100.64.0.3"}},
+		{Name: "laptop.example.ts.net", Addresses: []string{"100.64.0.4"}},
+	}
+
+	tests := []struct {
+		name      string
+		domain    string
+		wantNames []string
+		wantErr   bool
+	}{
+		{name: "exact domain", domain: "github.com", wantNames: []string{"connector-a.example.ts.net", "connector-b.example.ts.net"}},
+		{name: "wildcard domain", domain: "api.github.com", wantNames: []string{"connector-a.example.ts.net", "connector-b.example.ts.net"}},
+		{name: "case and trailing dot", domain: "Corp.Example.com.", wantNames: []string{"corp.example.ts.net"}},
+		{name: "not routed", domain: "example.com", wantErr: true},
+		{name: "wildcard needs a subdomain", domain: "notgithub.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findAppConnectors(tt.domain, policy.appConnectors(), devices)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("findAppConnectors() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if names := deviceNames(got); !slices.Equal(names, tt.wantNames) {
+				t.Errorf("findAppConnectors() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
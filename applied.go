@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
+)
+
+// appliedState is what the last successful update applied, kept in the
+// state file so a restarted daemon still knows.
+type appliedState struct {
+	// At is when the update was applied.
+	At time.Time `json:"at"`
+
+	// Domains are the split DNS domains tsddns owns, by domain.
+	Domains map[string]appliedDomain `json:"domains"`
+}
+
+// appliedDomain is one domain of the last applied split DNS config.
+type appliedDomain struct {
+	Nameservers []string `json:"nameservers"`
+
+	// Sources are the config entries the nameservers were resolved from.
+	Sources []string `json:"sources,omitempty"`
+
+	// ChangedAt is when the domain was last applied with different
+	// nameservers.
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// newAppliedState returns the applied state after the owned domains of
+// splitDNS were applied at now. Domains whose nameservers didn't change
+// keep their ChangedAt from prev.
+func newAppliedState(prev *appliedState, splitDNS map[string][]string, owned []string, sources Config, now time.Time) *appliedState {
+	applied := &appliedState{At: now, Domains: make(map[string]appliedDomain)}
+	for _, domain := range owned {
+		d := appliedDomain{Nameservers: splitDNS[domain], Sources: sources[domain], ChangedAt: now}
+		if prev != nil {
+			if old, ok := prev.Domains[domain]; ok && slices.Equal(old.Nameservers, d.Nameservers) {
+				d.ChangedAt = old.ChangedAt
+			}
+		}
+		applied.Domains[domain] = d
+	}
+	return applied
+}
+
+// driftedDomains returns the domains whose current nameservers differ from
+// what tsddns last applied, meaning something else changed them since,
+// sorted by domain.
+func driftedDomains(prev *appliedState, current map[string][]string) []domainChange {
+	if prev == nil {
+		return nil
+	}
+	var drifted []domainChange
+	for domain, d := range prev.Domains {
+		if now := current[domain]; !slices.Equal(now, d.Nameservers) {
+			drifted = append(drifted, domainChange{Domain: domain, Old: d.Nameservers, New: now})
+		}
+	}
+	sort.Slice(drifted, func(i, j int) bool { return drifted[i].Domain < drifted[j].Domain })
+	return drifted
+}
+
+// logDrift warns about domains changed outside tsddns since it last
+// applied them.
+func logDrift(ctx context.Context, drifted []domainChange) {
+	for _, c := range drifted {
+		if c.New == nil {
+			slog.WarnContext(ctx, "Domain was deleted outside tsddns since it was last applied", "domain", c.Domain, "applied", c.Old)
+		} else {
+			slog.WarnContext(ctx, "Domain was changed outside tsddns since it was last applied", "domain", c.Domain, "applied", c.Old, "current", c.New)
+		}
+	}
+}
+
+// loadApplied reads what the last successful update applied from the state
+// file. It's nil if nothing was recorded yet.
+func loadApplied(path string) (*appliedState, error) {
+	state, err := loadState(path)
+	if err != nil {
+		return nil, err
+	}
+	return state.Applied, nil
+}
+
+// saveApplied records what an update applied in the state file.
+func saveApplied(path string, applied *appliedState) error {
+	state, err := loadState(path)
+	if err != nil {
+		return err
+	}
+	state.Applied = applied
+	return saveState(path, state)
+}
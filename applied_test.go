@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewAppliedState(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+	prev := &appliedState{At: t0, Domains: map[string]appliedDomain{
+		"same.example.com":    {Nameservers: []string{"100.64.0.1"}, ChangedAt: t0},
+		"changed.example.com": {Nameservers: []string{"100.64.0.2"}, ChangedAt: t0},
+		"dropped.example.com": {Nameservers: []string{"100.64.0.3"}, ChangedAt: t0},
+	}}
+	splitDNS := map[string][]string{
+		"same.example.com":    {"100.64.0.1"},
+		"changed.example.com": {"100.64.0.4"},
+		"unowned.example.com": {"10.0.0.53"},
+	}
+	sources := Config{
+		"same.example.com":    {"device:ns1"},
+		"changed.example.com": {"svc:dns || 100.64.0.9"},
+	}
+
+	got := newAppliedState(prev, splitDNS, []string{"same.example.com", "changed.example.com"}, sources, t1)
+	want := &appliedState{At: t1, Domains: map[string]appliedDomain{
+		"same.example.com":    {Nameservers: []string{"100.64.0.1"}, Sources: []string{"device:ns1"}, ChangedAt: t0},
+		"changed.example.com": {Nameservers: []string{"100.64.0.4"}, Sources: []string{"svc:dns || 100.64.0.9"}, ChangedAt: t1},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newAppliedState() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDriftedDomains(t *testing.T) {
+	prev := &appliedState{Domains: map[string]appliedDomain{
+		"same.example.com":    {Nameservers: []string{"100.64.0.1"}},
+		"edited.example.com":  {Nameservers: []string{"100.64.0.2"}},
+		"deleted.example.com": {Nameservers: []string{"100.64.0.3"}},
+	}}
+	current := map[string][]string{
+		"same.example.com":   {"100.64.0.1"},
+		"edited.example.com": {"10.0.0.53"},
+		"other.example.com":  {"10.0.0.54"},
+	}
+	want := []domainChange{
+		{Domain: "deleted.example.com", Old: []string{"100.64.0.3"}},
+		{Domain: "edited.example.com", Old: []string{"100.64.0.2"}, New: []string{"10.0.0.53"}},
+	}
+	if got := driftedDomains(prev, current); !reflect.DeepEqual(got, want) {
+		t.Errorf("driftedDomains() = %+v, want %+v", got, want)
+	}
+	if got := driftedDomains(nil, current); got != nil {
+		t.Errorf("driftedDomains() without a previous state = %+v, want nothing", got)
+	}
+}
+
+func TestAppliedStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	applied, err := loadApplied(path)
+	if err != nil || applied != nil {
+		t.Fatalf("loadApplied() on a missing file = %v, %v, want nothing", applied, err)
+	}
+
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := &appliedState{At: at, Domains: map[string]appliedDomain{
+		"corp.example.com": {Nameservers: []string{"100.64.0.1"}, Sources: []string{"tag:dns"}, ChangedAt: at},
+	}}
+	if err := saveOwnedDomains(path, []string{"corp.example.com"}); err != nil {
+		t.Fatalf("saveOwnedDomains() unexpected error: %v", err)
+	}
+	if err := saveApplied(path, want); err != nil {
+		t.Fatalf("saveApplied() unexpected error: %v", err)
+	}
+	applied, err = loadApplied(path)
+	if err != nil || !reflect.DeepEqual(applied, want) {
+		t.Errorf("loadApplied() = %+v, %v, want %+v", applied, err, want)
+	}
+	// the applied state is kept next to the owned domains
+	if owned, err := loadOwnedDomains(path); err != nil || !owned["corp.example.com"] {
+		t.Errorf("loadOwnedDomains() = %v, %v, want corp.example.com", owned, err)
+	}
+}
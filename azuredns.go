@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+var (
+	// azureManagementAPI, azureLoginURL and azureIMDSToken are Azure
+	// endpoints. They're variables for tests.
+	azureManagementAPI = "https://management.azure.com"
+	azureLoginURL      = "https://login.microsoftonline.com"
+	azureIMDSToken     = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+const azurePrivateDNSVersion = "2024-06-01"
+
+// AzureDNS configures A and AAAA records kept in an Azure Private DNS zone.
+type AzureDNS struct {
+	// SubscriptionID and ResourceGroup locate the zone.
+	SubscriptionID string `json:"subscriptionId"`
+	ResourceGroup  string `json:"resourceGroup"`
+
+	// Zone is the private zone's name, like "internal.example.com".
+	Zone string `json:"zone"`
+
+	// TTL is the records' TTL. Defaults to 5m.
+	TTL Duration `json:"ttl,omitempty"`
+
+	// Records must be in Zone.
+	Records RecordSet `json:"records"`
+}
+
+func (a *AzureDNS) validate() error {
+	if a.SubscriptionID == "" || a.ResourceGroup == "" || a.Zone == "" {
+		return fmt.Errorf("needs a subscriptionId, resourceGroup and zone")
+	}
+	zone := normalizeDomain(a.Zone)
+	for name := range a.Records {
+		if n := normalizeDomain(name); n != zone && !strings.HasSuffix(n, "."+zone) {
+			return fmt.Errorf("record %s is not in zone %s", name, a.Zone)
+		}
+	}
+	return nil
+}
+
+// azureRecordSet is a Private DNS record set in the Azure API.
+type azureRecordSet struct {
+	Name       string `json:"name,omitempty"`
+	Type       string `json:"type,omitempty"`
+	Properties struct {
+		FQDN        string            `json:"fqdn,omitempty"`
+		TTL         int               `json:"ttl"`
+		Metadata    map[string]string `json:"metadata,omitempty"`
+		ARecords    []azureAddress    `json:"aRecords,omitempty"`
+		AAAARecords []azureAddress    `json:"aaaaRecords,omitempty"`
+	} `json:"properties"`
+}
+
+type azureAddress struct {
+	IPv4 string `json:"ipv4Address,omitempty"`
+	IPv6 string `json:"ipv6Address,omitempty"`
+}
+
+// sync resolves the configured records and reconciles the private
+// zone with them. tsddns tags the record sets it manages with managedBy
+// metadata and only changes or deletes those.
+func (a *AzureDNS) sync(ctx context.Context, r *resolver, _ map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, a.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	desired, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+	c, err := newAzureClient(ctx)
+	if err != nil {
+		return err
+	}
+	c.zone = fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/privateDnsZones/%s",
+		url.PathEscape(a.SubscriptionID), url.PathEscape(a.ResourceGroup), url.PathEscape(normalizeDomain(a.Zone)))
+
+	existing, err := c.listRecordSets(ctx)
+	if err != nil {
+		return fmt.Errorf("listing records: %w", err)
+	}
+	var owned, foreign []record
+	for _, set := range existing {
+		typ := strings.TrimPrefix(set.Type, "Microsoft.Network/privateDnsZones/")
+		name := normalizeDomain(set.Properties.FQDN)
+		var values []string
+		switch typ {
+		case "A":
+			for _, a := range set.Properties.ARecords {
+				values = append(values, a.IPv4)
+			}
+		case "AAAA":
+			for _, a := range set.Properties.AAAARecords {
+				values = append(values, a.IPv6)
+			}
+		}
+		for _, v := range values {
+			rec := record{Name: name, Type: typ, Value: v}
+			if set.Properties.Metadata["managedBy"] == "tsddns" {
+				owned = append(owned, rec)
+			} else {
+				foreign = append(foreign, rec)
+			}
+		}
+	}
+
+	create, remove := planRecords(desired, owned, foreign)
+	ttl := int(time.Duration(cmp.Or(a.TTL, Duration(defaultRecordTTL))).Seconds())
+	changes := changedRRsets(desired, create, remove)
+	for _, set := range changes {
+		path := "/" + set.Type + "/" + url.PathEscape(relativeName(set.Name, a.Zone))
+		if len(set.Values) == 0 {
+			slog.InfoContext(ctx, "Deleting Azure DNS records", "name", set.Name, "type", set.Type)
+			if err := c.do(ctx, "DELETE", path, nil, nil); err != nil {
+				return fmt.Errorf("deleting %s: %w", set.Name, err)
+			}
+			continue
+		}
+		slog.InfoContext(ctx, "Setting Azure DNS records", "name", set.Name, "type", set.Type, "values", set.Values)
+		var put azureRecordSet
+		put.Properties.TTL = ttl
+		put.Properties.Metadata = map[string]string{"managedBy": "tsddns"}
+		for _, v := range set.Values {
+			if set.Type == "A" {
+				put.Properties.ARecords = append(put.Properties.ARecords, azureAddress{IPv4: v})
+			} else {
+				put.Properties.AAAARecords = append(put.Properties.AAAARecords, azureAddress{IPv6: v})
+			}
+		}
+		if err := c.do(ctx, "PUT", path, put, nil); err != nil {
+			return fmt.Errorf("setting %s: %w", set.Name, err)
+		}
+	}
+	if len(changes) == 0 {
+		slog.InfoContext(ctx, "Azure DNS records are up to date")
+	}
+	return nil
+}
+
+// azureClient talks to one Private DNS zone in the Azure management API.
+type azureClient struct {
+	http  *http.Client
+	token string // set when not using client credentials
+	zone  string // the zone's resource ID
+}
+
+// newAzureClient authenticates with the service principal in
+// AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET, or otherwise
+// with the machine's managed identity.
+func newAzureClient(ctx context.Context) (*azureClient, error) {
+	if secret := os.Getenv("AZURE_CLIENT_SECRET"); secret != "" {
+		oauthConfig := clientcredentials.Config{
+			ClientID:     os.Getenv("AZURE_CLIENT_ID"),
+			ClientSecret: secret,
+			TokenURL:     azureLoginURL + "/" + url.PathEscape(os.Getenv("AZURE_TENANT_ID")) + "/oauth2/v2.0/token",
+			Scopes:       []string{azureManagementAPI + "/.default"},
+		}
+		return &azureClient{http: oauthConfig.Client(ctx)}, nil
+	}
+
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {azureManagementAPI + "/"}}
+	if id := os.Getenv("AZURE_CLIENT_ID"); id != "" {
+		query.Set("client_id", id) // a user-assigned identity
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", azureIMDSToken+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("getting managed identity token: %w", err)
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting managed identity token: status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("getting managed identity token: %w", err)
+	}
+	return &azureClient{http: http.DefaultClient, token: token.AccessToken}, nil
+}
+
+// listRecordSets returns every record set in the zone.
+func (c *azureClient) listRecordSets(ctx context.Context) ([]azureRecordSet, error) {
+	var all []azureRecordSet
+	next := azureManagementAPI + c.zone + "/ALL?api-version=" + azurePrivateDNSVersion
+	for next != "" {
+		var page struct {
+			Value    []azureRecordSet `json:"value"`
+			NextLink string           `json:"nextLink"`
+		}
+		if err := c.doURL(ctx, "GET", next, nil, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Value...)
+		next = page.NextLink
+	}
+	return all, nil
+}
+
+// do makes a request for path under the zone.
+func (c *azureClient) do(ctx context.Context, method, path string, in, out any) error {
+	return c.doURL(ctx, method, azureManagementAPI+c.zone+path+"?api-version="+azurePrivateDNSVersion, in, out)
+}
+
+func (c *azureClient) doURL(ctx context.Context, method, u string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(data, &apiErr)
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, apiErr.Error.Message)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
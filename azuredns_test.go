@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSyncAzureDNS(t *testing.T) {
+	const zonePath = "/subscriptions/sub1/resourceGroups/rg1/providers/Microsoft.Network/privateDnsZones/internal.example.com"
+	list := `{"value": [
+		{"name": "ns", "type": "Microsoft.Network/privateDnsZones/A", "properties": {"fqdn": "ns.internal.example.com.", "ttl": 300, "metadata": {"managedBy": "tsddns"}, "aRecords": [{"ipv4Address": "100.64.0.1"}]}},
+		{"name": "old", "type": "Microsoft.Network/privateDnsZones/AAAA", "properties": {"fqdn": "old.internal.example.com.", "ttl": 300, "metadata": {"managedBy": "tsddns"}, "aaaaRecords": [{"ipv6Address": "fd7a:115c:a1e0::2"}]}},
+		{"name": "manual", "type": "Microsoft.Network/privateDnsZones/A", "properties": {"fqdn": "manual.internal.example.com.", "ttl": 300, "aRecords": [{"ipv4Address": "100.64.0.3"}]}},
+		{"name": "@", "type": "Microsoft.Network/privateDnsZones/SOA", "properties": {"fqdn": "internal.example.com.", "ttl": 3600}}
+	]}`
+
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/tenant1/oauth2/v2.0/token":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"access_token": "token", "token_type": "Bearer", "expires_in": 3600})
+		case r.URL.Query().Get("api-version") != azurePrivateDNSVersion:
+			w.WriteHeader(http.StatusBadRequest)
+		case r.Method == "GET" && r.URL.Path == zonePath+"/ALL":
+			w.Write([]byte(list))
+		case r.Method == "PUT":
+			var set azureRecordSet
+			json.NewDecoder(r.Body).Decode(&set)
+			if set.Properties.Metadata["managedBy"] != "tsddns" {
+				t.Errorf("%s written without managedBy metadata", r.URL.Path)
+			}
+			var values []string
+			for _, a := range set.Properties.ARecords {
+				values = append(values, a.IPv4)
+			}
+			calls = append(calls, "PUT "+strings.TrimPrefix(r.URL.Path, zonePath)+" "+strings.Join(values, ","))
+			w.Write([]byte(`{}`))
+		case r.Method == "DELETE":
+			calls = append(calls, "DELETE "+strings.TrimPrefix(r.URL.Path, zonePath))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "not found"}}`))
+		}
+	}))
+	defer srv.Close()
+	azureManagementAPI, azureLoginURL = srv.URL, srv.URL
+	t.Setenv("AZURE_TENANT_ID", "tenant1")
+	t.Setenv("AZURE_CLIENT_ID", "client1")
+	t.Setenv("AZURE_CLIENT_SECRET", "secret")
+
+	a := &AzureDNS{
+		SubscriptionID: "sub1",
+		ResourceGroup:  "rg1",
+		Zone:           "internal.example.com",
+		Records: Config{
+			"ns.internal.example.com":     {"100.64.0.1", "100.64.0.4"},
+			"internal.example.com":        {"100.64.0.6"},
+			"manual.internal.example.com": {"100.64.0.5"},
+		},
+	}
+	if err := a.validate(); err != nil {
+		t.Fatalf("validate() unexpected error: %v", err)
+	}
+	if err := a.sync(context.Background(), newResolver(nil, Defaults{}), nil); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+	want := []string{
+		"PUT /A/@ 100.64.0.6",
+		"PUT /A/ns 100.64.0.1,100.64.0.4",
+		"DELETE /AAAA/old",
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	a.Records = Config{"ns.example.org": {"100.64.0.1"}}
+	if err := a.validate(); err == nil {
+		t.Error("validate() accepted a record outside the zone")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"sort"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// Backend is a destination the resolved split DNS config is applied to.
+// Only split DNS goes through it: the CoreDNS ConfigMap, exports and DNS
+// record backends (a recordBackend) are written by updateDNS itself,
+// without Plan and Apply.
+type Backend interface {
+	// Current returns the split DNS config the destination has now.
+	Current(ctx context.Context) (map[string][]string, error)
+
+	// Plan returns the changes that take current to desired.
+	Plan(current, desired map[string][]string) []domainChange
+
+	// Apply queues the writes for changes on tx, so a failed cycle rolls
+	// them back along with everything else.
+	Apply(ctx context.Context, tx *transaction, changes []domainChange) error
+}
+
+// backends maps the names the backend option takes to their constructors.
+var backends = map[string]func(client *tailscale.Client, cfg *ConfigFile) (Backend, error){
+	"tailscale": newTailscaleBackend,
+	"file":      newFileBackend,
+}
+
+// defaultBackend is the backend used when the config doesn't pick one.
+const defaultBackend = "tailscale"
+
+// backendNames returns the registered backend names, sorted.
+func backendNames() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newBackend returns the backend cfg selects.
+func newBackend(client *tailscale.Client, cfg *ConfigFile) (Backend, error) {
+	name := cmp.Or(cfg.Backend, defaultBackend)
+	newFunc, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+	return newFunc(client, cfg)
+}
+
+// tailscaleBackend applies split DNS to the tailnet through the Tailscale
+// API.
+type tailscaleBackend struct {
+	client *tailscale.Client
+}
+
+func newTailscaleBackend(client *tailscale.Client, _ *ConfigFile) (Backend, error) {
+	return &tailscaleBackend{client: client}, nil
+}
+
+func (b *tailscaleBackend) Current(ctx context.Context) (map[string][]string, error) {
+	current, err := b.client.DNS().SplitDNS(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching current split DNS: %w", err)
+	}
+	return current, nil
+}
+
+func (b *tailscaleBackend) Plan(current, desired map[string][]string) []domainChange {
+	return diffSplitDNS(current, desired)
+}
+
+// Apply queues a partial update of only the changed domains, with one
+// putting their old nameservers back as its rollback.
+func (b *tailscaleBackend) Apply(_ context.Context, tx *transaction, changes []domainChange) error {
+	patch, revert := splitDNSPatches(changes)
+	tx.add("split DNS",
+		func(ctx context.Context) error {
+			_, err := b.client.DNS().UpdateSplitDNS(ctx, patch)
+			return err
+		},
+		func(ctx context.Context) error {
+			_, err := b.client.DNS().UpdateSplitDNS(ctx, revert)
+			return err
+		},
+	)
+	return nil
+}
+
+// fileBackend keeps split DNS in a JSON file mapping domains to their
+// nameservers, for other tools to pick up, instead of applying it to the
+// tailnet.
+type fileBackend struct {
+	path string
+}
+
+func newFileBackend(_ *tailscale.Client, cfg *ConfigFile) (Backend, error) {
+	if cfg.BackendFile == "" {
+		return nil, fmt.Errorf("the file backend needs a backendFile")
+	}
+	return &fileBackend{path: cfg.BackendFile}, nil
+}
+
+// Current reads the file. A missing file is an empty config.
+func (b *fileBackend) Current(context.Context) (map[string][]string, error) {
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string][]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string][]string)
+	if err := json.Unmarshal(data, &current); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", b.path, err)
+	}
+	return current, nil
+}
+
+func (b *fileBackend) Plan(current, desired map[string][]string) []domainChange {
+	return diffSplitDNS(current, desired)
+}
+
+// Apply queues a rewrite of the file with changes applied to what it holds
+// then, with one putting the old contents back as its rollback.
+func (b *fileBackend) Apply(_ context.Context, tx *transaction, changes []domainChange) error {
+	var old []byte
+	tx.add("split DNS file",
+		func(ctx context.Context) error {
+			var err error
+			old, err = os.ReadFile(b.path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			current, err := b.Current(ctx)
+			if err != nil {
+				return err
+			}
+			next := maps.Clone(current)
+			for _, c := range changes {
+				if c.New == nil {
+					delete(next, c.Domain)
+				} else {
+					next[c.Domain] = c.New
+				}
+			}
+			return b.write(next)
+		},
+		func(context.Context) error {
+			if old == nil {
+				return os.Remove(b.path)
+			}
+			return writeFileAtomic(b.path, old, 0o644)
+		},
+	)
+	return nil
+}
+
+func (b *fileBackend) write(splitDNS map[string][]string) error {
+	data, err := json.MarshalIndent(splitDNS, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(b.path, append(data, '\n'), 0o644)
+}
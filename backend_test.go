@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ConfigFile
+		want    Backend
+		wantErr bool
+	}{
+		{name: "default", want: &tailscaleBackend{}},
+		{name: "tailscale", cfg: ConfigFile{Backend: "tailscale"}, want: &tailscaleBackend{}},
+		{name: "file", cfg: ConfigFile{Backend: "file", BackendFile: "/tmp/splitdns.json"}, want: &fileBackend{path: "/tmp/splitdns.json"}},
+		{name: "file without a path", cfg: ConfigFile{Backend: "file"}, wantErr: true},
+		{name: "unknown", cfg: ConfigFile{Backend: "bind"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newBackend(nil, &tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newBackend() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) && !tt.wantErr {
+				t.Errorf("newBackend() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "splitdns.json")
+	b := &fileBackend{path: path}
+	ctx := context.Background()
+
+	current, err := b.Current(ctx)
+	if err != nil || len(current) != 0 {
+		t.Fatalf("Current() of a missing file = %v, %v, want an empty config", current, err)
+	}
+
+	desired := map[string][]string{
+		"corp.example.com": {"100.64.0.1"},
+		"lab.example.com":  {"100.64.0.2"},
+	}
+	var tx transaction
+	if err := b.Apply(ctx, &tx, b.Plan(current, desired)); err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	if err := tx.commit(ctx); err != nil {
+		t.Fatalf("commit() unexpected error: %v", err)
+	}
+	if got, _ := b.Current(ctx); !reflect.DeepEqual(got, desired) {
+		t.Errorf("Current() = %v, want %v", got, desired)
+	}
+
+	// a failed cycle puts the old file back
+	before, _ := os.ReadFile(path)
+	tx = transaction{}
+	b.Apply(ctx, &tx, b.Plan(desired, map[string][]string{"corp.example.com": {"100.64.0.3"}}))
+	tx.add("next step", func(context.Context) error { return errors.New("failed") }, nil)
+	if err := tx.commit(ctx); err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("commit() error = %v, want a rolled back failure", err)
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Errorf("file after rollback = %s, want %s", after, before)
+	}
+}
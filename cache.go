@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// resolveCache keeps selector results across updates, so that daemon ticks
+// don't repeat lookups whose results are still fresh.
+type resolveCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResult
+
+	// devices is the tailnet's device list, which every device selector
+	// matches against, until devicesExpire.
+	devices       []tailscale.Device
+	devicesExpire time.Time
+}
+
+type cachedResult struct {
+	ips     []string
+	expires time.Time
+}
+
+// resultCache is shared by every update in this process. It's cleared when
+// the config changes, since defaults affect what an entry resolves to, and
+// when a webhook or the netmap reports a tailnet change, since results are
+// then stale.
+var resultCache = &resolveCache{}
+
+func (c *resolveCache) get(key string, now time.Time) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, ok := c.entries[key]
+	if !ok || !now.Before(res.expires) {
+		return nil, false
+	}
+	return res.ips, true
+}
+
+func (c *resolveCache) put(key string, ips []string, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cachedResult)
+	}
+	c.entries[key] = cachedResult{ips: ips, expires: expires}
+}
+
+func (c *resolveCache) getDevices(now time.Time) ([]tailscale.Device, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.devices == nil || !now.Before(c.devicesExpire) {
+		return nil, false
+	}
+	return c.devices, true
+}
+
+func (c *resolveCache) putDevices(devices []tailscale.Device, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.devices = devices
+	c.devicesExpire = expires
+}
+
+func (c *resolveCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+	c.devices = nil
+}
+
+// cached returns an earlier result for the entry: from this update, or
+// from an earlier one if the entry's selector has a cache TTL and the
+// result hasn't expired.
+func (r *resolver) cached(e entry) ([]string, bool) {
+	r.mu.Lock()
+	ips, ok := r.results[e.raw]
+	r.mu.Unlock()
+	if ok {
+		return ips, true
+	}
+	if r.cache == nil || r.defaults.CacheTTL[e.kind] <= 0 {
+		return nil, false
+	}
+	return r.cache.get(e.raw, r.now)
+}
+
+// remember records a result for the rest of this update and, if the
+// selector has a cache TTL, for later updates.
+func (r *resolver) remember(e entry, ips []string) {
+	r.mu.Lock()
+	if r.results == nil {
+		r.results = make(map[string][]string)
+	}
+	r.results[e.raw] = ips
+	r.mu.Unlock()
+	if ttl := r.defaults.CacheTTL[e.kind]; r.cache != nil && ttl > 0 {
+		r.cache.put(e.raw, ips, r.now.Add(time.Duration(ttl)))
+	}
+}
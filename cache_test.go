@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestResolveCache(t *testing.T) {
+	lookups := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/tailnet/test/services/svc:dns/" {
+			http.NotFound(w, r)
+			return
+		}
+		lookups++
+		json.NewEncoder(w).Encode(ServiceInfo{Name: "svc:dns", Addrs: []string{"100.64.0.1"}})
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	client := &tailscale.Client{BaseURL: baseURL, APIKey: "test-key", Tailnet: "test"}
+
+	cfg := Config{
+		"a.example.com": {"svc:dns"},
+		"b.example.com": {"svc:dns"},
+		"c.example.com": {"svc:dns", "10.0.0.53"},
+	}
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		ttl         map[string]Duration
+		after       time.Duration
+		wantLookups int
+	}{
+		{name: "first update", ttl: map[string]Duration{"svc": Duration(5 * time.Minute)}, wantLookups: 1},
+		{name: "within ttl", ttl: map[string]Duration{"svc": Duration(5 * time.Minute)}, after: 4 * time.Minute, wantLookups: 0},
+		{name: "after ttl", ttl: map[string]Duration{"svc": Duration(5 * time.Minute)}, after: 6 * time.Minute, wantLookups: 1},
+		{name: "no ttl for this type", ttl: map[string]Duration{"device": Duration(5 * time.Minute)}, after: 7 * time.Minute, wantLookups: 1},
+	}
+
+	cache := &resolveCache{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookups = 0
+			r := &resolver{client: client, defaults: Defaults{CacheTTL: tt.ttl}, now: start.Add(tt.after), cache: cache}
+			splitDNS, err := r.resolveAll(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("resolveAll() unexpected error: %v", err)
+			}
+			if lookups != tt.wantLookups {
+				t.Errorf("got %d service lookups, want %d", lookups, tt.wantLookups)
+			}
+			for domain, nameservers := range splitDNS {
+				if nameservers[0] != "100.64.0.1" {
+					t.Errorf("%s = %v, want 100.64.0.1 first", domain, nameservers)
+				}
+			}
+		})
+	}
+}
+
+func TestDeviceListCache(t *testing.T) {
+	lists := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/tailnet/test/devices" {
+			http.NotFound(w, r)
+			return
+		}
+		lists++
+		json.NewEncoder(w).Encode(map[string][]tailscale.Device{
+			"devices": {{Name: "ns1.example.ts.net", Hostname: "ns1", Addresses: []string{"100.64.0.1"}}},
+		})
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	client := &tailscale.Client{BaseURL: baseURL, APIKey: "test-key", Tailnet: "test"}
+
+	cfg := Config{"a.example.com": {"device:ns1"}}
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	cache := &resolveCache{}
+
+	tests := []struct {
+		name      string
+		ttl       Duration
+		after     time.Duration
+		clear     bool
+		wantLists int
+	}{
+		{name: "first update", ttl: Duration(5 * time.Minute), wantLists: 1},
+		{name: "within ttl", ttl: Duration(5 * time.Minute), after: 4 * time.Minute, wantLists: 0},
+		{name: "after ttl", ttl: Duration(5 * time.Minute), after: 6 * time.Minute, wantLists: 1},
+		{name: "tailnet changed", ttl: Duration(5 * time.Minute), after: 7 * time.Minute, clear: true, wantLists: 1},
+		{name: "no ttl", after: 8 * time.Minute, wantLists: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lists = 0
+			if tt.clear {
+				cache.clear()
+			}
+			r := &resolver{client: client, defaults: Defaults{DeviceListTTL: tt.ttl}, now: start.Add(tt.after), cache: cache}
+			splitDNS, err := r.resolveAll(context.Background(), cfg)
+			if err != nil {
+				t.Fatalf("resolveAll() unexpected error: %v", err)
+			}
+			if lists != tt.wantLists {
+				t.Errorf("listed devices %d times, want %d", lists, tt.wantLists)
+			}
+			if got := splitDNS["a.example.com"]; len(got) != 1 || got[0] != "100.64.0.1" {
+				t.Errorf("a.example.com = %v, want [100.64.0.1]", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// cloudDNSAPI and gcpMetadataToken are Google endpoints. They're
+	// variables for tests.
+	cloudDNSAPI      = "https://dns.googleapis.com/dns/v1"
+	gcpMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+const cloudDNSScope = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+
+// CloudDNS configures A and AAAA records kept in a Google Cloud DNS managed
+// zone.
+type CloudDNS struct {
+	// Project is the ID of the project the zone is in.
+	Project string `json:"project"`
+
+	// Zone is the managed zone's name, not its DNS name.
+	Zone string `json:"zone"`
+
+	// CredentialsFile is a service account key file. Defaults to
+	// GOOGLE_APPLICATION_CREDENTIALS, and without either the instance's
+	// service account is used through the metadata server.
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+
+	// TTL is the records' TTL. Defaults to 5m.
+	TTL Duration `json:"ttl,omitempty"`
+
+	// Records are fully qualified names in the managed zone.
+	Records RecordSet `json:"records"`
+}
+
+func (c *CloudDNS) validate() error {
+	if c.Project == "" || c.Zone == "" {
+		return fmt.Errorf("needs a project and zone")
+	}
+	return nil
+}
+
+// cloudDNSRecordSet is a ResourceRecordSet in the Cloud DNS API.
+type cloudDNSRecordSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl"`
+	RRDatas []string `json:"rrdatas"`
+}
+
+// sync resolves the configured records and reconciles the managed
+// zone with them in a single change. Only names with a tsddns marker TXT
+// record are changed or deleted.
+func (c *CloudDNS) sync(ctx context.Context, r *resolver, _ map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, c.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	desired, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+	token, err := gcpAccessToken(ctx, cmp.Or(c.CredentialsFile, os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")))
+	if err != nil {
+		return fmt.Errorf("getting access token: %w", err)
+	}
+
+	zone := "/projects/" + url.PathEscape(c.Project) + "/managedZones/" + url.PathEscape(c.Zone)
+	existing, err := listCloudDNSRecordSets(ctx, token, zone)
+	if err != nil {
+		return fmt.Errorf("listing records: %w", err)
+	}
+	sets := make(map[string]cloudDNSRecordSet)
+	markers := make(map[string]cloudDNSRecordSet)
+	for _, set := range existing {
+		name := normalizeDomain(set.Name)
+		switch {
+		case set.Type == "TXT" && strings.HasPrefix(name, ownerMarkerPrefix):
+			markers[strings.TrimPrefix(name, ownerMarkerPrefix)] = set
+		case set.Type == "A" || set.Type == "AAAA":
+			sets[name+" "+set.Type] = set
+		}
+	}
+	var owned, foreign []record
+	for _, set := range existing {
+		if set.Type != "A" && set.Type != "AAAA" {
+			continue
+		}
+		name := normalizeDomain(set.Name)
+		for _, v := range set.RRDatas {
+			rec := record{Name: name, Type: set.Type, Value: v}
+			if _, ok := markers[name]; ok {
+				owned = append(owned, rec)
+			} else {
+				foreign = append(foreign, rec)
+			}
+		}
+	}
+
+	create, remove := planRecords(desired, owned, foreign)
+	ttl := int(time.Duration(cmp.Or(c.TTL, Duration(defaultRecordTTL))).Seconds())
+	var change struct {
+		Additions []cloudDNSRecordSet `json:"additions,omitempty"`
+		Deletions []cloudDNSRecordSet `json:"deletions,omitempty"`
+	}
+	for _, set := range changedRRsets(desired, create, remove) {
+		if old, ok := sets[set.Name+" "+set.Type]; ok {
+			change.Deletions = append(change.Deletions, old)
+		}
+		if len(set.Values) == 0 {
+			slog.InfoContext(ctx, "Deleting Cloud DNS records", "name", set.Name, "type", set.Type)
+			continue
+		}
+		slog.InfoContext(ctx, "Setting Cloud DNS records", "name", set.Name, "type", set.Type, "values", set.Values)
+		change.Additions = append(change.Additions, cloudDNSRecordSet{Name: set.Name + ".", Type: set.Type, TTL: ttl, RRDatas: set.Values})
+	}
+
+	// Mark the names tsddns starts managing, and unmark the ones it's done
+	// with. Names with unmarked records are never in create.
+	for _, rec := range create {
+		if _, ok := markers[rec.Name]; !ok {
+			markers[rec.Name] = cloudDNSRecordSet{Name: ownerMarkerPrefix + rec.Name + ".", Type: "TXT", TTL: ttl, RRDatas: []string{ownerMarkerValue}}
+			change.Additions = append(change.Additions, markers[rec.Name])
+		}
+	}
+	var marked []string
+	for name := range markers {
+		marked = append(marked, name)
+	}
+	sort.Strings(marked)
+	for _, name := range marked {
+		if !hasName(desired, name) {
+			change.Deletions = append(change.Deletions, markers[name])
+		}
+	}
+
+	if len(change.Additions) == 0 && len(change.Deletions) == 0 {
+		slog.InfoContext(ctx, "Cloud DNS records are up to date")
+		return nil
+	}
+	if err := cloudDNSDo(ctx, token, "POST", zone+"/changes", change, nil); err != nil {
+		return fmt.Errorf("changing records: %w", err)
+	}
+	return nil
+}
+
+// listCloudDNSRecordSets returns every record set in the managed zone.
+func listCloudDNSRecordSets(ctx context.Context, token, zone string) ([]cloudDNSRecordSet, error) {
+	var all []cloudDNSRecordSet
+	pageToken := ""
+	for {
+		path := zone + "/rrsets"
+		if pageToken != "" {
+			path += "?pageToken=" + url.QueryEscape(pageToken)
+		}
+		var page struct {
+			RRSets        []cloudDNSRecordSet `json:"rrsets"`
+			NextPageToken string              `json:"nextPageToken"`
+		}
+		if err := cloudDNSDo(ctx, token, "GET", path, nil, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.RRSets...)
+		if page.NextPageToken == "" {
+			return all, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// cloudDNSDo makes a Cloud DNS API request.
+func cloudDNSDo(ctx context.Context, token, method, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, cloudDNSAPI+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, apiErr.Error.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// gcpAccessToken returns an OAuth access token for Cloud DNS, from the
+// service account key in credentialsFile, or from the metadata server when
+// it's empty.
+func gcpAccessToken(ctx context.Context, credentialsFile string) (string, error) {
+	var req *http.Request
+	if credentialsFile == "" {
+		var err error
+		req, err = http.NewRequestWithContext(ctx, "GET", gcpMetadataToken, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	} else {
+		data, err := os.ReadFile(credentialsFile)
+		if err != nil {
+			return "", err
+		}
+		var key struct {
+			ClientEmail string `json:"client_email"`
+			PrivateKey  string `json:"private_key"`
+			TokenURI    string `json:"token_uri"`
+		}
+		if err := json.Unmarshal(data, &key); err != nil {
+			return "", fmt.Errorf("parsing %s: %w", credentialsFile, err)
+		}
+		assertion, err := signJWT(key.ClientEmail, key.PrivateKey, key.TokenURI, time.Now())
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", credentialsFile, err)
+		}
+		form := url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		}
+		req, err = http.NewRequestWithContext(ctx, "POST", key.TokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint returned no access token")
+	}
+	return token.AccessToken, nil
+}
+
+// signJWT returns a JWT asserting the service account email, for exchanging
+// at tokenURI for an access token, signed with its PEM private key.
+func signJWT(email, privateKey, tokenURI string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return "", fmt.Errorf("no PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("parsing private key: %w", err)
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("private key is not RSA")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   email,
+		"scope": cloudDNSScope,
+		"aud":   tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSyncCloudDNS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing := []cloudDNSRecordSet{
+		{Name: "ns.internal.example.com.", Type: "A", TTL: 300, RRDatas: []string{"100.64.0.1"}},
+		{Name: "_tsddns.ns.internal.example.com.", Type: "TXT", TTL: 300, RRDatas: []string{ownerMarkerValue}},
+		{Name: "manual.internal.example.com.", Type: "A", TTL: 300, RRDatas: []string{"100.64.0.3"}},
+	}
+	var change struct {
+		Additions []cloudDNSRecordSet `json:"additions"`
+		Deletions []cloudDNSRecordSet `json:"deletions"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			r.ParseForm()
+			parts := strings.Split(r.PostForm.Get("assertion"), ".")
+			if len(parts) != 3 {
+				t.Fatalf("assertion %q is not a JWT", r.PostForm.Get("assertion"))
+			}
+			sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+				t.Errorf("JWT signature: %v", err)
+			}
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "ya29.token"})
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer ya29.token" {
+			t.Errorf("Authorization = %q, want the access token", got)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /projects/proj/managedZones/internal/rrsets":
+			json.NewEncoder(w).Encode(map[string]any{"rrsets": existing})
+		case "POST /projects/proj/managedZones/internal/changes":
+			json.NewDecoder(r.Body).Decode(&change)
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"message": "not found"}}`))
+		}
+	}))
+	defer srv.Close()
+	cloudDNSAPI = srv.URL
+
+	credentials := filepath.Join(t.TempDir(), "key.json")
+	keyJSON, _ := json.Marshal(map[string]string{
+		"client_email": "tsddns@proj.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    srv.URL + "/token",
+	})
+	if err := os.WriteFile(credentials, keyJSON, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &CloudDNS{
+		Project:         "proj",
+		Zone:            "internal",
+		CredentialsFile: credentials,
+		Records: Config{
+			"ns.internal.example.com":     {"100.64.0.4"},
+			"new.internal.example.com":    {"100.64.0.5"},
+			"manual.internal.example.com": {"100.64.0.6"},
+		},
+	}
+	if err := c.sync(context.Background(), newResolver(nil, Defaults{}), nil); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+
+	summarize := func(sets []cloudDNSRecordSet) string {
+		var out []string
+		for _, s := range sets {
+			out = append(out, s.Name+" "+s.Type+" "+strings.Join(s.RRDatas, ","))
+		}
+		return strings.Join(out, "; ")
+	}
+	wantAdd := `new.internal.example.com. A 100.64.0.5; ns.internal.example.com. A 100.64.0.4; _tsddns.new.internal.example.com. TXT "heritage=tsddns"`
+	if got := summarize(change.Additions); got != wantAdd {
+		t.Errorf("additions = %s, want %s", got, wantAdd)
+	}
+	if got, want := summarize(change.Deletions), "ns.internal.example.com. A 100.64.0.1"; got != want {
+		t.Errorf("deletions = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// cloudflareAPI is the Cloudflare API base URL. It's a variable for tests.
+var cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// Cloudflare configures A and AAAA records kept in a Cloudflare zone.
+type Cloudflare struct {
+	// ZoneID is the ID of the zone, shown on its overview page.
+	ZoneID string `json:"zoneId"`
+
+	// APITokenEnv names the environment variable holding an API token
+	// with DNS edit permission on the zone. Defaults to
+	// CLOUDFLARE_API_TOKEN.
+	APITokenEnv string `json:"apiTokenEnv,omitempty"`
+
+	// TTL is the records' TTL. Defaults to 5m.
+	TTL Duration `json:"ttl,omitempty"`
+
+	// Records are fully qualified names in the zone.
+	Records RecordSet `json:"records"`
+}
+
+func (c *Cloudflare) validate() error {
+	if c.ZoneID == "" {
+		return fmt.Errorf("needs a zoneId")
+	}
+	return nil
+}
+
+// cloudflareRecord is a DNS record as the Cloudflare API returns it.
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	Proxied bool   `json:"proxied"`
+}
+
+// sync resolves the configured records and reconciles the zone
+// with them. Only records carrying the tsddns comment are changed or
+// deleted.
+func (c *Cloudflare) sync(ctx context.Context, r *resolver, _ map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, c.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	desired, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+
+	token := os.Getenv(cmp.Or(c.APITokenEnv, "CLOUDFLARE_API_TOKEN"))
+	if token == "" {
+		return fmt.Errorf("no API token in %s", cmp.Or(c.APITokenEnv, "CLOUDFLARE_API_TOKEN"))
+	}
+
+	existing, err := listCloudflareRecords(ctx, token, c.ZoneID)
+	if err != nil {
+		return fmt.Errorf("listing records: %w", err)
+	}
+	var owned, foreign []record
+	for _, cr := range existing {
+		if cr.Type != "A" && cr.Type != "AAAA" {
+			continue
+		}
+		rec := record{ID: cr.ID, Name: normalizeDomain(cr.Name), Type: cr.Type, Value: cr.Content}
+		if cr.Comment == recordOwner {
+			owned = append(owned, rec)
+		} else {
+			foreign = append(foreign, rec)
+		}
+	}
+
+	create, remove := planRecords(desired, owned, foreign)
+	ttl := int(time.Duration(cmp.Or(c.TTL, Duration(defaultRecordTTL))).Seconds())
+	for _, rec := range create {
+		slog.InfoContext(ctx, "Creating Cloudflare record", "name", rec.Name, "type", rec.Type, "value", rec.Value)
+		body := cloudflareRecord{Type: rec.Type, Name: rec.Name, Content: rec.Value, TTL: ttl, Comment: recordOwner}
+		if err := cloudflareDo(ctx, token, "POST", "/zones/"+c.ZoneID+"/dns_records", body, nil); err != nil {
+			return fmt.Errorf("creating %s: %w", rec.Name, err)
+		}
+	}
+	for _, rec := range remove {
+		slog.InfoContext(ctx, "Deleting Cloudflare record", "name", rec.Name, "type", rec.Type, "value", rec.Value)
+		if err := cloudflareDo(ctx, token, "DELETE", "/zones/"+c.ZoneID+"/dns_records/"+rec.ID, nil, nil); err != nil {
+			return fmt.Errorf("deleting %s: %w", rec.Name, err)
+		}
+	}
+	if len(create) == 0 && len(remove) == 0 {
+		slog.InfoContext(ctx, "Cloudflare records are up to date")
+	}
+	return nil
+}
+
+// listCloudflareRecords returns every DNS record in the zone.
+func listCloudflareRecords(ctx context.Context, token, zoneID string) ([]cloudflareRecord, error) {
+	var all []cloudflareRecord
+	for page := 1; ; page++ {
+		var records []cloudflareRecord
+		info, err := cloudflareList(ctx, token, fmt.Sprintf("/zones/%s/dns_records?per_page=100&page=%d", zoneID, page), &records)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, records...)
+		if page >= info.TotalPages {
+			return all, nil
+		}
+	}
+}
+
+type cloudflareResultInfo struct {
+	TotalPages int `json:"total_pages"`
+}
+
+func cloudflareList(ctx context.Context, token, path string, out any) (cloudflareResultInfo, error) {
+	var info cloudflareResultInfo
+	err := cloudflareDo(ctx, token, "GET", path, nil, &struct {
+		Result     any                   `json:"result"`
+		ResultInfo *cloudflareResultInfo `json:"result_info"`
+	}{out, &info})
+	return info, err
+}
+
+// cloudflareDo makes a Cloudflare API request. out receives the whole
+// response envelope.
+func cloudflareDo(ctx context.Context, token, method, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, cloudflareAPI+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+	if !envelope.Success {
+		var msgs []string
+		for _, e := range envelope.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, strings.Join(msgs, "; "))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSyncCloudflare(t *testing.T) {
+	existing := []cloudflareRecord{
+		{ID: "1", Type: "A", Name: "ns.internal.example.com", Content: "100.64.0.1", Comment: recordOwner},
+		{ID: "2", Type: "A", Name: "old.internal.example.com", Content: "100.64.0.2", Comment: recordOwner},
+		{ID: "3", Type: "A", Name: "manual.internal.example.com", Content: "100.64.0.3"},
+		{ID: "4", Type: "TXT", Name: "internal.example.com", Content: "v=spf1 -all"},
+	}
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q, want bearer token", got)
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/zones/zone1/dns_records":
+			json.NewEncoder(w).Encode(map[string]any{
+				"success":     true,
+				"result":      existing,
+				"result_info": map[string]int{"total_pages": 1},
+			})
+			return
+		case r.Method == "POST":
+			var rec cloudflareRecord
+			json.NewDecoder(r.Body).Decode(&rec)
+			if rec.Comment != recordOwner || rec.TTL != 60 {
+				t.Errorf("created %+v, want tsddns comment and TTL 60", rec)
+			}
+			calls = append(calls, "POST "+rec.Name+" "+rec.Content)
+		case r.Method == "DELETE":
+			calls = append(calls, "DELETE "+strings.TrimPrefix(r.URL.Path, "/zones/zone1/dns_records/"))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": true})
+	}))
+	defer srv.Close()
+	cloudflareAPI = srv.URL
+	t.Setenv("CLOUDFLARE_API_TOKEN", "secret")
+
+	c := &Cloudflare{
+		ZoneID: "zone1",
+		TTL:    Duration(60e9),
+		Records: Config{
+			"ns.internal.example.com":     {"100.64.0.1", "100.64.0.4"},
+			"manual.internal.example.com": {"100.64.0.5"},
+		},
+	}
+	if err := c.sync(context.Background(), newResolver(nil, Defaults{}), nil); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+	want := []string{"POST ns.internal.example.com 100.64.0.4", "DELETE 2"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	t.Setenv("CLOUDFLARE_API_TOKEN", "")
+	if err := c.sync(context.Background(), newResolver(nil, Defaults{}), nil); err == nil {
+		t.Error("sync() succeeded without an API token")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// defaultDebounce is how long the daemon waits after a tailnet change for
+// others to fold into the same update.
+const defaultDebounce = 2 * time.Second
+
+// triggerSources are the channels coalesceTriggers listens on.
+type triggerSources struct {
+	interval <-chan time.Time
+	tailnet  <-chan struct{}
+	admin    <-chan struct{}
+	signal   <-chan struct{}
+}
+
+// coalesceTriggers collects the triggers that fire along with first, so
+// that a burst of webhook events or a signal right after the interval runs
+// one update instead of several identical ones. Only a tailnet change,
+// which come in bursts, waits out window for more; the interval, signals
+// and admin requests run right away, folding in just the triggers already
+// pending. It returns the distinct triggers, first first, and returns early
+// once ctx is done. A window of 0 disables coalescing.
+func coalesceTriggers(ctx context.Context, window time.Duration, first string, sources triggerSources) []string {
+	triggers := []string{first}
+	if window <= 0 {
+		return triggers
+	}
+	var timeout <-chan time.Time
+	if first == triggerTailnet {
+		timer := time.NewTimer(window)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		trigger := sources.next(ctx, timeout)
+		if trigger == "" {
+			return triggers
+		}
+		if !slices.Contains(triggers, trigger) {
+			triggers = append(triggers, trigger)
+		}
+	}
+}
+
+// next returns the next trigger to fire, waiting until timeout fires or
+// ctx is done for one, or only taking one already pending if timeout is
+// nil. It returns "" if none fired.
+func (s triggerSources) next(ctx context.Context, timeout <-chan time.Time) string {
+	if timeout == nil {
+		select {
+		case <-s.interval:
+			return triggerInterval
+		case <-s.tailnet:
+			return triggerTailnet
+		case <-s.admin:
+			return triggerAdmin
+		case <-s.signal:
+			return triggerSignal
+		default:
+			return ""
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return ""
+	case <-timeout:
+		return ""
+	case <-s.interval:
+		return triggerInterval
+	case <-s.tailnet:
+		return triggerTailnet
+	case <-s.admin:
+		return triggerAdmin
+	case <-s.signal:
+		return triggerSignal
+	}
+}
+
+// coalescedScope returns the config for an update run for all of
+// triggers: scoped, the first trigger's, if nothing else fired, and
+// otherwise nil for the whole config, which covers every domain any of them
+// would update.
+func coalescedScope(triggers []string, scoped *ConfigFile) *ConfigFile {
+	if len(triggers) > 1 {
+		return nil
+	}
+	return scoped
+}
+
+// bypassesPause reports whether triggers include one that runs even while
+// updates are paused: an admin request or SIGUSR1.
+func bypassesPause(triggers []string) bool {
+	return slices.Contains(triggers, triggerAdmin) || slices.Contains(triggers, triggerSignal)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCoalesceTriggers(t *testing.T) {
+	interval := make(chan time.Time, 1)
+	tailnet := make(chan struct{}, 1)
+	admin := make(chan struct{}, 1)
+	signal := make(chan struct{}, 1)
+	sources := triggerSources{interval: interval, tailnet: tailnet, admin: admin, signal: signal}
+
+	t.Run("burst", func(t *testing.T) {
+		tailnet <- struct{}{}
+		admin <- struct{}{}
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			tailnet <- struct{}{}
+		}()
+		got := coalesceTriggers(context.Background(), 100*time.Millisecond, triggerTailnet, sources)
+		slices.Sort(got[1:])
+		if want := []string{triggerTailnet, triggerAdmin}; !slices.Equal(got, want) {
+			t.Errorf("triggers = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("after the window", func(t *testing.T) {
+		got := coalesceTriggers(context.Background(), 10*time.Millisecond, triggerTailnet, sources)
+		signal <- struct{}{}
+		if !slices.Equal(got, []string{triggerTailnet}) {
+			t.Errorf("triggers = %v, want just the tailnet change", got)
+		}
+		<-signal
+	})
+
+	t.Run("single trigger", func(t *testing.T) {
+		for _, first := range []string{triggerInterval, triggerAdmin, triggerSignal} {
+			start := time.Now()
+			got := coalesceTriggers(context.Background(), time.Hour, first, sources)
+			if time.Since(start) > time.Second {
+				t.Errorf("%s waited for the window, want it to fire right away", first)
+			}
+			if !slices.Equal(got, []string{first}) {
+				t.Errorf("triggers = %v, want just %s", got, first)
+			}
+		}
+	})
+
+	t.Run("already pending", func(t *testing.T) {
+		signal <- struct{}{}
+		start := time.Now()
+		got := coalesceTriggers(context.Background(), time.Hour, triggerInterval, sources)
+		if time.Since(start) > time.Second {
+			t.Error("the interval waited for the window, want it to fire right away")
+		}
+		if want := []string{triggerInterval, triggerSignal}; !slices.Equal(got, want) {
+			t.Errorf("triggers = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		signal <- struct{}{}
+		defer func() { <-signal }()
+		if got := coalesceTriggers(context.Background(), 0, triggerInterval, sources); !slices.Equal(got, []string{triggerInterval}) {
+			t.Errorf("triggers = %v, want just the interval with no window", got)
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		start := time.Now()
+		coalesceTriggers(ctx, time.Hour, triggerTailnet, sources)
+		if time.Since(start) > time.Second {
+			t.Error("didn't return on shutdown")
+		}
+	})
+}
+
+func TestCoalescedScope(t *testing.T) {
+	cfg := &ConfigFile{
+		Domains:   Config{"svc.example.com": {"svc:dns"}, "office.example.com": {"10.0.0.53"}},
+		Schedules: map[string]*Schedule{"fast": {Interval: Duration(time.Minute), Domains: []string{"svc.example.com"}}},
+	}
+	scoped := cfg.withoutSchedules()
+	if got := coalescedScope([]string{triggerInterval}, scoped); got != scoped {
+		t.Error("an interval update on its own lost its scope")
+	}
+	if got := coalescedScope([]string{triggerInterval, triggerTailnet}, scoped); got != nil {
+		t.Error("an interval update coalesced with a tailnet change is still scoped, want the whole config")
+	}
+	if !bypassesPause([]string{triggerTailnet, triggerSignal}) || bypassesPause([]string{triggerInterval, triggerTailnet}) {
+		t.Error("bypassesPause() wrong, want only admin requests and SIGUSR1 to run while paused")
+	}
+}
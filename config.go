@@ -0,0 +1,583 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// configVersion is the structured config format written by `tsddns
+// migrate`. The legacy bare domain map is version 0.
+const configVersion = 1
+
+// Config maps split DNS domains to the nameserver entries that should be
+// resolved for them.
+type Config map[string][]string
+
+// fallbackSep joins the alternatives of a fallback chain into one entry.
+// In JSON a chain is written as a nested array.
+const fallbackSep = " || "
+
+// UnmarshalJSON accepts a nested array as a nameserver entry, which is a
+// fallback chain, and stores it joined with fallbackSep.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	var raw map[string][]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*c = nil
+		return nil
+	}
+
+	*c = make(Config, len(raw))
+	for domain, values := range raw {
+		nameservers, err := parseNameservers(values)
+		if err != nil {
+			return fmt.Errorf("%s: %w", domain, err)
+		}
+		(*c)[domain] = nameservers
+	}
+	return nil
+}
+
+// MarshalJSON writes fallback chains back out as nested arrays.
+func (c Config) MarshalJSON() ([]byte, error) {
+	if c == nil {
+		return []byte("null"), nil
+	}
+	out := make(map[string][]any, len(c))
+	for domain, nameservers := range c {
+		out[domain] = nameserverValues(nameservers)
+	}
+	return json.Marshal(out)
+}
+
+// Nameservers is a list of nameserver entries outside the domain map. Like
+// a domain's entries, it may contain fallback chains.
+type Nameservers []string
+
+func (n *Nameservers) UnmarshalJSON(data []byte) error {
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	nameservers, err := parseNameservers(values)
+	if err != nil {
+		return err
+	}
+	*n = nameservers
+	return nil
+}
+
+func (n Nameservers) MarshalJSON() ([]byte, error) {
+	return json.Marshal(nameserverValues(n))
+}
+
+// parseNameservers decodes a JSON list of nameserver entries, joining
+// nested arrays into fallback chains.
+func parseNameservers(values []json.RawMessage) ([]string, error) {
+	nameservers := make([]string, 0, len(values))
+	for _, v := range values {
+		var chain []string
+		if len(v) > 0 && v[0] == '[' {
+			if err := json.Unmarshal(v, &chain); err != nil {
+				return nil, err
+			}
+			if len(chain) == 0 {
+				return nil, fmt.Errorf("empty fallback chain")
+			}
+			nameservers = append(nameservers, strings.Join(chain, fallbackSep))
+			continue
+		}
+		var ns string
+		if err := json.Unmarshal(v, &ns); err != nil {
+			return nil, err
+		}
+		nameservers = append(nameservers, ns)
+	}
+	return nameservers, nil
+}
+
+// nameserverValues is the JSON form of nameserver entries, with fallback
+// chains split back into nested arrays.
+func nameserverValues(nameservers []string) []any {
+	values := make([]any, 0, len(nameservers))
+	for _, ns := range nameservers {
+		if strings.Contains(ns, fallbackSep) {
+			values = append(values, strings.Split(ns, fallbackSep))
+		} else {
+			values = append(values, ns)
+		}
+	}
+	return values
+}
+
+// ConfigFile is the structured config format. The legacy format, a bare
+// domain map, is still accepted and loads as a ConfigFile with only Domains
+// set.
+type ConfigFile struct {
+	Version  int      `json:"version,omitempty"`
+	Domains  Config   `json:"domains"`
+	Defaults Defaults `json:"defaults,omitzero"`
+
+	// Mode is "split" (the default) to manage split DNS, "global" to push
+	// Global as the tailnet's only nameservers with local DNS overridden,
+	// "records" to only push records to the record backends, or "server"
+	// to only serve the domains from the embedded DNS server. The last
+	// three leave split DNS alone.
+	Mode string `json:"mode,omitempty"`
+
+	// Backend is where split DNS is applied: "tailscale" (the default) for
+	// the tailnet, or "file" to keep it in BackendFile as JSON instead.
+	Backend     string `json:"backend,omitempty"`
+	BackendFile string `json:"backendFile,omitempty"`
+
+	// Global, if set, are resolved like a domain's nameservers and applied
+	// as the tailnet's global nameservers.
+	Global Nameservers `json:"global,omitempty"`
+
+	// SearchPaths, if set, are applied as the tailnet's MagicDNS search
+	// domains. An empty list clears them; leaving it out leaves them alone.
+	SearchPaths []string `json:"searchPaths,omitempty"`
+
+	// Preferences, if set, are the MagicDNS and override local DNS
+	// settings to keep the tailnet at.
+	Preferences *Preferences `json:"preferences,omitempty"`
+
+	// TTLHints is how long clients are expected to cache each domain's
+	// split DNS targets. It is used to suggest a re-query time when a
+	// domain changes.
+	TTLHints map[string]Duration `json:"ttlHints,omitempty"`
+
+	// Probe, if set, checks that every resolved nameserver answers DNS
+	// before the update is applied.
+	Probe *Probe `json:"probe,omitempty"`
+
+	// ReverseZones, if set, adds reverse DNS zones for the tailnet's
+	// address ranges.
+	ReverseZones *ReverseZones `json:"reverseZones,omitempty"`
+
+	// Discover, if set, adds a domain for every resolver tag found on the
+	// tailnet's devices.
+	Discover *Discover `json:"discover,omitempty"`
+
+	// RFC2136, if set, pushes A and AAAA records to a nameserver with
+	// dynamic updates after every update.
+	RFC2136 *RFC2136 `json:"rfc2136,omitempty"`
+
+	// Cloudflare, if set, keeps A and AAAA records in a Cloudflare zone
+	// after every update.
+	Cloudflare *Cloudflare `json:"cloudflare,omitempty"`
+
+	// Route53, if set, keeps A and AAAA records in a Route53 hosted zone
+	// after every update.
+	Route53 *Route53 `json:"route53,omitempty"`
+
+	// CloudDNS, if set, keeps A and AAAA records in a Google Cloud DNS
+	// managed zone after every update.
+	CloudDNS *CloudDNS `json:"cloudDNS,omitempty"`
+
+	// AzureDNS, if set, keeps A and AAAA records in an Azure Private DNS
+	// zone after every update.
+	AzureDNS *AzureDNS `json:"azureDNS,omitempty"`
+
+	// PowerDNS, if set, keeps A and AAAA records in PowerDNS zones after
+	// every update.
+	PowerDNS *PowerDNS `json:"powerdns,omitempty"`
+
+	// NextDNS, if set, keeps rewrites in a NextDNS profile after every
+	// update.
+	NextDNS *NextDNS `json:"nextdns,omitempty"`
+
+	// PiHole, if set, keeps a Pi-hole's local records and forwarded
+	// domains in sync after every update.
+	PiHole *PiHole `json:"pihole,omitempty"`
+
+	// AdGuard, if set, keeps an AdGuard Home's rewrites and per-domain
+	// upstreams in sync after every update.
+	AdGuard *AdGuard `json:"adguard,omitempty"`
+
+	// Technitium, if set, keeps a Technitium DNS Server's records and
+	// conditional forwarder zones in sync after every update.
+	Technitium *Technitium `json:"technitium,omitempty"`
+
+	// OPNsense, if set, keeps an OPNsense firewall's Unbound host overrides
+	// and query forwarding in sync after every update.
+	OPNsense *OPNsense `json:"opnsense,omitempty"`
+
+	// MikroTik, if set, keeps a MikroTik router's static DNS entries in
+	// sync after every update.
+	MikroTik *MikroTik `json:"mikrotik,omitempty"`
+
+	// HostsFile, if set, keeps records in a hosts-format file after every
+	// update.
+	HostsFile *HostsFile `json:"hostsFile,omitempty"`
+
+	// ZoneFile, if set, keeps records in a zone file after every update.
+	ZoneFile *ZoneFile `json:"zoneFile,omitempty"`
+
+	// Server, if set, runs the embedded DNS server, answering for the
+	// domains with their resolved nameservers.
+	Server *Server `json:"server,omitempty"`
+
+	// CoreDNSConfigMap, if set, adds forward stanzas for the applied split
+	// DNS domains to the cluster's CoreDNS config along with every update.
+	CoreDNSConfigMap *CoreDNSConfigMap `json:"coreDNSConfigMap,omitempty"`
+
+	// Exports write the applied split DNS config to local resolvers'
+	// config files.
+	Exports []Export `json:"exports,omitempty"`
+
+	// FlushHooks run after an apply that changed at least one domain.
+	FlushHooks []FlushHook `json:"flushHooks,omitempty"`
+
+	// Notify lists webhooks told about every update that changed split
+	// DNS or failed.
+	Notify []Notification `json:"notify,omitempty"`
+
+	// Email, if set, sends an email when updates keep failing or split DNS
+	// drifted.
+	Email *Email `json:"email,omitempty"`
+
+	// MQTT, if set, publishes the outcome of every update and each domain
+	// it changed to an MQTT broker.
+	MQTT *MQTT `json:"mqtt,omitempty"`
+
+	// Drift, if set, checks for split DNS changed outside tsddns between
+	// updates in daemon mode.
+	Drift *Drift `json:"drift,omitempty"`
+
+	// Schedules, by name, update groups of domains on their own intervals
+	// in daemon mode. Domains in no schedule update every -interval.
+	Schedules map[string]*Schedule `json:"schedules,omitempty"`
+
+	// scope, if set, limits an update to some domains. See forSchedules.
+	scope *updateScope
+
+	// Exclude lists domains tsddns must never create, modify or delete,
+	// even if they appear in Domains. Their current split DNS routes are
+	// carried over unchanged on every update.
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Profiles are named environments selected with -profile.
+	Profiles map[string]*Profile `json:"profiles,omitempty"`
+}
+
+// defaultOnlineWindow is how recently a device must have been seen to count
+// as online when Defaults.OnlineWindow isn't set.
+const defaultOnlineWindow = 5 * time.Minute
+
+// Defaults apply to every entry that doesn't override them with an option.
+type Defaults struct {
+	// OnlineOnly makes device selectors skip devices that haven't been
+	// seen within OnlineWindow. Entries override it with ?online=.
+	OnlineOnly   bool     `json:"onlineOnly,omitempty"`
+	OnlineWindow Duration `json:"onlineWindow,omitempty"`
+
+	// Family picks which of a device's or service's addresses to use:
+	// "ipv4", "ipv6" or "both". Empty uses the first address. Entries
+	// override it with ?family=.
+	Family string `json:"family,omitempty"`
+
+	// MaxDevices caps how many devices a tag: or user: selector adds.
+	// Zero means every matching device. Entries override it with ?max=.
+	MaxDevices int `json:"maxDevices,omitempty"`
+
+	// Resolver is the DNS server dns: and srv: entries query, as host or host:port.
+	// Empty uses the system resolver. Entries override it with ?server=.
+	Resolver string `json:"resolver,omitempty"`
+
+	// Order is "rotate" or "shuffle" to change the order of each domain's
+	// nameservers on every apply, spreading load across them. Empty keeps
+	// the resolved order.
+	Order string `json:"order,omitempty"`
+
+	// CacheTTL is how long results of each selector type, such as "svc"
+	// or "device", are reused by later updates. Types without a TTL are
+	// looked up on every update, but only once per update.
+	CacheTTL map[string]Duration `json:"cacheTTL,omitempty"`
+
+	// DeviceListTTL is how long the tailnet's device list is reused by
+	// later updates. Zero fetches it on every update that needs it.
+	DeviceListTTL Duration `json:"deviceListTTL,omitempty"`
+
+	// Posture is what a device must satisfy to be picked by a device
+	// selector, keyed like the entry options: "os", "minversion",
+	// "uptodate" and "attr". Entries override each key with an option.
+	Posture map[string]string `json:"posture,omitempty"`
+}
+
+func (d Defaults) onlineWindow() time.Duration {
+	if d.OnlineWindow <= 0 {
+		return defaultOnlineWindow
+	}
+	return time.Duration(d.OnlineWindow)
+}
+
+// Duration is a time.Duration that is written as a string like "5m" in
+// JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5m\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func loadConfig(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing config JSON: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func parseConfig(data []byte) (*ConfigFile, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	if isLegacyConfig(raw) {
+		var domains Config
+		if err := json.Unmarshal(data, &domains); err != nil {
+			return nil, err
+		}
+		return &ConfigFile{Domains: domains}, nil
+	}
+
+	var cfg ConfigFile
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Domains == nil {
+		cfg.Domains = Config{}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// isLegacyConfig reports whether raw is a bare domain map. Every value in
+// the legacy format is a list of nameservers, while the structured format
+// always keeps domains in an object.
+func isLegacyConfig(raw map[string]json.RawMessage) bool {
+	for _, v := range raw {
+		if len(v) == 0 || v[0] != '[' {
+			return false
+		}
+	}
+	return true
+}
+
+// isExcluded reports whether domain is on the exclusion list. Matching
+// ignores case and trailing dots.
+func (c *ConfigFile) isExcluded(domain string) bool {
+	domain = normalizeDomain(domain)
+	for _, ex := range c.Exclude {
+		if normalizeDomain(ex) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// managedDomains returns the domain map without excluded domains, and
+// without domains outside the update's scope.
+func (c *ConfigFile) managedDomains() Config {
+	if len(c.Exclude) == 0 && c.scope == nil {
+		return c.Domains
+	}
+	managed := make(Config, len(c.Domains))
+	for domain, nameservers := range c.Domains {
+		if c.isExcluded(domain) {
+			slog.Warn("Domain is excluded, leaving its split DNS route untouched", "domain", domain)
+			continue
+		}
+		if !c.inScope(domain) {
+			continue
+		}
+		managed[domain] = nameservers
+	}
+	return managed
+}
+
+// preserveExcluded copies the current routes of excluded domains, and of
+// domains outside the update's scope, into desired, so a full replacement
+// neither changes nor deletes them.
+func (c *ConfigFile) preserveExcluded(desired, current map[string][]string) {
+	for domain, nameservers := range current {
+		if c.isExcluded(domain) || !c.inScope(domain) {
+			desired[domain] = nameservers
+		}
+	}
+}
+
+func (c *ConfigFile) validate() error {
+	if c.Version > configVersion {
+		return fmt.Errorf("config version %d is newer than this tsddns supports (%d)", c.Version, configVersion)
+	}
+	switch c.Defaults.Family {
+	case "", "ipv4", "ipv6", "both":
+	default:
+		return fmt.Errorf("defaults: unknown address family %q", c.Defaults.Family)
+	}
+	switch c.Defaults.Order {
+	case "", "rotate", "shuffle":
+	default:
+		return fmt.Errorf("defaults: unknown order %q", c.Defaults.Order)
+	}
+	for kind := range c.Defaults.CacheTTL {
+		if _, ok := selectorKinds[kind]; !ok {
+			return fmt.Errorf("defaults: cacheTTL for unknown selector %q", kind)
+		}
+	}
+	if c.Defaults.DeviceListTTL < 0 {
+		return fmt.Errorf("defaults: deviceListTTL can't be negative")
+	}
+	for key := range c.Defaults.Posture {
+		if !slices.Contains(postureOptions, key) {
+			return fmt.Errorf("defaults: unknown posture requirement %q", key)
+		}
+	}
+	if _, err := c.Defaults.posture(); err != nil {
+		return fmt.Errorf("defaults: %w", err)
+	}
+	if c.Probe != nil {
+		if err := c.Probe.validate(); err != nil {
+			return fmt.Errorf("probe: %w", err)
+		}
+	}
+	switch c.Mode {
+	case "", "split":
+	case "global":
+		if len(c.Global) == 0 {
+			return fmt.Errorf("global mode needs global nameservers")
+		}
+		if len(c.Domains) > 0 || c.Discover != nil || c.ReverseZones != nil {
+			return fmt.Errorf("global mode doesn't manage split DNS; remove domains, discover and reverseZones")
+		}
+		if c.Preferences != nil && c.Preferences.OverrideLocalDNS != nil && !*c.Preferences.OverrideLocalDNS {
+			return fmt.Errorf("global mode always overrides local DNS")
+		}
+	case "records":
+		if !c.hasRecordBackends() {
+			return fmt.Errorf("records mode needs a record backend, like rfc2136, cloudflare or powerdns")
+		}
+		if len(c.Domains) > 0 || c.Discover != nil || c.ReverseZones != nil || len(c.Global) > 0 {
+			return fmt.Errorf("records mode doesn't manage tailnet DNS; remove domains, discover, reverseZones and global")
+		}
+	case "server":
+		if c.Server == nil {
+			return fmt.Errorf("server mode needs server")
+		}
+		if len(c.Global) > 0 {
+			return fmt.Errorf("server mode doesn't manage tailnet DNS; remove global")
+		}
+	default:
+		return fmt.Errorf("unknown mode %q", c.Mode)
+	}
+	if c.Server != nil {
+		if err := c.Server.validate(); err != nil {
+			return fmt.Errorf("server: %w", err)
+		}
+	}
+	if err := c.validateRecordBackends(); err != nil {
+		return err
+	}
+	if c.Backend != "" {
+		if _, ok := backends[c.Backend]; !ok {
+			return fmt.Errorf("unknown backend %q, want one of %s", c.Backend, strings.Join(backendNames(), ", "))
+		}
+		if c.Mode != "" && c.Mode != "split" {
+			return fmt.Errorf("backend applies split DNS, which %s mode doesn't", c.Mode)
+		}
+	}
+	if (c.Backend == "file") != (c.BackendFile != "") {
+		return fmt.Errorf("backendFile goes with the file backend")
+	}
+	if c.CoreDNSConfigMap != nil && c.Mode != "" && c.Mode != "split" {
+		return fmt.Errorf("coreDNSConfigMap needs split DNS, which %s mode doesn't apply", c.Mode)
+	}
+	for _, domain := range c.SearchPaths {
+		if domain == "" || strings.ContainsAny(domain, " /:") {
+			return fmt.Errorf("searchPaths: %q is not a domain", domain)
+		}
+	}
+	if c.ReverseZones != nil {
+		if err := c.ReverseZones.validate(); err != nil {
+			return fmt.Errorf("reverseZones: %w", err)
+		}
+	}
+	if c.Discover != nil {
+		if err := c.Discover.validate(); err != nil {
+			return fmt.Errorf("discover: %w", err)
+		}
+	}
+	for i, e := range c.Exports {
+		if err := e.validate(); err != nil {
+			return fmt.Errorf("exports[%d]: %w", i, err)
+		}
+	}
+	for i, h := range c.FlushHooks {
+		if err := h.validate(); err != nil {
+			return fmt.Errorf("flushHooks[%d]: %w", i, err)
+		}
+	}
+	for i, n := range c.Notify {
+		if err := n.validate(); err != nil {
+			return fmt.Errorf("notify[%d]: %w", i, err)
+		}
+	}
+	if c.Email != nil {
+		if err := c.Email.validate(); err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+	}
+	if c.MQTT != nil {
+		if err := c.MQTT.validate(); err != nil {
+			return fmt.Errorf("mqtt: %w", err)
+		}
+		if c.Mode != "" && c.Mode != "split" {
+			return fmt.Errorf("mqtt publishes split DNS changes, which %s mode doesn't apply", c.Mode)
+		}
+	}
+	if c.Drift != nil {
+		if err := c.Drift.validate(); err != nil {
+			return fmt.Errorf("drift: %w", err)
+		}
+		if c.Mode != "" && c.Mode != "split" {
+			return fmt.Errorf("drift checks split DNS, which %s mode doesn't apply", c.Mode)
+		}
+	}
+	if err := c.validateSchedules(); err != nil {
+		return err
+	}
+	if len(c.Notify) > 0 && c.Mode != "" && c.Mode != "split" {
+		return fmt.Errorf("notify reports split DNS changes, which %s mode doesn't apply", c.Mode)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		configJSON  string
+		wantErr     bool
+		wantDomains int
+		wantHooks   int
+	}{
+		{
+			name:        "legacy format",
+			configJSON:  `{"example.com": ["192.168.1.1"], "other.com": ["svc:dns"]}`,
+			wantDomains: 2,
+		},
+		{
+			name: "structured format",
+			configJSON: `{
+				"domains": {"example.com": ["192.168.1.1"]},
+				"ttlHints": {"example.com": "5m"},
+				"flushHooks": [{"type": "systemd-resolved"}]
+			}`,
+			wantDomains: 1,
+			wantHooks:   1,
+		},
+		{
+			name:       "structured without domains",
+			configJSON: `{"ttlHints": {}}`,
+		},
+		{
+			name:       "bad ttl hint",
+			configJSON: `{"domains": {}, "ttlHints": {"example.com": "soon"}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "unknown hook type",
+			configJSON: `{"domains": {}, "flushHooks": [{"type": "nscd"}]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "bad probe type",
+			configJSON: `{"domains": {}, "probe": {"type": "PTR"}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "global mode without nameservers",
+			configJSON: `{"mode": "global"}`,
+			wantErr:    true,
+		},
+		{
+			name:       "global mode with domains",
+			configJSON: `{"mode": "global", "global": ["1.1.1.1"], "domains": {"example.com": ["10.0.0.1"]}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "global mode without override",
+			configJSON: `{"mode": "global", "global": ["1.1.1.1"], "preferences": {"overrideLocalDNS": false}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "records mode without a backend",
+			configJSON: `{"mode": "records"}`,
+			wantErr:    true,
+		},
+		{
+			name:       "record outside zone",
+			configJSON: `{"domains": {}, "rfc2136": {"server": "ns1", "zone": "internal.example.com", "records": {"ns.example.com": ["tag:dns"]}}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "cloudflare without zone",
+			configJSON: `{"mode": "records", "cloudflare": {"records": {"ns.example.com": ["tag:dns"]}}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "server mode without server",
+			configJSON: `{"mode": "server", "domains": {"example.com": ["10.0.0.1"]}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "server with bad upstream",
+			configJSON: `{"mode": "server", "server": {"upstreams": ["dns.google"]}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "coredns configmap in server mode",
+			configJSON: `{"mode": "server", "server": {}, "coreDNSConfigMap": {}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "file backend",
+			configJSON: `{"domains": {}, "backend": "file", "backendFile": "/var/lib/tsddns/splitdns.json"}`,
+		},
+		{
+			name:       "unknown backend",
+			configJSON: `{"domains": {}, "backend": "bind"}`,
+			wantErr:    true,
+		},
+		{
+			name:       "file backend without a file",
+			configJSON: `{"domains": {}, "backend": "file"}`,
+			wantErr:    true,
+		},
+		{
+			name:       "backend in records mode",
+			configJSON: `{"mode": "records", "backend": "tailscale", "hostsFile": {"path": "/etc/hosts", "records": {"a.example.com": ["100.64.0.1"]}}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "unknown mode",
+			configJSON: `{"domains": {}, "mode": "both"}`,
+			wantErr:    true,
+		},
+		{
+			name:       "bad search path",
+			configJSON: `{"domains": {}, "searchPaths": ["corp.example.com", "https://example.com"]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "discover domain without label",
+			configJSON: `{"domains": {}, "discover": {"domain": "corp.example.com"}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "webhook without url",
+			configJSON: `{"domains": {}, "flushHooks": [{"type": "webhook"}]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "notification without url",
+			configJSON: `{"domains": {}, "notify": [{"headers": {"Authorization": "Bearer x"}}]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "notification with unknown format",
+			configJSON: `{"domains": {}, "notify": [{"url": "https://example.com/hook", "format": "teams"}]}`,
+			wantErr:    true,
+		},
+		{
+			name:       "email without recipients",
+			configJSON: `{"domains": {}, "email": {"server": "smtp.example.com:587", "from": "tsddns@example.com"}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "email server without port",
+			configJSON: `{"domains": {}, "email": {"server": "smtp.example.com", "from": "tsddns@example.com", "to": ["ops@example.com"]}}`,
+			wantErr:    true,
+		},
+		{
+			name:       "notification in global mode",
+			configJSON: `{"mode": "global", "global": ["100.64.0.1"], "notify": [{"url": "https://example.com/hook"}]}`,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseConfig([]byte(tt.configJSON))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(cfg.Domains) != tt.wantDomains {
+				t.Errorf("got %d domains, want %d", len(cfg.Domains), tt.wantDomains)
+			}
+			if len(cfg.FlushHooks) != tt.wantHooks {
+				t.Errorf("got %d hooks, want %d", len(cfg.FlushHooks), tt.wantHooks)
+			}
+		})
+	}
+}
+
+func TestParseConfigTTLHints(t *testing.T) {
+	cfg, err := parseConfig([]byte(`{"domains": {}, "ttlHints": {"example.com": "90s"}}`))
+	if err != nil {
+		t.Fatalf("parseConfig() unexpected error: %v", err)
+	}
+	if got := time.Duration(cfg.TTLHints["example.com"]); got != 90*time.Second {
+		t.Errorf("ttl hint = %v, want 90s", got)
+	}
+}
+
+func TestExcludedDomains(t *testing.T) {
+	cfg := &ConfigFile{
+		Domains: Config{
+			"example.com":        {"192.168.1.1"},
+			"manual.example.com": {"192.168.1.2"},
+		},
+		Exclude: []string{"Manual.Example.com.", "other.example.com"},
+	}
+
+	managed := cfg.managedDomains()
+	if _, ok := managed["manual.example.com"]; ok || len(managed) != 1 {
+		t.Errorf("managedDomains() = %v, want only example.com", managed)
+	}
+
+	desired := map[string][]string{"example.com": {"192.168.1.1"}}
+	current := map[string][]string{
+		"manual.example.com": {"10.0.0.1"},
+		"other.example.com":  {"10.0.0.2"},
+		"stale.example.com":  {"10.0.0.3"},
+	}
+	cfg.preserveExcluded(desired, current)
+
+	if got := desired["manual.example.com"]; len(got) != 1 || got[0] != "10.0.0.1" {
+		t.Errorf("manual.example.com = %v, want current route [10.0.0.1]", got)
+	}
+	if _, ok := desired["other.example.com"]; !ok {
+		t.Error("other.example.com was dropped, want it preserved")
+	}
+	if _, ok := desired["stale.example.com"]; ok {
+		t.Error("stale.example.com was preserved, want it removed")
+	}
+}
+
+func TestConfigFallbackChains(t *testing.T) {
+	cfg, err := parseConfig([]byte(`{"domains": {"example.com": [["svc:dns", "device:ns-backup", "10.0.0.53"], "192.168.1.1"]}}`))
+	if err != nil {
+		t.Fatalf("parseConfig() unexpected error: %v", err)
+	}
+	want := []string{"svc:dns || device:ns-backup || 10.0.0.53", "192.168.1.1"}
+	if got := cfg.Domains["example.com"]; !slices.Equal(got, want) {
+		t.Errorf("example.com = %q, want %q", got, want)
+	}
+
+	out, err := json.Marshal(cfg.Domains)
+	if err != nil {
+		t.Fatalf("Marshal() unexpected error: %v", err)
+	}
+	if want := `{"example.com":[["svc:dns","device:ns-backup","10.0.0.53"],"192.168.1.1"]}`; string(out) != want {
+		t.Errorf("Marshal() = %s, want %s", out, want)
+	}
+
+	if _, err := parseConfig([]byte(`{"domains": {"example.com": [[]]}}`)); err == nil {
+		t.Error("parseConfig() accepted an empty fallback chain")
+	}
+}
+
+func TestConfigGlobalNameservers(t *testing.T) {
+	cfg, err := parseConfig([]byte(`{"domains": {}, "global": [["svc:dns", "1.1.1.1"], "9.9.9.9"]}`))
+	if err != nil {
+		t.Fatalf("parseConfig() unexpected error: %v", err)
+	}
+	want := Nameservers{"svc:dns || 1.1.1.1", "9.9.9.9"}
+	if !slices.Equal(cfg.Global, want) {
+		t.Errorf("global = %q, want %q", cfg.Global, want)
+	}
+
+	out, err := json.Marshal(cfg.Global)
+	if err != nil {
+		t.Fatalf("Marshal() unexpected error: %v", err)
+	}
+	if want := `[["svc:dns","1.1.1.1"],"9.9.9.9"]`; string(out) != want {
+		t.Errorf("Marshal() = %s, want %s", out, want)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// consulAddr and consulToken are the Consul agent and ACL token used by
+// consul-svc: selectors. They're set by the -consul-addr and -consul-token
+// flags.
+var (
+	consulAddr  = "http://127.0.0.1:8500"
+	consulToken = ""
+)
+
+// consulServiceEntry is the part of a Consul health entry tsddns uses.
+type consulServiceEntry struct {
+	Node struct {
+		Node    string `json:"Node"`
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string `json:"Address"`
+	} `json:"Service"`
+}
+
+// address is the instance's service address, or its node's address if the
+// service didn't register one.
+func (e consulServiceEntry) address() string {
+	if e.Service.Address != "" {
+		return e.Service.Address
+	}
+	return e.Node.Address
+}
+
+// getConsulService returns the addresses of the healthy instances of a
+// service, written name or name@datacenter, sorted by node name. If tag is
+// set, only instances with that tag are returned.
+func getConsulService(ctx context.Context, addr, token, service, tag string) ([]string, error) {
+	name, dc, _ := strings.Cut(service, "@")
+
+	query := url.Values{"passing": {"true"}}
+	if dc != "" {
+		query.Set("dc", dc)
+	}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	// CONSUL_HTTP_ADDR is often given without a scheme
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u := fmt.Sprintf("%s/v1/health/service/%s?%s", strings.TrimSuffix(addr, "/"), url.PathEscape(name), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("querying Consul: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Consul returned status %d", resp.StatusCode)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decoding Consul response: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Node.Node < entries[j].Node.Node })
+
+	var addrs []string
+	for _, e := range entries {
+		if a := e.address(); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no healthy instances of %s", service)
+	}
+	return addrs, nil
+}
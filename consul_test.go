@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestResolveConsul(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Consul-Token") != "secret" {
+			http.Error(w, "ACL not found", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/health/service/dns" || r.URL.Query().Get("passing") != "true" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		switch {
+		case r.URL.Query().Get("dc") == "dc2":
+			w.Write([]byte(`[{"Node": {"Node": "dc2-ns", "Address": "10.2.0.53"}, "Service": {}}]`))
+		case r.URL.Query().Get("tag") == "primary":
+			w.Write([]byte(`[{"Node": {"Node": "ns-b", "Address": "10.0.0.2"}, "Service": {"Address": "10.0.1.2"}}]`))
+		default:
+			w.Write([]byte(`[
+				{"Node": {"Node": "ns-b", "Address": "10.0.0.2"}, "Service": {"Address": "10.0.1.2"}},
+				{"Node": {"Node": "ns-a", "Address": "10.0.0.1"}, "Service": {}},
+				{"Node": {"Node": "ns-c", "Address": "fd00::3"}, "Service": {}}
+			]`))
+		}
+	}))
+	defer server.Close()
+
+	oldAddr, oldToken := consulAddr, consulToken
+	consulAddr, consulToken = server.URL, "secret"
+	defer func() { consulAddr, consulToken = oldAddr, oldToken }()
+
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "healthy instances", entry: "consul-svc:dns", want: []string{"10.0.0.1", "10.0.1.2", "fd00::3"}},
+		{name: "ipv4 only", entry: "consul-svc:dns?family=ipv4", want: []string{"10.0.0.1", "10.0.1.2"}},
+		{name: "capped", entry: "consul-svc:dns?max=1", want: []string{"10.0.0.1"}},
+		{name: "datacenter", entry: "consul-svc:dns@dc2", want: []string{"10.2.0.53"}},
+		{name: "tag", entry: "consul-svc:dns?tag=primary", want: []string{"10.0.1.2"}},
+		{name: "unknown service", entry: "consul-svc:ntp", wantErr: true},
+	}
+
+	r := newResolver(nil, Defaults{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// CoreDNSConfigMap configures the cluster CoreDNS ConfigMap tsddns adds
+// forward stanzas for the split DNS domains to, so that pods resolve them
+// like tailnet clients do. tsddns only ever changes its own marked block
+// of the config.
+type CoreDNSConfigMap struct {
+	// Namespace is the ConfigMap's namespace. Defaults to "kube-system".
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name is the ConfigMap's name. Defaults to "coredns".
+	Name string `json:"name,omitempty"`
+
+	// Key is the ConfigMap key holding the config. Defaults to
+	// "Corefile"; set it to a dedicated key, like "tsddns.server" in the
+	// "coredns-custom" ConfigMap of k3s and AKS, to keep tsddns's stanzas
+	// out of the main Corefile.
+	Key string `json:"key,omitempty"`
+}
+
+func (c *CoreDNSConfigMap) namespace() string { return cmp.Or(c.Namespace, "kube-system") }
+func (c *CoreDNSConfigMap) name() string      { return cmp.Or(c.Name, "coredns") }
+func (c *CoreDNSConfigMap) key() string       { return cmp.Or(c.Key, "Corefile") }
+
+// k8sConfigMap is the part of a Kubernetes ConfigMap tsddns uses.
+type k8sConfigMap struct {
+	Metadata struct {
+		Name            string `json:"name,omitempty"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+// addCoreDNSStep queues a write of the forward stanzas for splitDNS to the
+// CoreDNS ConfigMap if they changed. Rolling it back restores the block
+// that was there before, leaving the rest of the config as it is then.
+func addCoreDNSStep(ctx context.Context, r *resolver, tx *transaction, c *CoreDNSConfigMap, splitDNS map[string][]string) error {
+	k, err := r.kube()
+	if err != nil {
+		return fmt.Errorf("CoreDNS ConfigMap: %w", err)
+	}
+	cm, err := k.getConfigMap(ctx, c.namespace(), c.name())
+	if err != nil {
+		return fmt.Errorf("fetching CoreDNS ConfigMap: %w", err)
+	}
+	var old []string
+	if cm != nil {
+		old = slices.Clone(findManagedBlock(configLines(cm.Data[c.key()])))
+	}
+	block := managedBlock(coreDNSServerBlocks(splitDNS))
+	if slices.Equal(old, block) {
+		slog.InfoContext(ctx, "CoreDNS ConfigMap is up to date")
+		return nil
+	}
+	slog.InfoContext(ctx, "Updating the split DNS domains in the CoreDNS ConfigMap", "namespace", c.namespace(), "name", c.name())
+	tx.add("CoreDNS ConfigMap",
+		func(ctx context.Context) error { return k.setConfigMapBlock(ctx, c, block) },
+		func(ctx context.Context) error { return k.setConfigMapBlock(ctx, c, old) },
+	)
+	return nil
+}
+
+// configLines splits a config into lines.
+func configLines(config string) []string {
+	if config == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(config, "\n"), "\n")
+}
+
+// setConfigMapBlock replaces tsddns's block in the ConfigMap's key with
+// block, creating the ConfigMap if it doesn't exist. The change is
+// conditional on the ConfigMap's resourceVersion, so a concurrent edit
+// fails it rather than being overwritten.
+func (k *k8sClient) setConfigMapBlock(ctx context.Context, c *CoreDNSConfigMap, block []string) error {
+	cm, err := k.getConfigMap(ctx, c.namespace(), c.name())
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/configmaps", c.namespace())
+	method := "POST"
+	contentType := "application/json"
+	if cm == nil {
+		cm = &k8sConfigMap{}
+		cm.Metadata.Name = c.name()
+	} else {
+		path += "/" + c.name()
+		method = "PATCH"
+		contentType = "application/merge-patch+json"
+	}
+
+	lines := replaceManagedBlock(configLines(cm.Data[c.key()]), block)
+	config := ""
+	if len(lines) > 0 {
+		config = strings.Join(lines, "\n") + "\n"
+	}
+	cm.Data = map[string]string{c.key(): config}
+	body, err := json.Marshal(cm)
+	if err != nil {
+		return err
+	}
+
+	req, err := k.newRequest(ctx, method, path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("ConfigMap %s/%s changed while it was being updated", c.namespace(), c.name())
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Kubernetes API returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// getConfigMap returns a ConfigMap, or nil if it doesn't exist.
+func (k *k8sClient) getConfigMap(ctx context.Context, namespace, name string) (*k8sConfigMap, error) {
+	req, err := k.newRequest(ctx, "GET", fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", namespace, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Kubernetes API returned status %d", resp.StatusCode)
+	}
+	var cm k8sConfigMap
+	if err := json.NewDecoder(resp.Body).Decode(&cm); err != nil {
+		return nil, fmt.Errorf("decoding ConfigMap: %w", err)
+	}
+	return &cm, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeConfigMaps is a Kubernetes API server holding ConfigMaps, which
+// enforces resourceVersion preconditions on patches.
+type fakeConfigMaps struct {
+	data    map[string]map[string]string // by path
+	version int
+	edit    func() // runs before a patch is applied, to simulate a concurrent edit
+}
+
+func (f *fakeConfigMaps) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var cm k8sConfigMap
+	switch r.Method {
+	case "GET":
+		data, ok := f.data[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		cm.Metadata.ResourceVersion = strconv.Itoa(f.version)
+		cm.Data = data
+		json.NewEncoder(w).Encode(cm)
+	case "PATCH":
+		if f.edit != nil {
+			f.edit()
+		}
+		json.NewDecoder(r.Body).Decode(&cm)
+		if r.Header.Get("Content-Type") != "application/merge-patch+json" || cm.Metadata.ResourceVersion != strconv.Itoa(f.version) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		for k, v := range cm.Data {
+			f.data[r.URL.Path][k] = v
+		}
+		f.version++
+	case "POST":
+		json.NewDecoder(r.Body).Decode(&cm)
+		f.data[r.URL.Path+"/"+cm.Metadata.Name] = cm.Data
+		f.version++
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func TestCoreDNSConfigMap(t *testing.T) {
+	const path = "/api/v1/namespaces/kube-system/configmaps/coredns"
+	corefile := ".:53 {\n    forward . /etc/resolv.conf\n}\n"
+	api := &fakeConfigMaps{data: map[string]map[string]string{
+		path: {"Corefile": corefile, "other": "untouched"},
+	}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	r := newResolver(nil, Defaults{})
+	r.k8s = &k8sClient{host: server.URL, http: server.Client()}
+	c := &CoreDNSConfigMap{}
+	splitDNS := map[string][]string{
+		"corp.example.com": {"100.64.0.1", "100.64.0.2"},
+		"doh.example.com":  {"https://dns.example.com/dns-query"},
+	}
+	ctx := context.Background()
+
+	var tx transaction
+	if err := addCoreDNSStep(ctx, r, &tx, c, splitDNS); err != nil {
+		t.Fatalf("addCoreDNSStep() unexpected error: %v", err)
+	}
+	if err := tx.commit(ctx); err != nil {
+		t.Fatalf("commit() unexpected error: %v", err)
+	}
+	want := corefile + "# BEGIN tsddns\n\ncorp.example.com {\n\tforward . 100.64.0.1 100.64.0.2\n}\n# END tsddns\n"
+	if got := api.data[path]["Corefile"]; got != want {
+		t.Errorf("Corefile = %q, want %q", got, want)
+	}
+	if api.data[path]["other"] != "untouched" {
+		t.Errorf("other key = %q, want it untouched", api.data[path]["other"])
+	}
+
+	// nothing to do when the block is current
+	tx = transaction{}
+	if err := addCoreDNSStep(ctx, r, &tx, c, splitDNS); err != nil {
+		t.Fatalf("addCoreDNSStep() unexpected error: %v", err)
+	}
+	if len(tx.steps) != 0 {
+		t.Errorf("queued %d steps for an unchanged block, want none", len(tx.steps))
+	}
+
+	// a failed cycle puts the old block back, keeping edits made since
+	tx = transaction{}
+	if err := addCoreDNSStep(ctx, r, &tx, c, map[string][]string{"lab.example.com": {"10.0.0.53"}}); err != nil {
+		t.Fatalf("addCoreDNSStep() unexpected error: %v", err)
+	}
+	tx.add("next step", func(context.Context) error {
+		api.data[path]["Corefile"] = "# edited\n" + api.data[path]["Corefile"]
+		api.version++
+		return errors.New("failed")
+	}, nil)
+	if err := tx.commit(ctx); err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("commit() error = %v, want a rolled back failure", err)
+	}
+	if got := api.data[path]["Corefile"]; got != "# edited\n"+want {
+		t.Errorf("Corefile after rollback = %q, want %q", got, "# edited\n"+want)
+	}
+
+	// a concurrent edit fails the write rather than being overwritten
+	api.edit = func() { api.version++ }
+	tx = transaction{}
+	addCoreDNSStep(ctx, r, &tx, c, nil)
+	if err := tx.commit(ctx); err == nil || !strings.Contains(err.Error(), "changed while") {
+		t.Errorf("commit() error = %v, want a conflict", err)
+	}
+}
+
+func TestCoreDNSConfigMapCreate(t *testing.T) {
+	api := &fakeConfigMaps{data: map[string]map[string]string{}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	r := newResolver(nil, Defaults{})
+	r.k8s = &k8sClient{host: server.URL, http: server.Client()}
+	c := &CoreDNSConfigMap{Name: "coredns-custom", Key: "tsddns.server"}
+
+	var tx transaction
+	if err := addCoreDNSStep(context.Background(), r, &tx, c, map[string][]string{"corp.example.com": {"100.64.0.1"}}); err != nil {
+		t.Fatalf("addCoreDNSStep() unexpected error: %v", err)
+	}
+	if err := tx.commit(context.Background()); err != nil {
+		t.Fatalf("commit() unexpected error: %v", err)
+	}
+	want := "# BEGIN tsddns\n\ncorp.example.com {\n\tforward . 100.64.0.1\n}\n# END tsddns\n"
+	if got := api.data["/api/v1/namespaces/kube-system/configmaps/coredns-custom"]["tsddns.server"]; got != want {
+		t.Errorf("tsddns.server = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func getDeviceIP(hostname string, devices []tailscale.Device) (string, error) {
+	device, err := findDevice(hostname, devices)
+	if err != nil {
+		return "", err
+	}
+	if len(device.Addresses) == 0 {
+		return "", fmt.Errorf("device %s has no addresses", hostname)
+	}
+	return device.Addresses[0], nil
+}
+
+// findDevice returns the first device whose hostname or MagicDNS name is
+// hostname, or whose MagicDNS name starts with hostname.
+func findDevice(hostname string, devices []tailscale.Device) (tailscale.Device, error) {
+	for _, device := range devices {
+		if device.Hostname == hostname || device.Name == hostname || strings.HasPrefix(device.Name, hostname+".") {
+			return device, nil
+		}
+	}
+	return tailscale.Device{}, fmt.Errorf("device %s not found", hostname)
+}
+
+// findNodeDevice resolves a nodeid: selector to the device with that stable
+// node ID, which survives renames. deviceIDs maps node IDs to device IDs.
+func findNodeDevice(nodeID string, deviceIDs map[string]string, devices []tailscale.Device) ([]tailscale.Device, error) {
+	if nodeID == "" {
+		return nil, fmt.Errorf("nodeid: needs a node ID")
+	}
+	if id, ok := deviceIDs[nodeID]; ok {
+		for _, device := range devices {
+			if device.ID == id {
+				return []tailscale.Device{device}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no device with node ID %s", nodeID)
+}
+
+// deviceIDsByNode returns the tailnet's device IDs by stable node ID,
+// fetching them at most once per update. The client's Device doesn't carry
+// the node ID, so they're read from the device list's JSON directly.
+func (r *resolver) deviceIDsByNode(ctx context.Context) (map[string]string, error) {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+	if r.nodeIDs != nil {
+		return r.nodeIDs, nil
+	}
+	var resp struct {
+		Devices []struct {
+			ID     string `json:"id"`
+			NodeID string `json:"nodeId"`
+		} `json:"devices"`
+	}
+	if err := apiGet(ctx, r.client, "devices", &resp); err != nil {
+		return nil, fmt.Errorf("listing node IDs: %w", err)
+	}
+	ids := make(map[string]string, len(resp.Devices))
+	for _, d := range resp.Devices {
+		if d.NodeID != "" {
+			ids[d.NodeID] = d.ID
+		}
+	}
+	r.nodeIDs = ids
+	return ids, nil
+}
+
+// findDevices resolves a device: selector. A name starting with "~" is a
+// regular expression and a name containing glob characters is a glob; both
+// return every matching device with an address, sorted by name. Any other
+// name is looked up with findDevice.
+func findDevices(name string, devices []tailscale.Device) ([]tailscale.Device, error) {
+	var match func(string) bool
+	if pattern, ok := strings.CutPrefix(name, "~"); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad device pattern %q: %w", pattern, err)
+		}
+		match = re.MatchString
+	} else if strings.ContainsAny(name, "*?[") {
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, fmt.Errorf("bad device pattern %q: %w", name, err)
+		}
+		match = func(s string) bool {
+			matched, _ := path.Match(name, s)
+			return matched
+		}
+	} else {
+		device, err := findDevice(name, devices)
+		if err != nil {
+			return nil, err
+		}
+		return []tailscale.Device{device}, nil
+	}
+
+	var matched []tailscale.Device
+	for _, device := range devices {
+		magicName, _, _ := strings.Cut(device.Name, ".")
+		if match(device.Hostname) || match(magicName) {
+			matched = append(matched, device)
+		}
+	}
+	matched = withAddresses(matched)
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no devices matching %s with an address", name)
+	}
+	return matched, nil
+}
+
+// findTaggedDevices returns the devices carrying tag that have an address,
+// sorted by name. The tag may be given with or without its "tag:" prefix.
+func findTaggedDevices(tag string, devices []tailscale.Device) ([]tailscale.Device, error) {
+	tag = "tag:" + strings.TrimPrefix(tag, "tag:")
+
+	var tagged []tailscale.Device
+	for _, device := range devices {
+		if slices.Contains(device.Tags, tag) {
+			tagged = append(tagged, device)
+		}
+	}
+	tagged = withAddresses(tagged)
+	if len(tagged) == 0 {
+		return nil, fmt.Errorf("no devices with %s and an address", tag)
+	}
+	return tagged, nil
+}
+
+// findUserDevices returns the devices owned by user that have an address,
+// sorted by name. If hostGlob is set, only devices whose hostname matches
+// it are considered.
+func findUserDevices(user, hostGlob string, devices []tailscale.Device) ([]tailscale.Device, error) {
+	var owned []tailscale.Device
+	for _, device := range devices {
+		if !strings.EqualFold(device.User, user) {
+			continue
+		}
+		if hostGlob != "" {
+			matched, err := matchHostGlob(hostGlob, device)
+			if err != nil {
+				return nil, err
+			}
+			if !matched {
+				continue
+			}
+		}
+		owned = append(owned, device)
+	}
+	owned = withAddresses(owned)
+	if len(owned) == 0 {
+		if hostGlob != "" {
+			return nil, fmt.Errorf("no devices owned by %s matching %s with an address", user, hostGlob)
+		}
+		return nil, fmt.Errorf("no devices owned by %s with an address", user)
+	}
+	return owned, nil
+}
+
+// findGroupDevices returns the devices owned by any of members that have an
+// address, sorted by name. If tag or hostGlob are set, devices must also
+// carry the tag or have a matching hostname.
+func findGroupDevices(group string, members []string, tag, hostGlob string, devices []tailscale.Device) ([]tailscale.Device, error) {
+	if tag != "" {
+		tag = "tag:" + strings.TrimPrefix(tag, "tag:")
+	}
+
+	var owned []tailscale.Device
+	for _, device := range devices {
+		if !slices.ContainsFunc(members, func(m string) bool { return strings.EqualFold(m, device.User) }) {
+			continue
+		}
+		if tag != "" && !slices.Contains(device.Tags, tag) {
+			continue
+		}
+		if hostGlob != "" {
+			matched, err := matchHostGlob(hostGlob, device)
+			if err != nil {
+				return nil, err
+			}
+			if !matched {
+				continue
+			}
+		}
+		owned = append(owned, device)
+	}
+	owned = withAddresses(owned)
+	if len(owned) == 0 {
+		return nil, fmt.Errorf("no devices owned by members of %s with an address", group)
+	}
+	return owned, nil
+}
+
+// exitNodeRoutes are the routes a device advertises to offer itself as an
+// exit node.
+var exitNodeRoutes = []string{"0.0.0.0/0", "::/0"}
+
+// routeLookup returns a device's advertised and approved subnet routes.
+type routeLookup func(tailscale.Device) (*tailscale.DeviceRoutes, error)
+
+// deviceRoutes returns the device's subnet routes, fetching them at most
+// once per update since the device list doesn't carry them.
+func (r *resolver) deviceRoutes(ctx context.Context, device tailscale.Device) (*tailscale.DeviceRoutes, error) {
+	r.mu.Lock()
+	routes, ok := r.routes[device.ID]
+	r.mu.Unlock()
+	if ok {
+		return routes, nil
+	}
+
+	routes, err := r.client.Devices().SubnetRoutes(ctx, device.ID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching routes of %s: %w", device.Name, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.routes == nil {
+		r.routes = make(map[string]*tailscale.DeviceRoutes)
+	}
+	r.routes[device.ID] = routes
+	return routes, nil
+}
+
+// isExitNode reports whether routes include an exit node route that is
+// both advertised and approved.
+func isExitNode(routes *tailscale.DeviceRoutes) bool {
+	for _, route := range exitNodeRoutes {
+		if isApprovedRoute(routes, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// isApprovedRoute reports whether the device both advertises route and has
+// it approved by an admin.
+func isApprovedRoute(routes *tailscale.DeviceRoutes, route string) bool {
+	return slices.Contains(routes.Advertised, route) && slices.Contains(routes.Enabled, route)
+}
+
+// findSubnetRouters returns the devices with an approved route covering
+// prefix that have an address, sorted by name.
+func findSubnetRouters(prefix string, devices []tailscale.Device, routes routeLookup) ([]tailscale.Device, error) {
+	want, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("bad route %q: %w", prefix, err)
+	}
+	want = want.Masked()
+
+	var routers []tailscale.Device
+	for _, device := range withAddresses(devices) {
+		deviceRoutes, err := routes(device)
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range deviceRoutes.Advertised {
+			p, err := netip.ParsePrefix(route)
+			if err != nil || !isApprovedRoute(deviceRoutes, route) {
+				continue
+			}
+			if p.Bits() <= want.Bits() && p.Contains(want.Addr()) {
+				routers = append(routers, device)
+				break
+			}
+		}
+	}
+	if len(routers) == 0 {
+		return nil, fmt.Errorf("no subnet routers for %s with an address", want)
+	}
+	return routers, nil
+}
+
+// findExitNodes returns the approved exit nodes that have an address, sorted
+// by name. If hostGlob is set, only devices whose hostname matches it are
+// considered.
+func findExitNodes(hostGlob string, devices []tailscale.Device, routes routeLookup) ([]tailscale.Device, error) {
+	var exitNodes []tailscale.Device
+	for _, device := range withAddresses(devices) {
+		if hostGlob != "" {
+			matched, err := matchHostGlob(hostGlob, device)
+			if err != nil {
+				return nil, err
+			}
+			if !matched {
+				continue
+			}
+		}
+		deviceRoutes, err := routes(device)
+		if err != nil {
+			return nil, err
+		}
+		if isExitNode(deviceRoutes) {
+			exitNodes = append(exitNodes, device)
+		}
+	}
+	if len(exitNodes) == 0 {
+		if hostGlob != "" {
+			return nil, fmt.Errorf("no exit nodes matching %s with an address", hostGlob)
+		}
+		return nil, fmt.Errorf("no exit nodes with an address")
+	}
+	return exitNodes, nil
+}
+
+// matchHostGlob reports whether the device's hostname or the first label of
+// its MagicDNS name matches glob.
+func matchHostGlob(glob string, device tailscale.Device) (bool, error) {
+	magicName, _, _ := strings.Cut(device.Name, ".")
+	for _, name := range []string{device.Hostname, magicName} {
+		matched, err := path.Match(glob, name)
+		if err != nil {
+			return false, fmt.Errorf("bad host pattern %q: %w", glob, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// withAddresses returns the devices that have an address, sorted by name so
+// that results are stable across runs.
+func withAddresses(devices []tailscale.Device) []tailscale.Device {
+	var kept []tailscale.Device
+	for _, device := range devices {
+		if len(device.Addresses) > 0 {
+			kept = append(kept, device)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Name < kept[j].Name })
+	return kept
+}
+
+// filterFamily returns the addresses to use for an entry. An empty family
+// keeps the historical behaviour of using only the first address; "ipv4"
+// and "ipv6" keep the addresses of that family and "both" keeps them all.
+func filterFamily(addrs []string, family string) ([]string, error) {
+	if family == "" {
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("no addresses")
+		}
+		return addrs[:1], nil
+	}
+
+	var kept []string
+	for _, a := range addrs {
+		addr, err := netip.ParseAddr(a)
+		if err != nil {
+			return nil, fmt.Errorf("bad address %q: %w", a, err)
+		}
+		switch family {
+		case "ipv4":
+			if addr.Is4() {
+				kept = append(kept, a)
+			}
+		case "ipv6":
+			if addr.Is6() {
+				kept = append(kept, a)
+			}
+		case "both":
+			kept = append(kept, a)
+		default:
+			return nil, fmt.Errorf("unknown address family %q", family)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("no %s addresses", family)
+	}
+	return kept, nil
+}
+
+// latestSeenDevice returns the device seen most recently, or the first one
+// if none has been seen.
+func latestSeenDevice(devices []tailscale.Device) tailscale.Device {
+	latest := devices[0]
+	for _, device := range devices[1:] {
+		if !device.LastSeen.IsZero() && (latest.LastSeen.IsZero() || device.LastSeen.After(latest.LastSeen.Time)) {
+			latest = device
+		}
+	}
+	return latest
+}
+
+// keyExpired reports whether the device's node key has expired, in which
+// case it can't be reached until it re-authenticates.
+func keyExpired(device tailscale.Device, now time.Time) bool {
+	return !device.KeyExpiryDisabled && !device.Expires.IsZero() && device.Expires.Before(now)
+}
+
+// onlineDevices returns the devices seen within window of now.
+func onlineDevices(devices []tailscale.Device, window time.Duration, now time.Time) []tailscale.Device {
+	var online []tailscale.Device
+	for _, device := range devices {
+		if !device.LastSeen.IsZero() && now.Sub(device.LastSeen.Time) <= window {
+			online = append(online, device)
+		}
+	}
+	return online
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestFindTaggedDevices(t *testing.T) {
+	devices := []tailscale.Device{
+		{Name: "ns2.example.ts.net", Tags: []string{"tag:dns-server"}, Addresses: []string{"100.64.0.2"}},
+		{Name: "ns1.example.ts.net", Tags: []string{"tag:web", "tag:dns-server"}, Addresses: []string{"100.64.0.1"}},
+		{Name: "ns0.example.ts.net", Tags: []string{"tag:dns-server"}},
+		{Name: "web.example.ts.net", Tags: []string{"tag:web"}, Addresses: []string{"100.64.0.3"}},
+	}
+
+	tests := []struct {
+		name      string
+		tag       string
+		wantNames []string
+		wantErr   bool
+	}{
+		{name: "every tagged device by name", tag: "dns-server", wantNames: []string{"ns1.example.ts.net", "ns2.example.ts.net"}},
+		{name: "tag with prefix", tag: "tag:web", wantNames: []string{"ns1.example.ts.net", "web.example.ts.net"}},
+		{name: "no tagged devices", tag: "missing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findTaggedDevices(tt.tag, devices)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("findTaggedDevices() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if names := deviceNames(got); !slices.Equal(names, tt.wantNames) {
+				t.Errorf("findTaggedDevices() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestFindUserDevices(t *testing.T) {
+	devices := []tailscale.Device{
+		{Name: "laptop.example.ts.net", Hostname: "alice-laptop", User: "alice@example.com", Addresses: []string{"100.64.0.1"}},
+		{Name: "ns-home.example.ts.net", Hostname: "pi", User: "alice@example.com", Addresses: []string{"100.64.0.2"}},
+		{Name: "desktop.example.ts.net", Hostname: "bob-desktop", User: "bob@example.com", Addresses: []string{"100.64.0.3"}},
+	}
+
+	tests := []struct {
+		name      string
+		user      string
+		hostGlob  string
+		wantNames []string
+		wantErr   bool
+	}{
+		{name: "every device by name", user: "alice@example.com", wantNames: []string{"laptop.example.ts.net", "ns-home.example.ts.net"}},
+		{name: "user is case insensitive", user: "Bob@Example.com", wantNames: []string{"desktop.example.ts.net"}},
+		{name: "hostname glob", user: "alice@example.com", hostGlob: "alice-*", wantNames: []string{"laptop.example.ts.net"}},
+		{name: "magicdns name glob", user: "alice@example.com", hostGlob: "ns-*", wantNames: []string{"ns-home.example.ts.net"}},
+		{name: "glob matches another user's device", user: "alice@example.com", hostGlob: "bob-*", wantErr: true},
+		{name: "bad glob", user: "alice@example.com", hostGlob: "[", wantErr: true},
+		{name: "unknown user", user: "carol@example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findUserDevices(tt.user, tt.hostGlob, devices)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("findUserDevices() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if names := deviceNames(got); !slices.Equal(names, tt.wantNames) {
+				t.Errorf("findUserDevices() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func deviceNames(devices []tailscale.Device) []string {
+	var names []string
+	for _, d := range devices {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+// routesFrom is a routeLookup serving routes by device ID, with none for
+// devices that aren't listed.
+func routesFrom(routes map[string]tailscale.DeviceRoutes) routeLookup {
+	return func(device tailscale.Device) (*tailscale.DeviceRoutes, error) {
+		r := routes[device.ID]
+		return &r, nil
+	}
+}
+
+func TestDeviceRoutes(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/api/v2/device/12345/routes":
+			w.Write([]byte(`{"advertisedRoutes": ["0.0.0.0/0", "::/0", "10.0.0.0/8"], "enabledRoutes": ["0.0.0.0/0", "::/0"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	r := &resolver{client: &tailscale.Client{BaseURL: baseURL, APIKey: "test-key", Tailnet: "example.com"}}
+
+	device := tailscale.Device{ID: "12345", Name: "gw.example.ts.net", Addresses: []string{"100.64.0.1"}}
+	for range 2 {
+		routes, err := r.deviceRoutes(context.Background(), device)
+		if err != nil {
+			t.Fatalf("deviceRoutes() unexpected error: %v", err)
+		}
+		if !isExitNode(routes) || isApprovedRoute(routes, "10.0.0.0/8") {
+			t.Errorf("deviceRoutes() = %+v, want an approved exit node with 10.0.0.0/8 pending", routes)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("fetched routes %d times, want once per update", n)
+	}
+
+	if _, err := r.deviceRoutes(context.Background(), tailscale.Device{ID: "999", Name: "gone.example.ts.net"}); err == nil {
+		t.Error("deviceRoutes() of an unknown device succeeded")
+	}
+	failing := func(tailscale.Device) (*tailscale.DeviceRoutes, error) {
+		return nil, errors.New("API returned status 500")
+	}
+	if _, err := findExitNodes("", []tailscale.Device{device}, failing); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Error("findExitNodes() hid a failed route lookup")
+	}
+}
+
+func TestFilterFamily(t *testing.T) {
+	addrs := []string{"100.64.0.1", "fd7a:115c:a1e0::1"}
+
+	tests := []struct {
+		name    string
+		addrs   []string
+		family  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "default is first address", addrs: addrs, want: []string{"100.64.0.1"}},
+		{name: "ipv4", addrs: addrs, family: "ipv4", want: []string{"100.64.0.1"}},
+		{name: "ipv6", addrs: addrs, family: "ipv6", want: []string{"fd7a:115c:a1e0::1"}},
+		{name: "both", addrs: addrs, family: "both", want: addrs},
+		{name: "no ipv6 address", addrs: addrs[:1], family: "ipv6", wantErr: true},
+		{name: "no addresses", wantErr: true},
+		{name: "unknown family", addrs: addrs, family: "ipx", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := filterFamily(tt.addrs, tt.family)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("filterFamily() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("filterFamily() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindDevices(t *testing.T) {
+	devices := []tailscale.Device{
+		{Name: "dns-12.example.ts.net", Hostname: "dns-12", Addresses: []string{"100.64.0.12"}},
+		{Name: "dns-3.example.ts.net", Hostname: "dns-3", Addresses: []string{"100.64.0.3"}},
+		{Name: "dns-old.example.ts.net", Hostname: "dns-old", Addresses: []string{"100.64.0.4"}},
+		{Name: "ns-a.example.ts.net", Hostname: "resolver-a", Addresses: []string{"100.64.0.5"}},
+		{Name: "ns-b.example.ts.net", Hostname: "resolver-b"},
+	}
+
+	tests := []struct {
+		name      string
+		pattern   string
+		wantNames []string
+		wantErr   bool
+	}{
+		{name: "exact name", pattern: "dns-3", wantNames: []string{"dns-3.example.ts.net"}},
+		{name: "glob on hostname", pattern: "dns-*", wantNames: []string{"dns-12.example.ts.net", "dns-3.example.ts.net", "dns-old.example.ts.net"}},
+		{name: "glob on magicdns name", pattern: "ns-*", wantNames: []string{"ns-a.example.ts.net"}},
+		{name: "single character glob", pattern: "dns-?", wantNames: []string{"dns-3.example.ts.net"}},
+		{name: "regex", pattern: `~^dns-\d+$`, wantNames: []string{"dns-12.example.ts.net", "dns-3.example.ts.net"}},
+		{name: "no matches", pattern: "web-*", wantErr: true},
+		{name: "bad glob", pattern: "dns-[", wantErr: true},
+		{name: "bad regex", pattern: "~dns-(", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findDevices(tt.pattern, devices)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("findDevices() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if names := deviceNames(got); !slices.Equal(names, tt.wantNames) {
+				t.Errorf("findDevices() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestFindNodeDevice(t *testing.T) {
+	devices := []tailscale.Device{
+		{ID: "1111", Name: "ns1.example.ts.net", Addresses: []string{"100.64.0.1"}},
+		{ID: "2222", Name: "ns2.example.ts.net", Addresses: []string{"100.64.0.2"}},
+	}
+	deviceIDs := map[string]string{"n1111CNTRL": "1111", "n2222CNTRL": "2222", "n4444CNTRL": "4444"}
+
+	tests := []struct {
+		name      string
+		nodeID    string
+		wantNames []string
+		wantErr   bool
+	}{
+		{name: "found", nodeID: "n2222CNTRL", wantNames: []string{"ns2.example.ts.net"}},
+		{name: "hostname is not a node ID", nodeID: "ns1", wantErr: true},
+		{name: "device ID is not a node ID", nodeID: "1111", wantErr: true},
+		{name: "missing", nodeID: "n3333CNTRL", wantErr: true},
+		{name: "not in device list", nodeID: "n4444CNTRL", wantErr: true},
+		{name: "empty", nodeID: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findNodeDevice(tt.nodeID, deviceIDs, devices)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("findNodeDevice() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if names := deviceNames(got); !slices.Equal(names, tt.wantNames) {
+				t.Errorf("findNodeDevice() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestResolveNodeID(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/tailnet/example.com/devices":
+			requests.Add(1)
+			w.Write([]byte(`{"devices": [
+				{"id": "1111", "nodeId": "n1111CNTRL", "name": "ns1.example.ts.net", "addresses": ["100.64.0.1"]},
+				{"id": "2222", "nodeId": "n2222CNTRL", "name": "ns2.example.ts.net", "addresses": ["100.64.0.2", "fd7a:115c:a1e0::2"]}
+			]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	r := &resolver{client: &tailscale.Client{BaseURL: baseURL, APIKey: "test-key", Tailnet: "example.com"}, now: time.Now()}
+	if err := r.listDevices(context.Background()); err != nil {
+		t.Fatalf("listDevices() unexpected error: %v", err)
+	}
+
+	for range 2 {
+		got, err := r.resolve(context.Background(), parseEntry("nodeid:n2222CNTRL"))
+		if err != nil {
+			t.Fatalf("resolve() unexpected error: %v", err)
+		}
+		if want := []string{"100.64.0.2"}; !slices.Equal(got, want) {
+			t.Errorf("resolve() = %v, want %v", got, want)
+		}
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("listed devices %d times, want once for the devices and once for node IDs", n)
+	}
+	if _, err := r.resolve(context.Background(), parseEntry("nodeid:n3333CNTRL")); err == nil {
+		t.Error("resolve() of an unknown node ID succeeded")
+	}
+}
+
+func TestFindExitNodes(t *testing.T) {
+	exit := tailscale.DeviceRoutes{Advertised: exitNodeRoutes, Enabled: exitNodeRoutes}
+	devices := []tailscale.Device{
+		{ID: "2", Name: "gw-2.example.ts.net", Hostname: "gw-2", Addresses: []string{"100.64.0.2"}},
+		{ID: "1", Name: "gw-1.example.ts.net", Hostname: "gw-1", Addresses: []string{"100.64.0.1"}},
+		{ID: "3", Name: "pending.example.ts.net", Hostname: "pending", Addresses: []string{"100.64.0.3"}},
+		{ID: "4", Name: "router.example.ts.net", Hostname: "router", Addresses: []string{"100.64.0.4"}},
+		{ID: "5", Name: "exit.example.ts.net", Hostname: "exit", Addresses: []string{"100.64.0.5"}},
+	}
+	routes := routesFrom(map[string]tailscale.DeviceRoutes{
+		"1": exit,
+		"2": exit,
+		"3": {Advertised: exitNodeRoutes},
+		"4": {Advertised: []string{"10.0.0.0/8"}, Enabled: []string{"10.0.0.0/8"}},
+		"5": {Advertised: []string{"::/0"}, Enabled: []string{"::/0"}},
+	})
+
+	tests := []struct {
+		name      string
+		hostGlob  string
+		wantNames []string
+		wantErr   bool
+	}{
+		{name: "all approved exit nodes", wantNames: []string{"exit.example.ts.net", "gw-1.example.ts.net", "gw-2.example.ts.net"}},
+		{name: "host glob", hostGlob: "gw-*", wantNames: []string{"gw-1.example.ts.net", "gw-2.example.ts.net"}},
+		{name: "unapproved exit node", hostGlob: "pending", wantErr: true},
+		{name: "subnet router", hostGlob: "router", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findExitNodes(tt.hostGlob, devices, routes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("findExitNodes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if names := deviceNames(got); !slices.Equal(names, tt.wantNames) {
+				t.Errorf("findExitNodes() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestFindSubnetRouters(t *testing.T) {
+	devices := []tailscale.Device{
+		{ID: "2", Name: "router-b.example.ts.net", Addresses: []string{"100.64.0.2"}},
+		{ID: "1", Name: "router-a.example.ts.net", Addresses: []string{"100.64.0.1"}},
+		{ID: "3", Name: "pending.example.ts.net", Addresses: []string{"100.64.0.3"}},
+		{ID: "4", Name: "narrow.example.ts.net", Addresses: []string{"100.64.0.4"}},
+	}
+	routes := routesFrom(map[string]tailscale.DeviceRoutes{
+		"2": {Advertised: []string{"10.0.0.0/8"}, Enabled: []string{"10.0.0.0/8"}},
+		"1": {Advertised: []string{"10.0.0.0/8", "192.168.1.0/24"}, Enabled: []string{"10.0.0.0/8", "192.168.1.0/24"}},
+		"3": {Advertised: []string{"10.0.0.0/8"}},
+		"4": {Advertised: []string{"10.1.0.0/16"}, Enabled: []string{"10.1.0.0/16"}},
+	})
+
+	tests := []struct {
+		name      string
+		prefix    string
+		wantNames []string
+		wantErr   bool
+	}{
+		{name: "exact route", prefix: "10.0.0.0/8", wantNames: []string{"router-a.example.ts.net", "router-b.example.ts.net"}},
+		{name: "covered prefix", prefix: "10.1.2.0/24", wantNames: []string{"narrow.example.ts.net", "router-a.example.ts.net", "router-b.example.ts.net"}},
+		{name: "unmasked prefix", prefix: "192.168.1.1/24", wantNames: []string{"router-a.example.ts.net"}},
+		{name: "no router", prefix: "172.16.0.0/12", wantErr: true},
+		{name: "bad prefix", prefix: "10.0.0.0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findSubnetRouters(tt.prefix, devices, routes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("findSubnetRouters() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if names := deviceNames(got); !slices.Equal(names, tt.wantNames) {
+				t.Errorf("findSubnetRouters() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
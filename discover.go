@@ -0,0 +1,90 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+const defaultDiscoverTagPrefix = "tsddns-"
+
+// Discover configures domains that are generated from device tags instead
+// of being listed in the config. A device tagged with TagPrefix followed by
+// a label, such as tag:tsddns-corp, becomes a nameserver for the domain
+// Domain makes from that label.
+type Discover struct {
+	// TagPrefix is what a tag starts with, after "tag:", to mark a
+	// resolver. Defaults to "tsddns-".
+	TagPrefix string `json:"tagPrefix,omitempty"`
+
+	// Domain is the domain template. "{label}" is replaced with the part
+	// of the tag after TagPrefix, e.g. "{label}.example.com".
+	Domain string `json:"domain"`
+}
+
+func (d *Discover) validate() error {
+	if !strings.Contains(d.Domain, "{label}") {
+		return fmt.Errorf("domain %q must contain {label}", d.Domain)
+	}
+	if strings.Contains(d.TagPrefix, ":") {
+		return fmt.Errorf("tagPrefix %q must not include \"tag:\"", d.TagPrefix)
+	}
+	return nil
+}
+
+// discoverDomains returns a tag: entry for each domain the tags of devices
+// generate, keyed by domain.
+func discoverDomains(d *Discover, devices []tailscale.Device) map[string]string {
+	prefix := "tag:" + cmp.Or(d.TagPrefix, defaultDiscoverTagPrefix)
+	found := make(map[string]string)
+	for _, device := range devices {
+		for _, tag := range device.Tags {
+			label, ok := strings.CutPrefix(tag, prefix)
+			if !ok || label == "" {
+				continue
+			}
+			found[strings.ReplaceAll(d.Domain, "{label}", label)] = tag
+		}
+	}
+	return found
+}
+
+// discover adds the domains generated by d to domains and returns the
+// result. Domains the config already lists, or that isExcluded reports,
+// are left alone.
+func (r *resolver) discover(ctx context.Context, d *Discover, domains Config, isExcluded func(string) bool) (Config, error) {
+	if err := r.listDevices(ctx); err != nil {
+		return nil, err
+	}
+
+	listed := make(map[string]bool, len(domains))
+	merged := make(Config, len(domains))
+	for domain, nameservers := range domains {
+		listed[normalizeDomain(domain)] = true
+		merged[domain] = nameservers
+	}
+
+	found := discoverDomains(d, r.devices)
+	discovered := make([]string, 0, len(found))
+	for domain := range found {
+		discovered = append(discovered, domain)
+	}
+	sort.Strings(discovered)
+	for _, domain := range discovered {
+		switch {
+		case listed[normalizeDomain(domain)]:
+			slog.InfoContext(ctx, "Discovered domain is already in the config", "domain", domain, "device", found[domain])
+		case isExcluded(domain):
+			slog.InfoContext(ctx, "Discovered domain is excluded", "domain", domain, "device", found[domain])
+		default:
+			slog.InfoContext(ctx, "Discovered domain", "domain", domain, "device", found[domain])
+			merged[domain] = []string{found[domain]}
+		}
+	}
+	return merged, nil
+}
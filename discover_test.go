@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestDiscoverDomains(t *testing.T) {
+	devices := []tailscale.Device{
+		{Name: "ns1.example.ts.net", Tags: []string{"tag:server", "tag:tsddns-corp"}},
+		{Name: "ns2.example.ts.net", Tags: []string{"tag:tsddns-corp", "tag:tsddns-lab"}},
+		{Name: "web.example.ts.net", Tags: []string{"tag:tsddns-"}},
+		{Name: "edge.example.ts.net", Tags: []string{"tag:dns-edge"}},
+		{Name: "laptop.example.ts.net"},
+	}
+
+	tests := []struct {
+		name     string
+		discover Discover
+		want     map[string]string
+	}{
+		{
+			name:     "default prefix",
+			discover: Discover{Domain: "{label}.example.com"},
+			want:     map[string]string{"corp.example.com": "tag:tsddns-corp", "lab.example.com": "tag:tsddns-lab"},
+		},
+		{
+			name:     "custom prefix",
+			discover: Discover{TagPrefix: "dns-", Domain: "{label}.example.com"},
+			want:     map[string]string{"edge.example.com": "tag:dns-edge"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := discoverDomains(&tt.discover, devices)
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("discoverDomains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	r := newResolver(nil, Defaults{})
+	r.devices = []tailscale.Device{
+		{Name: "ns1.example.ts.net", Tags: []string{"tag:tsddns-corp"}},
+		{Name: "ns2.example.ts.net", Tags: []string{"tag:tsddns-lab"}},
+		{Name: "ns3.example.ts.net", Tags: []string{"tag:tsddns-legacy"}},
+	}
+	domains := Config{"Corp.example.com.": {"192.0.2.1"}}
+	isExcluded := func(domain string) bool { return domain == "legacy.example.com" }
+
+	got, err := r.discover(context.Background(), &Discover{Domain: "{label}.example.com"}, domains, isExcluded)
+	if err != nil {
+		t.Fatalf("discover() error = %v", err)
+	}
+	want := Config{
+		"Corp.example.com.": {"192.0.2.1"},
+		"lab.example.com":   {"tag:tsddns-lab"},
+	}
+	if !maps.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("discover() = %v, want %v", got, want)
+	}
+	if len(domains) != 1 {
+		t.Errorf("discover() changed the configured domains: %v", domains)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// dnsResolver returns the resolver to use for server, which is host or
+// host:port. An empty server means the system resolver.
+func dnsResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// lookupHost returns the A and AAAA records for host, IPv4 first. If server
+// is set, it's queried instead of the system resolver.
+func lookupHost(ctx context.Context, host, server string) ([]string, error) {
+	ips, err := dnsResolver(server).LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("looking up %s: %w", host, err)
+	}
+	for i, ip := range ips {
+		ips[i] = ip.Unmap()
+	}
+	// sorted so the first address, used by default, is stable
+	slices.SortFunc(ips, netip.Addr.Compare)
+
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	return addrs, nil
+}
+
+// lookupSRV returns the targets of the SRV records for name, by priority and
+// then weight. Unlike net.LookupSRV, the order doesn't change between runs.
+func lookupSRV(ctx context.Context, name, server string) ([]string, error) {
+	_, records, err := dnsResolver(server).LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("looking up SRV %s: %w", name, err)
+	}
+	slices.SortFunc(records, func(a, b *net.SRV) int {
+		if c := cmp.Compare(a.Priority, b.Priority); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Weight, a.Weight); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Target, b.Target)
+	})
+
+	var targets []string
+	for _, srv := range records {
+		// a lone "." means the service is explicitly not available
+		if srv.Target != "." {
+			targets = append(targets, srv.Target)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("SRV %s has no targets", name)
+	}
+	return targets, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testDNS is a minimal DNS server answering A and SRV queries from fixed
+// records. AAAA queries, and names without records, get empty answers.
+type testDNS struct {
+	a   map[string]net.IP // name to A record
+	srv map[string][]net.SRV
+}
+
+func (d testDNS) serve(conn net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query := buf[:n]
+
+		// the question ends after the name, type and class
+		var labels []string
+		end := 12
+		for end < n && query[end] != 0 {
+			l := int(query[end])
+			if end+1+l > n {
+				break
+			}
+			labels = append(labels, string(query[end+1:end+1+l]))
+			end += l + 1
+		}
+		end += 5
+		if end > n {
+			continue
+		}
+		name := strings.ToLower(strings.Join(labels, "."))
+		qtype := binary.BigEndian.Uint16(query[end-4:])
+
+		var answers [][]byte
+		switch qtype {
+		case 1:
+			if ip, ok := d.a[name]; ok {
+				answers = append(answers, ip.To4())
+			}
+		case 33:
+			for _, rec := range d.srv[name] {
+				rdata := binary.BigEndian.AppendUint16(nil, rec.Priority)
+				rdata = binary.BigEndian.AppendUint16(rdata, rec.Weight)
+				rdata = binary.BigEndian.AppendUint16(rdata, rec.Port)
+				if target := strings.TrimSuffix(rec.Target, "."); target != "" {
+					for _, label := range strings.Split(target, ".") {
+						rdata = append(rdata, byte(len(label)))
+						rdata = append(rdata, label...)
+					}
+				}
+				answers = append(answers, append(rdata, 0))
+			}
+		}
+
+		resp := append([]byte{}, query[:end]...)
+		resp[2] |= 0x80 // response
+		resp[3] = 0x80  // recursion available, no error
+		binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+		for _, rdata := range answers {
+			resp = append(resp, 0xc0, 12) // pointer to the question name
+			resp = binary.BigEndian.AppendUint16(resp, qtype)
+			resp = binary.BigEndian.AppendUint16(resp, 1)
+			resp = binary.BigEndian.AppendUint32(resp, 60)
+			resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+			resp = append(resp, rdata...)
+		}
+		conn.WriteTo(resp, from)
+	}
+}
+
+// startTestDNS serves d on a local UDP port and returns its address.
+func startTestDNS(t *testing.T, d testDNS) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go d.serve(conn)
+	return conn.LocalAddr().String()
+}
+
+func TestLookupHost(t *testing.T) {
+	server := startTestDNS(t, testDNS{a: map[string]net.IP{"ns1.dc.example.com": net.ParseIP("192.0.2.53")}})
+
+	r := newResolver(nil, Defaults{Resolver: server})
+
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "default resolver", entry: "dns:ns1.dc.example.com", want: []string{"192.0.2.53"}},
+		{name: "server option", entry: "dns:ns1.dc.example.com?server=" + server, want: []string{"192.0.2.53"}},
+		{name: "no ipv6 records", entry: "dns:ns1.dc.example.com?family=ipv6", wantErr: true},
+		{name: "no records", entry: "dns:ns2.dc.example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSRV(t *testing.T) {
+	server := startTestDNS(t, testDNS{
+		a: map[string]net.IP{
+			"ns1.example.com": net.ParseIP("192.0.2.1"),
+			"ns2.example.com": net.ParseIP("192.0.2.2"),
+			"ns3.example.com": net.ParseIP("192.0.2.3"),
+		},
+		srv: map[string][]net.SRV{
+			"_dns._udp.corp.example.com": {
+				{Target: "ns3.example.com.", Port: 53, Priority: 20, Weight: 10},
+				{Target: "ns2.example.com.", Port: 53, Priority: 10, Weight: 10},
+				{Target: "ns1.example.com.", Port: 53, Priority: 10, Weight: 50},
+			},
+			"_dns._udp.gone.example.com": {{Target: "."}},
+			"_dns._udp.bad.example.com":  {{Target: "missing.example.com.", Port: 53, Priority: 10, Weight: 10}},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "priority then weight", entry: "srv:_dns._udp.corp.example.com", want: []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}},
+		{name: "capped", entry: "srv:_dns._udp.corp.example.com?max=2", want: []string{"192.0.2.1", "192.0.2.2"}},
+		{name: "service not available", entry: "srv:_dns._udp.gone.example.com", wantErr: true},
+		{name: "unresolvable target", entry: "srv:_dns._udp.bad.example.com", wantErr: true},
+		{name: "no records", entry: "srv:_dns._udp.other.example.com", wantErr: true},
+	}
+
+	r := newResolver(nil, Defaults{Resolver: server})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
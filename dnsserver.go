@@ -0,0 +1,390 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultServerListen = ":53"
+	defaultServerTTL    = time.Minute
+	forwardTimeout      = 5 * time.Second
+)
+
+// dnsServer is the embedded DNS server, if one is configured. updateDNS
+// hands it new answers after every update.
+var dnsServer *embeddedServer
+
+// Server configures the embedded DNS server, which answers for Records
+// itself and forwards queries for the split DNS domains to their resolved
+// nameservers, so tsddns can be used as a nameserver directly.
+type Server struct {
+	// Listen is the address to serve DNS on, over UDP and TCP. Defaults to
+	// ":53".
+	Listen string `json:"listen,omitempty"`
+
+	// TTL is the TTL of answers for Records. Defaults to 1m.
+	TTL Duration `json:"ttl,omitempty"`
+
+	// Records are the names the server answers A and AAAA queries for.
+	Records RecordSet `json:"records,omitempty"`
+
+	// Upstreams are the nameservers, as IP or IP:port, that queries for
+	// any other name are forwarded to. Without them, those queries are
+	// refused.
+	Upstreams []string `json:"upstreams,omitempty"`
+}
+
+func (s *Server) listenAddr() string {
+	return cmp.Or(s.Listen, defaultServerListen)
+}
+
+func (s *Server) validate() error {
+	if _, _, err := net.SplitHostPort(s.listenAddr()); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	for _, upstream := range s.Upstreams {
+		if _, err := netip.ParseAddrPort(withDNSPort(upstream)); err != nil {
+			return fmt.Errorf("upstream %q is not an IP address", upstream)
+		}
+	}
+	return nil
+}
+
+// withDNSPort adds port 53 to server if it has no port.
+func withDNSPort(server string) string {
+	if addr, err := netip.ParseAddr(server); err == nil {
+		return netip.AddrPortFrom(addr, 53).String()
+	}
+	return server
+}
+
+// serveDNS resolves the server's records and gives the embedded DNS server
+// its new answers, forwarding the split DNS domains in splitDNS.
+func serveDNS(ctx context.Context, r *resolver, cfg *ConfigFile, splitDNS map[string][]string) error {
+	if dnsServer == nil || cfg.Server == nil {
+		return nil
+	}
+	resolved, err := r.resolveAll(ctx, cfg.Server.Records)
+	if err != nil {
+		return fmt.Errorf("resolving server records: %w", err)
+	}
+	records, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+	_, forward := exportDomains(splitDNS)
+	var upstreams []string
+	for _, upstream := range cfg.Server.Upstreams {
+		upstreams = append(upstreams, withDNSPort(upstream))
+	}
+	dnsServer.update(records, forward, upstreams, time.Duration(cmp.Or(cfg.Server.TTL, Duration(defaultServerTTL))))
+	return nil
+}
+
+// embeddedServer answers DNS queries over UDP and TCP from the latest
+// answers it was given.
+type embeddedServer struct {
+	port uint16 // the port it listens on
+
+	mu        sync.RWMutex
+	records   map[string][]record // by name
+	forward   map[string][]string // domain to nameserver addresses
+	upstreams []string
+	ttl       time.Duration
+}
+
+// startDNSServer starts serving DNS on addr. Until it's given answers,
+// every query is refused.
+func startDNSServer(addr string) (*embeddedServer, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &embeddedServer{port: uint16(pc.LocalAddr().(*net.UDPAddr).Port)}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	go s.serveUDP(pc)
+	go s.serveTCP(ln)
+	return s, nil
+}
+
+func (s *embeddedServer) update(records []record, forward map[string][]string, upstreams []string, ttl time.Duration) {
+	byName := make(map[string][]record)
+	for _, rec := range records {
+		byName[rec.Name] = append(byName[rec.Name], rec)
+	}
+	// a domain pointed at this server is served here rather than
+	// forwarded back to it, so its names without records don't exist
+	own := ownAddrs()
+	forwardDomains := make(map[string][]string)
+	for domain, addrs := range forward {
+		servers := []string{}
+		for _, addr := range addrs {
+			addrPort := withDNSPort(addr)
+			if ap, err := netip.ParseAddrPort(addrPort); err == nil && own[ap.Addr()] && ap.Port() == s.port {
+				continue
+			}
+			servers = append(servers, addrPort)
+		}
+		forwardDomains[normalizeDomain(domain)] = servers
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records, s.forward, s.upstreams, s.ttl = byName, forwardDomains, upstreams, ttl
+}
+
+func (s *embeddedServer) serveUDP(pc net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := s.handle(query, "udp"); resp != nil {
+				pc.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *embeddedServer) serveTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				conn.SetDeadline(time.Now().Add(30 * time.Second))
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				resp := s.handle(query, "tcp")
+				if resp == nil {
+					return
+				}
+				if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp)))); err != nil {
+					return
+				}
+				if _, err := conn.Write(resp); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// readTCPMessage reads one length-prefixed DNS message.
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// handle answers one query. It returns nil for messages too malformed to
+// answer at all.
+func (s *embeddedServer) handle(query []byte, network string) []byte {
+	name, qtype, end, err := parseQuestion(query)
+	if err != nil {
+		if len(query) < 12 || query[2]&0x80 != 0 {
+			return nil
+		}
+		return replyHeader(query, 12, 1, false) // FORMERR
+	}
+
+	s.mu.RLock()
+	records, isRecord := s.records[name]
+	forward, inDomain := s.forwardFor(name)
+	upstreams := s.upstreams
+	ttl := s.ttl
+	s.mu.RUnlock()
+
+	switch {
+	case isRecord:
+		return answerRecords(query, end, qtype, records, ttl)
+	case inDomain && len(forward) == 0:
+		return replyHeader(query, end, 3, true) // NXDOMAIN
+	case inDomain:
+		return forwardOrFail(query, end, forward, network)
+	case len(upstreams) > 0:
+		return forwardOrFail(query, end, upstreams, network)
+	}
+	return replyHeader(query, end, 5, false) // REFUSED
+}
+
+// forwardFor returns the nameservers of the longest split DNS domain name
+// is in, and whether it's in one. s.mu must be held.
+func (s *embeddedServer) forwardFor(name string) ([]string, bool) {
+	for candidate := name; ; {
+		if servers, ok := s.forward[candidate]; ok {
+			return servers, true
+		}
+		dot := strings.IndexByte(candidate, '.')
+		if dot < 0 {
+			return nil, false
+		}
+		candidate = candidate[dot+1:]
+	}
+}
+
+// ownAddrs returns the addresses of this machine's interfaces.
+func ownAddrs() map[netip.Addr]bool {
+	own := make(map[netip.Addr]bool)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return own
+	}
+	for _, a := range addrs {
+		if prefix, err := netip.ParsePrefix(a.String()); err == nil {
+			own[prefix.Addr()] = true
+		}
+	}
+	return own
+}
+
+// forwardOrFail relays query to the first of servers that answers, or
+// answers SERVFAIL if none do.
+func forwardOrFail(query []byte, end int, servers []string, network string) []byte {
+	for _, server := range servers {
+		resp, err := forwardQuery(query, server, network)
+		if err == nil {
+			return resp
+		}
+		slog.Warn("Forwarding DNS query failed", "server", server, "error", err)
+	}
+	return replyHeader(query, end, 2, false) // SERVFAIL
+}
+
+// forwardQuery sends query to server over network and returns its response.
+func forwardQuery(query []byte, server, network string) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, forwardTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(forwardTimeout))
+
+	if network == "tcp" {
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// ignore stray packets that aren't a response to this query
+		if n >= 12 && buf[0] == query[0] && buf[1] == query[1] && buf[2]&0x80 != 0 {
+			return buf[:n], nil
+		}
+	}
+}
+
+// parseQuestion returns the lowercased name and type of a query's only
+// question, and the offset just past it.
+func parseQuestion(msg []byte) (name string, qtype uint16, end int, err error) {
+	if len(msg) < 12 || msg[2]&0x80 != 0 || binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return "", 0, 0, fmt.Errorf("not a query with one question")
+	}
+	var labels []string
+	i := 12
+	for {
+		if i >= len(msg) {
+			return "", 0, 0, fmt.Errorf("truncated question")
+		}
+		n := int(msg[i])
+		i++
+		if n == 0 {
+			break
+		}
+		if n > 63 || i+n > len(msg) {
+			return "", 0, 0, fmt.Errorf("bad label")
+		}
+		labels = append(labels, strings.ToLower(string(msg[i:i+n])))
+		i += n
+	}
+	if i+4 > len(msg) {
+		return "", 0, 0, fmt.Errorf("truncated question")
+	}
+	return strings.Join(labels, "."), binary.BigEndian.Uint16(msg[i:]), i + 4, nil
+}
+
+// replyHeader returns a response to query with its question and the given
+// rcode, and no records.
+func replyHeader(query []byte, end int, rcode byte, authoritative bool) []byte {
+	resp := append([]byte(nil), query[:end]...)
+	resp[2] = 0x80 | query[2]&0x79 // QR, keeping opcode and RD
+	if authoritative {
+		resp[2] |= 0x04
+	}
+	resp[3] = 0x80 | rcode // RA
+	if end == 12 {
+		binary.BigEndian.PutUint16(resp[4:], 0)
+	}
+	clear(resp[6:12]) // no answer, authority or additional records
+	return resp
+}
+
+// answerRecords answers a query for one of the server's names. Types other
+// than A and AAAA get an empty answer.
+func answerRecords(query []byte, end int, qtype uint16, records []record, ttl time.Duration) []byte {
+	resp := replyHeader(query, end, 0, true)
+	var count uint16
+	for _, rec := range records {
+		addr := netip.MustParseAddr(rec.Value)
+		var typ uint16 = 28 // AAAA
+		if addr.Is4() {
+			typ = 1
+		}
+		if qtype != typ && qtype != 255 {
+			continue
+		}
+		resp = append(resp, 0xc0, 12) // pointer to the question's name
+		resp = binary.BigEndian.AppendUint16(resp, typ)
+		resp = binary.BigEndian.AppendUint16(resp, 1) // class IN
+		resp = binary.BigEndian.AppendUint32(resp, uint32(ttl.Seconds()))
+		ip := addr.AsSlice()
+		resp = binary.BigEndian.AppendUint16(resp, uint16(len(ip)))
+		resp = append(resp, ip...)
+		count++
+	}
+	binary.BigEndian.PutUint16(resp[6:], count)
+	return resp
+}
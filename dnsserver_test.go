@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEmbeddedServer(t *testing.T) {
+	// a fake nameserver for the forwarded domain that answers NXDOMAIN
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			resp := append([]byte(nil), buf[:n]...)
+			resp[2] |= 0x80
+			resp[3] = 3
+			upstream.WriteTo(resp, addr)
+		}
+	}()
+
+	s := &embeddedServer{port: 5353}
+	s.update(
+		[]record{
+			{Name: "ns.internal.example.com", Type: "A", Value: "100.64.0.1"},
+			{Name: "ns.internal.example.com", Type: "AAAA", Value: "fd7a:115c:a1e0::1"},
+		},
+		map[string][]string{
+			"corp.example.com":     {upstream.LocalAddr().String()},
+			"internal.example.com": {"127.0.0.1:5353"}, // this server
+		},
+		nil,
+		time.Minute,
+	)
+
+	tests := []struct {
+		name        string
+		qname       string
+		qtype       uint16
+		wantRcode   byte
+		wantAnswers uint16
+		wantAA      bool
+	}{
+		{name: "record A", qname: "NS.internal.example.com", qtype: 1, wantAnswers: 1, wantAA: true},
+		{name: "record AAAA", qname: "ns.internal.example.com", qtype: 28, wantAnswers: 1, wantAA: true},
+		{name: "record other type", qname: "ns.internal.example.com", qtype: 16, wantAA: true},
+		{name: "forwarded", qname: "db.corp.example.com", qtype: 1, wantRcode: 3},
+		{name: "served here", qname: "db.internal.example.com", qtype: 1, wantRcode: 3, wantAA: true},
+		{name: "unknown", qname: "example.org", qtype: 1, wantRcode: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, id, err := buildQuery(tt.qname, tt.qtype)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := s.handle(query, "udp")
+			if len(resp) < 12 || binary.BigEndian.Uint16(resp) != id || resp[2]&0x80 == 0 {
+				t.Fatalf("handle() = %x, want a response to query %x", resp, id)
+			}
+			if rcode := resp[3] & 0x0f; rcode != tt.wantRcode {
+				t.Errorf("rcode = %d, want %d", rcode, tt.wantRcode)
+			}
+			if aa := resp[2]&0x04 != 0; aa != tt.wantAA {
+				t.Errorf("authoritative = %v, want %v", aa, tt.wantAA)
+			}
+			if n := binary.BigEndian.Uint16(resp[6:]); n != tt.wantAnswers {
+				t.Errorf("got %d answers, want %d", n, tt.wantAnswers)
+			}
+		})
+	}
+
+	query, _, _ := buildQuery("ns.internal.example.com", 1)
+	resp := s.handle(query, "udp")
+	if got := resp[len(resp)-4:]; net.IP(got).String() != "100.64.0.1" {
+		t.Errorf("answer address = %v, want 100.64.0.1", net.IP(got))
+	}
+	if ttl := binary.BigEndian.Uint32(resp[len(resp)-10:]); ttl != 60 {
+		t.Errorf("answer TTL = %d, want 60", ttl)
+	}
+}
+
+func TestParseQuestion(t *testing.T) {
+	query, _, err := buildQuery("Foo.Example.com", 28)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, qtype, end, err := parseQuestion(query)
+	if err != nil {
+		t.Fatalf("parseQuestion() unexpected error: %v", err)
+	}
+	if name != "foo.example.com" || qtype != 28 || end != len(query) {
+		t.Errorf("parseQuestion() = %q, %d, %d, want foo.example.com, 28, %d", name, qtype, end, len(query))
+	}
+
+	if _, _, _, err := parseQuestion(query[:len(query)-2]); err == nil {
+		t.Error("parseQuestion() accepted a truncated question")
+	}
+}
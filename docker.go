@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// dockerSocket is the Docker daemon socket used by docker: selectors. It's
+// set by the -docker-socket flag.
+var dockerSocket = "/var/run/docker.sock"
+
+// dockerContainer is the part of a container listing tsddns uses.
+type dockerContainer struct {
+	Names           []string `json:"Names"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string `json:"IPAddress"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// name is the container's name without the leading "/".
+func (c dockerContainer) name() string {
+	if len(c.Names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// addrs returns the container's addresses on network, or on every network
+// in name order if network is empty.
+func (c dockerContainer) addrs(network string) []string {
+	var names []string
+	for name := range c.NetworkSettings.Networks {
+		if network == "" || name == network {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var addrs []string
+	for _, name := range names {
+		n := c.NetworkSettings.Networks[name]
+		if n.IPAddress != "" {
+			addrs = append(addrs, n.IPAddress)
+		}
+		if n.GlobalIPv6Address != "" {
+			addrs = append(addrs, n.GlobalIPv6Address)
+		}
+	}
+	return addrs
+}
+
+// findContainers lists the running containers named name, or carrying the
+// label when name has the form label=key or label=key=value, sorted by name.
+func findContainers(ctx context.Context, socket, name string) ([]dockerContainer, error) {
+	filter := map[string][]string{"name": {"^/" + name + "$"}}
+	if label, ok := strings.CutPrefix(name, "label="); ok {
+		filter = map[string][]string{"label": {label}}
+	}
+	filterJSON, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://docker/containers/json?filters="+url.QueryEscape(string(filterJSON)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("querying Docker: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Docker returned status %d", resp.StatusCode)
+	}
+
+	var containers []dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("decoding containers: %w", err)
+	}
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("no running containers matching %s", name)
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].name() < containers[j].name() })
+	return containers, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestResolveDocker(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listening on %s: %v", socket, err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var filters map[string][]string
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch {
+		case slices.Equal(filters["name"], []string{"^/pihole$"}):
+			w.Write([]byte(`[{"Names": ["/pihole"], "NetworkSettings": {"Networks": {
+				"dns": {"IPAddress": "172.20.0.53", "GlobalIPv6Address": "fd00:20::53"},
+				"bridge": {"IPAddress": "172.17.0.2"}
+			}}}]`))
+		case slices.Equal(filters["label"], []string{"role=dns"}):
+			w.Write([]byte(`[
+				{"Names": ["/dns-b"], "NetworkSettings": {"Networks": {"dns": {"IPAddress": "172.20.0.3"}}}},
+				{"Names": ["/dns-a"], "NetworkSettings": {"Networks": {"dns": {"IPAddress": "172.20.0.2"}}}}
+			]`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	server.Listener = ln
+	server.Start()
+	defer server.Close()
+
+	oldSocket := dockerSocket
+	dockerSocket = socket
+	defer func() { dockerSocket = oldSocket }()
+
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "by name", entry: "docker:pihole", want: []string{"172.17.0.2"}},
+		{name: "by name on a network", entry: "docker:pihole?network=dns&family=both", want: []string{"172.20.0.53", "fd00:20::53"}},
+		{name: "by label", entry: "docker:label=role=dns", want: []string{"172.20.0.2", "172.20.0.3"}},
+		{name: "by label, capped", entry: "docker:label=role=dns?max=1", want: []string{"172.20.0.2"}},
+		{name: "unknown network", entry: "docker:pihole?network=other", wantErr: true},
+		{name: "not running", entry: "docker:adguard", wantErr: true},
+	}
+
+	r := newResolver(nil, Defaults{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
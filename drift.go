@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// defaultDriftInterval is how often the daemon checks for drift when
+// Drift.Interval isn't set.
+const defaultDriftInterval = time.Minute
+
+// Drift configures checks between updates for split DNS changed outside
+// tsddns, such as edits in the admin console. They compare split DNS with
+// what the last update applied, as recorded in -state-file.
+type Drift struct {
+	// Interval is how often to check. Defaults to a minute.
+	Interval Duration `json:"interval,omitempty"`
+
+	// Repair runs an update as soon as drift is found, putting the drifted
+	// domains back. Otherwise drift is only reported, and put back by the
+	// next update.
+	Repair bool `json:"repair,omitempty"`
+}
+
+func (d *Drift) validate() error {
+	if d.Interval < 0 {
+		return fmt.Errorf("interval can't be negative")
+	}
+	return nil
+}
+
+func (d *Drift) interval() time.Duration {
+	if d.Interval <= 0 {
+		return defaultDriftInterval
+	}
+	return time.Duration(d.Interval)
+}
+
+// reportedDrift is the drift reported last, so that drift which persists
+// across checks and updates is only alerted on once.
+var reportedDrift string
+
+// checkDrift reads split DNS and reports any domain that differs from what
+// the last update applied. It changes nothing.
+func checkDrift(ctx context.Context, client *tailscale.Client, cfg *ConfigFile) ([]domainChange, error) {
+	applied, err := loadApplied(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := newBackend(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	current, err := backend.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	drifted := driftedDomains(applied, current)
+	reportDrift(ctx, client.Tailnet, cfg, drifted, cfg.Drift != nil && cfg.Drift.Repair)
+	return drifted, nil
+}
+
+// reportDrift logs drifted domains and, unless the same drift was reported
+// already, emails and notifies about them. repairing is whether they're
+// about to be put back.
+func reportDrift(ctx context.Context, tailnet string, cfg *ConfigFile, drifted []domainChange, repairing bool) {
+	logDrift(ctx, drifted)
+	if dryRun {
+		return
+	}
+	metrics.gauge("drift.domains", len(drifted))
+	key := diffLines(drifted)
+	if key == reportedDrift {
+		return
+	}
+	reportedDrift = key
+	emailDrift(ctx, tailnet, cfg.Email, drifted, repairing)
+	notifyDrift(ctx, tailnet, cfg.Notify, drifted)
+}
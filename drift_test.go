@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestCheckDrift(t *testing.T) {
+	dir := t.TempDir()
+	defer func(path string) { stateFile = path }(stateFile)
+	stateFile = filepath.Join(dir, "state.json")
+	defer func() { reportedDrift = "" }()
+
+	applied := newAppliedState(nil, map[string][]string{"corp.example.com": {"100.64.0.1"}}, []string{"corp.example.com"}, nil, time.Now())
+	if err := saveApplied(stateFile, applied); err != nil {
+		t.Fatal(err)
+	}
+	notify, payloads, _ := notificationServer(t)
+	cfg := &ConfigFile{
+		Backend:     "file",
+		BackendFile: filepath.Join(dir, "splitdns.json"),
+		Notify:      []Notification{{URL: notify.URL}},
+		Drift:       &Drift{},
+	}
+	backend := &fileBackend{path: cfg.BackendFile}
+	client := &tailscale.Client{Tailnet: "example.com"}
+
+	steps := []struct {
+		name       string
+		splitDNS   map[string][]string
+		wantDrift  int
+		wantNotify int
+	}{
+		{name: "as applied", splitDNS: map[string][]string{"corp.example.com": {"100.64.0.1"}, "other.example.com": {"10.0.0.53"}}},
+		{name: "edited", splitDNS: map[string][]string{"corp.example.com": {"8.8.8.8"}}, wantDrift: 1, wantNotify: 1},
+		{name: "still edited", splitDNS: map[string][]string{"corp.example.com": {"8.8.8.8"}}, wantDrift: 1, wantNotify: 1},
+		{name: "put back", splitDNS: map[string][]string{"corp.example.com": {"100.64.0.1"}}, wantNotify: 1},
+		{name: "edited again", splitDNS: map[string][]string{"corp.example.com": {"8.8.8.8"}}, wantDrift: 1, wantNotify: 2},
+	}
+	for _, step := range steps {
+		if err := backend.write(step.splitDNS); err != nil {
+			t.Fatal(err)
+		}
+		drifted, err := checkDrift(context.Background(), client, cfg)
+		if err != nil {
+			t.Fatalf("%s: checkDrift() unexpected error: %v", step.name, err)
+		}
+		if len(drifted) != step.wantDrift {
+			t.Errorf("%s: drifted = %+v, want %d domains", step.name, drifted, step.wantDrift)
+		}
+		if len(*payloads) != step.wantNotify {
+			t.Errorf("%s: sent %d notifications, want %d", step.name, len(*payloads), step.wantNotify)
+		}
+	}
+	if p := (*payloads)[0]; p.Event != "drift" || p.Changes[0].Domain != "corp.example.com" {
+		t.Errorf("notification = %+v, want drift of corp.example.com", p)
+	}
+	// the file backend reads split DNS without changing it
+	if current, _ := backend.Current(context.Background()); current["corp.example.com"][0] != "8.8.8.8" {
+		t.Errorf("split DNS = %v, want the drift left in place", current)
+	}
+}
+
+func TestDriftChatMessage(t *testing.T) {
+	p := notificationPayload{
+		Event:   "drift",
+		Tailnet: "example.com",
+		Changes: []domainChange{{Domain: "corp.example.com", Old: []string{"100.64.0.1"}, New: []string{"8.8.8.8"}}},
+	}
+	msg := chatMessage(p, "*", "")
+	for _, want := range []string{"*Split DNS for example.com was changed outside tsddns*", "From what tsddns applied:", "corp.example.com"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message doesn't contain %q:\n%s", want, msg)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// dryRun makes updates print what they would change instead of applying
+// it. It's set by the -dry-run and -check flags.
+var dryRun bool
+
+// errChangesPending is returned by a dry run that found something to
+// change.
+var errChangesPending = errors.New("the tailnet doesn't match the config")
+
+// formatDiff renders split DNS changes as a readable diff, one line per
+// domain: "+" for added, "-" for removed and "~" for changed domains.
+func formatDiff(changes []domainChange) string {
+	if len(changes) == 0 {
+		return "Split DNS is up to date, nothing would change\n"
+	}
+	var added, changed, removed int
+	for _, c := range changes {
+		switch {
+		case c.Old == nil:
+			added++
+		case c.New == nil:
+			removed++
+		default:
+			changed++
+		}
+	}
+	return diffLines(changes) + fmt.Sprintf("%d domains would change (%d added, %d changed, %d removed)\n", len(changes), added, changed, removed)
+}
+
+// diffLines is the per-domain part of formatDiff.
+func diffLines(changes []domainChange) string {
+	var b strings.Builder
+	for _, c := range changes {
+		switch {
+		case c.Old == nil:
+			fmt.Fprintf(&b, "+ %s: %s\n", c.Domain, strings.Join(c.New, ", "))
+		case c.New == nil:
+			fmt.Fprintf(&b, "- %s: %s\n", c.Domain, strings.Join(c.Old, ", "))
+		default:
+			fmt.Fprintf(&b, "~ %s: %s -> %s\n", c.Domain, strings.Join(c.Old, ", "), strings.Join(c.New, ", "))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestFormatDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []domainChange
+		want    string
+	}{
+		{
+			name: "no changes",
+			want: "Split DNS is up to date, nothing would change\n",
+		},
+		{
+			name: "mixed",
+			changes: diffSplitDNS(
+				map[string][]string{
+					"same.example.com":    {"100.64.0.1"},
+					"changed.example.com": {"100.64.0.2"},
+					"removed.example.com": {"100.64.0.3"},
+				},
+				map[string][]string{
+					"same.example.com":    {"100.64.0.1"},
+					"changed.example.com": {"100.64.0.4", "100.64.0.6"},
+					"added.example.com":   {"100.64.0.5"},
+				},
+			),
+			want: "+ added.example.com: 100.64.0.5\n" +
+				"~ changed.example.com: 100.64.0.2 -> 100.64.0.4, 100.64.0.6\n" +
+				"- removed.example.com: 100.64.0.3\n" +
+				"3 domains would change (1 added, 1 changed, 1 removed)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatDiff(tt.changes); got != tt.want {
+				t.Errorf("formatDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
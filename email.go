@@ -0,0 +1,185 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultEmailAfterFailures is how many updates in a row must fail before
+// an email goes out, so a single blip doesn't page anyone.
+const defaultEmailAfterFailures = 3
+
+// emailTimeout bounds sending one email.
+const emailTimeout = 30 * time.Second
+
+// consecutiveFailures counts the updates that failed in a row, for email
+// alerts.
+var consecutiveFailures int
+
+// Email configures alerts sent by SMTP when updates keep failing or split
+// DNS was changed outside tsddns.
+type Email struct {
+	// Server is the SMTP server's host:port. Port 465 uses TLS from the
+	// start; other ports upgrade with STARTTLS when the server offers it.
+	Server string `json:"server"`
+
+	From string   `json:"from"`
+	To   []string `json:"to"`
+
+	// Username, if set, authenticates with the password in the
+	// environment variable named by PasswordEnv, SMTP_PASSWORD by
+	// default. Authentication needs TLS unless the server is local.
+	Username    string `json:"username,omitempty"`
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+
+	// AfterFailures is how many updates in a row must fail before an
+	// email is sent. Defaults to 3.
+	AfterFailures int `json:"afterFailures,omitempty"`
+}
+
+func (e *Email) validate() error {
+	if _, _, err := net.SplitHostPort(e.Server); err != nil {
+		return fmt.Errorf("server must be host:port: %w", err)
+	}
+	if e.From == "" || len(e.To) == 0 {
+		return fmt.Errorf("needs from and to")
+	}
+	if e.AfterFailures < 0 {
+		return fmt.Errorf("afterFailures can't be negative")
+	}
+	return nil
+}
+
+// emailOutcome counts failed updates and emails when the count reaches the
+// threshold, and again once an update succeeds after that. An update that
+// had to restore the last known good split DNS, or couldn't, emails right
+// away. Dry runs count for nothing.
+func emailOutcome(ctx context.Context, tailnet string, e *Email, err error) {
+	if dryRun || errors.Is(err, errChangesPending) {
+		return
+	}
+	if err == nil {
+		failed := consecutiveFailures
+		consecutiveFailures = 0
+		if e != nil && failed >= cmp.Or(e.AfterFailures, defaultEmailAfterFailures) {
+			e.sendLogged(ctx, fmt.Sprintf("Updates recovered for %s", tailnet),
+				fmt.Sprintf("tsddns updated split DNS for %s successfully after %d failed updates.\n", tailnet, failed))
+		}
+		return
+	}
+
+	consecutiveFailures++
+	if e == nil {
+		return
+	}
+	switch {
+	case errors.Is(err, errRestoreFailed):
+		e.sendLogged(ctx, fmt.Sprintf("Split DNS may be half updated for %s", tailnet),
+			fmt.Sprintf("A tsddns update for %s failed after writing split DNS, and putting back the last known good split DNS failed too, so it may be half updated. Check it in the admin console. The error was:\n\n%v\n", tailnet, err))
+	case errors.Is(err, errRolledBack):
+		e.sendLogged(ctx, fmt.Sprintf("Rolled back split DNS for %s", tailnet),
+			fmt.Sprintf("A tsddns update for %s failed after writing split DNS, so the domains it changed were put back to their last known good nameservers. The error was:\n\n%v\n", tailnet, err))
+	case consecutiveFailures == cmp.Or(e.AfterFailures, defaultEmailAfterFailures):
+		e.sendLogged(ctx, fmt.Sprintf("Updates failing for %s", tailnet),
+			fmt.Sprintf("The last %d tsddns updates for %s failed, so its DNS may be out of date. The latest error was:\n\n%v\n\nYou'll get another email once an update succeeds.\n", consecutiveFailures, tailnet, err))
+	}
+}
+
+// emailDrift emails about domains changed outside tsddns since it last
+// applied them. repairing is whether they're being put back right away.
+func emailDrift(ctx context.Context, tailnet string, e *Email, drifted []domainChange, repairing bool) {
+	if e == nil || dryRun || len(drifted) == 0 {
+		return
+	}
+	var b strings.Builder
+	if repairing {
+		fmt.Fprintf(&b, "These split DNS domains of %s were changed outside tsddns since it last applied them, and are being put back:\n\n", tailnet)
+	} else {
+		fmt.Fprintf(&b, "These split DNS domains of %s were changed outside tsddns since it last applied them, and will be put back by the next update:\n\n", tailnet)
+	}
+	for _, c := range drifted {
+		if c.New == nil {
+			fmt.Fprintf(&b, "- %s: deleted, was %s\n", c.Domain, strings.Join(c.Old, ", "))
+		} else {
+			fmt.Fprintf(&b, "~ %s: %s, was %s\n", c.Domain, strings.Join(c.New, ", "), strings.Join(c.Old, ", "))
+		}
+	}
+	e.sendLogged(ctx, fmt.Sprintf("Split DNS drift in %s", tailnet), b.String())
+}
+
+// sendLogged sends an email, logging rather than returning failures since
+// they don't change what was applied.
+func (e *Email) sendLogged(ctx context.Context, subject, body string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), emailTimeout)
+	defer cancel()
+	if err := e.send(ctx, "[tsddns] "+subject, body); err != nil {
+		slog.ErrorContext(ctx, "Sending email failed", "server", e.Server, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Sent email", "subject", subject, "to", e.To)
+}
+
+func (e *Email) send(ctx context.Context, subject, body string) error {
+	host, port, _ := net.SplitHostPort(e.Server)
+	tlsConfig := &tls.Config{ServerName: host}
+
+	var conn net.Conn
+	var err error
+	if port == "465" {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", e.Server)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", e.Server)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && port != "465" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starting TLS: %w", err)
+		}
+	}
+	if e.Username != "" {
+		envVar := cmp.Or(e.PasswordEnv, "SMTP_PASSWORD")
+		if err := c.Auth(smtp.PlainAuth("", e.Username, os.Getenv(envVar), host)); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n",
+		e.From, strings.Join(e.To, ", "), subject, time.Now().Format(time.RFC1123Z))
+	w.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// smtpServer is a minimal SMTP server that records the messages it
+// receives, advertising AUTH PLAIN but not STARTTLS.
+func smtpServer(t *testing.T) (addr string, messages chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	messages = make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+	reply("220 test ESMTP")
+	var session strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line + " x")[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			reply("250-test")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			session.WriteString(line)
+			reply("235 ok")
+		case "MAIL", "RCPT":
+			session.WriteString(line)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				session.WriteString(line)
+			}
+			reply("250 queued")
+		case "QUIT":
+			// recorded before replying so the client sees it once it returns
+			messages <- session.String()
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestEmailOutcome(t *testing.T) {
+	addr, messages := smtpServer(t)
+	e := &Email{Server: addr, From: "tsddns@example.com", To: []string{"ops@example.com", "oncall@example.com"}, AfterFailures: 2}
+	consecutiveFailures = 0
+	defer func() { consecutiveFailures = 0 }()
+	ctx := context.Background()
+	failure := errors.New("listing devices: status 503")
+
+	// one failure is under the threshold, the second reaches it and the
+	// third doesn't email again
+	emailOutcome(ctx, "example.com", e, failure)
+	emailOutcome(ctx, "example.com", e, failure)
+	emailOutcome(ctx, "example.com", e, failure)
+	emailOutcome(ctx, "example.com", e, nil)
+	emailOutcome(ctx, "example.com", e, nil)
+
+	first, second := <-messages, <-messages
+	for _, want := range []string{"MAIL FROM:<tsddns@example.com>", "RCPT TO:<ops@example.com>", "RCPT TO:<oncall@example.com>", "Subject: [tsddns] Updates failing for example.com", "The last 2 tsddns updates", "status 503"} {
+		if !strings.Contains(first, want) {
+			t.Errorf("failure email doesn't contain %q:\n%s", want, first)
+		}
+	}
+	if !strings.Contains(second, "Subject: [tsddns] Updates recovered for example.com") || !strings.Contains(second, "after 3 failed updates") {
+		t.Errorf("recovery email is\n%s", second)
+	}
+	select {
+	case m := <-messages:
+		t.Errorf("sent another email:\n%s", m)
+	default:
+	}
+}
+
+func TestEmailAuth(t *testing.T) {
+	addr, messages := smtpServer(t)
+	t.Setenv("TEST_SMTP_PASSWORD", "hunter2")
+	e := &Email{Server: addr, From: "tsddns@example.com", To: []string{"ops@example.com"}, Username: "tsddns", PasswordEnv: "TEST_SMTP_PASSWORD"}
+
+	if err := e.send(context.Background(), "subject", "body"); err != nil {
+		t.Fatal(err)
+	}
+	// AUTH PLAIN's initial response is base64 of "\x00tsddns\x00hunter2"
+	if m := <-messages; !strings.Contains(m, "AUTH PLAIN AHRzZGRucwBodW50ZXIy") {
+		t.Errorf("session didn't authenticate:\n%s", m)
+	}
+}
+
+func TestEmailDrift(t *testing.T) {
+	addr, messages := smtpServer(t)
+	e := &Email{Server: addr, From: "tsddns@example.com", To: []string{"ops@example.com"}}
+
+	emailDrift(context.Background(), "example.com", e, nil, true)
+	emailDrift(context.Background(), "example.com", e, []domainChange{
+		{Domain: "a.example.com", Old: []string{"100.64.0.1"}},
+		{Domain: "b.example.com", Old: []string{"100.64.0.2"}, New: []string{"8.8.8.8"}},
+	}, true)
+
+	m := <-messages
+	for _, want := range []string{"Subject: [tsddns] Split DNS drift in example.com", "- a.example.com: deleted, was 100.64.0.1", "~ b.example.com: 8.8.8.8, was 100.64.0.2"} {
+		if !strings.Contains(m, want) {
+			t.Errorf("drift email doesn't contain %q:\n%s", want, m)
+		}
+	}
+	select {
+	case m := <-messages:
+		t.Errorf("sent an email without drift:\n%s", m)
+	default:
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const etcdRetryDelay = 5 * time.Second
+
+// etcdSource reads the config from a single etcd key through etcd's v3 JSON
+// gateway, so it doesn't need the gRPC client.
+type etcdSource struct {
+	endpoints []string
+	key       string
+	username  string
+	password  string
+	http      *http.Client
+	token     string
+
+	// rejected, if set, is called with the error for every config written
+	// to the key that can't be used.
+	rejected func(error)
+}
+
+func newEtcdSource(endpoints, key, username, password, caFile, certFile, keyFile string) (*etcdSource, error) {
+	if endpoints == "" {
+		return nil, fmt.Errorf("etcd key set but no etcd endpoints")
+	}
+
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading etcd CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading etcd client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	var eps []string
+	for _, ep := range strings.Split(endpoints, ",") {
+		eps = append(eps, strings.TrimSuffix(strings.TrimSpace(ep), "/"))
+	}
+
+	return &etcdSource{
+		endpoints: eps,
+		key:       key,
+		username:  username,
+		password:  password,
+		http:      &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}, nil
+}
+
+type etcdKV struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+type etcdHeader struct {
+	Revision string `json:"revision"`
+}
+
+// load returns the config stored at the key and the revision it was read
+// at, for starting a watch.
+func (s *etcdSource) load(ctx context.Context) (*ConfigFile, int64, error) {
+	var resp struct {
+		Header etcdHeader `json:"header"`
+		KVs    []etcdKV   `json:"kvs"`
+	}
+	req := map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(s.key))}
+	if err := s.post(ctx, "/v3/kv/range", req, &resp); err != nil {
+		return nil, 0, err
+	}
+	if len(resp.KVs) == 0 {
+		return nil, 0, fmt.Errorf("etcd key %s not found", s.key)
+	}
+
+	cfg, err := s.decode(resp.KVs[0].Value)
+	if err != nil {
+		return nil, 0, err
+	}
+	rev, _ := strconv.ParseInt(resp.Header.Revision, 10, 64)
+	return cfg, rev, nil
+}
+
+func (s *etcdSource) decode(value string) (*ConfigFile, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("decoding etcd value: %w", err)
+	}
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing config JSON from etcd: %w", err)
+	}
+	return cfg, nil
+}
+
+// watch sends every valid config written to the key after rev to updates.
+// It reconnects on errors and only returns once ctx is done.
+func (s *etcdSource) watch(ctx context.Context, rev int64, updates chan<- *ConfigFile) {
+	for ctx.Err() == nil {
+		next, err := s.watchOnce(ctx, rev+1, updates)
+		if next > rev {
+			rev = next
+		}
+		if err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "etcd watch failed, retrying", "delay", etcdRetryDelay, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(etcdRetryDelay):
+		}
+	}
+}
+
+// watchOnce streams events from one watch request. It returns the newest
+// revision it saw so a retry can resume after it.
+func (s *etcdSource) watchOnce(ctx context.Context, start int64, updates chan<- *ConfigFile) (int64, error) {
+	req := map[string]any{
+		"create_request": map[string]string{
+			"key":            base64.StdEncoding.EncodeToString([]byte(s.key)),
+			"start_revision": strconv.FormatInt(start, 10),
+		},
+	}
+	resp, err := s.do(ctx, "/v3/watch", req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var rev int64
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var msg struct {
+			Result struct {
+				Events []struct {
+					Type string `json:"type"`
+					KV   etcdKV `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return rev, fmt.Errorf("decoding watch response: %w", err)
+		}
+		if msg.Error != nil {
+			return rev, fmt.Errorf("etcd: %s", msg.Error.Message)
+		}
+
+		for _, ev := range msg.Result.Events {
+			if r, err := strconv.ParseInt(ev.KV.ModRevision, 10, 64); err == nil && r > rev {
+				rev = r
+			}
+			if ev.Type == "DELETE" {
+				slog.WarnContext(ctx, "etcd key was deleted, keeping the current config", "key", s.key)
+				continue
+			}
+			cfg, err := s.decode(ev.KV.Value)
+			if err != nil {
+				slog.ErrorContext(ctx, "Ignoring config update from etcd", "error", err)
+				if s.rejected != nil {
+					s.rejected(err)
+				}
+				continue
+			}
+			select {
+			case updates <- cfg:
+			case <-ctx.Done():
+				return rev, ctx.Err()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return rev, err
+	}
+	return rev, fmt.Errorf("watch stream closed")
+}
+
+func (s *etcdSource) post(ctx context.Context, path string, in, out any) error {
+	resp, err := s.do(ctx, path, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// do sends a request to the first endpoint that answers, authenticating
+// first if credentials are configured.
+func (s *etcdSource) do(ctx context.Context, path string, in any) (*http.Response, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, ep := range s.endpoints {
+		if s.username != "" && s.token == "" {
+			if err := s.authenticate(ctx, ep); err != nil {
+				lastErr = err
+				continue
+			}
+		}
+
+		resp, err := s.send(ctx, ep+path, body)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode == http.StatusUnauthorized && s.username != "" {
+			// tokens expire; get a new one and try once more
+			resp.Body.Close()
+			s.token = ""
+			if err := s.authenticate(ctx, ep); err != nil {
+				lastErr = err
+				continue
+			}
+			if resp, err = s.send(ctx, ep+path, body); err != nil {
+				lastErr = err
+				continue
+			}
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("etcd %s returned status %d", ep, resp.StatusCode)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+func (s *etcdSource) send(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", s.token)
+	}
+	return s.http.Do(req)
+}
+
+func (s *etcdSource) authenticate(ctx context.Context, endpoint string) error {
+	body, err := json.Marshal(map[string]string{"name": s.username, "password": s.password})
+	if err != nil {
+		return err
+	}
+	resp, err := s.send(ctx, endpoint+"/v3/auth/authenticate", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd authentication returned status %d", resp.StatusCode)
+	}
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return err
+	}
+	s.token = auth.Token
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func b64(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+func TestEtcdSourceLoad(t *testing.T) {
+	const config = `{"domains": {"example.com": ["192.168.1.1"]}}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/auth/authenticate":
+			json.NewEncoder(w).Encode(map[string]string{"token": "test-token"})
+		case "/v3/kv/range":
+			if r.Header.Get("Authorization") != "test-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var req struct {
+				Key string `json:"key"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Key != b64("/tsddns/config") {
+				json.NewEncoder(w).Encode(map[string]any{"header": map[string]string{"revision": "7"}})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"header": map[string]string{"revision": "7"},
+				"kvs":    []etcdKV{{Key: req.Key, Value: b64(config), ModRevision: "5"}},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		key      string
+		username string
+		wantErr  bool
+	}{
+		{name: "with auth", key: "/tsddns/config", username: "root"},
+		{name: "missing auth", key: "/tsddns/config", wantErr: true},
+		{name: "missing key", key: "/tsddns/other", username: "root", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := newEtcdSource("http://127.0.0.1:1,"+server.URL, tt.key, tt.username, "secret", "", "", "")
+			if err != nil {
+				t.Fatalf("newEtcdSource() unexpected error: %v", err)
+			}
+
+			cfg, rev, err := src.load(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if rev != 7 {
+				t.Errorf("load() revision = %d, want 7", rev)
+			}
+			if len(cfg.Domains) != 1 {
+				t.Errorf("load() got %d domains, want 1", len(cfg.Domains))
+			}
+		})
+	}
+}
+
+func TestEtcdSourceWatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/watch" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintln(w, `{"result":{"created":true}}`)
+		fmt.Fprintf(w, `{"result":{"events":[{"kv":{"value":%q,"mod_revision":"8"}}]}}`+"\n", b64(`{bad`))
+		fmt.Fprintf(w, `{"result":{"events":[{"kv":{"value":%q,"mod_revision":"9"}}]}}`+"\n", b64(`{"new.com": ["10.0.0.1"]}`))
+	}))
+	defer server.Close()
+
+	src, err := newEtcdSource(server.URL, "/tsddns/config", "", "", "", "", "")
+	if err != nil {
+		t.Fatalf("newEtcdSource() unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan *ConfigFile)
+	go src.watch(ctx, 7, updates)
+
+	select {
+	case cfg := <-updates:
+		if _, ok := cfg.Domains["new.com"]; !ok {
+			t.Errorf("watch() sent %v, want new.com", cfg.Domains)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch() sent no update")
+	}
+}
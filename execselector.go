@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const defaultExecTimeout = 10 * time.Second
+
+// runResolverCommand runs an exec: entry's command line and returns the
+// addresses it prints, one per line. Blank lines and lines starting with
+// "#" are ignored. The command line is split on spaces; there is no shell.
+func runResolverCommand(ctx context.Context, command string, timeout time.Duration) ([]string, error) {
+	argv := strings.Fields(command)
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("exec: needs a command")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s: timed out after %v", argv[0], timeout)
+		}
+		return nil, fmt.Errorf("%s: %w: %s", argv[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var addrs []string
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addr, err := netip.ParseAddr(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s printed %q, want an IP address", argv[0], line)
+		}
+		addrs = append(addrs, addr.String())
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s printed no addresses", argv[0])
+	}
+	return addrs, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestResolveExec(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "one per line", entry: "exec:printf 192.0.2.1\\n\\n#comment\\n2001:db8::1\\n", want: []string{"192.0.2.1", "2001:db8::1"}},
+		{name: "family", entry: "exec:printf 192.0.2.1\\n2001:db8::1\\n?family=ipv6", want: []string{"2001:db8::1"}},
+		{name: "capped", entry: "exec:printf 192.0.2.1\\n192.0.2.2\\n?max=1", want: []string{"192.0.2.1"}},
+		{name: "not an address", entry: "exec:echo ns1.example.com", wantErr: true},
+		{name: "no output", entry: "exec:true", wantErr: true},
+		{name: "command fails", entry: "exec:false", wantErr: true},
+		{name: "no such command", entry: "exec:/nonexistent/lookup-ns", wantErr: true},
+		{name: "timeout", entry: "exec:sleep 5?timeout=50ms", wantErr: true},
+		{name: "bad timeout", entry: "exec:true?timeout=soon", wantErr: true},
+		{name: "no command", entry: "exec:", wantErr: true},
+	}
+
+	r := newResolver(nil, Defaults{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
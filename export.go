@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// exportFormats render a split DNS config as a local resolver's config, by
+// export type. Only nameservers that are IP addresses are written.
+var exportFormats = map[string]func(splitDNS map[string][]string) []byte{
+	"blocky":  renderBlocky,
+	"coredns": renderCoreDNS,
+	"dnsmasq": renderDnsmasq,
+	"unbound": renderUnbound,
+}
+
+// defaultReloads are the usual reload commands of the resolvers of export
+// types that have one, run when an export doesn't set its own.
+var defaultReloads = map[string][]string{
+	// dnsmasq re-reads its servers-file on SIGHUP
+	"dnsmasq": {"pkill", "-HUP", "-x", "dnsmasq"},
+	"unbound": {"unbound-control", "reload"},
+}
+
+// Export writes the applied split DNS config to a local resolver's config
+// file after every update, so that the resolver routes the same domains as
+// the tailnet.
+type Export struct {
+	// Type is the file format: "blocky", "coredns", "dnsmasq" or
+	// "unbound".
+	Type string `json:"type"`
+
+	// Path is the file to write. It is replaced atomically.
+	Path string `json:"path"`
+
+	// Reload is the argv to run after the file changed, such as a command
+	// that signals the resolver to re-read it. Without it and ReloadURL,
+	// dnsmasq and unbound exports run their resolver's usual reload; an
+	// empty list runs nothing.
+	Reload []string `json:"reload,omitempty"`
+
+	// ReloadURL, if set, is POSTed to after the file changed and Reload
+	// ran, for resolvers with an HTTP API.
+	ReloadURL string `json:"reloadURL,omitempty"`
+}
+
+func (e Export) validate() error {
+	if _, ok := exportFormats[e.Type]; !ok {
+		return fmt.Errorf("unknown export type %q", e.Type)
+	}
+	if e.Path == "" {
+		return fmt.Errorf("%s export needs a path", e.Type)
+	}
+	return nil
+}
+
+// runExports writes every export. Failures are logged rather than returned
+// since the DNS change has already been applied.
+func runExports(ctx context.Context, exports []Export, splitDNS map[string][]string) {
+	for _, e := range exports {
+		if err := e.run(ctx, splitDNS); err != nil {
+			slog.ErrorContext(ctx, "Export failed", "path", e.Path, "error", err)
+		}
+	}
+}
+
+// run writes the export's file and, if its content changed, runs the
+// reload command.
+func (e Export) run(ctx context.Context, splitDNS map[string][]string) error {
+	data := exportFormats[e.Type](splitDNS)
+	if old, err := os.ReadFile(e.Path); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+	if err := writeFileAtomic(e.Path, data, 0o644); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Wrote export", "type", e.Type, "path", e.Path)
+
+	if err := runReload(ctx, e.reloadCommand()); err != nil {
+		return err
+	}
+	if e.ReloadURL != "" {
+		ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "POST", e.ReloadURL, nil)
+		if err != nil {
+			return fmt.Errorf("reloading: %w", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("reloading: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("reloading: %s returned status %d", e.ReloadURL, resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+// runReload runs the reload command argv, if there is one, after a file it
+// reads was written.
+func runReload(ctx context.Context, argv []string) error {
+	if len(argv) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, argv[0], argv[1:]...).CombinedOutput(); err != nil {
+		return fmt.Errorf("reloading: %s: %w: %s", argv[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// reloadCommand returns the argv to run after the file changed: Reload if
+// it's set, and otherwise the type's default unless ReloadURL reloads the
+// resolver instead.
+func (e Export) reloadCommand() []string {
+	if e.Reload != nil || e.ReloadURL != "" {
+		return e.Reload
+	}
+	return defaultReloads[e.Type]
+}
+
+// exportDomains returns the domains of splitDNS in order, with only the
+// nameservers that are IP addresses. Domains without any are left out.
+func exportDomains(splitDNS map[string][]string) (domains []string, addrs map[string][]string) {
+	addrs = make(map[string][]string)
+	for domain, nameservers := range splitDNS {
+		for _, ns := range nameservers {
+			if _, err := netip.ParseAddr(ns); err == nil {
+				addrs[domain] = append(addrs[domain], ns)
+			}
+		}
+		if len(addrs[domain]) > 0 {
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+	return domains, addrs
+}
+
+const exportHeader = "# Generated by tsddns from the tailnet's split DNS config. Do not edit.\n"
+
+// renderBlocky renders a conditional upstream mapping, for a file in
+// Blocky's config directory. IPv6 nameservers are bracketed as Blocky's
+// upstream syntax needs.
+func renderBlocky(splitDNS map[string][]string) []byte {
+	var b strings.Builder
+	b.WriteString(exportHeader)
+	b.WriteString("conditional:\n  mapping:\n")
+	domains, addrs := exportDomains(splitDNS)
+	for _, domain := range domains {
+		var upstreams []string
+		for _, addr := range addrs[domain] {
+			if strings.Contains(addr, ":") {
+				addr = "[" + addr + "]"
+			}
+			upstreams = append(upstreams, addr)
+		}
+		fmt.Fprintf(&b, "    %s: \"%s\"\n", strings.TrimSuffix(domain, "."), strings.Join(upstreams, ","))
+	}
+	return []byte(b.String())
+}
+
+// renderCoreDNS renders a server block with a forward stanza per domain,
+// for including in a Corefile with import.
+func renderCoreDNS(splitDNS map[string][]string) []byte {
+	blocks := coreDNSServerBlocks(splitDNS)
+	if len(blocks) == 0 {
+		return []byte(exportHeader)
+	}
+	return []byte(exportHeader + strings.Join(blocks, "\n") + "\n")
+}
+
+// coreDNSServerBlocks returns the lines of a server block with a forward
+// stanza per domain, each preceded by a blank line.
+func coreDNSServerBlocks(splitDNS map[string][]string) []string {
+	var lines []string
+	domains, addrs := exportDomains(splitDNS)
+	for _, domain := range domains {
+		lines = append(lines, "", domain+" {", "\tforward . "+strings.Join(addrs[domain], " "), "}")
+	}
+	return lines
+}
+
+// renderDnsmasq renders a server= line per nameserver, for a conf.d file or
+// a servers-file.
+func renderDnsmasq(splitDNS map[string][]string) []byte {
+	var b strings.Builder
+	b.WriteString(exportHeader)
+	domains, addrs := exportDomains(splitDNS)
+	for _, domain := range domains {
+		for _, addr := range addrs[domain] {
+			fmt.Fprintf(&b, "server=/%s/%s\n", domain, addr)
+		}
+	}
+	return []byte(b.String())
+}
+
+// renderUnbound renders a forward-zone clause per domain, for including in
+// unbound.conf.
+func renderUnbound(splitDNS map[string][]string) []byte {
+	var b strings.Builder
+	b.WriteString(exportHeader)
+	domains, addrs := exportDomains(splitDNS)
+	for _, domain := range domains {
+		fmt.Fprintf(&b, "\nforward-zone:\n\tname: \"%s.\"\n", strings.TrimSuffix(domain, "."))
+		for _, addr := range addrs[domain] {
+			fmt.Fprintf(&b, "\tforward-addr: %s\n", addr)
+		}
+	}
+	return []byte(b.String())
+}
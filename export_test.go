@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var exportSplitDNS = map[string][]string{
+	"corp.example.com": {"100.64.0.1", "fd7a:115c:a1e0::1"},
+	"doh.example.com":  {"https://dns.example.com/dns-query"},
+	"lab.example.com":  {"10.0.0.53", "https://dns.example.com/dns-query"},
+}
+
+func TestRenderExports(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want string
+	}{
+		{
+			typ: "blocky",
+			want: exportHeader + `conditional:
+  mapping:
+    corp.example.com: "100.64.0.1,[fd7a:115c:a1e0::1]"
+    lab.example.com: "10.0.0.53"
+`,
+		},
+		{
+			typ: "coredns",
+			want: exportHeader + `
+corp.example.com {
+	forward . 100.64.0.1 fd7a:115c:a1e0::1
+}
+
+lab.example.com {
+	forward . 10.0.0.53
+}
+`,
+		},
+		{
+			typ: "dnsmasq",
+			want: exportHeader + `server=/corp.example.com/100.64.0.1
+server=/corp.example.com/fd7a:115c:a1e0::1
+server=/lab.example.com/10.0.0.53
+`,
+		},
+		{
+			typ: "unbound",
+			want: exportHeader + `
+forward-zone:
+	name: "corp.example.com."
+	forward-addr: 100.64.0.1
+	forward-addr: fd7a:115c:a1e0::1
+
+forward-zone:
+	name: "lab.example.com."
+	forward-addr: 10.0.0.53
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			if got := string(exportFormats[tt.typ](exportSplitDNS)); got != tt.want {
+				t.Errorf("render = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportRun(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "reloaded")
+	e := Export{Type: "coredns", Path: filepath.Join(dir, "tsddns.conf"), Reload: []string{"touch", marker}}
+
+	if err := e.run(context.Background(), exportSplitDNS); err != nil {
+		t.Fatalf("run() unexpected error: %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("reload didn't run after the file was written: %v", err)
+	}
+
+	// an unchanged file is neither rewritten nor reloaded
+	os.Remove(marker)
+	if err := e.run(context.Background(), exportSplitDNS); err != nil {
+		t.Fatalf("run() unexpected error: %v", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("reload ran although the file didn't change")
+	}
+
+	e.Reload = []string{"false"}
+	if err := e.run(context.Background(), map[string][]string{"other.example.com": {"10.0.0.1"}}); err == nil {
+		t.Error("run() didn't report a failed reload")
+	}
+}
+
+func TestExportReloadCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		export Export
+		want   []string
+	}{
+		{name: "dnsmasq", export: Export{Type: "dnsmasq"}, want: []string{"pkill", "-HUP", "-x", "dnsmasq"}},
+		{name: "unbound", export: Export{Type: "unbound"}, want: []string{"unbound-control", "reload"}},
+		{name: "no default", export: Export{Type: "coredns"}},
+		{name: "own reload", export: Export{Type: "unbound", Reload: []string{"systemctl", "reload", "unbound"}}, want: []string{"systemctl", "reload", "unbound"}},
+		{name: "disabled", export: Export{Type: "dnsmasq", Reload: []string{}}},
+		{name: "reload url", export: Export{Type: "unbound", ReloadURL: "http://127.0.0.1:8080/reload"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.export.reloadCommand(); !slices.Equal(got, tt.want) {
+				t.Errorf("reloadCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// an empty list in the config opts out of the default
+	var e Export
+	if err := json.Unmarshal([]byte(`{"type": "dnsmasq", "path": "/etc/dnsmasq.servers", "reload": []}`), &e); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.reloadCommand(); len(got) != 0 {
+		t.Errorf("reloadCommand() = %q with \"reload\": [], want nothing", got)
+	}
+}
+
+func TestExportReloadURL(t *testing.T) {
+	reloads := 0
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			reloads++
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	e := Export{Type: "blocky", Path: filepath.Join(t.TempDir(), "tsddns.yml"), ReloadURL: srv.URL}
+	if err := e.run(context.Background(), exportSplitDNS); err != nil {
+		t.Fatalf("run() unexpected error: %v", err)
+	}
+	if err := e.run(context.Background(), exportSplitDNS); err != nil {
+		t.Fatalf("run() unexpected error: %v", err)
+	}
+	if reloads != 1 {
+		t.Errorf("reload URL was called %d times, want once", reloads)
+	}
+
+	status = http.StatusInternalServerError
+	if err := e.run(context.Background(), map[string][]string{"other.example.com": {"10.0.0.1"}}); err == nil {
+		t.Error("run() didn't report a failed reload")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// resolveGlobal resolves the entries of the global nameserver list the same
+// way a domain's entries are resolved, including fallback chains and
+// weights.
+func (r *resolver) resolveGlobal(ctx context.Context, nameservers []string) ([]string, error) {
+	if err := r.prefetchDevices(ctx, nameservers); err != nil {
+		return nil, err
+	}
+
+	var resolved []string
+	weights := make(map[string]int)
+	weighted := false
+	for _, ns := range nameservers {
+		ns, weight, err := parseWeight(ns)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := r.resolveEntry(ctx, "global", ns)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			weights[ip] = max(weights[ip], weight)
+		}
+		weighted = weighted || weight != 1
+		resolved = append(resolved, ips...)
+	}
+	for _, ip := range duplicates(resolved) {
+		slog.WarnContext(ctx, "The global nameservers include an address more than once", "ip", ip)
+	}
+	if weighted {
+		sortByWeight(resolved, weights)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestResolveGlobal(t *testing.T) {
+	tests := []struct {
+		name        string
+		nameservers []string
+		want        []string
+		wantErr     bool
+	}{
+		{name: "literal", nameservers: []string{"1.1.1.1", "9.9.9.9"}, want: []string{"1.1.1.1", "9.9.9.9"}},
+		{name: "weighted", nameservers: []string{"1.1.1.1", "9.9.9.9?weight=3"}, want: []string{"9.9.9.9", "1.1.1.1"}},
+		{name: "fallback", nameservers: []string{"exec:false || 8.8.8.8"}, want: []string{"8.8.8.8"}},
+		{name: "unresolvable", nameservers: []string{"exec:false"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newResolver(nil, Defaults{})
+			got, err := r.resolveGlobal(context.Background(), tt.nameservers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveGlobal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolveGlobal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateGlobalDNS(t *testing.T) {
+	var nameservers []string
+	config := map[string]any{"preferences": map[string]any{"overrideLocalDNS": false}}
+	splitDNSWrites := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v2/tailnet/test/dns/nameservers":
+			json.NewEncoder(w).Encode(map[string][]string{"dns": nameservers})
+		case "POST /api/v2/tailnet/test/dns/nameservers":
+			var body map[string][]string
+			json.NewDecoder(r.Body).Decode(&body)
+			nameservers = body["dns"]
+			json.NewEncoder(w).Encode(body)
+		case "GET /api/v2/tailnet/test/dns/configuration":
+			json.NewEncoder(w).Encode(config)
+		case "POST /api/v2/tailnet/test/dns/configuration":
+			config = nil
+			json.NewDecoder(r.Body).Decode(&config)
+		default:
+			if strings.Contains(r.URL.Path, "split-dns") {
+				splitDNSWrites++
+			}
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	client := &tailscale.Client{BaseURL: baseURL, APIKey: "test-key", Tailnet: "test"}
+
+	cfg, err := parseConfig([]byte(`{"mode": "global", "global": ["9.9.9.9", "1.1.1.1"]}`))
+	if err != nil {
+		t.Fatalf("parseConfig() unexpected error: %v", err)
+	}
+	if err := updateDNS(context.Background(), client, cfg); err != nil {
+		t.Fatalf("updateDNS() unexpected error: %v", err)
+	}
+
+	if want := []string{"9.9.9.9", "1.1.1.1"}; !slices.Equal(nameservers, want) {
+		t.Errorf("global nameservers = %v, want %v", nameservers, want)
+	}
+	if override, err := getOverrideLocalDNS(context.Background(), client); err != nil || !override {
+		t.Errorf("override local DNS = %v, %v, want true", override, err)
+	}
+	if splitDNSWrites > 0 {
+		t.Errorf("global mode touched split DNS %d times", splitDNSWrites)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// policyFile is the part of the tailnet policy file tsddns reads.
+type policyFile struct {
+	Groups    map[string][]string `json:"groups"`
+	NodeAttrs []nodeAttr          `json:"nodeAttrs"`
+}
+
+// nodeAttr is a nodeAttrs entry. Only app connector configuration is read.
+type nodeAttr struct {
+	App struct {
+		AppConnectors []appConnector `json:"tailscale.com/app-connectors"`
+	} `json:"app"`
+}
+
+// getPolicyFile fetches the tailnet policy file.
+func getPolicyFile(ctx context.Context, client *tailscale.Client) (*policyFile, error) {
+	var policy policyFile
+	if err := apiGet(ctx, client, "acl", &policy); err != nil {
+		return nil, fmt.Errorf("fetching policy file: %w", err)
+	}
+	return &policy, nil
+}
+
+// policy returns the tailnet policy file, fetching it at most once per
+// update.
+func (r *resolver) policy(ctx context.Context) (*policyFile, error) {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+	if r.acl == nil {
+		policy, err := getPolicyFile(ctx, r.client)
+		if err != nil {
+			return nil, err
+		}
+		r.acl = policy
+	}
+	return r.acl, nil
+}
+
+// groupMembers returns the members of the named group, which may be given
+// with or without its "group:" prefix.
+func (r *resolver) groupMembers(ctx context.Context, group string) ([]string, error) {
+	policy, err := r.policy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	group = "group:" + strings.TrimPrefix(group, "group:")
+	members, ok := policy.Groups[group]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in the policy file", group)
+	}
+	return members, nil
+}
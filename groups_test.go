@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestResolveGroup(t *testing.T) {
+	policyFetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/tailnet/example.com/acl" {
+			http.NotFound(w, r)
+			return
+		}
+		policyFetches++
+		w.Write([]byte(`{
+			"groups": {
+				"group:infra": ["alice@example.com", "Bob@example.com"],
+				"group:empty": []
+			},
+			"acls": []
+		}`))
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	client := &tailscale.Client{BaseURL: baseURL, APIKey: "test-key", Tailnet: "example.com"}
+
+	devices := []tailscale.Device{
+		{Name: "alice-laptop.example.ts.net", Hostname: "alice-laptop", User: "alice@example.com", Addresses: []string{"100.64.0.1"}},
+		{Name: "bob-debug.example.ts.net", Hostname: "debug-ns", User: "bob@example.com", Tags: []string{"tag:debug-dns"}, Addresses: []string{"100.64.0.2"}},
+		{Name: "carol-debug.example.ts.net", Hostname: "debug-ns", User: "carol@example.com", Tags: []string{"tag:debug-dns"}, Addresses: []string{"100.64.0.3"}},
+	}
+
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "every member device", entry: "group:infra", want: []string{"100.64.0.1", "100.64.0.2"}},
+		{name: "with prefix", entry: "group:group:infra", want: []string{"100.64.0.1", "100.64.0.2"}},
+		{name: "intersect tag", entry: "group:infra?tag=debug-dns", want: []string{"100.64.0.2"}},
+		{name: "intersect host", entry: "group:infra?host=alice-*", want: []string{"100.64.0.1"}},
+		{name: "empty group", entry: "group:empty", wantErr: true},
+		{name: "unknown group", entry: "group:oncall", wantErr: true},
+	}
+
+	r := &resolver{client: client, devices: devices, now: time.Now()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ips, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(ips, tt.want) {
+				t.Errorf("resolve() = %v, want %v", ips, tt.want)
+			}
+		})
+	}
+
+	if policyFetches != 1 {
+		t.Errorf("fetched the policy file %d times, want once per update", policyFetches)
+	}
+}
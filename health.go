@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minStuckAfter is the least time an update may run before the daemon is
+// considered wedged, since an update with API retries can take minutes.
+const minStuckAfter = 10 * time.Minute
+
+// health tracks the daemon's updates for the /healthz and /readyz
+// endpoints.
+type health struct {
+	// interval is the longest the daemon waits between updates.
+	interval time.Duration
+	now      func() time.Time
+
+	mu          sync.Mutex
+	running     time.Time // when the update in flight started
+	lastDone    time.Time
+	lastErr     error
+	lastSuccess time.Time
+	configErr   error
+	paused      bool
+}
+
+func newHealth(interval time.Duration) *health {
+	return &health{interval: interval, now: time.Now, lastDone: time.Now()}
+}
+
+// begin records that an update started.
+func (h *health) begin() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = h.now()
+}
+
+// done records how an update ended.
+func (h *health) done(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = time.Time{}
+	h.lastDone = h.now()
+	h.lastErr = err
+	if err == nil {
+		h.lastSuccess = h.lastDone
+	}
+}
+
+// setConfigErr records why the latest config was rejected, or nil once a
+// config is accepted.
+func (h *health) setConfigErr(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.configErr = err
+}
+
+// setPaused pauses or resumes the daemon's updates.
+func (h *health) setPaused(paused bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.paused = paused
+}
+
+func (h *health) isPaused() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.paused
+}
+
+// live returns why the daemon looks wedged: an update that has run for
+// far too long, or no update for far longer than the interval.
+func (h *health) live() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	stuckAfter := max(2*h.interval, minStuckAfter)
+	if !h.running.IsZero() {
+		if age := now.Sub(h.running); age > stuckAfter {
+			return fmt.Errorf("update running for %v", age.Round(time.Second))
+		}
+		return nil
+	}
+	// a paused daemon skips its updates on purpose
+	if h.paused {
+		return nil
+	}
+	if age := now.Sub(h.lastDone); age > h.interval+stuckAfter {
+		return fmt.Errorf("no update for %v", age.Round(time.Second))
+	}
+	return nil
+}
+
+// ready returns why the daemon isn't keeping DNS up to date: a rejected
+// config, a failed last update, which includes bad credentials, or no
+// successful update within two intervals, or updates being paused.
+func (h *health) ready() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case h.paused:
+		return fmt.Errorf("updates are paused")
+	case h.configErr != nil:
+		return fmt.Errorf("config rejected: %w", h.configErr)
+	case h.lastErr != nil:
+		return fmt.Errorf("last update failed: %w", h.lastErr)
+	case h.lastSuccess.IsZero():
+		return fmt.Errorf("no successful update yet")
+	}
+	if age := h.now().Sub(h.lastSuccess); age > 2*h.interval {
+		return fmt.Errorf("last successful update was %v ago", age.Round(time.Second))
+	}
+	return nil
+}
+
+func (h *health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	switch r.URL.Path {
+	case "/healthz":
+		err = h.live()
+	case "/readyz":
+		err = h.ready()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	h.mu.Lock()
+	lastSuccess := h.lastSuccess
+	h.mu.Unlock()
+	if lastSuccess.IsZero() {
+		fmt.Fprintln(w, "ok")
+	} else {
+		fmt.Fprintf(w, "ok, last successful update at %s\n", lastSuccess.Format(time.RFC3339))
+	}
+}
+
+// startHealthServer serves /healthz and /readyz for h on addr.
+func startHealthServer(addr string, h *health) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := http.Serve(ln, h); err != nil {
+			slog.Error("Health server stopped", "error", err)
+		}
+	}()
+	return nil
+}
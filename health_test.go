@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	h := newHealth(5 * time.Minute)
+	h.now = func() time.Time { return now }
+	h.lastDone = start
+
+	check := func(path string, wantStatus int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != wantStatus {
+			t.Errorf("%s at +%v = %d %q, want %d", path, now.Sub(start), rec.Code, rec.Body.String(), wantStatus)
+		}
+	}
+
+	// live but not ready until the first update succeeds
+	check("/healthz", http.StatusOK)
+	check("/readyz", http.StatusServiceUnavailable)
+
+	h.begin()
+	now = now.Add(time.Second)
+	h.done(nil)
+	check("/readyz", http.StatusOK)
+
+	// a failed update, like one with bad credentials, isn't ready
+	h.begin()
+	h.done(errors.New("API returned status 401"))
+	check("/healthz", http.StatusOK)
+	check("/readyz", http.StatusServiceUnavailable)
+	h.begin()
+	h.done(nil)
+	check("/readyz", http.StatusOK)
+
+	// a rejected config isn't ready until a good one arrives
+	h.setConfigErr(errors.New("bad JSON"))
+	check("/readyz", http.StatusServiceUnavailable)
+	h.setConfigErr(nil)
+	check("/readyz", http.StatusOK)
+
+	// a stale success isn't ready
+	now = now.Add(11 * time.Minute)
+	check("/readyz", http.StatusServiceUnavailable)
+
+	// an update stuck for too long is wedged
+	h.begin()
+	now = now.Add(9 * time.Minute)
+	check("/healthz", http.StatusOK)
+	now = now.Add(2 * time.Minute)
+	check("/healthz", http.StatusServiceUnavailable)
+
+	// and so is a loop that stopped updating
+	h.done(nil)
+	now = now.Add(16 * time.Minute)
+	check("/healthz", http.StatusServiceUnavailable)
+
+	check("/other", http.StatusNotFound)
+}
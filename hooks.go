@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const hookTimeout = 30 * time.Second
+
+// FlushHook is an action run after split DNS changes so that clients drop
+// cached answers for the changed domains.
+type FlushHook struct {
+	// Type is one of "systemd-resolved", "dnsmasq", "command" or "webhook".
+	Type string `json:"type"`
+
+	// Command is the argv to run for the "command" type.
+	Command []string `json:"command,omitempty"`
+
+	// URL receives a JSON POST of the changes for the "webhook" type.
+	URL string `json:"url,omitempty"`
+
+	// Domains limits the hook to changes of these domains. Empty means
+	// every domain.
+	Domains []string `json:"domains,omitempty"`
+}
+
+func (h FlushHook) validate() error {
+	switch h.Type {
+	case "systemd-resolved", "dnsmasq":
+	case "command":
+		if len(h.Command) == 0 {
+			return fmt.Errorf("command hook needs a command")
+		}
+	case "webhook":
+		if h.URL == "" {
+			return fmt.Errorf("webhook hook needs a url")
+		}
+	default:
+		return fmt.Errorf("unknown hook type %q", h.Type)
+	}
+	return nil
+}
+
+// domainChange is a domain whose nameservers differ between what the
+// tailnet had and what was applied.
+type domainChange struct {
+	Domain string   `json:"domain"`
+	Old    []string `json:"old,omitempty"`
+	New    []string `json:"new,omitempty"`
+
+	// RequeryAfter is when clients that cached the old targets should
+	// have picked up the new ones, based on the domain's TTL hint.
+	RequeryAfter *time.Time `json:"requeryAfter,omitempty"`
+}
+
+// diffSplitDNS returns the domains that differ between current and desired,
+// sorted by domain. Domains missing from desired are reported as removed.
+func diffSplitDNS(current, desired map[string][]string) []domainChange {
+	var changes []domainChange
+	for domain, ns := range desired {
+		if old, ok := current[domain]; !ok || !slices.Equal(old, ns) {
+			changes = append(changes, domainChange{Domain: domain, Old: old, New: ns})
+		}
+	}
+	for domain, old := range current {
+		if _, ok := desired[domain]; !ok {
+			changes = append(changes, domainChange{Domain: domain, Old: old})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Domain < changes[j].Domain })
+	return changes
+}
+
+// annotateChanges sets the suggested re-query time on each change that has a
+// TTL hint.
+func annotateChanges(changes []domainChange, hints map[string]Duration, now time.Time) {
+	for i := range changes {
+		ttl, ok := hints[changes[i].Domain]
+		if !ok {
+			continue
+		}
+		t := now.Add(time.Duration(ttl))
+		changes[i].RequeryAfter = &t
+	}
+}
+
+// runFlushHooks runs each hook for the changes it covers. Failures are
+// logged rather than returned since the DNS change has already been applied.
+func runFlushHooks(ctx context.Context, hooks []FlushHook, changes []domainChange) {
+	for _, h := range hooks {
+		matched := h.filter(changes)
+		if len(matched) == 0 {
+			continue
+		}
+		slog.InfoContext(ctx, "Running flush hook", "hook", h.Type, "domains", len(matched))
+		if err := h.run(ctx, matched); err != nil {
+			slog.ErrorContext(ctx, "Flush hook failed", "hook", h.Type, "error", err)
+		}
+	}
+}
+
+func (h FlushHook) filter(changes []domainChange) []domainChange {
+	if len(h.Domains) == 0 {
+		return changes
+	}
+	var matched []domainChange
+	for _, c := range changes {
+		if slices.Contains(h.Domains, c.Domain) {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+func (h FlushHook) run(ctx context.Context, changes []domainChange) error {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+
+	switch h.Type {
+	case "systemd-resolved":
+		return runHookCommand(ctx, []string{"resolvectl", "flush-caches"}, changes)
+	case "dnsmasq":
+		// dnsmasq clears its cache on SIGHUP.
+		return runHookCommand(ctx, []string{"pkill", "-HUP", "-x", "dnsmasq"}, changes)
+	case "command":
+		return runHookCommand(ctx, h.Command, changes)
+	case "webhook":
+		return postHookWebhook(ctx, h.URL, changes)
+	}
+	return fmt.Errorf("unknown hook type %q", h.Type)
+}
+
+// runHookCommand runs argv with the changed domains in TSDDNS_DOMAINS.
+func runHookCommand(ctx context.Context, argv []string, changes []domainChange) error {
+	domains := make([]string, len(changes))
+	for i, c := range changes {
+		domains[i] = c.Domain
+	}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), "TSDDNS_DOMAINS="+strings.Join(domains, ","))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", argv[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func postHookWebhook(ctx context.Context, url string, changes []domainChange) error {
+	body, err := json.Marshal(map[string]any{"changes": changes})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDiffSplitDNS(t *testing.T) {
+	current := map[string][]string{
+		"same.com":    {"100.64.0.1"},
+		"changed.com": {"100.64.0.2"},
+		"removed.com": {"100.64.0.3"},
+	}
+	desired := map[string][]string{
+		"same.com":    {"100.64.0.1"},
+		"changed.com": {"100.64.0.4"},
+		"added.com":   {"100.64.0.5"},
+	}
+
+	changes := diffSplitDNS(current, desired)
+	want := []string{"added.com", "changed.com", "removed.com"}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d: %+v", len(changes), len(want), changes)
+	}
+	for i, c := range changes {
+		if c.Domain != want[i] {
+			t.Errorf("changes[%d] = %s, want %s", i, c.Domain, want[i])
+		}
+	}
+	if changes[2].New != nil {
+		t.Errorf("removed domain has new nameservers %v", changes[2].New)
+	}
+}
+
+func TestAnnotateChanges(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	changes := []domainChange{{Domain: "hinted.com"}, {Domain: "plain.com"}}
+
+	annotateChanges(changes, map[string]Duration{"hinted.com": Duration(5 * time.Minute)}, now)
+
+	if changes[0].RequeryAfter == nil || !changes[0].RequeryAfter.Equal(now.Add(5*time.Minute)) {
+		t.Errorf("hinted.com requeryAfter = %v, want %v", changes[0].RequeryAfter, now.Add(5*time.Minute))
+	}
+	if changes[1].RequeryAfter != nil {
+		t.Errorf("plain.com requeryAfter = %v, want nil", changes[1].RequeryAfter)
+	}
+}
+
+func TestRunFlushHooksWebhook(t *testing.T) {
+	var got []domainChange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Changes []domainChange `json:"changes"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		got = body.Changes
+	}))
+	defer server.Close()
+
+	hooks := []FlushHook{{Type: "webhook", URL: server.URL, Domains: []string{"b.com"}}}
+	changes := []domainChange{
+		{Domain: "a.com", New: []string{"100.64.0.1"}},
+		{Domain: "b.com", New: []string{"100.64.0.2"}},
+	}
+
+	runFlushHooks(context.Background(), hooks, changes)
+
+	if len(got) != 1 || got[0].Domain != "b.com" {
+		t.Errorf("webhook got %+v, want only b.com", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// HostsFile configures records kept in a hosts-format file, like
+// /etc/hosts, for systems that can't be pointed at a nameserver.
+type HostsFile struct {
+	// Path is the file to write. tsddns keeps its lines in a marked block
+	// and leaves every other line alone. The file is replaced atomically.
+	Path string `json:"path"`
+
+	// Records are host names. Each address gets an "ip hostname" line.
+	Records RecordSet `json:"records"`
+
+	// Reload is the argv to run after the file changed.
+	Reload []string `json:"reload,omitempty"`
+}
+
+func (h *HostsFile) validate() error {
+	if h.Path == "" {
+		return fmt.Errorf("needs a path")
+	}
+	if len(h.Records) == 0 {
+		return fmt.Errorf("needs records")
+	}
+	return nil
+}
+
+// hostsLines returns tsddns's block of hosts file lines, one per address.
+func hostsLines(records []record) []string {
+	var lines []string
+	for _, rec := range records {
+		lines = append(lines, rec.Value+"\t"+rec.Name)
+	}
+	return managedBlock(lines)
+}
+
+// sync resolves the configured records and writes them to the
+// hosts file's managed block, running the reload command if it changed.
+func (h *HostsFile) sync(ctx context.Context, r *resolver, _ map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, h.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	records, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+
+	perm := fs.FileMode(0o644)
+	old, err := os.ReadFile(h.Path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if info, err := os.Stat(h.Path); err == nil {
+			perm = info.Mode().Perm()
+		}
+	}
+	var lines []string
+	if len(old) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(old), "\n"), "\n")
+	}
+	lines = replaceManagedBlock(lines, hostsLines(records))
+	var data []byte
+	if len(lines) > 0 {
+		data = []byte(strings.Join(lines, "\n") + "\n")
+	}
+	if bytes.Equal(old, data) {
+		slog.InfoContext(ctx, "Hosts file is up to date", "path", h.Path)
+		return nil
+	}
+	if err := writeFileAtomic(h.Path, data, perm); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Wrote hosts file", "path", h.Path, "records", len(records))
+	return runReload(ctx, h.Reload)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncHostsFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string // "" for no file
+		want     string
+	}{
+		{
+			name: "new file",
+			want: "# BEGIN tsddns\n100.64.0.1\tfoo.internal.example.com\nfd7a:115c:a1e0::1\tfoo.internal.example.com\n# END tsddns\n",
+		},
+		{
+			name:     "keeps other lines",
+			existing: "127.0.0.1\tlocalhost\n# BEGIN tsddns\n100.64.0.9\told.internal.example.com\n# END tsddns\n10.0.0.1\trouter\n",
+			want:     "127.0.0.1\tlocalhost\n10.0.0.1\trouter\n# BEGIN tsddns\n100.64.0.1\tfoo.internal.example.com\nfd7a:115c:a1e0::1\tfoo.internal.example.com\n# END tsddns\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "hosts")
+			if tt.existing != "" {
+				if err := os.WriteFile(path, []byte(tt.existing), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			marker := filepath.Join(dir, "reloaded")
+			h := &HostsFile{
+				Path:    path,
+				Records: Config{"foo.internal.example.com": {"fd7a:115c:a1e0::1", "100.64.0.1"}},
+				Reload:  []string{"touch", marker},
+			}
+			if err := h.sync(context.Background(), newResolver(nil, Defaults{}), nil); err != nil {
+				t.Fatalf("sync() unexpected error: %v", err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("hosts file = %q, want %q", got, tt.want)
+			}
+			if _, err := os.Stat(marker); err != nil {
+				t.Errorf("reload didn't run after the file was written: %v", err)
+			}
+			if info, err := os.Stat(path); err == nil && tt.existing != "" && info.Mode().Perm() != 0o600 {
+				t.Errorf("file mode = %v, want the original 0600", info.Mode().Perm())
+			}
+
+			// an unchanged file isn't rewritten or reloaded
+			os.Remove(marker)
+			if err := h.sync(context.Background(), newResolver(nil, Defaults{}), nil); err != nil {
+				t.Fatalf("sync() unexpected error: %v", err)
+			}
+			if _, err := os.Stat(marker); err == nil {
+				t.Error("reload ran although the file didn't change")
+			}
+		})
+	}
+}
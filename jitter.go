@@ -0,0 +1,16 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// jitteredInterval returns interval plus a random delay of up to jitter,
+// so instances started together drift apart instead of calling the API at
+// the same moments.
+func jitteredInterval(interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + rand.N(jitter+1)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitteredInterval(t *testing.T) {
+	if got := jitteredInterval(5*time.Minute, 0); got != 5*time.Minute {
+		t.Errorf("jitteredInterval() without jitter = %v, want 5m", got)
+	}
+	seen := make(map[time.Duration]bool)
+	for range 100 {
+		got := jitteredInterval(5*time.Minute, 30*time.Second)
+		if got < 5*time.Minute || got > 5*time.Minute+30*time.Second {
+			t.Fatalf("jitteredInterval() = %v, want between 5m and 5m30s", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("jitteredInterval() returned the same interval 100 times")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// k8sAddressAnnotation overrides the address a k8s: entry resolves to.
+const k8sAddressAnnotation = "tsddns/address"
+
+// serviceAccountDir is where Kubernetes mounts the pod's API credentials.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// k8sClient talks to the Kubernetes API with the pod's service account.
+type k8sClient struct {
+	host      string
+	tokenFile string
+	http      *http.Client
+}
+
+func newInClusterK8s() (*k8sClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+
+	pem, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("reading cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in cluster CA")
+	}
+
+	return &k8sClient{
+		host:      "https://" + net.JoinHostPort(host, port),
+		tokenFile: serviceAccountDir + "/token",
+		http:      &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+	}, nil
+}
+
+// newRequest returns an authenticated request for path on the API server.
+func (k *k8sClient) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, k.host+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// the token is read every time because bound tokens are rotated
+	if k.tokenFile != "" {
+		token, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return req, nil
+}
+
+// k8sService is the part of a Kubernetes Service tsddns uses.
+type k8sService struct {
+	Metadata struct {
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		ClusterIPs []string `json:"clusterIPs"`
+	} `json:"spec"`
+	Status struct {
+		LoadBalancer struct {
+			Ingress []struct {
+				IP string `json:"ip"`
+			} `json:"ingress"`
+		} `json:"loadBalancer"`
+	} `json:"status"`
+}
+
+func (k *k8sClient) getService(ctx context.Context, namespace, name string) (*k8sService, error) {
+	req, err := k.newRequest(ctx, "GET", fmt.Sprintf("/api/v1/namespaces/%s/services/%s", namespace, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("service %s/%s not found", namespace, name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Kubernetes API returned status %d", resp.StatusCode)
+	}
+
+	var svc k8sService
+	if err := json.NewDecoder(resp.Body).Decode(&svc); err != nil {
+		return nil, fmt.Errorf("decoding service: %w", err)
+	}
+	return &svc, nil
+}
+
+// addrs returns the addresses of a Kubernetes Service for a k8s:
+// entry. ipType is "cluster" or "loadbalancer"; if it's empty the
+// tsddns/address annotation wins, then load balancer IPs, then cluster IPs.
+func (svc *k8sService) addrs(ipType string) ([]string, error) {
+	if ipType == "" {
+		if a := svc.Metadata.Annotations[k8sAddressAnnotation]; a != "" {
+			var addrs []string
+			for _, addr := range strings.Split(a, ",") {
+				addrs = append(addrs, strings.TrimSpace(addr))
+			}
+			return addrs, nil
+		}
+	}
+
+	var lbIPs []string
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			lbIPs = append(lbIPs, ingress.IP)
+		}
+	}
+	var clusterIPs []string
+	for _, ip := range svc.Spec.ClusterIPs {
+		if ip != "None" {
+			clusterIPs = append(clusterIPs, ip)
+		}
+	}
+
+	switch ipType {
+	case "":
+		if len(lbIPs) > 0 {
+			return lbIPs, nil
+		}
+		if len(clusterIPs) > 0 {
+			return clusterIPs, nil
+		}
+		return nil, fmt.Errorf("service has no addresses")
+	case "loadbalancer":
+		if len(lbIPs) == 0 {
+			return nil, fmt.Errorf("service has no load balancer IP")
+		}
+		return lbIPs, nil
+	case "cluster":
+		if len(clusterIPs) == 0 {
+			return nil, fmt.Errorf("service has no cluster IP")
+		}
+		return clusterIPs, nil
+	}
+	return nil, fmt.Errorf("unknown ip option %q", ipType)
+}
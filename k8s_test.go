@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestResolveK8s(t *testing.T) {
+	services := map[string]string{
+		"/api/v1/namespaces/dns/services/coredns": `{
+			"spec": {"clusterIPs": ["10.96.0.10", "fd00::a"]}
+		}`,
+		"/api/v1/namespaces/dns/services/external": `{
+			"spec": {"clusterIPs": ["10.96.0.11"]},
+			"status": {"loadBalancer": {"ingress": [{"ip": "192.168.1.53"}, {"hostname": "lb.example.com"}]}}
+		}`,
+		"/api/v1/namespaces/dns/services/annotated": `{
+			"metadata": {"annotations": {"tsddns/address": "100.64.0.53, 100.64.0.54"}},
+			"spec": {"clusterIPs": ["10.96.0.12"]}
+		}`,
+		"/api/v1/namespaces/dns/services/headless": `{
+			"spec": {"clusterIPs": ["None"]}
+		}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := services[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	r := newResolver(nil, Defaults{})
+	r.k8s = &k8sClient{host: server.URL, http: server.Client()}
+
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "cluster IP", entry: "k8s:dns/coredns", want: []string{"10.96.0.10"}},
+		{name: "cluster IPs, both families", entry: "k8s:dns/coredns?family=both", want: []string{"10.96.0.10", "fd00::a"}},
+		{name: "load balancer preferred", entry: "k8s:dns/external", want: []string{"192.168.1.53"}},
+		{name: "forced cluster IP", entry: "k8s:dns/external?ip=cluster", want: []string{"10.96.0.11"}},
+		{name: "annotation", entry: "k8s:dns/annotated?family=ipv4", want: []string{"100.64.0.53", "100.64.0.54"}},
+		{name: "no load balancer", entry: "k8s:dns/coredns?ip=loadbalancer", wantErr: true},
+		{name: "headless", entry: "k8s:dns/headless", wantErr: true},
+		{name: "not found", entry: "k8s:dns/missing", wantErr: true},
+		{name: "missing namespace", entry: "k8s:coredns", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
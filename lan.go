@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// lanRouters returns the devices matching name that have an approved
+// subnet route, the candidates for lan: selectors.
+func lanRouters(name string, devices []tailscale.Device, routes routeLookup) ([]tailscale.Device, error) {
+	var routers []tailscale.Device
+	for _, device := range devices {
+		deviceRoutes, err := routes(device)
+		if err != nil {
+			return nil, err
+		}
+		if len(subnetRoutes(deviceRoutes)) > 0 {
+			routers = append(routers, device)
+		}
+	}
+	if len(routers) == 0 {
+		return nil, fmt.Errorf("no subnet routers matching %s", name)
+	}
+	return routers, nil
+}
+
+// subnetRoutes returns the approved routes among routes, not counting exit
+// node routes.
+func subnetRoutes(routes *tailscale.DeviceRoutes) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, route := range routes.Advertised {
+		if slices.Contains(exitNodeRoutes, route) || !isApprovedRoute(routes, route) {
+			continue
+		}
+		if p, err := netip.ParsePrefix(route); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// lanAddrs returns the device's own addresses inside its approved subnet
+// routes, taken from the endpoints it reports to the coordination server.
+// If route is set, only addresses inside it are returned.
+func (r *resolver) lanAddrs(ctx context.Context, device tailscale.Device, route string) ([]string, error) {
+	deviceRoutes, err := r.deviceRoutes(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+	routes := subnetRoutes(deviceRoutes)
+	if route != "" {
+		want, err := netip.ParsePrefix(route)
+		if err != nil {
+			return nil, fmt.Errorf("bad route option %q: %w", route, err)
+		}
+		want = want.Masked()
+		if !slices.ContainsFunc(routes, func(p netip.Prefix) bool { return p.Bits() <= want.Bits() && p.Contains(want.Addr()) }) {
+			return nil, fmt.Errorf("device %s has no approved route covering %s", device.Name, want)
+		}
+		routes = []netip.Prefix{want}
+	}
+
+	var resp struct {
+		ClientConnectivity struct {
+			Endpoints []string `json:"endpoints"`
+		} `json:"clientConnectivity"`
+	}
+	url := fmt.Sprintf("%s/api/v2/device/%s?fields=all", r.client.BaseURL.String(), device.ID)
+	if err := apiGetURL(ctx, r.client, url, &resp); err != nil {
+		return nil, fmt.Errorf("fetching endpoints of %s: %w", device.Name, err)
+	}
+
+	var addrs []netip.Addr
+	for _, ep := range resp.ClientConnectivity.Endpoints {
+		addrPort, err := netip.ParseAddrPort(ep)
+		if err != nil {
+			continue
+		}
+		addr := addrPort.Addr().Unmap()
+		if !slices.Contains(addrs, addr) && slices.ContainsFunc(routes, func(p netip.Prefix) bool { return p.Contains(addr) }) {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("device %s reports no address inside its routes", device.Name)
+	}
+	slices.SortFunc(addrs, netip.Addr.Compare)
+
+	out := make([]string, len(addrs))
+	for i, addr := range addrs {
+		out[i] = addr.String()
+	}
+	return out, nil
+}
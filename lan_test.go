@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestResolveLAN(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/device/1":
+			w.Write([]byte(`{"clientConnectivity": {"endpoints": [
+				"203.0.113.7:41641", "192.168.1.10:41641", "10.20.0.1:41641", "[fd00:1::10]:41641", "192.168.1.10:41642"
+			]}}`))
+		case "/api/v2/device/1/routes":
+			w.Write([]byte(`{"advertisedRoutes": ["192.168.1.0/24", "10.20.0.0/16", "fd00:1::/64", "0.0.0.0/0"], "enabledRoutes": ["192.168.1.0/24", "10.20.0.0/16", "fd00:1::/64", "0.0.0.0/0"]}`))
+		case "/api/v2/device/2":
+			w.Write([]byte(`{"clientConnectivity": {"endpoints": ["203.0.113.8:41641"]}}`))
+		case "/api/v2/device/2/routes":
+			w.Write([]byte(`{"advertisedRoutes": ["172.16.0.0/12"], "enabledRoutes": ["172.16.0.0/12"]}`))
+		case "/api/v2/device/3/routes":
+			w.Write([]byte(`{"advertisedRoutes": ["192.168.1.0/24"], "enabledRoutes": []}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	client := &tailscale.Client{BaseURL: baseURL, APIKey: "test-key", Tailnet: "example.com"}
+
+	devices := []tailscale.Device{
+		{ID: "1", Name: "router.example.ts.net", Hostname: "router", Addresses: []string{"100.64.0.1"}},
+		{ID: "2", Name: "remote.example.ts.net", Hostname: "remote", Addresses: []string{"100.64.0.2"}},
+		{ID: "3", Name: "laptop.example.ts.net", Hostname: "laptop", Addresses: []string{"100.64.0.3"}},
+	}
+
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "first lan address", entry: "lan:router", want: []string{"10.20.0.1"}},
+		{name: "every lan address", entry: "lan:router?family=both", want: []string{"10.20.0.1", "192.168.1.10", "fd00:1::10"}},
+		{name: "route option", entry: "lan:router?route=192.168.1.0/24", want: []string{"192.168.1.10"}},
+		{name: "route not advertised", entry: "lan:router?route=172.16.0.0/12", wantErr: true},
+		{name: "no endpoint in routes", entry: "lan:remote", wantErr: true},
+		{name: "not a subnet router", entry: "lan:laptop", wantErr: true},
+	}
+
+	r := &resolver{client: client, devices: devices, now: time.Now()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ips, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(ips, tt.want) {
+				t.Errorf("resolve() = %v, want %v", ips, tt.want)
+			}
+		})
+	}
+}
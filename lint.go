@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// tailnetPrefixes are the address ranges Tailscale assigns to nodes.
+var tailnetPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("fd7a:115c:a1e0::/48"),
+}
+
+func isTailnetAddr(addr netip.Addr) bool {
+	for _, p := range tailnetPrefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// lintWarning is a suspicious but valid piece of config.
+type lintWarning struct {
+	Domain  string
+	Message string
+}
+
+func (w lintWarning) String() string {
+	return w.Domain + ": " + w.Message
+}
+
+// lintConfig returns warnings about configs that are likely mistakes, sorted
+// by domain.
+func lintConfig(cfg *ConfigFile) []lintWarning {
+	var warnings []lintWarning
+	warn := func(domain, format string, args ...any) {
+		warnings = append(warnings, lintWarning{Domain: domain, Message: fmt.Sprintf(format, args...)})
+	}
+
+	normalized := make(map[string]string)
+	for domain, nameservers := range cfg.Domains {
+		norm := normalizeDomain(domain)
+		if other, ok := normalized[norm]; ok {
+			warn(domain, "same domain as %q; only one of them will be applied", other)
+		}
+		normalized[norm] = domain
+
+		if cfg.isExcluded(domain) {
+			warn(domain, "is on the exclude list and will never be applied")
+		}
+
+		if len(nameservers) == 0 {
+			warn(domain, "has no nameservers")
+		}
+
+		seen := make(map[string]bool)
+		for _, ns := range nameservers {
+			if seen[ns] {
+				warn(domain, "nameserver %s is listed more than once", ns)
+			}
+			seen[ns] = true
+
+			addr, err := netip.ParseAddr(ns)
+			if err == nil && addr.IsPrivate() && !isTailnetAddr(addr) && isTailnetOnlyDomain(norm) {
+				warn(domain, "nameserver %s is a private LAN address, but %s is a tailnet-only domain; clients off that LAN can't reach it", ns, domain)
+			}
+		}
+
+		for _, pair := range sameDeviceSelectors(nameservers) {
+			warn(domain, "%s and %s match the same device", pair[0], pair[1])
+		}
+	}
+
+	for domain, nameservers := range cfg.Domains {
+		parent, ok := parentDomain(normalizeDomain(domain), normalized)
+		if !ok {
+			continue
+		}
+		if slices.Equal(nameservers, cfg.Domains[normalized[parent]]) {
+			warn(domain, "is shadowed by %s with the same nameservers; the entry is redundant", normalized[parent])
+		}
+	}
+
+	sort.Slice(warnings, func(i, j int) bool {
+		if warnings[i].Domain != warnings[j].Domain {
+			return warnings[i].Domain < warnings[j].Domain
+		}
+		return warnings[i].Message < warnings[j].Message
+	})
+	return warnings
+}
+
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// isTailnetOnlyDomain reports whether domain is a MagicDNS name, which only
+// means anything inside the tailnet.
+func isTailnetOnlyDomain(domain string) bool {
+	return domain == "ts.net" || strings.HasSuffix(domain, ".ts.net")
+}
+
+// parentDomain returns the closest configured domain that domain is a
+// subdomain of.
+func parentDomain(domain string, configured map[string]string) (string, bool) {
+	for {
+		_, rest, ok := strings.Cut(domain, ".")
+		if !ok {
+			return "", false
+		}
+		if _, ok := configured[rest]; ok {
+			return rest, true
+		}
+		domain = rest
+	}
+}
+
+// sameDeviceSelectors returns pairs of device selectors that are spellings
+// of the same device, like device:ns1 and device:ns1.example.ts.net.
+func sameDeviceSelectors(nameservers []string) [][2]string {
+	var pairs [][2]string
+	for i, a := range nameservers {
+		nameA, ok := strings.CutPrefix(a, "device:")
+		if !ok {
+			continue
+		}
+		for _, b := range nameservers[i+1:] {
+			nameB, ok := strings.CutPrefix(b, "device:")
+			if !ok || nameA == nameB {
+				continue
+			}
+			if strings.HasPrefix(nameB, nameA+".") || strings.HasPrefix(nameA, nameB+".") {
+				pairs = append(pairs, [2]string{a, b})
+			}
+		}
+	}
+	return pairs
+}
+
+func logLintWarnings(cfg *ConfigFile) {
+	for _, w := range lintConfig(cfg) {
+		slog.Warn("Config warning", "domain", w.Domain, "warning", w.Message)
+	}
+}
+
+// runLint implements `tsddns lint`. It prints every warning and, with
+// -strict, fails if there were any.
+func runLint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	configPath := fs.String("config", "/config.json", "Path to config.json")
+	strict := fs.Bool("strict", false, "Exit non-zero if there are warnings")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	warnings := lintConfig(cfg)
+	for _, w := range warnings {
+		fmt.Println(w)
+	}
+	for _, name := range cfg.profileNames() {
+		effective, _, _ := cfg.forProfile(name)
+		for _, w := range lintConfig(effective) {
+			fmt.Printf("[%s] %s\n", name, w)
+			warnings = append(warnings, w)
+		}
+	}
+	if *strict && len(warnings) > 0 {
+		return fmt.Errorf("%d warnings", len(warnings))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		exclude []string
+		want    []string
+	}{
+		{
+			name: "clean",
+			config: Config{
+				"example.com":          {"svc:dns"},
+				"internal.example.com": {"192.168.1.1", "device:router"},
+				"corp.ts.net":          {"100.64.0.1"},
+			},
+		},
+		{
+			name:   "duplicate nameserver",
+			config: Config{"example.com": {"192.168.1.1", "192.168.1.1"}},
+			want:   []string{"listed more than once"},
+		},
+		{
+			name:   "private nameserver for tailnet-only domain",
+			config: Config{"corp.ts.net": {"10.0.0.53"}},
+			want:   []string{"private LAN address"},
+		},
+		{
+			name: "redundant subdomain",
+			config: Config{
+				"example.com":     {"svc:dns"},
+				"sub.example.com": {"svc:dns"},
+			},
+			want: []string{"is shadowed by example.com"},
+		},
+		{
+			name: "same domain spelled twice",
+			config: Config{
+				"example.com":  {"svc:dns"},
+				"Example.com.": {"svc:other"},
+			},
+			want: []string{"same domain as"},
+		},
+		{
+			name:   "same device twice",
+			config: Config{"example.com": {"device:ns1", "device:ns1.example.ts.net"}},
+			want:   []string{"match the same device"},
+		},
+		{
+			name:    "excluded domain",
+			config:  Config{"manual.example.com": {"192.168.1.1"}},
+			exclude: []string{"manual.example.com"},
+			want:    []string{"exclude list"},
+		},
+		{
+			name:   "no nameservers",
+			config: Config{"example.com": {}},
+			want:   []string{"has no nameservers"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := lintConfig(&ConfigFile{Domains: tt.config, Exclude: tt.exclude})
+			if len(warnings) != len(tt.want) {
+				t.Fatalf("lintConfig() = %v, want %d warnings", warnings, len(tt.want))
+			}
+			for i, w := range warnings {
+				if !strings.Contains(w.Message, tt.want[i]) {
+					t.Errorf("warning %d = %q, want containing %q", i, w.Message, tt.want[i])
+				}
+			}
+		})
+	}
+}
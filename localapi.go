@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// localAPISocket is the tailscaled socket used by local: selectors. It's set
+// by the -tailscaled-socket flag.
+var localAPISocket = "/var/run/tailscale/tailscaled.sock"
+
+// localPeer is the part of a LocalAPI peer status tsddns uses.
+type localPeer struct {
+	HostName     string   `json:"HostName"`
+	DNSName      string   `json:"DNSName"`
+	TailscaleIPs []string `json:"TailscaleIPs"`
+	Online       bool     `json:"Online"`
+}
+
+// localStatus is the node's view of the tailnet from tailscaled's LocalAPI.
+type localStatus struct {
+	Self *localPeer            `json:"Self"`
+	Peer map[string]*localPeer `json:"Peer"`
+}
+
+// localAPIClient returns an HTTP client that talks to the tailscaled
+// listening on socket.
+func localAPIClient(socket string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+// localAPIURL is the base of LocalAPI URLs. tailscaled checks the host,
+// which is otherwise unused over the socket.
+const localAPIURL = "http://local-tailscaled.sock/localapi/v0"
+
+// getLocalStatus fetches the status of the tailscaled listening on socket.
+func getLocalStatus(ctx context.Context, socket string) (*localStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", localAPIURL+"/status", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := localAPIClient(socket).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("querying tailscaled: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tailscaled returned status %d", resp.StatusCode)
+	}
+
+	var status localStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("decoding tailscaled status: %w", err)
+	}
+	return &status, nil
+}
+
+// find returns the node, this one included, whose MagicDNS name or hostname
+// is name. A short name matches the first label of the MagicDNS name.
+func (s *localStatus) find(name string) (*localPeer, error) {
+	name = strings.TrimSuffix(name, ".")
+
+	peers := make([]*localPeer, 0, len(s.Peer)+1)
+	if s.Self != nil {
+		peers = append(peers, s.Self)
+	}
+	for _, key := range slices.Sorted(maps.Keys(s.Peer)) {
+		peers = append(peers, s.Peer[key])
+	}
+
+	for _, p := range peers {
+		dnsName := strings.TrimSuffix(p.DNSName, ".")
+		if strings.EqualFold(dnsName, name) || strings.HasPrefix(strings.ToLower(dnsName), strings.ToLower(name)+".") {
+			return p, nil
+		}
+	}
+	for _, p := range peers {
+		if strings.EqualFold(p.HostName, name) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("node %s not found in tailscaled status", name)
+}
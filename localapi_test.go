@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestResolveLocal(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "tailscaled.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listening on %s: %v", socket, err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/localapi/v0/status" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{
+			"Self": {"HostName": "tsddns", "DNSName": "tsddns.example.ts.net.", "TailscaleIPs": ["100.64.0.1", "fd7a:115c:a1e0::1"], "Online": false},
+			"Peer": {
+				"nodekey:1": {"HostName": "ns1", "DNSName": "ns1.example.ts.net.", "TailscaleIPs": ["100.64.0.2", "fd7a:115c:a1e0::2"], "Online": true},
+				"nodekey:2": {"HostName": "old-ns", "DNSName": "ns2.example.ts.net.", "TailscaleIPs": ["100.64.0.3"], "Online": false}
+			}
+		}`))
+	}))
+	server.Listener = ln
+	server.Start()
+	defer server.Close()
+
+	oldSocket := localAPISocket
+	localAPISocket = socket
+	defer func() { localAPISocket = oldSocket }()
+
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "magicdns name", entry: "local:ns1.example.ts.net", want: []string{"100.64.0.2"}},
+		{name: "short name", entry: "local:ns1", want: []string{"100.64.0.2"}},
+		{name: "hostname", entry: "local:old-ns", want: []string{"100.64.0.3"}},
+		{name: "local self", entry: "local:tsddns?online=true", want: []string{"100.64.0.1"}},
+		{name: "both families", entry: "local:ns1?family=both", want: []string{"100.64.0.2", "fd7a:115c:a1e0::2"}},
+		{name: "offline", entry: "local:ns2?online=true", wantErr: true},
+		{name: "not found", entry: "local:ns3", wantErr: true},
+		{name: "self", entry: "self", want: []string{"100.64.0.1"}},
+		{name: "self ipv6", entry: "self:ipv6", want: []string{"fd7a:115c:a1e0::1"}},
+		{name: "self both", entry: "self:both", want: []string{"100.64.0.1", "fd7a:115c:a1e0::1"}},
+		{name: "self bad family", entry: "self:ipv5", wantErr: true},
+	}
+
+	r := newResolver(nil, Defaults{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
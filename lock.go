@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// lockTimeout is how long an update waits for another tsddns process
+// updating the same tailnet before giving up.
+const lockTimeout = 5 * time.Minute
+
+// lockRetryDelay is how often a waiting update checks whether the lock
+// was released.
+var lockRetryDelay = time.Second
+
+// lockPath returns the lock file for tailnet in dir. "-", the API's
+// default tailnet, is named "default".
+func lockPath(dir, tailnet string) string {
+	if tailnet == "" || tailnet == "-" {
+		tailnet = "default"
+	}
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, tailnet)
+	return filepath.Join(dir, "tsddns-"+name+".lock")
+}
+
+// lockTailnet takes an exclusive advisory lock on tailnet's lock file in
+// dir, so that two tsddns processes, like a cron job and a daemon, never
+// update the same tailnet at once. It waits up to lockTimeout for another
+// process to finish. The lock is released by the returned func, or by the
+// OS if the process dies.
+func lockTailnet(ctx context.Context, dir, tailnet string) (unlock func(), err error) {
+	path := lockPath(dir, tailnet)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for logged := false; ; logged = true {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %w", path, err)
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("another tsddns process has held %s for over %v", path, lockTimeout)
+		}
+		if !logged {
+			slog.InfoContext(ctx, "Waiting for another tsddns process updating this tailnet", "lock", path)
+		}
+		if err := sleepContext(ctx, lockRetryDelay); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
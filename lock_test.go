@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockPath(t *testing.T) {
+	tests := []struct {
+		tailnet, want string
+	}{
+		{"-", "tsddns-default.lock"},
+		{"", "tsddns-default.lock"},
+		{"example.com", "tsddns-example.com.lock"},
+		{"user@github", "tsddns-user_github.lock"},
+		{"../../etc", "tsddns-.._.._etc.lock"},
+	}
+	for _, tt := range tests {
+		if got := lockPath("/run/lock", tt.tailnet); got != filepath.Join("/run/lock", tt.want) {
+			t.Errorf("lockPath(%q) = %s, want %s", tt.tailnet, got, tt.want)
+		}
+	}
+}
+
+func TestLockTailnet(t *testing.T) {
+	dir := t.TempDir()
+	old := lockRetryDelay
+	lockRetryDelay = 10 * time.Millisecond
+	defer func() { lockRetryDelay = old }()
+
+	unlock, err := lockTailnet(context.Background(), dir, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another tailnet has its own lock
+	unlockOther, err := lockTailnet(context.Background(), dir, "other.example.com")
+	if err != nil {
+		t.Fatalf("locking another tailnet: %v", err)
+	}
+	unlockOther()
+
+	// the same tailnet waits for the holder
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := lockTailnet(ctx, dir, "example.com"); err != context.DeadlineExceeded {
+		t.Fatalf("second lock error = %v, want it to wait until the context is done", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		unlock, err := lockTailnet(context.Background(), dir, "example.com")
+		if err == nil {
+			unlock()
+		}
+		acquired <- err
+	}()
+	time.Sleep(30 * time.Millisecond)
+	unlock()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("lock after release: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock wasn't acquired after release")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// runIDKey is the context key for the ID of the update a log line belongs
+// to.
+type runIDKey struct{}
+
+// withRunID returns ctx with a new random run ID, which every log line
+// logged with the returned context carries as run_id so one update's lines
+// can be picked out of a daemon's interleaved output.
+func withRunID(ctx context.Context) context.Context {
+	var b [4]byte
+	rand.Read(b[:])
+	return context.WithValue(ctx, runIDKey{}, hex.EncodeToString(b[:]))
+}
+
+// runID returns ctx's run ID, or "" outside an update.
+func runID(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
+}
+
+// runIDHandler adds the run ID of a record's context, if it has one.
+type runIDHandler struct {
+	slog.Handler
+}
+
+func (h runIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := runID(ctx); id != "" {
+		r.AddAttrs(slog.String("run_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h runIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return runIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h runIDHandler) WithGroup(name string) slog.Handler {
+	return runIDHandler{h.Handler.WithGroup(name)}
+}
+
+// newLogHandler returns a handler writing format, "text" or "json", to w,
+// dropping records below level.
+func newLogHandler(w io.Writer, format, level string) (slog.Handler, error) {
+	return newLogHandlerWith(w, format, level, nil)
+}
+
+// newLogHandlerWith is newLogHandler with replace rewriting or dropping
+// attributes, as slog.HandlerOptions.ReplaceAttr.
+func newLogHandlerWith(w io.Writer, format, level string, replace func([]string, slog.Attr) slog.Attr) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: replace}
+	switch format {
+	case "text":
+		return runIDHandler{slog.NewTextHandler(w, opts)}, nil
+	case "json":
+		return runIDHandler{slog.NewJSONHandler(w, opts)}, nil
+	}
+	return nil, fmt.Errorf("invalid log format %q: want text or json", format)
+}
+
+// setupLogging makes the default logger write format at level to stderr,
+// to the syslog daemon at syslogTarget with facility, or to both.
+func setupLogging(format, level string, stderr bool, syslogTarget, facility string) error {
+	var handlers teeHandler
+	if stderr {
+		h, err := newLogHandler(os.Stderr, format, level)
+		if err != nil {
+			return err
+		}
+		handlers = append(handlers, h)
+	}
+	if syslogTarget != "" {
+		w, err := newSyslogWriter(syslogTarget, facility)
+		if err != nil {
+			return err
+		}
+		h, err := newSyslogHandler(w, format, level)
+		if err != nil {
+			return err
+		}
+		handlers = append(handlers, h)
+	}
+	switch len(handlers) {
+	case 0:
+		return fmt.Errorf("-log-stderr=false needs -syslog to log somewhere")
+	case 1:
+		slog.SetDefault(slog.New(handlers[0]))
+	default:
+		slog.SetDefault(slog.New(handlers))
+	}
+	return nil
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLogHandler(t *testing.T) {
+	tests := []struct {
+		format, level string
+		wantErr       bool
+	}{
+		{"text", "info", false},
+		{"json", "debug", false},
+		{"json", "WARN", false},
+		{"text", "error", false},
+		{"xml", "info", true},
+		{"text", "loud", true},
+	}
+	for _, tt := range tests {
+		_, err := newLogHandler(&bytes.Buffer{}, tt.format, tt.level)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newLogHandler(%q, %q) error = %v, wantErr %v", tt.format, tt.level, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLogHandlerRunID(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := newLogHandler(&buf, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(h).With("domain", "example.com")
+
+	ctx := withRunID(context.Background())
+	logger.InfoContext(ctx, "first")
+	logger.InfoContext(ctx, "second")
+	logger.InfoContext(withRunID(context.Background()), "third")
+	logger.Info("no run")
+	logger.DebugContext(ctx, "dropped")
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("line %q isn't JSON: %v", line, err)
+		}
+		lines = append(lines, m)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4 with debug dropped:\n%s", len(lines), buf.String())
+	}
+	for _, m := range lines {
+		if m["domain"] != "example.com" {
+			t.Errorf("line %v lost the domain field", m)
+		}
+	}
+	if lines[0]["run_id"] == nil || lines[0]["run_id"] != lines[1]["run_id"] {
+		t.Errorf("lines of one run have run_id %v and %v, want the same", lines[0]["run_id"], lines[1]["run_id"])
+	}
+	if lines[2]["run_id"] == nil || lines[2]["run_id"] == lines[0]["run_id"] {
+		t.Errorf("another run has run_id %v, want a new one", lines[2]["run_id"])
+	}
+	if _, ok := lines[3]["run_id"]; ok {
+		t.Errorf("line without a run has run_id %v", lines[3]["run_id"])
+	}
+}
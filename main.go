@@ -1,29 +1,44 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
-	"strings"
+	"slices"
+	"sync/atomic"
 	"time"
 
-	"golang.org/x/oauth2/clientcredentials"
 	tailscale "github.com/tailscale/tailscale-client-go/v2"
+	"golang.org/x/oauth2/clientcredentials"
 )
 
-type Config map[string][]string
-
-type ServiceInfo struct {
-	Name  string   `json:"name"`
-	Addrs []string `json:"addrs"`
-}
-
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			if err := runMigrate(os.Args[2:]); err != nil {
+				fatal("Failed to migrate config", "error", err)
+			}
+			return
+		case "export":
+			if err := runExport(os.Args[2:]); err != nil {
+				fatal("Export failed", "error", err)
+			}
+			return
+		case "lint":
+			if err := runLint(os.Args[2:]); err != nil {
+				fatal("Lint failed", "error", err)
+			}
+			return
+		}
+	}
+
 	configPath := flag.String("config", "/config.json", "Path to config.json")
 	tailnet := flag.String("tailnet", "-", "Tailscale tailnet name")
 	apiKey := flag.String("api-key", os.Getenv("TAILSCALE_API_KEY"), "Tailscale API key")
@@ -31,210 +46,726 @@ func main() {
 	clientSecret := flag.String("client-secret", os.Getenv("TAILSCALE_CLIENT_SECRET"), "OAuth client secret")
 	baseURL := flag.String("base-url", "https://api.tailscale.com", "API base URL")
 	interval := flag.Duration("interval", 0, "Run continuously (e.g., 5m, 1h)")
+	jitter := flag.Duration("jitter", 0, "Add a random delay of up to this much to every interval (e.g., 30s)")
+	debounce := flag.Duration("debounce", defaultDebounce, "In daemon mode, wait this long after a tailnet change for other triggers to fold into the same update; 0 disables")
+	profileName := flag.String("profile", "", "Config profile to use")
+	etcdEndpoints := flag.String("etcd-endpoints", os.Getenv("ETCD_ENDPOINTS"), "Comma-separated etcd endpoints")
+	etcdKey := flag.String("etcd-key", "", "Read config from this etcd key instead of -config")
+	etcdUsername := flag.String("etcd-username", os.Getenv("ETCD_USERNAME"), "etcd username")
+	etcdPassword := flag.String("etcd-password", os.Getenv("ETCD_PASSWORD"), "etcd password")
+	etcdCA := flag.String("etcd-ca-file", "", "CA bundle for etcd TLS")
+	etcdCert := flag.String("etcd-cert-file", "", "Client certificate for etcd TLS")
+	etcdCertKey := flag.String("etcd-key-file", "", "Client key for etcd TLS")
+	flag.StringVar(&localAPISocket, "tailscaled-socket", localAPISocket, "tailscaled socket for local: entries")
+	flag.StringVar(&dockerSocket, "docker-socket", dockerSocket, "Docker socket for docker: entries")
+	flag.StringVar(&consulAddr, "consul-addr", cmp.Or(os.Getenv("CONSUL_HTTP_ADDR"), consulAddr), "Consul agent for consul-svc: entries")
+	flag.StringVar(&consulToken, "consul-token", os.Getenv("CONSUL_HTTP_TOKEN"), "Consul ACL token")
+	flag.StringVar(&stateFile, "state-file", "", "Only manage domains recorded in this file, leaving others untouched")
+	healthAddr := flag.String("health-addr", "", "Serve /healthz and /readyz on this address (e.g., :8081)")
+	adminAddr := flag.String("admin-addr", "", "Serve the admin API on this address (e.g., :8082)")
+	adminToken := flag.String("admin-token", os.Getenv("TSDDNS_ADMIN_TOKEN"), "Bearer token the admin API requires")
+	pprofFlag := flag.Bool("pprof", false, "Serve Go runtime profiles under /debug/pprof/ on -admin-addr")
+	webhookAddr := flag.String("webhook-addr", "", "Listen for Tailscale webhook events on this address (e.g., :8080)")
+	webhookSecret := flag.String("webhook-secret", os.Getenv("TAILSCALE_WEBHOOK_SECRET"), "Tailscale webhook secret")
+	watchNetmapFlag := flag.Bool("watch-netmap", false, "Update as soon as tailscaled's netmap shows a device change")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print what would change instead of applying it")
+	check := flag.Bool("check", false, "Like -dry-run, but exit 0 if nothing would change, 2 if something would and 1 on errors")
+	flag.BoolVar(&takeOwnership, "take-ownership", false, "Manage configured domains that already exist but aren't in -state-file")
+	flag.Float64Var(&apiRate, "api-rate", apiRate, "Limit Tailscale API calls to this many a second; 0 disables the limit")
+	flag.IntVar(&apiBurst, "api-burst", apiBurst, "Allow bursts of this many Tailscale API calls above -api-rate")
+	flag.IntVar(&resolveWorkers, "resolve-workers", resolveWorkers, "Resolve up to this many config entries at once")
+	flag.StringVar(&pingURL, "ping-url", os.Getenv("TSDDNS_PING_URL"), "Ping this URL after every successful update and its /fail variant after every failed one (e.g., a healthchecks.io check)")
+	startupWait := flag.Duration("startup-wait", 0, "Keep retrying the config, Tailscale API and tailscaled for this long at startup while they aren't reachable yet (e.g., 2m)")
+	lockDir := flag.String("lock-dir", os.TempDir(), "Directory for the per-tailnet lock file that keeps concurrent runs apart; empty disables locking")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	logLevel := flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	logStderr := flag.Bool("log-stderr", true, "Log to stderr")
+	syslogTarget := flag.String("syslog", "", "Also log to syslog: local, or udp://host:port or tcp://host:port for a remote server")
+	syslogFacility := flag.String("syslog-facility", "daemon", "Syslog facility: daemon, user, auth, syslog or local0 to local7")
+	otlpEndpoint := flag.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "Export traces of every update to this OTLP/HTTP collector (e.g., http://localhost:4318)")
+	otlpHeaders := flag.String("otlp-headers", os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), "Comma-separated key=value headers to send with traces")
+	statsdAddr := flag.String("statsd-addr", os.Getenv("STATSD_ADDR"), "Send update metrics to this statsd server over UDP (e.g., localhost:8125)")
+	statsdPrefix := flag.String("statsd-prefix", "tsddns", "Prefix for statsd metric names")
+	statsdTags := flag.String("statsd-tags", "", "Comma-separated key=value tags to add to every statsd metric")
+	statsdTagFormat := flag.String("statsd-tag-format", "dogstatsd", "How statsd tags are sent: dogstatsd, graphite or none")
+	sentryDSN := flag.String("sentry-dsn", os.Getenv("SENTRY_DSN"), "Report update errors and panics to this Sentry DSN")
 
 	flag.Parse()
+	dryRun = dryRun || *check
+	if err := setupLogging(*logFormat, *logLevel, *logStderr, *syslogTarget, *syslogFacility); err != nil {
+		fatal("Invalid logging flags", "error", err)
+	}
+	var err error
+	if *otlpEndpoint != "" {
+		if tracer, err = newOTLPExporter(*otlpEndpoint, *otlpHeaders); err != nil {
+			fatal("Invalid tracing flags", "error", err)
+		}
+	}
+	if *statsdAddr != "" {
+		if metrics, err = newStatsdClient(*statsdAddr, *statsdPrefix, *statsdTags, *statsdTagFormat); err != nil {
+			fatal("Invalid statsd flags", "error", err)
+		}
+	}
+	if *sentryDSN != "" {
+		if reporter, err = newSentryReporter(*sentryDSN, os.Getenv("SENTRY_ENVIRONMENT"), os.Getenv("SENTRY_RELEASE")); err != nil {
+			fatal("Invalid Sentry DSN", "error", err)
+		}
+	}
 
-	cfg, err := loadConfig(*configPath)
+	if pingURL != "" {
+		if err := validatePingURL(pingURL); err != nil {
+			fatal("Invalid ping URL", "error", err)
+		}
+	}
+
+	ctx, updateCtx := notifyShutdown(shutdownGrace)
+	startupDeadline := time.Now().Add(*startupWait)
+
+	loadCtx, loadSpan := startSpan(ctx, "config load")
+	var cfg *ConfigFile
+	var etcd *etcdSource
+	var etcdRev int64
+	if *etcdKey != "" {
+		loadSpan.set("source", "etcd", "key", *etcdKey)
+		etcd, err = newEtcdSource(*etcdEndpoints, *etcdKey, *etcdUsername, *etcdPassword, *etcdCA, *etcdCert, *etcdCertKey)
+		if err != nil {
+			loadSpan.finish(err)
+			fatal("Failed to set up etcd", "error", err)
+		}
+		err = waitUntilUp(loadCtx, "etcd", startupDeadline, func(ctx context.Context) (err error) {
+			cfg, etcdRev, err = etcd.load(ctx)
+			return err
+		})
+	} else {
+		loadSpan.set("source", "file", "path", *configPath)
+		err = waitUntilUp(loadCtx, "config file", startupDeadline, func(context.Context) (err error) {
+			cfg, err = loadConfig(*configPath)
+			return err
+		})
+	}
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		loadSpan.finish(err)
+		fatal("Failed to load config", "error", err)
 	}
 
-	client, err := createClient(*tailnet, *apiKey, *clientID, *clientSecret, *baseURL)
+	cfg, profile, err := cfg.forProfile(*profileName)
+	loadSpan.finish(err)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		fatal("Failed to load config", "error", err)
+	}
+	if profile != nil {
+		applyProfile(flag.CommandLine, profile, tailnet, apiKey, clientID, clientSecret)
+		slog.Info("Using profile", "profile", *profileName, "tailnet", *tailnet)
+	}
+	logLintWarnings(cfg)
+
+	if *healthAddr != "" && *interval <= 0 {
+		fatal("Health endpoints need -interval to run as a daemon")
+	}
+	if *adminAddr != "" {
+		if *interval <= 0 {
+			fatal("The admin API needs -interval to run as a daemon")
+		}
+		if *adminToken == "" {
+			fatal("The admin API needs -admin-token to authenticate requests")
+		}
+	} else if *pprofFlag {
+		fatal("Profiling needs -admin-addr to serve the profiles on")
+	}
+	if cfg.Drift != nil {
+		if *interval <= 0 {
+			fatal("Drift checks need -interval to run as a daemon")
+		}
+		if stateFile == "" {
+			fatal("Drift checks need -state-file to know what was applied")
+		}
+	}
+	if *jitter < 0 || *jitter > 0 && *interval <= 0 {
+		fatal("Jitter needs -interval and can't be negative")
+	}
+	if dryRun {
+		if *interval > 0 {
+			fatal("A dry run runs once and can't be combined with -interval")
+		}
+		if cfg.Mode != "" && cfg.Mode != "split" {
+			fatal("A dry run only previews split DNS, which this mode doesn't apply", "mode", cfg.Mode)
+		}
+	}
+
+	if cfg.Server != nil && !dryRun {
+		if *interval <= 0 {
+			fatal("The DNS server needs -interval to keep its answers up to date")
+		}
+		dnsServer, err = startDNSServer(cfg.Server.listenAddr())
+		if err != nil {
+			fatal("Failed to start DNS server", "error", err)
+		}
+		slog.Info("Serving DNS", "addr", cfg.Server.listenAddr())
+	}
+
+	tailnetChanges := make(chan struct{}, 1)
+	if *webhookAddr != "" {
+		if *interval <= 0 {
+			fatal("Webhook events need -interval to run as a daemon")
+		}
+		if *webhookSecret == "" {
+			fatal("Webhook events need -webhook-secret to verify them")
+		}
+		if err := startWebhookListener(*webhookAddr, *webhookSecret, tailnetChanges); err != nil {
+			fatal("Failed to start webhook listener", "error", err)
+		}
+		slog.Info("Listening for Tailscale webhook events", "addr", *webhookAddr)
+	}
+
+	if *watchNetmapFlag {
+		if *interval <= 0 {
+			fatal("Watching the netmap needs -interval to run as a daemon")
+		}
+		slog.Info("Watching tailscaled netmap for device changes", "socket", localAPISocket)
+		go watchNetmap(ctx, localAPISocket, tailnetChanges)
 	}
 
-	ctx := context.Background()
+	client, err := createClient(*tailnet, *apiKey, *clientID, *clientSecret, *baseURL)
+	if err != nil {
+		fatal("Failed to create client", "error", err)
+	}
+	if *startupWait > 0 {
+		if err := waitForDependencies(ctx, client, cfg, startupDeadline); err != nil {
+			fatal("Gave up waiting for dependencies", "error", err)
+		}
+	}
+	// update runs one update while holding the tailnet's lock
+	update := func(ctx context.Context, cfg *ConfigFile) error {
+		if *lockDir != "" {
+			unlock, err := lockTailnet(ctx, *lockDir, client.Tailnet)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+		}
+		return updateDNS(ctx, client, cfg)
+	}
 
 	if *interval > 0 {
-		log.Printf("Running in daemon mode with interval: %v", *interval)
-		ticker := time.NewTicker(*interval)
-		defer ticker.Stop()
+		slog.Info("Running in daemon mode", "interval", *interval, "jitter", *jitter)
+		timer := time.NewTimer(jitteredInterval(*interval, *jitter))
+		defer timer.Stop()
+
+		status := newHealth(*interval + *jitter)
+		if *healthAddr != "" {
+			if err := startHealthServer(*healthAddr, status); err != nil {
+				fatal("Failed to start health server", "error", err)
+			}
+			slog.Info("Serving /healthz and /readyz", "addr", *healthAddr)
+		}
+
+		var effective atomic.Pointer[ConfigFile]
+		effective.Store(cfg)
+		adminRequests := make(chan struct{}, 1)
+		if *adminAddr != "" {
+			admin := &adminHandler{token: *adminToken, trigger: adminRequests, status: status, config: effective.Load, pprof: *pprofFlag}
+			if err := startAdminServer(*adminAddr, admin); err != nil {
+				fatal("Failed to start admin server", "error", err)
+			}
+			slog.Info("Serving the admin API", "addr", *adminAddr)
+		}
+		kicks := make(chan struct{}, 1)
+		notifyReconcile(kicks)
+
+		// driftTicker is stopped, and never fires, unless the config asks
+		// for drift checks
+		driftTicker := time.NewTicker(time.Hour)
+		resetDrift := func() {
+			if cfg.Drift != nil && stateFile != "" {
+				driftTicker.Reset(cfg.Drift.interval())
+			} else {
+				driftTicker.Stop()
+			}
+		}
+		resetDrift()
+		defer driftTicker.Stop()
+
+		// scheduleTimer fires when the next schedule is due, and is
+		// stopped without schedules
+		var schedules *scheduler
+		scheduleTimer := time.NewTimer(time.Hour)
+		resetSchedules := func() {
+			schedules = newScheduler(cfg.Schedules, *jitter, time.Now())
+			if d, ok := schedules.wait(time.Now()); ok {
+				scheduleTimer.Reset(d)
+			} else {
+				scheduleTimer.Stop()
+			}
+		}
+		resetSchedules()
+		defer scheduleTimer.Stop()
+		// lookForDrift checks for drift while holding the tailnet's lock,
+		// so it doesn't catch another process's update halfway
+		lookForDrift := func(ctx context.Context) ([]domainChange, error) {
+			if *lockDir != "" {
+				unlock, err := lockTailnet(ctx, *lockDir, client.Tailnet)
+				if err != nil {
+					return nil, err
+				}
+				defer unlock()
+			}
+			return checkDrift(ctx, client, cfg)
+		}
 
-		runUpdate := func() {
-			if err := updateDNS(ctx, client, cfg); err != nil {
-				log.Printf("Error updating DNS: %v", err)
+		var lastErr error
+		var lastDone time.Time
+		runUpdate := func(trigger string, cfg *ConfigFile) {
+			ctx, root := startRun(updateCtx, cfg, trigger)
+			defer reportPanic(ctx)
+			status.begin()
+			start := time.Now()
+			lastErr = update(ctx, cfg)
+			lastDone = time.Now()
+			root.finish(lastErr)
+			status.done(lastErr)
+			pingOutcome(ctx, lastErr)
+			recordOutcome(ctx, lastDone.Sub(start), lastErr)
+			reportError(ctx, lastErr, "tailnet", client.Tailnet, "mode", cmp.Or(cfg.Mode, "split"))
+			if lastErr != nil {
+				slog.ErrorContext(ctx, "Error updating DNS", "duration", lastDone.Sub(start), "error", lastErr)
+			} else {
+				slog.InfoContext(ctx, "Update finished", "duration", lastDone.Sub(start))
 			}
 		}
 
-		runUpdate()
-		for range ticker.C {
-			runUpdate()
+		configUpdates := make(chan *ConfigFile)
+		if etcd != nil {
+			etcd.rejected = status.setConfigErr
+			slog.Info("Watching etcd for config changes", "key", *etcdKey)
+			go etcd.watch(ctx, etcdRev, configUpdates)
+		}
+
+		runUpdate(triggerStartup, cfg)
+		for {
+			var trigger string
+			// scoped is the config for this update, if it's limited to
+			// some domains
+			var scoped *ConfigFile
+			select {
+			case <-ctx.Done():
+			case <-timer.C:
+				trigger = triggerInterval
+				scoped = cfg.withoutSchedules()
+				timer.Reset(jitteredInterval(*interval, *jitter))
+			case <-scheduleTimer.C:
+				due := schedules.due(time.Now())
+				if d, ok := schedules.wait(time.Now()); ok {
+					scheduleTimer.Reset(d)
+				}
+				if len(due) == 0 {
+					continue
+				}
+				slog.Info("Schedules due, updating their domains", "schedules", due)
+				trigger = triggerSchedule
+				scoped = cfg.forSchedules(due)
+			case <-tailnetChanges:
+				slog.Info("Tailnet changed, updating now")
+				trigger = triggerTailnet
+				resultCache.clear()
+			case newCfg := <-configUpdates:
+				newEffective, _, err := newCfg.forProfile(*profileName)
+				status.setConfigErr(err)
+				if err != nil {
+					slog.Error("Ignoring config update from etcd", "error", err)
+					continue
+				}
+				slog.Info("Config changed in etcd, updating now")
+				trigger = triggerConfig
+				cfg = newEffective
+				effective.Store(cfg)
+				resultCache.clear()
+				resetDrift()
+				resetSchedules()
+				logLintWarnings(cfg)
+			case <-adminRequests:
+				trigger = triggerAdmin
+				resultCache.clear()
+			case <-kicks:
+				slog.Info("Got SIGUSR1, updating now")
+				trigger = triggerSignal
+				resultCache.clear()
+			case <-driftTicker.C:
+				ctx := withTrigger(withRunID(ctx), triggerDrift)
+				drifted, err := lookForDrift(ctx)
+				if err != nil {
+					slog.ErrorContext(ctx, "Checking for drift failed", "error", err)
+					continue
+				}
+				if len(drifted) == 0 || !cfg.Drift.Repair {
+					continue
+				}
+				slog.InfoContext(ctx, "Split DNS drifted, updating now to put it back", "domains", len(drifted))
+				trigger = triggerDrift
+			}
+			// a burst of triggers, like webhook events during device churn,
+			// runs one update
+			triggers := coalesceTriggers(ctx, *debounce, trigger, triggerSources{
+				interval: timer.C,
+				tailnet:  tailnetChanges,
+				admin:    adminRequests,
+				signal:   kicks,
+			})
+			if len(triggers) > 1 {
+				slog.Info("Coalesced triggers into one update", "triggers", triggers)
+				if slices.Contains(triggers[1:], triggerInterval) {
+					timer.Reset(jitteredInterval(*interval, *jitter))
+				}
+				if slices.ContainsFunc(triggers[1:], func(t string) bool { return t != triggerInterval }) {
+					resultCache.clear()
+				}
+				scoped = coalescedScope(triggers, scoped)
+			}
+			if ctx.Err() != nil {
+				if lastErr != nil {
+					slog.Info("Shut down; the last update failed", "error", lastErr)
+				} else {
+					slog.Info("Shut down; the last update completed", "at", lastDone)
+				}
+				return
+			}
+			// admin requests and SIGUSR1 run even while paused
+			if !bypassesPause(triggers) && status.isPaused() {
+				slog.Info("Updates are paused, skipping", "trigger", trigger)
+				continue
+			}
+			runUpdate(trigger, cmp.Or(scoped, cfg))
 		}
 	} else {
-		if err := updateDNS(ctx, client, cfg); err != nil {
-			log.Fatalf("Failed to update DNS: %v", err)
+		ctx, root := startRun(updateCtx, cfg, triggerStartup)
+		defer reportPanic(ctx)
+		start := time.Now()
+		err := update(ctx, cfg)
+		pending := errors.Is(err, errChangesPending)
+		if pending {
+			err = nil
+		}
+		root.finish(err)
+		pingOutcome(ctx, err)
+		recordOutcome(ctx, time.Since(start), err)
+		reportError(ctx, err, "tailnet", client.Tailnet, "mode", cmp.Or(cfg.Mode, "split"))
+		if pending && *check {
+			os.Exit(2)
+		}
+		if err != nil {
+			fatal("Failed to update DNS", "error", err)
 		}
 	}
 }
 
-func updateDNS(ctx context.Context, client *tailscale.Client, cfg Config) error {
-	splitDNS, err := resolveSplitDNS(ctx, client, cfg)
+// startRun returns the context for one update, with a new run ID and what
+// triggered it, and the root span of its trace.
+func startRun(ctx context.Context, cfg *ConfigFile, trigger string) (context.Context, *span) {
+	ctx = withTrigger(withRunID(ctx), trigger)
+	return startSpan(ctx, "reconcile", "run_id", runID(ctx), "trigger", trigger, "mode", cmp.Or(cfg.Mode, "split"))
+}
+
+func updateDNS(ctx context.Context, client *tailscale.Client, cfg *ConfigFile) (err error) {
+	defer func() { emailOutcome(ctx, client.Tailnet, cfg.Email, err) }()
+
+	switch cfg.Mode {
+	case "global":
+		return updateGlobalDNS(ctx, client, cfg)
+	case "records":
+		return syncRecords(ctx, newResolver(client, cfg.Defaults), cfg, nil)
+	case "server":
+		return updateServerDNS(ctx, client, cfg)
+	}
+
+	var changes []domainChange
+	defer func() {
+		notifyOutcome(ctx, client.Tailnet, cfg.Notify, changes, err)
+		publishOutcome(ctx, client.Tailnet, cfg.MQTT, changes, err)
+	}()
+
+	r := newResolver(client, cfg.Defaults)
+	splitDNS, sources, err := desiredSplitDNS(ctx, r, cfg)
 	if err != nil {
-		return fmt.Errorf("resolving services: %w", err)
+		return err
+	}
+	var global []string
+	if len(cfg.Global) > 0 {
+		global, err = r.resolveGlobal(ctx, cfg.Global)
+		if err != nil {
+			return fmt.Errorf("resolving global nameservers: %w", err)
+		}
 	}
 
-	log.Printf("Updating split DNS configuration with %d domains...", len(splitDNS))
+	backend, err := newBackend(client, cfg)
+	if err != nil {
+		return err
+	}
+	// snapshot the current config so a failed cycle can be rolled back
+	current, err := backend.Current(ctx)
+	if err != nil {
+		return err
+	}
+	orderNameservers(cfg.Defaults.Order, splitDNS, current, r.weights, time.Now())
+	var owned []string
+	var applied *appliedState
+	if stateFile != "" {
+		wasOwned, err := loadOwnedDomains(stateFile)
+		if err != nil {
+			return err
+		}
+		owned = mergeUnowned(splitDNS, current, wasOwned, takeOwnership)
+		owned = append(owned, cfg.ownedOutOfScope(current, wasOwned)...)
+		if applied, err = loadApplied(stateFile); err != nil {
+			return err
+		}
+		reportDrift(ctx, client.Tailnet, cfg, driftedDomains(applied, current), true)
+	}
+	cfg.preserveExcluded(splitDNS, current)
+
+	slog.InfoContext(ctx, "Desired split DNS configuration", "domains", len(splitDNS))
 	for domain, nameservers := range splitDNS {
-		log.Printf("  %s -> %v", domain, nameservers)
+		slog.DebugContext(ctx, "Desired split DNS domain", "domain", domain, "nameservers", nameservers)
 	}
 
-	if err := client.DNS().SetSplitDNS(ctx, splitDNS); err != nil {
-		return fmt.Errorf("updating split DNS: %w", err)
+	// only changed domains are written, so an unchanged config is no write
+	// at all
+	changes = backend.Plan(current, splitDNS)
+	var tx transaction
+	if len(changes) > 0 {
+		slog.InfoContext(ctx, "Updating split DNS domains", "domains", len(changes))
+		if err := backend.Apply(ctx, &tx, changes); err != nil {
+			return err
+		}
+	} else {
+		slog.InfoContext(ctx, "Split DNS configuration is up to date")
+	}
+	splitDNSSteps := len(tx.steps)
+	if err := addSettingSteps(ctx, client, &tx, cfg, global); err != nil {
+		return err
+	}
+	if cfg.CoreDNSConfigMap != nil {
+		if err := addCoreDNSStep(ctx, r, &tx, cfg.CoreDNSConfigMap, splitDNS); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		fmt.Print(formatDiff(changes))
+		for _, step := range tx.steps[splitDNSSteps:] {
+			fmt.Printf("Would also update %s\n", step.name)
+		}
+		if len(tx.steps) > 0 {
+			return errChangesPending
+		}
+		return nil
+	}
+	if err := tx.commit(ctx); err != nil {
+		return restoreLastKnownGood(ctx, backend, changes, applied, tx.applied > 0, err)
+	}
+	if err := verifyApplied(ctx, backend, changes); err != nil {
+		if rbErr := tx.rollback(ctx, len(tx.steps)); rbErr != nil {
+			err = fmt.Errorf("%w; rollback incomplete: %w", err, rbErr)
+		}
+		return restoreLastKnownGood(ctx, backend, changes, applied, true, err)
 	}
 
-	log.Println("Successfully updated split DNS configuration")
-	return nil
-}
+	if len(changes) > 0 {
+		slog.InfoContext(ctx, "Successfully updated split DNS configuration")
+	}
+	if stateFile != "" {
+		if err := saveOwnedDomains(stateFile, owned); err != nil {
+			return fmt.Errorf("saving state file: %w", err)
+		}
+		next := newAppliedState(applied, splitDNS, owned, sources, time.Now())
+		cfg.keepOutOfScope(applied, next)
+		applied = next
+		if err := saveApplied(stateFile, applied); err != nil {
+			return fmt.Errorf("saving state file: %w", err)
+		}
+	}
 
-func createClient(tailnet, apiKey, clientID, clientSecret, baseURL string) (*tailscale.Client, error) {
-	parsedURL, err := url.Parse(baseURL)
-	if err != nil {
-		return nil, fmt.Errorf("invalid base URL: %w", err)
+	runExports(ctx, cfg.Exports, splitDNS)
+
+	annotateChanges(changes, cfg.TTLHints, time.Now())
+	lastUpdate.record(splitDNS, changes, time.Now())
+	metrics.count("domains.changed", len(changes))
+	for _, c := range changes {
+		if c.RequeryAfter != nil {
+			slog.InfoContext(ctx, "Domain changed", "domain", c.Domain, "old", c.Old, "new", c.New, "requery_after", *c.RequeryAfter)
+		} else {
+			slog.InfoContext(ctx, "Domain changed", "domain", c.Domain, "old", c.Old, "new", c.New)
+		}
 	}
+	runFlushHooks(ctx, cfg.FlushHooks, changes)
 
-	client := &tailscale.Client{
-		Tailnet: tailnet,
-		BaseURL: parsedURL,
+	// the embedded server and records are updated with the rest of the
+	// config, not with a schedule's domains
+	if cfg.scheduledOnly() {
+		return nil
 	}
+	if err := serveDNS(ctx, r, cfg, splitDNS); err != nil {
+		return err
+	}
+	// record backends are outside the transaction, which has committed by
+	// now: a failing one fails the update but leaves split DNS applied
+	return syncRecords(ctx, r, cfg, splitDNS)
+}
 
-	if clientID != "" && clientSecret != "" {
-		log.Println("Using OAuth client credentials authentication")
-		oauthConfig := clientcredentials.Config{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			TokenURL:     baseURL + "/api/v2/oauth/token",
+// desiredSplitDNS resolves the configured domains, with any reverse zones
+// and discovered domains, into the split DNS config tsddns wants. sources
+// are the entries each domain was resolved from.
+func desiredSplitDNS(ctx context.Context, r *resolver, cfg *ConfigFile) (map[string][]string, Config, error) {
+	domains := cfg.managedDomains()
+	if cfg.ReverseZones != nil {
+		var err error
+		domains, err = addReverseZones(cfg.ReverseZones, domains, cfg.isExcluded)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generating reverse zones: %w", err)
 		}
-		client.HTTP = oauthConfig.Client(context.Background())
-	} else if apiKey != "" {
-		log.Println("Using API key authentication")
-		client.APIKey = apiKey
-	} else {
-		return nil, fmt.Errorf("need either api key or oauth creds")
 	}
-
-	return client, nil
+	if cfg.Discover != nil {
+		var err error
+		domains, err = r.discover(ctx, cfg.Discover, domains, cfg.isExcluded)
+		if err != nil {
+			return nil, nil, fmt.Errorf("discovering domains: %w", err)
+		}
+	}
+	splitDNS, err := r.resolveAll(ctx, domains)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolving services: %w", err)
+	}
+	if cfg.Probe != nil {
+		if err := probeNameservers(ctx, cfg.Probe, splitDNS); err != nil {
+			return nil, nil, fmt.Errorf("probing nameservers: %w", err)
+		}
+	}
+	return splitDNS, domains, nil
 }
 
-func loadConfig(path string) (Config, error) {
-	data, err := os.ReadFile(path)
+// updateServerDNS is updateDNS for the server mode, where the resolved
+// domains are only served by the embedded DNS server and tailnet DNS is
+// left alone.
+func updateServerDNS(ctx context.Context, client *tailscale.Client, cfg *ConfigFile) error {
+	r := newResolver(client, cfg.Defaults)
+	splitDNS, _, err := desiredSplitDNS(ctx, r, cfg)
 	if err != nil {
-		return nil, fmt.Errorf("reading config file: %w", err)
+		return err
+	}
+	if err := serveDNS(ctx, r, cfg, splitDNS); err != nil {
+		return err
 	}
+	return syncRecords(ctx, r, cfg, splitDNS)
+}
 
-	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("parsing config JSON: %w", err)
+// updateGlobalDNS is updateDNS for the global mode, where the resolved
+// nameservers are the tailnet's global nameservers with local DNS
+// overridden, and split DNS is left alone.
+func updateGlobalDNS(ctx context.Context, client *tailscale.Client, cfg *ConfigFile) error {
+	r := newResolver(client, cfg.Defaults)
+	global, err := r.resolveGlobal(ctx, cfg.Global)
+	if err != nil {
+		return fmt.Errorf("resolving global nameservers: %w", err)
 	}
 
-	return cfg, nil
+	var tx transaction
+	if err := addSettingSteps(ctx, client, &tx, cfg, global); err != nil {
+		return err
+	}
+	if len(tx.steps) == 0 {
+		slog.InfoContext(ctx, "Global DNS configuration is up to date")
+		return nil
+	}
+	if err := tx.commit(ctx); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Successfully updated global DNS configuration")
+	return nil
 }
 
-func resolveSplitDNS(ctx context.Context, client *tailscale.Client, cfg Config) (tailscale.SplitDNSRequest, error) {
-	splitDNS := make(tailscale.SplitDNSRequest)
-
-	// only fetch devices list if we actually need it
-	var devices []tailscale.Device
-	needsDevices := false
-	for _, nameservers := range cfg {
-		for _, ns := range nameservers {
-			if strings.HasPrefix(ns, "device:") {
-				needsDevices = true
-				break
-			}
+// addSettingSteps queues writes for the tailnet DNS settings other than
+// split DNS that differ from what cfg wants: the global nameservers, if
+// global is set, the search paths and the DNS preferences.
+func addSettingSteps(ctx context.Context, client *tailscale.Client, tx *transaction, cfg *ConfigFile, global []string) error {
+	if global != nil {
+		currentGlobal, err := client.DNS().Nameservers(ctx)
+		if err != nil {
+			return fmt.Errorf("fetching current global nameservers: %w", err)
 		}
-		if needsDevices {
-			break
+		if !slices.Equal(currentGlobal, global) {
+			slog.InfoContext(ctx, "Updating global nameservers", "old", currentGlobal, "new", global)
+			tx.add("global nameservers",
+				func(ctx context.Context) error { return client.DNS().SetNameservers(ctx, global) },
+				func(ctx context.Context) error { return client.DNS().SetNameservers(ctx, currentGlobal) },
+			)
 		}
 	}
-
-	if needsDevices {
-		devs, err := client.Devices().List(ctx)
+	if cfg.SearchPaths != nil {
+		currentPaths, err := client.DNS().SearchPaths(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("listing devices: %w", err)
+			return fmt.Errorf("fetching current search paths: %w", err)
+		}
+		if !slices.Equal(currentPaths, cfg.SearchPaths) {
+			slog.InfoContext(ctx, "Updating search paths", "old", currentPaths, "new", cfg.SearchPaths)
+			tx.add("search paths",
+				func(ctx context.Context) error { return client.DNS().SetSearchPaths(ctx, cfg.SearchPaths) },
+				func(ctx context.Context) error { return client.DNS().SetSearchPaths(ctx, currentPaths) },
+			)
 		}
-		devices = devs
 	}
-
-	for domain, nameservers := range cfg {
-		var resolved []string
-		for _, ns := range nameservers {
-			if strings.HasPrefix(ns, "svc:") {
-				log.Printf("Resolving service %s for domain %s...", ns, domain)
-				ip, err := getServiceIP(ctx, client, ns)
-				if err != nil {
-					return nil, fmt.Errorf("resolving service %s: %w", ns, err)
-				}
-				log.Printf("  Resolved %s to %s", ns, ip)
-				resolved = append(resolved, ip)
-			} else if strings.HasPrefix(ns, "device:") {
-				deviceName := strings.TrimPrefix(ns, "device:")
-				log.Printf("Resolving device %s for domain %s...", deviceName, domain)
-				ip, err := getDeviceIP(deviceName, devices)
-				if err != nil {
-					return nil, fmt.Errorf("resolving device %s: %w", deviceName, err)
-				}
-				log.Printf("  Resolved device:%s to %s", deviceName, ip)
-				resolved = append(resolved, ip)
-			} else {
-				resolved = append(resolved, ns)
-			}
+	if prefs := cfg.preferences(); prefs != nil {
+		if err := addPreferenceSteps(ctx, client, tx, prefs); err != nil {
+			return err
 		}
-		splitDNS[domain] = resolved
 	}
-
-	return splitDNS, nil
+	return nil
 }
 
-func getServiceIP(ctx context.Context, client *tailscale.Client, serviceName string) (string, error) {
-	// TODO: use the official client once services API is added
-	url := fmt.Sprintf("%s/api/v2/tailnet/%s/services/%s/", client.BaseURL.String(), client.Tailnet, serviceName)
+// applyProfile sets the tailnet and credentials to the profile's. Flags
+// given on the command line win over the profile.
+func applyProfile(fs *flag.FlagSet, profile *Profile, tailnet, apiKey, clientID, clientSecret *string) {
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return "", err
+	if profile.Tailnet != "" && !setFlags["tailnet"] {
+		*tailnet = profile.Tailnet
 	}
-
-	var httpClient *http.Client
-	if client.APIKey != "" {
-		req.SetBasicAuth(client.APIKey, "")
-		httpClient = &http.Client{}
-	} else if client.HTTP != nil {
-		httpClient = client.HTTP
-	} else {
-		return "", fmt.Errorf("no auth configured")
+	if key, id, secret, ok := profile.credentials(); ok && !setFlags["api-key"] && !setFlags["client-id"] && !setFlags["client-secret"] {
+		*apiKey, *clientID, *clientSecret = key, id, secret
 	}
+}
 
-	resp, err := httpClient.Do(req)
+func createClient(tailnet, apiKey, clientID, clientSecret, baseURL string) (*tailscale.Client, error) {
+	parsedURL, err := url.Parse(baseURL)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("invalid base URL: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API returned status %d", resp.StatusCode)
+	// the timeout covers every retry of a call
+	client := &tailscale.Client{
+		Tailnet: tailnet,
+		BaseURL: parsedURL,
+		HTTP:    &http.Client{Timeout: 2 * time.Minute},
 	}
+	transport := http.DefaultTransport
 
-	var svcInfo ServiceInfo
-	if err := json.NewDecoder(resp.Body).Decode(&svcInfo); err != nil {
-		return "", err
+	if clientID != "" && clientSecret != "" {
+		slog.Info("Using OAuth client credentials authentication")
+		oauthConfig := clientcredentials.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			TokenURL:     baseURL + "/api/v2/oauth/token",
+		}
+		transport = oauthConfig.Client(context.Background()).Transport
+	} else if apiKey != "" {
+		slog.Info("Using API key authentication")
+		client.APIKey = apiKey
+	} else {
+		return nil, fmt.Errorf("need either api key or oauth creds")
 	}
 
-	if len(svcInfo.Addrs) == 0 {
-		return "", fmt.Errorf("service %s has no addresses", serviceName)
+	// every attempt of a retried call takes a token
+	if apiRate > 0 {
+		transport = &rateLimitTransport{next: transport, bucket: newTokenBucket(apiRate, apiBurst)}
 	}
+	client.HTTP.Transport = newRetryTransport(transport)
 
-	return svcInfo.Addrs[0], nil
-}
-
-func getDeviceIP(hostname string, devices []tailscale.Device) (string, error) {
-	for _, device := range devices {
-		if device.Hostname == hostname || device.Name == hostname || strings.HasPrefix(device.Name, hostname+".") {
-			if len(device.Addresses) == 0 {
-				return "", fmt.Errorf("device %s has no addresses", hostname)
-			}
-			return device.Addresses[0], nil
-		}
-	}
-	return "", fmt.Errorf("device %s not found", hostname)
+	return client, nil
 }
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	tailscale "github.com/tailscale/tailscale-client-go/v2"
@@ -54,8 +55,8 @@ func TestLoadConfig(t *testing.T) {
 				return
 			}
 
-			if !tt.wantErr && len(cfg) != tt.wantDomains {
-				t.Errorf("got %d domains, want %d", len(cfg), tt.wantDomains)
+			if !tt.wantErr && len(cfg.Domains) != tt.wantDomains {
+				t.Errorf("got %d domains, want %d", len(cfg.Domains), tt.wantDomains)
 			}
 		})
 	}
@@ -352,6 +353,13 @@ func TestResolveSplitDNSWithServiceAPI(t *testing.T) {
 			})
 			return
 		}
+		if r.URL.Path == "/api/v2/tailnet/test/services/svc:dual-stack/" {
+			json.NewEncoder(w).Encode(ServiceInfo{
+				Name:  "svc:dual-stack",
+				Addrs: []string{"100.64.0.4", "fd7a:115c:a1e0::4"},
+			})
+			return
+		}
 		if r.URL.Path == "/api/v2/tailnet/test/devices" {
 			json.NewEncoder(w).Encode(map[string][]tailscale.Device{
 				"devices": {
@@ -359,6 +367,11 @@ func TestResolveSplitDNSWithServiceAPI(t *testing.T) {
 						Hostname:  "test-device",
 						Addresses: []string{"100.64.0.2"},
 					},
+					{
+						Name:      "resolver.example.ts.net",
+						Tags:      []string{"tag:dns-server"},
+						Addresses: []string{"100.64.0.3"},
+					},
 				},
 			})
 			return
@@ -393,6 +406,28 @@ func TestResolveSplitDNSWithServiceAPI(t *testing.T) {
 		}
 	})
 
+	t.Run("resolves every service address", func(t *testing.T) {
+		serverURL, _ := url.Parse(server.URL)
+		client := &tailscale.Client{
+			BaseURL: serverURL,
+			Tailnet: "test",
+			APIKey:  "test-key",
+		}
+
+		cfg := Config{
+			"service.example.com": {"svc:dual-stack?family=both"},
+		}
+
+		result, err := resolveSplitDNS(context.Background(), client, cfg)
+		if err != nil {
+			t.Fatalf("resolveSplitDNS() unexpected error: %v", err)
+		}
+
+		if len(result["service.example.com"]) != 2 {
+			t.Errorf("expected 2 addresses, got %v", result["service.example.com"])
+		}
+	})
+
 	t.Run("resolves device to IP", func(t *testing.T) {
 		serverURL, _ := url.Parse(server.URL)
 		client := &tailscale.Client{
@@ -418,6 +453,28 @@ func TestResolveSplitDNSWithServiceAPI(t *testing.T) {
 			t.Errorf("expected 100.64.0.2, got %s", result["device.example.com"][0])
 		}
 	})
+
+	t.Run("resolves tag to IP", func(t *testing.T) {
+		serverURL, _ := url.Parse(server.URL)
+		client := &tailscale.Client{
+			BaseURL: serverURL,
+			Tailnet: "test",
+			APIKey:  "test-key",
+		}
+
+		cfg := Config{
+			"tag.example.com": {"tag:dns-server"},
+		}
+
+		result, err := resolveSplitDNS(context.Background(), client, cfg)
+		if err != nil {
+			t.Fatalf("resolveSplitDNS() unexpected error: %v", err)
+		}
+
+		if result["tag.example.com"][0] != "100.64.0.3" {
+			t.Errorf("expected 100.64.0.3, got %s", result["tag.example.com"][0])
+		}
+	})
 }
 
 func TestUpdateDNS(t *testing.T) {
@@ -426,8 +483,10 @@ func TestUpdateDNS(t *testing.T) {
 			Tailnet: "test",
 		}
 
-		cfg := Config{
-			"example.com": {"192.168.1.1"},
+		cfg := &ConfigFile{
+			Domains: Config{
+				"example.com": {"192.168.1.1"},
+			},
 		}
 
 		err := updateDNS(context.Background(), client, cfg)
@@ -438,3 +497,89 @@ func TestUpdateDNS(t *testing.T) {
 		}
 	})
 }
+
+func TestUpdateDNSSkipsUnchanged(t *testing.T) {
+	splitDNS := map[string][]string{
+		"corp.example.com": {"100.64.0.9"},
+		"old.example.com":  {"100.64.0.8"},
+	}
+	searchPaths := []string{"corp.example.com"}
+	var writes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + strings.TrimPrefix(r.URL.Path, "/api/v2/tailnet/test/dns/") {
+		case "GET split-dns":
+			json.NewEncoder(w).Encode(splitDNS)
+		case "PATCH split-dns":
+			writes = append(writes, "split-dns")
+			var patch map[string][]string
+			json.NewDecoder(r.Body).Decode(&patch)
+			for domain, ns := range patch {
+				if ns == nil {
+					delete(splitDNS, domain)
+				} else {
+					splitDNS[domain] = ns
+				}
+			}
+			json.NewEncoder(w).Encode(splitDNS)
+		case "GET searchpaths":
+			json.NewEncoder(w).Encode(map[string][]string{"searchPaths": searchPaths})
+		default:
+			writes = append(writes, r.Method+" "+r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	client := &tailscale.Client{BaseURL: serverURL, Tailnet: "test", APIKey: "test-key"}
+	cfg := &ConfigFile{
+		Domains:     Config{"corp.example.com": {"100.64.0.1"}},
+		SearchPaths: []string{"corp.example.com"},
+	}
+
+	if err := updateDNS(context.Background(), client, cfg); err != nil {
+		t.Fatalf("updateDNS() unexpected error: %v", err)
+	}
+	if len(writes) != 1 {
+		t.Errorf("first update made writes %q, want one split DNS patch", writes)
+	}
+
+	// the tailnet now matches the config, so the next tick writes nothing
+	writes = nil
+	if err := updateDNS(context.Background(), client, cfg); err != nil {
+		t.Fatalf("updateDNS() unexpected error: %v", err)
+	}
+	if len(writes) != 0 {
+		t.Errorf("unchanged update made writes %q, want none", writes)
+	}
+}
+
+func TestUpdateDNSDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("dry run made a write: %s %s", r.Method, r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"corp.example.com": {"100.64.0.1"}})
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	client := &tailscale.Client{BaseURL: serverURL, Tailnet: "test", APIKey: "test-key"}
+	dryRun = true
+	defer func() { dryRun = false }()
+
+	tests := []struct {
+		name    string
+		domains Config
+		wantErr error
+	}{
+		{name: "in sync", domains: Config{"corp.example.com": {"100.64.0.1"}}},
+		{name: "changes pending", domains: Config{"corp.example.com": {"100.64.0.2"}}, wantErr: errChangesPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := updateDNS(context.Background(), client, &ConfigFile{Domains: tt.domains})
+			if err != tt.wantErr {
+				t.Errorf("updateDNS() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// runMigrate implements `tsddns migrate`, which rewrites a config of any
+// supported format in the current structured format.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	in := fs.String("in", "", "Config file to migrate")
+	out := fs.String("out", "", "Where to write the migrated config (default: stdout)")
+	fs.Parse(args)
+
+	if *in == "" {
+		return fmt.Errorf("-in is required")
+	}
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	migrated, err := migrateConfig(data)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err := os.Stdout.Write(migrated)
+		return err
+	}
+	if err := writeFileAtomic(*out, migrated, 0644); err != nil {
+		return err
+	}
+	slog.Info("Wrote migrated config", "path", *out)
+	return nil
+}
+
+func migrateConfig(data []byte) ([]byte, error) {
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing config JSON: %w", err)
+	}
+	cfg.Version = configVersion
+
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it
+// into place, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
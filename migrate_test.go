@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{
+			name:  "legacy",
+			input: `{"example.com": ["svc:dns"], "internal.example.com": ["192.168.1.1"]}`,
+		},
+		{
+			name:  "already structured",
+			input: `{"domains": {"example.com": ["svc:dns"], "internal.example.com": ["192.168.1.1"]}}`,
+		},
+		{
+			name:    "newer version",
+			input:   `{"version": 99, "domains": {}}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := migrateConfig([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("migrateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			cfg, err := parseConfig(out)
+			if err != nil {
+				t.Fatalf("migrated config doesn't parse: %v\n%s", err, out)
+			}
+			if cfg.Version != configVersion {
+				t.Errorf("migrated version = %d, want %d", cfg.Version, configVersion)
+			}
+			if len(cfg.Domains) != 2 || cfg.Domains["example.com"][0] != "svc:dns" {
+				t.Errorf("migrated domains = %v", cfg.Domains)
+			}
+
+			again, err := migrateConfig(out)
+			if err != nil || string(again) != string(out) {
+				t.Errorf("migrating twice changed the output:\n%s\n%s", out, again)
+			}
+		})
+	}
+}
+
+func TestRunMigrate(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "old.json")
+	out := filepath.Join(dir, "new.json")
+	os.WriteFile(in, []byte(`{"example.com": ["192.168.1.1"]}`), 0644)
+
+	if err := runMigrate([]string{"-in", in, "-out", out}); err != nil {
+		t.Fatalf("runMigrate() unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading migrated config: %v", err)
+	}
+	if !strings.Contains(string(data), `"domains"`) {
+		t.Errorf("migrated config has no domains section:\n%s", data)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// MikroTik configures the static DNS of a MikroTik router, kept in sync
+// through the RouterOS v7 REST API.
+type MikroTik struct {
+	// URL is the router's web address, like "https://router.lan".
+	URL string `json:"url"`
+
+	// Username is the RouterOS user to log in as.
+	Username string `json:"username"`
+
+	// PasswordEnv names the environment variable holding the user's
+	// password. Defaults to MIKROTIK_PASSWORD.
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+
+	// CAFile is a CA bundle to verify the router's certificate with, for
+	// a self-signed one.
+	CAFile string `json:"caFile,omitempty"`
+
+	// TTL is the records' TTL. Defaults to 5m.
+	TTL Duration `json:"ttl,omitempty"`
+
+	// Records become static A and AAAA entries.
+	Records RecordSet `json:"records,omitempty"`
+
+	// Forward, if set, adds a static FWD entry for every applied split DNS
+	// domain and its subdomains. A FWD entry forwards to one server, so
+	// each domain is forwarded to its first nameserver.
+	Forward bool `json:"forward,omitempty"`
+}
+
+func (m *MikroTik) validate() error {
+	if m.URL == "" || m.Username == "" {
+		return fmt.Errorf("needs a url and username")
+	}
+	if len(m.Records) == 0 && !m.Forward {
+		return fmt.Errorf("needs records or forward")
+	}
+	return nil
+}
+
+// mikroTikEntry is a static DNS entry in the RouterOS REST API, which
+// represents every value as a string.
+type mikroTikEntry struct {
+	ID             string `json:".id,omitempty"`
+	Name           string `json:"name"`
+	Type           string `json:"type,omitempty"`
+	Address        string `json:"address,omitempty"`
+	ForwardTo      string `json:"forward-to,omitempty"`
+	MatchSubdomain string `json:"match-subdomain,omitempty"`
+	TTL            string `json:"ttl,omitempty"`
+	Comment        string `json:"comment,omitempty"`
+}
+
+func (m *MikroTik) forwards() bool { return m.Forward }
+
+// sync resolves the configured records and reconciles the router's
+// static DNS with them, and with the split DNS domains if forwarding.
+// tsddns comments the entries it manages and only changes or deletes
+// those. splitDNS is nil when split DNS isn't managed.
+func (m *MikroTik) sync(ctx context.Context, r *resolver, splitDNS map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, m.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	desired, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+	c, err := newMikroTikClient(m)
+	if err != nil {
+		return err
+	}
+
+	var entries []mikroTikEntry
+	if err := c.do(ctx, "GET", "", nil, &entries); err != nil {
+		return fmt.Errorf("listing static DNS: %w", err)
+	}
+	var owned, foreign, ownedFwd []record
+	taken := make(map[string]bool) // domains with FWD entries tsddns doesn't manage
+	for _, e := range entries {
+		name := normalizeDomain(e.Name)
+		mine := e.Comment == recordOwner
+		switch typ := cmp.Or(e.Type, "A"); typ {
+		case "A", "AAAA":
+			rec := record{ID: e.ID, Name: name, Type: typ, Value: e.Address}
+			if mine {
+				owned = append(owned, rec)
+			} else {
+				foreign = append(foreign, rec)
+			}
+		case "FWD":
+			if mine {
+				ownedFwd = append(ownedFwd, record{ID: e.ID, Name: name, Type: typ, Value: e.ForwardTo})
+			} else {
+				taken[name] = true
+			}
+		}
+	}
+
+	create, remove := planRecords(desired, owned, foreign)
+	if m.Forward {
+		var forwards []record
+		domains, addrs := exportDomains(splitDNS)
+		for _, domain := range domains {
+			name := normalizeDomain(domain)
+			if taken[name] {
+				slog.WarnContext(ctx, "Domain has a MikroTik FWD entry tsddns doesn't manage, leaving it untouched", "domain", name)
+				continue
+			}
+			forwards = append(forwards, record{Name: name, Type: "FWD", Value: addrs[domain][0]})
+		}
+		createFwd, removeFwd := planRecords(forwards, ownedFwd, nil)
+		create = append(create, createFwd...)
+		for _, rec := range removeFwd {
+			if !taken[rec.Name] {
+				remove = append(remove, rec)
+			}
+		}
+	}
+
+	for _, rec := range remove {
+		slog.InfoContext(ctx, "Deleting MikroTik static DNS entry", "name", rec.Name, "type", rec.Type, "value", rec.Value)
+		if err := c.do(ctx, "DELETE", "/"+url.PathEscape(rec.ID), nil, nil); err != nil {
+			return fmt.Errorf("deleting %s: %w", rec.Name, err)
+		}
+	}
+	ttl := fmt.Sprintf("%ds", int(time.Duration(cmp.Or(m.TTL, Duration(defaultRecordTTL))).Seconds()))
+	for _, rec := range create {
+		slog.InfoContext(ctx, "Creating MikroTik static DNS entry", "name", rec.Name, "type", rec.Type, "value", rec.Value)
+		e := mikroTikEntry{Name: rec.Name, Type: rec.Type, Comment: recordOwner}
+		if rec.Type == "FWD" {
+			e.ForwardTo, e.MatchSubdomain = rec.Value, "yes"
+		} else {
+			e.Address, e.TTL = rec.Value, ttl
+		}
+		if err := c.do(ctx, "PUT", "", e, nil); err != nil {
+			return fmt.Errorf("creating %s: %w", rec.Name, err)
+		}
+	}
+	if len(create) == 0 && len(remove) == 0 {
+		slog.InfoContext(ctx, "MikroTik static DNS is up to date")
+	}
+	return nil
+}
+
+// mikroTikClient talks to the /ip/dns/static menu of the RouterOS REST API.
+type mikroTikClient struct {
+	url      string
+	username string
+	password string
+	http     *http.Client
+}
+
+func newMikroTikClient(m *MikroTik) (*mikroTikClient, error) {
+	c := &mikroTikClient{
+		url:      strings.TrimSuffix(m.URL, "/") + "/rest/ip/dns/static",
+		username: m.Username,
+		password: os.Getenv(cmp.Or(m.PasswordEnv, "MIKROTIK_PASSWORD")),
+	}
+	var err error
+	c.http, err = httpClientWithCA(m.CAFile)
+	return c, err
+}
+
+func (c *mikroTikClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Message string `json:"message"`
+			Detail  string `json:"detail"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, cmp.Or(apiErr.Detail, apiErr.Message))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSyncMikroTik(t *testing.T) {
+	entries := []mikroTikEntry{
+		{ID: "*1", Name: "ns.internal.example.com", Address: "100.64.0.1", Comment: recordOwner},
+		{ID: "*2", Name: "old.internal.example.com", Type: "AAAA", Address: "fd7a:115c:a1e0::2", Comment: recordOwner},
+		{ID: "*3", Name: "nas.internal.example.com", Address: "192.168.88.10"},
+		{ID: "*4", Name: "corp.example.com", Type: "FWD", ForwardTo: "100.64.0.9", Comment: recordOwner},
+		{ID: "*5", Name: "lab.example.com", Type: "FWD", ForwardTo: "10.0.0.53"},
+	}
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "tsddns" || pass != "hunter2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": 401, "message": "Unauthorized"}`))
+			return
+		}
+		switch r.Method {
+		case "GET":
+			json.NewEncoder(w).Encode(entries)
+		case "PUT":
+			var e mikroTikEntry
+			json.NewDecoder(r.Body).Decode(&e)
+			if e.Comment != recordOwner {
+				t.Errorf("created %s without the tsddns comment", e.Name)
+			}
+			calls = append(calls, strings.Join([]string{"PUT", e.Name, e.Type, e.Address + e.ForwardTo, e.MatchSubdomain, e.TTL}, " "))
+			json.NewEncoder(w).Encode(e)
+		case "DELETE":
+			calls = append(calls, "DELETE "+strings.TrimPrefix(r.URL.Path, "/rest/ip/dns/static/"))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("MIKROTIK_PASSWORD", "hunter2")
+
+	m := &MikroTik{
+		URL:      srv.URL,
+		Username: "tsddns",
+		Forward:  true,
+		Records: Config{
+			"ns.internal.example.com":  {"100.64.0.1"},
+			"new.internal.example.com": {"100.64.0.5"},
+			"nas.internal.example.com": {"100.64.0.6"},
+		},
+	}
+	splitDNS := map[string][]string{
+		"corp.example.com": {"100.64.0.10", "100.64.0.11"},
+		"lab.example.com":  {"100.64.0.12"},
+	}
+	if err := m.sync(context.Background(), newResolver(nil, Defaults{}), splitDNS); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+	want := []string{
+		"DELETE *2",
+		"DELETE *4",
+		"PUT new.internal.example.com A 100.64.0.5  300s",
+		"PUT corp.example.com FWD 100.64.0.10 yes ",
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	t.Setenv("MIKROTIK_PASSWORD", "wrong")
+	if err := m.sync(context.Background(), newResolver(nil, Defaults{}), splitDNS); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("sync() error = %v, want the API's error", err)
+	}
+}
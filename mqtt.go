@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// MQTT configures publishing the outcome of every update, and each domain
+// it changed, to an MQTT broker, for dashboards like Home Assistant's.
+type MQTT struct {
+	// Broker is the broker's URL: mqtt://host[:port] (1883 by default) or
+	// mqtts://host[:port] (8883) for TLS. tcp:// and ssl:// work too.
+	Broker string `json:"broker"`
+
+	// Topic prefixes the topics published to. Defaults to "tsddns".
+	Topic string `json:"topic,omitempty"`
+
+	// ClientID identifies tsddns to the broker. Defaults to a random one
+	// for every connection.
+	ClientID string `json:"clientId,omitempty"`
+
+	// Username, if set, authenticates with the password in the
+	// environment variable named by PasswordEnv, MQTT_PASSWORD by default.
+	Username    string `json:"username,omitempty"`
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+
+	// QoS is 0 to publish at most once, or 1 to wait for the broker to
+	// acknowledge every message.
+	QoS int `json:"qos,omitempty"`
+
+	// Retain has the broker keep the last message of every topic, so a
+	// dashboard that connects later still sees the current state.
+	Retain bool `json:"retain,omitempty"`
+}
+
+func (m *MQTT) validate() error {
+	if _, _, err := m.address(); err != nil {
+		return err
+	}
+	if strings.ContainsAny(m.Topic, "+#") {
+		return fmt.Errorf("topic can't contain wildcards")
+	}
+	if m.QoS != 0 && m.QoS != 1 {
+		return fmt.Errorf("qos must be 0 or 1")
+	}
+	return nil
+}
+
+// address returns the broker's host:port and whether it uses TLS.
+func (m *MQTT) address() (string, bool, error) {
+	u, err := url.Parse(m.Broker)
+	if err != nil || u.Hostname() == "" {
+		return "", false, fmt.Errorf("broker must be a URL like mqtt://host:1883")
+	}
+	var useTLS bool
+	port := "1883"
+	switch u.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl":
+		useTLS, port = true, "8883"
+	default:
+		return "", false, fmt.Errorf("unknown broker scheme %q: want mqtt or mqtts", u.Scheme)
+	}
+	return net.JoinHostPort(u.Hostname(), cmp.Or(u.Port(), port)), useTLS, nil
+}
+
+// mqttMessage is one message to publish.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// publishOutcome publishes the outcome of an update to <topic>/reconcile
+// and each domain it changed to <topic>/domains/<domain>. Dry runs publish
+// nothing, and failures to publish are logged rather than returned since
+// they don't change what was applied.
+func publishOutcome(ctx context.Context, tailnet string, m *MQTT, changes []domainChange, err error) {
+	if m == nil || dryRun || errors.Is(err, errChangesPending) {
+		return
+	}
+	now := time.Now().UTC()
+	result := struct {
+		RunID   string    `json:"runId,omitempty"`
+		Trigger string    `json:"trigger,omitempty"`
+		Tailnet string    `json:"tailnet"`
+		Time    time.Time `json:"time"`
+		Result  string    `json:"result"`
+		Error   string    `json:"error,omitempty"`
+		Changes int       `json:"changes"`
+	}{RunID: runID(ctx), Tailnet: tailnet, Time: now, Result: "unchanged", Changes: len(changes)}
+	result.Trigger, _ = ctx.Value(triggerKey{}).(string)
+	switch {
+	case errors.Is(err, errRolledBack):
+		result.Result, result.Error = "rolledBack", err.Error()
+	case err != nil:
+		result.Result, result.Error = "failed", err.Error()
+	case len(changes) > 0:
+		result.Result = "applied"
+	}
+
+	topic := cmp.Or(strings.TrimSuffix(m.Topic, "/"), "tsddns")
+	payload, _ := json.Marshal(result)
+	messages := []mqttMessage{{topic + "/reconcile", payload}}
+	if err == nil {
+		for _, c := range changes {
+			payload, _ := json.Marshal(struct {
+				domainChange
+				RunID string    `json:"runId,omitempty"`
+				Time  time.Time `json:"time"`
+			}{c, result.RunID, now})
+			messages = append(messages, mqttMessage{topic + "/domains/" + strings.TrimSuffix(c.Domain, "."), payload})
+		}
+	}
+
+	// a cancelled update is still worth reporting
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), hookTimeout)
+	defer cancel()
+	if err := m.publish(ctx, messages); err != nil {
+		slog.ErrorContext(ctx, "Publishing to MQTT failed", "broker", m.Broker, "error", err)
+	}
+}
+
+// MQTT 3.1.1 control packet types.
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttDisconnect = 14
+)
+
+// publish connects to the broker, publishes messages and disconnects.
+func (m *MQTT) publish(ctx context.Context, messages []mqttMessage) error {
+	addr, useTLS, err := m.address()
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn = tls.Client(conn, &tls.Config{ServerName: host})
+	}
+	r := bufio.NewReader(conn)
+
+	if _, err := conn.Write(m.connectPacket()); err != nil {
+		return err
+	}
+	header, body, err := readMQTTPacket(r)
+	if err != nil {
+		return fmt.Errorf("connecting: %w", err)
+	}
+	if header>>4 != mqttConnack || len(body) != 2 {
+		return fmt.Errorf("connecting: broker sent packet type %d, want CONNACK", header>>4)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("broker refused the connection with code %d", body[1])
+	}
+
+	for i, msg := range messages {
+		id := uint16(i + 1)
+		if _, err := conn.Write(m.publishPacket(msg, id)); err != nil {
+			return fmt.Errorf("publishing %s: %w", msg.topic, err)
+		}
+		if m.QoS == 0 {
+			continue
+		}
+		header, body, err := readMQTTPacket(r)
+		if err != nil {
+			return fmt.Errorf("publishing %s: %w", msg.topic, err)
+		}
+		if header>>4 != mqttPuback || len(body) != 2 || binary.BigEndian.Uint16(body) != id {
+			return fmt.Errorf("publishing %s: broker didn't acknowledge it", msg.topic)
+		}
+	}
+	_, err = conn.Write([]byte{mqttDisconnect << 4, 0})
+	return err
+}
+
+func (m *MQTT) connectPacket() []byte {
+	var flags byte = 0x02 // clean session
+	var payload []byte
+	clientID := m.ClientID
+	if clientID == "" {
+		var b [6]byte
+		rand.Read(b[:])
+		clientID = "tsddns-" + hex.EncodeToString(b[:])
+	}
+	payload = appendMQTTString(payload, clientID)
+	if m.Username != "" {
+		flags |= 0xC0 // username and password
+		payload = appendMQTTString(payload, m.Username)
+		payload = appendMQTTString(payload, os.Getenv(cmp.Or(m.PasswordEnv, "MQTT_PASSWORD")))
+	}
+	// protocol name and level 4 for 3.1.1, flags, and a 60s keep alive
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, flags, 0, 60)
+	return mqttPacket(mqttConnect<<4, append(body, payload...))
+}
+
+func (m *MQTT) publishPacket(msg mqttMessage, id uint16) []byte {
+	header := byte(mqttPublish<<4) | byte(m.QoS)<<1
+	if m.Retain {
+		header |= 0x01
+	}
+	body := appendMQTTString(nil, msg.topic)
+	if m.QoS > 0 {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	return mqttPacket(header, append(body, msg.payload...))
+}
+
+// mqttPacket frames body with header and its remaining length.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// readMQTTPacket reads one packet, returning its fixed header, the type in
+// the high four bits, and its body.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n, shift int
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, fmt.Errorf("malformed packet length")
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
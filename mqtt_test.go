@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// published is a message a fakeBroker received.
+type published struct {
+	topic   string
+	payload map[string]any
+	retain  bool
+}
+
+// fakeBroker accepts MQTT connections, acknowledging QoS 1 messages, and
+// records what's published and the connect packets' bodies. It sends on the
+// returned channel once it's done with each connection.
+func fakeBroker(t *testing.T) (string, *[]published, *[][]byte, <-chan struct{}) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []published
+	var connects [][]byte
+	done := make(chan struct{})
+	handled := make(chan struct{}, 1)
+	t.Cleanup(func() { ln.Close(); <-done })
+	go func() {
+		defer close(done)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			for {
+				header, body, err := readMQTTPacket(r)
+				if err != nil || header>>4 == mqttDisconnect {
+					break
+				}
+				switch header >> 4 {
+				case mqttConnect:
+					connects = append(connects, body)
+					conn.Write([]byte{mqttConnack << 4, 2, 0, 0})
+				case mqttPublish:
+					n := int(binary.BigEndian.Uint16(body))
+					topic, rest := string(body[2:2+n]), body[2+n:]
+					if qos := header >> 1 & 0x03; qos == 1 {
+						conn.Write(append([]byte{mqttPuback << 4, 2}, rest[:2]...))
+						rest = rest[2:]
+					}
+					var p map[string]any
+					json.Unmarshal(rest, &p)
+					messages = append(messages, published{topic: topic, payload: p, retain: header&0x01 != 0})
+				}
+			}
+			conn.Close()
+			handled <- struct{}{}
+		}
+	}()
+	return "mqtt://" + ln.Addr().String(), &messages, &connects, handled
+}
+
+func TestPublishOutcome(t *testing.T) {
+	broker, messages, connects, handled := fakeBroker(t)
+	changes := []domainChange{
+		{Domain: "corp.example.com", Old: []string{"100.64.0.1"}, New: []string{"100.64.0.2"}},
+		{Domain: "lab.example.com.", New: []string{"100.64.0.3"}},
+	}
+	tests := []struct {
+		name       string
+		mqtt       *MQTT
+		changes    []domainChange
+		err        error
+		dryRun     bool
+		wantTopics []string
+		wantResult string
+		wantRetain bool
+	}{
+		{name: "applied", mqtt: &MQTT{Broker: broker}, changes: changes,
+			wantTopics: []string{"tsddns/reconcile", "tsddns/domains/corp.example.com", "tsddns/domains/lab.example.com"}, wantResult: "applied"},
+		{name: "unchanged", mqtt: &MQTT{Broker: broker, Topic: "home/dns/", Retain: true},
+			wantTopics: []string{"home/dns/reconcile"}, wantResult: "unchanged", wantRetain: true},
+		{name: "failed", mqtt: &MQTT{Broker: broker}, changes: changes, err: errors.New("API returned status 500"),
+			wantTopics: []string{"tsddns/reconcile"}, wantResult: "failed"},
+		{name: "rolled back", mqtt: &MQTT{Broker: broker, QoS: 1}, err: fmt.Errorf("verifying: %w", errRolledBack),
+			wantTopics: []string{"tsddns/reconcile"}, wantResult: "rolledBack"},
+		{name: "qos 1", mqtt: &MQTT{Broker: broker, QoS: 1}, changes: changes[:1],
+			wantTopics: []string{"tsddns/reconcile", "tsddns/domains/corp.example.com"}, wantResult: "applied"},
+		{name: "dry run", mqtt: &MQTT{Broker: broker}, changes: changes, dryRun: true},
+		{name: "no mqtt", changes: changes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(dry bool) { dryRun = dry }(dryRun)
+			dryRun = tt.dryRun
+			*messages = nil
+
+			ctx := withTrigger(withRunID(context.Background()), triggerInterval)
+			publishOutcome(ctx, "example.com", tt.mqtt, tt.changes, tt.err)
+			if len(tt.wantTopics) > 0 {
+				<-handled
+			}
+
+			var topics []string
+			for _, m := range *messages {
+				topics = append(topics, m.topic)
+			}
+			if strings.Join(topics, " ") != strings.Join(tt.wantTopics, " ") {
+				t.Fatalf("published to %v, want %v", topics, tt.wantTopics)
+			}
+			if len(tt.wantTopics) == 0 {
+				return
+			}
+			result := (*messages)[0].payload
+			if result["result"] != tt.wantResult || result["runId"] != runID(ctx) || result["trigger"] != triggerInterval {
+				t.Errorf("reconcile message = %v, want result %s with the run's context", result, tt.wantResult)
+			}
+			if (*messages)[0].retain != tt.wantRetain {
+				t.Errorf("retain = %v, want %v", (*messages)[0].retain, tt.wantRetain)
+			}
+			if tt.err != nil && result["error"] != tt.err.Error() {
+				t.Errorf("reconcile message error = %v, want %q", result["error"], tt.err)
+			}
+			if len(*messages) > 1 {
+				domain := (*messages)[1].payload
+				if domain["domain"] != "corp.example.com" || fmt.Sprint(domain["new"]) != "[100.64.0.2]" {
+					t.Errorf("domain message = %v, want corp.example.com's change", domain)
+				}
+			}
+		})
+	}
+
+	t.Run("credentials", func(t *testing.T) {
+		t.Setenv("HA_MQTT_PASSWORD", "hunter2")
+		*connects = nil
+		m := &MQTT{Broker: broker, ClientID: "tsddns-home", Username: "tsddns", PasswordEnv: "HA_MQTT_PASSWORD"}
+		publishOutcome(context.Background(), "example.com", m, nil, nil)
+		<-handled
+		if len(*connects) != 1 {
+			t.Fatalf("got %d connections, want 1", len(*connects))
+		}
+		body := string((*connects)[0])
+		for _, want := range []string{"MQTT", "tsddns-home", "tsddns", "hunter2"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("CONNECT %q doesn't contain %q", body, want)
+			}
+		}
+	})
+}
+
+func TestMQTTValidate(t *testing.T) {
+	tests := []struct {
+		mqtt     MQTT
+		wantAddr string
+		wantTLS  bool
+		wantErr  bool
+	}{
+		{mqtt: MQTT{Broker: "mqtt://homeassistant.local"}, wantAddr: "homeassistant.local:1883"},
+		{mqtt: MQTT{Broker: "mqtts://broker.example.com"}, wantAddr: "broker.example.com:8883", wantTLS: true},
+		{mqtt: MQTT{Broker: "tcp://10.0.0.5:1884", QoS: 1}, wantAddr: "10.0.0.5:1884"},
+		{mqtt: MQTT{Broker: "homeassistant.local:1883"}, wantErr: true},
+		{mqtt: MQTT{Broker: "http://homeassistant.local"}, wantErr: true},
+		{mqtt: MQTT{Broker: "mqtt://homeassistant.local", Topic: "tsddns/#"}, wantErr: true},
+		{mqtt: MQTT{Broker: "mqtt://homeassistant.local", QoS: 2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		err := tt.mqtt.validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v) error = %v, wantErr %v", tt.mqtt, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if addr, useTLS, _ := tt.mqtt.address(); addr != tt.wantAddr || useTLS != tt.wantTLS {
+			t.Errorf("address(%q) = %s, %v, want %s, %v", tt.mqtt.Broker, addr, useTLS, tt.wantAddr, tt.wantTLS)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ipnBusMask asks the IPN bus for the current netmap up front, without
+// private keys.
+const ipnBusMask = 1<<3 | 1<<4 // NotifyInitialNetMap | NotifyNoPrivateKeys
+
+// netmapRetryDelay is how long to wait before reconnecting to tailscaled
+// after the IPN bus stream ends.
+var netmapRetryDelay = 10 * time.Second
+
+// netmapNode is the part of a netmap node that can change what an entry
+// resolves to. Endpoints, DERP regions and the like change constantly and
+// are left out so they don't trigger updates.
+type netmapNode struct {
+	StableID      string   `json:"StableID"`
+	Name          string   `json:"Name"`
+	Addresses     []string `json:"Addresses"`
+	Tags          []string `json:"Tags"`
+	PrimaryRoutes []string `json:"PrimaryRoutes"`
+	Online        *bool    `json:"Online"`
+	Expired       bool     `json:"Expired"`
+	Hostinfo      struct {
+		Hostname string `json:"Hostname"`
+	} `json:"Hostinfo"`
+}
+
+// ipnNotify is a message on the IPN bus. Only those carrying a netmap are
+// of interest.
+type ipnNotify struct {
+	NetMap *struct {
+		SelfNode *netmapNode  `json:"SelfNode"`
+		Peers    []netmapNode `json:"Peers"`
+	} `json:"NetMap"`
+}
+
+// netmapFingerprint returns a string that changes when any node's
+// relevant fields change.
+func netmapFingerprint(self *netmapNode, peers []netmapNode) string {
+	nodes := slices.Clone(peers)
+	if self != nil {
+		nodes = append(nodes, *self)
+	}
+	for i := range nodes {
+		nodes[i].Addresses = slices.Sorted(slices.Values(nodes[i].Addresses))
+		nodes[i].Tags = slices.Sorted(slices.Values(nodes[i].Tags))
+		nodes[i].PrimaryRoutes = slices.Sorted(slices.Values(nodes[i].PrimaryRoutes))
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].StableID < nodes[j].StableID })
+	data, _ := json.Marshal(nodes)
+	return string(data)
+}
+
+// watchNetmap follows the netmap of the tailscaled listening on socket and
+// sends on trigger whenever a node is added, removed or changes in a way
+// that matters, reconnecting whenever the stream ends. It runs until ctx is
+// done.
+func watchNetmap(ctx context.Context, socket string, trigger chan<- struct{}) {
+	var last string
+	for {
+		err := watchIPNBus(ctx, socket, func(fingerprint string) {
+			if last != "" && fingerprint != last {
+				select {
+				case trigger <- struct{}{}:
+				default:
+					// an update is already pending and will see this change too
+				}
+			}
+			last = fingerprint
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		slog.WarnContext(ctx, "Watching tailscaled netmap failed, retrying", "delay", netmapRetryDelay, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(netmapRetryDelay):
+		}
+	}
+}
+
+// watchIPNBus streams the IPN bus of the tailscaled listening on socket,
+// calling fn with the fingerprint of every netmap it sends, until the
+// stream ends.
+func watchIPNBus(ctx context.Context, socket string, fn func(fingerprint string)) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/watch-ipn-bus?mask=%d", localAPIURL, ipnBusMask), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := localAPIClient(socket).Do(req)
+	if err != nil {
+		return fmt.Errorf("querying tailscaled: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tailscaled returned status %d", resp.StatusCode)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var n ipnNotify
+		if err := dec.Decode(&n); err != nil {
+			return fmt.Errorf("reading IPN bus: %w", err)
+		}
+		if n.NetMap != nil {
+			fn(netmapFingerprint(n.NetMap.SelfNode, n.NetMap.Peers))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNetmapFingerprint(t *testing.T) {
+	online, offline := true, false
+	base := []netmapNode{
+		{StableID: "n1", Name: "ns1.example.ts.net.", Addresses: []string{"100.64.0.1/32", "fd7a:115c:a1e0::1/128"}, Tags: []string{"tag:dns"}, Online: &online},
+		{StableID: "n2", Name: "ns2.example.ts.net.", Addresses: []string{"100.64.0.2/32"}},
+	}
+	tests := []struct {
+		name     string
+		peers    []netmapNode
+		wantSame bool
+	}{
+		{
+			name:     "reordered",
+			peers:    []netmapNode{base[1], {StableID: "n1", Name: "ns1.example.ts.net.", Addresses: []string{"fd7a:115c:a1e0::1/128", "100.64.0.1/32"}, Tags: []string{"tag:dns"}, Online: &online}},
+			wantSame: true,
+		},
+		{
+			name:  "device added",
+			peers: append([]netmapNode{{StableID: "n3", Name: "ns3.example.ts.net."}}, base...),
+		},
+		{
+			name:  "device removed",
+			peers: base[:1],
+		},
+		{
+			name:  "went offline",
+			peers: []netmapNode{{StableID: "n1", Name: "ns1.example.ts.net.", Addresses: []string{"100.64.0.1/32", "fd7a:115c:a1e0::1/128"}, Tags: []string{"tag:dns"}, Online: &offline}, base[1]},
+		},
+		{
+			name:  "tag removed",
+			peers: []netmapNode{{StableID: "n1", Name: "ns1.example.ts.net.", Addresses: []string{"100.64.0.1/32", "fd7a:115c:a1e0::1/128"}, Online: &online}, base[1]},
+		},
+	}
+	want := netmapFingerprint(nil, base)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := netmapFingerprint(nil, tt.peers); (got == want) != tt.wantSame {
+				t.Errorf("fingerprint same = %v, want %v", got == want, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestWatchNetmap(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "tailscaled.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listening on %s: %v", socket, err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/localapi/v0/watch-ipn-bus" {
+			http.NotFound(w, r)
+			return
+		}
+		// the initial netmap, an unrelated notification, the same netmap
+		// again and then one with a new device
+		w.Write([]byte(`{"NetMap": {"Peers": [{"StableID": "n1", "Addresses": ["100.64.0.1/32"]}]}}
+{"Health": {}}
+{"NetMap": {"Peers": [{"StableID": "n1", "Addresses": ["100.64.0.1/32"]}]}}
+{"NetMap": {"Peers": [{"StableID": "n1", "Addresses": ["100.64.0.1/32"]}, {"StableID": "n2", "Addresses": ["100.64.0.2/32"]}]}}
+`))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	server.Listener = ln
+	server.Start()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trigger := make(chan struct{}, 1)
+	go watchNetmap(ctx, socket, trigger)
+
+	select {
+	case <-trigger:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update triggered for the new device")
+	}
+	select {
+	case <-trigger:
+		t.Error("more than one update triggered")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
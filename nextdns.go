@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+)
+
+// nextDNSAPI is the NextDNS API base URL. It's a variable for tests.
+var nextDNSAPI = "https://api.nextdns.io"
+
+// NextDNS configures rewrites kept in a NextDNS profile.
+type NextDNS struct {
+	// Profile is the profile's ID, as in its DoH URL.
+	Profile string `json:"profile"`
+
+	// APIKeyEnv names the environment variable holding the API key, from
+	// the account page. Defaults to NEXTDNS_API_KEY.
+	APIKeyEnv string `json:"apiKeyEnv,omitempty"`
+
+	// Records are rewrites. A rewrite has one answer, so each name is
+	// rewritten to its first address, preferring IPv4.
+	Records RecordSet `json:"records"`
+}
+
+func (n *NextDNS) validate() error {
+	if n.Profile == "" {
+		return fmt.Errorf("needs a profile")
+	}
+	return nil
+}
+
+// nextDNSRewrite is a rewrite in the NextDNS API.
+type nextDNSRewrite struct {
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// sync resolves the configured records and reconciles the profile's
+// rewrites with them. Rewrites can't be marked, so tsddns manages the
+// rewrites of the configured names, and of the names it wrote before
+// according to the state file.
+func (n *NextDNS) sync(ctx context.Context, r *resolver, _ map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, n.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	records, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+	// records are sorted with A before AAAA, so the first of each name is
+	// the one to use
+	desired := make(map[string]string)
+	for _, rec := range records {
+		if _, ok := desired[rec.Name]; !ok {
+			desired[rec.Name] = rec.Value
+		}
+	}
+
+	managed, err := loadOwnedRecords("nextdns")
+	if err != nil {
+		return err
+	}
+	for name := range n.Records {
+		managed[normalizeDomain(name)] = true
+	}
+
+	c := &nextDNSClient{
+		url:    nextDNSAPI + "/profiles/" + url.PathEscape(n.Profile) + "/rewrites",
+		apiKey: os.Getenv(cmp.Or(n.APIKeyEnv, "NEXTDNS_API_KEY")),
+	}
+	var existing struct {
+		Data []nextDNSRewrite `json:"data"`
+	}
+	if err := c.do(ctx, "GET", "", nil, &existing); err != nil {
+		return fmt.Errorf("listing rewrites: %w", err)
+	}
+
+	changed := false
+	current := make(map[string]bool)
+	for _, rw := range existing.Data {
+		name := normalizeDomain(rw.Name)
+		if !managed[name] {
+			continue
+		}
+		if desired[name] == rw.Content && !current[name] {
+			current[name] = true
+			continue
+		}
+		slog.InfoContext(ctx, "Deleting NextDNS rewrite", "name", name, "value", rw.Content)
+		if err := c.do(ctx, "DELETE", "/"+url.PathEscape(rw.ID), nil, nil); err != nil {
+			return fmt.Errorf("deleting rewrite %s: %w", name, err)
+		}
+		changed = true
+	}
+
+	var names []string
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if current[name] {
+			continue
+		}
+		slog.InfoContext(ctx, "Creating NextDNS rewrite", "name", name, "value", desired[name])
+		if err := c.do(ctx, "POST", "", nextDNSRewrite{Name: name, Content: desired[name]}, nil); err != nil {
+			return fmt.Errorf("creating rewrite %s: %w", name, err)
+		}
+		changed = true
+	}
+	if !changed {
+		slog.InfoContext(ctx, "NextDNS rewrites are up to date")
+	}
+	return saveOwnedRecords("nextdns", names)
+}
+
+// nextDNSClient talks to a profile's rewrites in the NextDNS API.
+type nextDNSClient struct {
+	url    string
+	apiKey string
+}
+
+func (c *nextDNSClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", c.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// errors come back in an errors list, sometimes with a 200
+	var envelope struct {
+		Errors []struct {
+			Code   string `json:"code"`
+			Detail string `json:"detail"`
+		} `json:"errors"`
+	}
+	json.Unmarshal(data, &envelope)
+	if len(envelope.Errors) > 0 {
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, cmp.Or(envelope.Errors[0].Detail, envelope.Errors[0].Code))
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSyncNextDNS(t *testing.T) {
+	rewrites := []nextDNSRewrite{
+		{ID: "1", Name: "ns.internal.example.com", Content: "100.64.0.1"},
+		{ID: "2", Name: "old.internal.example.com", Content: "100.64.0.2"},
+		{ID: "3", Name: "manual.internal.example.com", Content: "100.64.0.3"},
+		{ID: "4", Name: "db.internal.example.com", Content: "100.64.0.9"},
+	}
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": [{"code": "forbidden"}]}`))
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/profiles/abc123/rewrites":
+			json.NewEncoder(w).Encode(map[string]any{"data": rewrites})
+		case r.Method == "POST" && r.URL.Path == "/profiles/abc123/rewrites":
+			var rw nextDNSRewrite
+			json.NewDecoder(r.Body).Decode(&rw)
+			calls = append(calls, "POST "+rw.Name+" "+rw.Content)
+			w.Write([]byte(`{"data": {}}`))
+		case r.Method == "DELETE":
+			calls = append(calls, "DELETE "+strings.TrimPrefix(r.URL.Path, "/profiles/abc123/rewrites/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	nextDNSAPI = srv.URL
+	t.Setenv("NEXTDNS_API_KEY", "secret")
+
+	stateFile = filepath.Join(t.TempDir(), "state.json")
+	defer func() { stateFile = "" }()
+	if err := saveOwnedRecords("nextdns", []string{"old.internal.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	n := &NextDNS{
+		Profile: "abc123",
+		Records: Config{
+			"ns.internal.example.com":  {"100.64.0.1"},
+			"db.internal.example.com":  {"fd7a:115c:a1e0::4", "100.64.0.4"},
+			"new.internal.example.com": {"100.64.0.5"},
+		},
+	}
+	if err := n.sync(context.Background(), newResolver(nil, Defaults{}), nil); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+	want := []string{
+		"DELETE 2",
+		"DELETE 4",
+		"POST db.internal.example.com 100.64.0.4",
+		"POST new.internal.example.com 100.64.0.5",
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	owned, err := loadOwnedRecords("nextdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owned) != 3 || owned["old.internal.example.com"] || !owned["new.internal.example.com"] {
+		t.Errorf("owned records = %v, want the three configured names", owned)
+	}
+
+	t.Setenv("NEXTDNS_API_KEY", "wrong")
+	if err := n.sync(context.Background(), newResolver(nil, Defaults{}), nil); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Errorf("sync() error = %v, want the API's error", err)
+	}
+}
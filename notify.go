@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// What can trigger an update, as reported in notifications.
+const (
+	triggerStartup  = "startup"
+	triggerInterval = "interval"
+	triggerTailnet  = "tailnet change"
+	triggerConfig   = "config change"
+	triggerAdmin    = "admin request"
+	triggerSignal   = "signal"
+	triggerDrift    = "drift"
+	triggerSchedule = "schedule"
+)
+
+type triggerKey struct{}
+
+// withTrigger returns ctx recording what triggered the update it's for.
+func withTrigger(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, triggerKey{}, trigger)
+}
+
+// maxChatDiffLines is how many changed domains a Slack or Discord message
+// lists before summarizing the rest, to stay within their message limits.
+const maxChatDiffLines = 20
+
+// Notification is a webhook that gets a JSON POST after every update that
+// changed split DNS or failed.
+type Notification struct {
+	URL string `json:"url"`
+
+	// Format is "json", the default, for notificationPayload as it is, or
+	// "slack" or "discord" for a chat message to an incoming webhook.
+	Format string `json:"format,omitempty"`
+
+	// Headers are added to the request, e.g. for authentication.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (n Notification) validate() error {
+	if !strings.HasPrefix(n.URL, "http://") && !strings.HasPrefix(n.URL, "https://") {
+		return fmt.Errorf("url must start with http:// or https://")
+	}
+	switch n.Format {
+	case "", "json", "slack", "discord":
+	default:
+		return fmt.Errorf("unknown format %q: want json, slack or discord", n.Format)
+	}
+	return nil
+}
+
+// notificationPayload is the body of a notification.
+type notificationPayload struct {
+	// Event is "applied", "failed", "rolledBack" for a failed update
+	// that restored the last known good split DNS, or "drift" for split
+	// DNS changed outside tsddns.
+	Event   string    `json:"event"`
+	Trigger string    `json:"trigger,omitempty"`
+	RunID   string    `json:"runId,omitempty"`
+	Tailnet string    `json:"tailnet"`
+	Time    time.Time `json:"time"`
+
+	// Changes are what was applied, or for a failed update what it tried
+	// to apply, if it got that far. For drift, each change is from what
+	// tsddns applied to what split DNS has now.
+	Changes []domainChange `json:"changes"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// notifyOutcome sends the outcome of an update to every notification: its
+// changes if it applied some, or its error if it failed. Dry runs and
+// updates that had nothing to change send nothing. Failures to notify are
+// logged rather than returned since they don't change what was applied.
+func notifyOutcome(ctx context.Context, tailnet string, notifications []Notification, changes []domainChange, err error) {
+	if len(notifications) == 0 || dryRun || errors.Is(err, errChangesPending) {
+		return
+	}
+	p := notificationPayload{
+		Event:   "applied",
+		RunID:   runID(ctx),
+		Tailnet: tailnet,
+		Time:    time.Now().UTC(),
+		Changes: changes,
+	}
+	p.Trigger, _ = ctx.Value(triggerKey{}).(string)
+	if errors.Is(err, errRolledBack) {
+		p.Event = "rolledBack"
+		p.Error = err.Error()
+	} else if err != nil {
+		p.Event = "failed"
+		p.Error = err.Error()
+	} else if len(changes) == 0 {
+		return
+	}
+	if p.Changes == nil {
+		p.Changes = []domainChange{}
+	}
+
+	// a cancelled update is still worth reporting
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), hookTimeout)
+	defer cancel()
+	for _, n := range notifications {
+		if err := n.send(ctx, p); err != nil {
+			slog.ErrorContext(ctx, "Notification failed", "url", n.URL, "error", err)
+		}
+	}
+}
+
+// notifyDrift tells every notification about domains changed outside
+// tsddns since it last applied them.
+func notifyDrift(ctx context.Context, tailnet string, notifications []Notification, drifted []domainChange) {
+	if len(notifications) == 0 || dryRun || len(drifted) == 0 {
+		return
+	}
+	p := notificationPayload{
+		Event:   "drift",
+		RunID:   runID(ctx),
+		Tailnet: tailnet,
+		Time:    time.Now().UTC(),
+		Changes: drifted,
+	}
+	p.Trigger, _ = ctx.Value(triggerKey{}).(string)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), hookTimeout)
+	defer cancel()
+	for _, n := range notifications {
+		if err := n.send(ctx, p); err != nil {
+			slog.ErrorContext(ctx, "Notification failed", "url", n.URL, "error", err)
+		}
+	}
+}
+
+func (n Notification) send(ctx context.Context, p notificationPayload) error {
+	var body []byte
+	var err error
+	switch n.Format {
+	case "slack":
+		body, err = json.Marshal(map[string]string{"text": chatMessage(p, "*", "")})
+	case "discord":
+		body, err = json.Marshal(map[string]string{"content": chatMessage(p, "**", "diff")})
+	default:
+		body, err = json.Marshal(p)
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// chatMessage renders p as a chat message, bolding with bold and showing
+// the changes in a code block of language lang.
+func chatMessage(p notificationPayload, bold, lang string) string {
+	var b strings.Builder
+	switch p.Event {
+	case "failed":
+		fmt.Fprintf(&b, "%stsddns failed to update split DNS for %s%s", bold, p.Tailnet, bold)
+	case "rolledBack":
+		fmt.Fprintf(&b, "%stsddns failed to update split DNS for %s and rolled it back%s", bold, p.Tailnet, bold)
+	case "drift":
+		fmt.Fprintf(&b, "%sSplit DNS for %s was changed outside tsddns%s", bold, p.Tailnet, bold)
+	default:
+		fmt.Fprintf(&b, "%stsddns updated split DNS for %s%s", bold, p.Tailnet, bold)
+	}
+	if p.Trigger != "" {
+		fmt.Fprintf(&b, " (%s)", p.Trigger)
+	}
+	b.WriteString("\n")
+	if p.Error != "" {
+		fmt.Fprintf(&b, "`%s`\n", strings.ReplaceAll(p.Error, "`", "'"))
+	}
+	if len(p.Changes) > 0 {
+		switch p.Event {
+		case "failed", "rolledBack":
+			b.WriteString("It was applying:\n")
+		case "drift":
+			b.WriteString("From what tsddns applied:\n")
+		}
+		shown := p.Changes[:min(len(p.Changes), maxChatDiffLines)]
+		fmt.Fprintf(&b, "```%s\n%s", lang, diffLines(shown))
+		if more := len(p.Changes) - len(shown); more > 0 {
+			fmt.Fprintf(&b, "... and %d more domains\n", more)
+		}
+		b.WriteString("```\n")
+	}
+	if p.RunID != "" {
+		fmt.Fprintf(&b, "run %s", p.RunID)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
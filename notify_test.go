@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// notificationServer records the notifications it receives.
+func notificationServer(t *testing.T) (*httptest.Server, *[]notificationPayload, *[]http.Header) {
+	var payloads []notificationPayload
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p notificationPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decoding notification: %v", err)
+		}
+		payloads = append(payloads, p)
+		headers = append(headers, r.Header)
+	}))
+	t.Cleanup(server.Close)
+	return server, &payloads, &headers
+}
+
+func TestNotifyOutcome(t *testing.T) {
+	changes := []domainChange{{Domain: "corp.example.com", Old: []string{"100.64.0.1"}, New: []string{"100.64.0.2"}}}
+	tests := []struct {
+		name      string
+		changes   []domainChange
+		err       error
+		dryRun    bool
+		wantEvent string
+	}{
+		{name: "applied", changes: changes, wantEvent: "applied"},
+		{name: "nothing changed"},
+		{name: "failed before planning", err: errors.New("listing devices: 503"), wantEvent: "failed"},
+		{name: "failed apply", changes: changes, err: errors.New("applying split DNS: 400"), wantEvent: "failed"},
+		{name: "dry run", changes: changes, err: errChangesPending, dryRun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, payloads, headers := notificationServer(t)
+			dryRun = tt.dryRun
+			defer func() { dryRun = false }()
+
+			ctx := withTrigger(withRunID(context.Background()), triggerTailnet)
+			notifications := []Notification{{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}}}
+			notifyOutcome(ctx, "example.com", notifications, tt.changes, tt.err)
+
+			if tt.wantEvent == "" {
+				if len(*payloads) != 0 {
+					t.Fatalf("sent %+v, want nothing", *payloads)
+				}
+				return
+			}
+			if len(*payloads) != 1 {
+				t.Fatalf("sent %d notifications, want 1", len(*payloads))
+			}
+			p := (*payloads)[0]
+			if p.Event != tt.wantEvent || p.Trigger != triggerTailnet || p.RunID != runID(ctx) || p.Tailnet != "example.com" || p.Time.IsZero() {
+				t.Errorf("got %+v", p)
+			}
+			if len(p.Changes) != len(tt.changes) {
+				t.Errorf("got changes %+v, want %+v", p.Changes, tt.changes)
+			}
+			if tt.err != nil && p.Error != tt.err.Error() {
+				t.Errorf("error = %q, want %q", p.Error, tt.err)
+			}
+			if got := (*headers)[0].Get("Authorization"); got != "Bearer token" {
+				t.Errorf("Authorization = %q", got)
+			}
+		})
+	}
+}
+
+func TestUpdateDNSNotifiesFailedApply(t *testing.T) {
+	notify, payloads, _ := notificationServer(t)
+	tailnet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PATCH" {
+			http.Error(w, `{"message": "invalid nameserver"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"corp.example.com": {"100.64.0.1"}})
+	}))
+	defer tailnet.Close()
+	serverURL, _ := url.Parse(tailnet.URL)
+	client := &tailscale.Client{BaseURL: serverURL, Tailnet: "test", APIKey: "test-key"}
+	cfg := &ConfigFile{
+		Domains: Config{"corp.example.com": {"100.64.0.2"}},
+		Notify:  []Notification{{URL: notify.URL}},
+	}
+
+	err := updateDNS(withTrigger(context.Background(), triggerInterval), client, cfg)
+	if err == nil {
+		t.Fatal("updateDNS() succeeded, want the PATCH to fail")
+	}
+	if len(*payloads) != 1 {
+		t.Fatalf("sent %d notifications, want 1", len(*payloads))
+	}
+	p := (*payloads)[0]
+	if p.Event != "failed" || p.Trigger != triggerInterval || !strings.Contains(p.Error, "split DNS") {
+		t.Errorf("got %+v", p)
+	}
+	if len(p.Changes) != 1 || p.Changes[0].Domain != "corp.example.com" {
+		t.Errorf("got changes %+v, want the attempted change to corp.example.com", p.Changes)
+	}
+}
+
+func TestChatMessage(t *testing.T) {
+	var many []domainChange
+	for i := 0; i < maxChatDiffLines+3; i++ {
+		many = append(many, domainChange{Domain: "d.example.com", New: []string{"100.64.0.1"}})
+	}
+	tests := []struct {
+		name string
+		p    notificationPayload
+		want string
+	}{
+		{
+			name: "applied",
+			p: notificationPayload{Event: "applied", Trigger: triggerInterval, RunID: "5f2c9a1e", Tailnet: "example.com", Changes: []domainChange{
+				{Domain: "a.example.com", New: []string{"100.64.0.1"}},
+				{Domain: "b.example.com", Old: []string{"100.64.0.2"}, New: []string{"100.64.0.3", "100.64.0.4"}},
+			}},
+			want: "**tsddns updated split DNS for example.com** (interval)\n```diff\n+ a.example.com: 100.64.0.1\n~ b.example.com: 100.64.0.2 -> 100.64.0.3, 100.64.0.4\n```\nrun 5f2c9a1e",
+		},
+		{
+			name: "failed before planning",
+			p:    notificationPayload{Event: "failed", Tailnet: "example.com", Error: "resolving `tag:dns`: no devices"},
+			want: "**tsddns failed to update split DNS for example.com**\n`resolving 'tag:dns': no devices`",
+		},
+		{
+			name: "failed apply",
+			p: notificationPayload{Event: "failed", Tailnet: "example.com", Error: "applying split DNS: 400", Changes: []domainChange{
+				{Domain: "a.example.com", Old: []string{"100.64.0.1"}},
+			}},
+			want: "**tsddns failed to update split DNS for example.com**\n`applying split DNS: 400`\nIt was applying:\n```diff\n- a.example.com: 100.64.0.1\n```",
+		},
+		{
+			name: "truncated",
+			p:    notificationPayload{Event: "applied", Tailnet: "example.com", Changes: many},
+			want: "**tsddns updated split DNS for example.com**\n```diff\n" + strings.Repeat("+ d.example.com: 100.64.0.1\n", maxChatDiffLines) + "... and 3 more domains\n```",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chatMessage(tt.p, "**", "diff"); got != tt.want {
+				t.Errorf("chatMessage() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotificationFormats(t *testing.T) {
+	tests := []struct {
+		format  string
+		wantKey string
+	}{
+		{format: "", wantKey: "event"},
+		{format: "json", wantKey: "event"},
+		{format: "slack", wantKey: "text"},
+		{format: "discord", wantKey: "content"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var body map[string]any
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&body)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+
+			n := Notification{URL: server.URL, Format: tt.format}
+			p := notificationPayload{Event: "applied", Tailnet: "example.com", Changes: []domainChange{{Domain: "a.example.com", New: []string{"100.64.0.1"}}}}
+			if err := n.send(context.Background(), p); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := body[tt.wantKey]; !ok {
+				t.Errorf("body %v has no %q", body, tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// OPNsense configures the Unbound resolver of an OPNsense firewall, kept in
+// sync through the OPNsense API.
+type OPNsense struct {
+	// URL is the firewall's web address, like "https://fw.example.com".
+	URL string `json:"url"`
+
+	// KeyEnv and SecretEnv name the environment variables holding an API
+	// key and secret. Default to OPNSENSE_API_KEY and OPNSENSE_API_SECRET.
+	KeyEnv    string `json:"keyEnv,omitempty"`
+	SecretEnv string `json:"secretEnv,omitempty"`
+
+	// CAFile is a CA bundle to verify the firewall's certificate with, for
+	// the usual self-signed one.
+	CAFile string `json:"caFile,omitempty"`
+
+	// Records are host names. They become Unbound host overrides.
+	Records RecordSet `json:"records,omitempty"`
+
+	// Forward, if set, adds a query forwarding entry for every applied
+	// split DNS domain pointing at its nameservers.
+	Forward bool `json:"forward,omitempty"`
+}
+
+func (o *OPNsense) validate() error {
+	if o.URL == "" {
+		return fmt.Errorf("needs a url")
+	}
+	if len(o.Records) == 0 && !o.Forward {
+		return fmt.Errorf("needs records or forward")
+	}
+	for name := range o.Records {
+		if !strings.Contains(normalizeDomain(name), ".") {
+			return fmt.Errorf("record %s needs a domain", name)
+		}
+	}
+	return nil
+}
+
+// opnsenseHostOverride is an Unbound host override in the OPNsense API.
+type opnsenseHostOverride struct {
+	UUID        string `json:"uuid,omitempty"`
+	Enabled     string `json:"enabled"`
+	Hostname    string `json:"hostname"`
+	Domain      string `json:"domain"`
+	RR          string `json:"rr"`
+	Server      string `json:"server"`
+	Description string `json:"description"`
+}
+
+// opnsenseForward is an Unbound query forwarding entry in the OPNsense API.
+type opnsenseForward struct {
+	UUID        string `json:"uuid,omitempty"`
+	Enabled     string `json:"enabled"`
+	Type        string `json:"type"`
+	Domain      string `json:"domain"`
+	Server      string `json:"server"`
+	Port        string `json:"port"`
+	Description string `json:"description"`
+}
+
+func (o *OPNsense) forwards() bool { return o.Forward }
+
+// sync resolves the configured records and reconciles Unbound's
+// host overrides, and its query forwarding if forwarding, with them. tsddns
+// sets the description of the entries it manages and only changes or
+// deletes those. splitDNS is nil when split DNS isn't managed.
+func (o *OPNsense) sync(ctx context.Context, r *resolver, splitDNS map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, o.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	desired, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+	c, err := newOPNsenseClient(o)
+	if err != nil {
+		return err
+	}
+
+	changed, err := c.syncHostOverrides(ctx, desired)
+	if err != nil {
+		return err
+	}
+	if o.Forward {
+		forwardsChanged, err := c.syncForwards(ctx, splitDNS)
+		if err != nil {
+			return err
+		}
+		changed = changed || forwardsChanged
+	}
+	if !changed {
+		slog.InfoContext(ctx, "OPNsense Unbound is up to date")
+		return nil
+	}
+	if err := c.call(ctx, "/api/unbound/service/reconfigure", struct{}{}, nil); err != nil {
+		return fmt.Errorf("applying changes: %w", err)
+	}
+	return nil
+}
+
+// syncHostOverrides reconciles the host overrides with desired, and reports
+// whether anything changed.
+func (c *opnsenseClient) syncHostOverrides(ctx context.Context, desired []record) (bool, error) {
+	var existing []opnsenseHostOverride
+	if err := c.search(ctx, "/api/unbound/settings/searchHostOverride", &existing); err != nil {
+		return false, fmt.Errorf("listing host overrides: %w", err)
+	}
+	var owned, foreign []record
+	for _, h := range existing {
+		if h.RR != "A" && h.RR != "AAAA" {
+			continue
+		}
+		rec := record{ID: h.UUID, Name: normalizeDomain(h.Hostname + "." + h.Domain), Type: h.RR, Value: h.Server}
+		if h.Description == recordOwner {
+			owned = append(owned, rec)
+		} else {
+			foreign = append(foreign, rec)
+		}
+	}
+
+	create, remove := planRecords(desired, owned, foreign)
+	for _, rec := range remove {
+		slog.InfoContext(ctx, "Deleting OPNsense host override", "name", rec.Name, "type", rec.Type, "value", rec.Value)
+		if err := c.call(ctx, "/api/unbound/settings/delHostOverride/"+rec.ID, struct{}{}, nil); err != nil {
+			return false, fmt.Errorf("deleting %s: %w", rec.Name, err)
+		}
+	}
+	for _, rec := range create {
+		slog.InfoContext(ctx, "Creating OPNsense host override", "name", rec.Name, "type", rec.Type, "value", rec.Value)
+		host, domain, _ := strings.Cut(rec.Name, ".")
+		add := map[string]opnsenseHostOverride{"host": {
+			Enabled:     "1",
+			Hostname:    host,
+			Domain:      domain,
+			RR:          rec.Type,
+			Server:      rec.Value,
+			Description: recordOwner,
+		}}
+		if err := c.call(ctx, "/api/unbound/settings/addHostOverride", add, nil); err != nil {
+			return false, fmt.Errorf("creating %s: %w", rec.Name, err)
+		}
+	}
+	return len(create) > 0 || len(remove) > 0, nil
+}
+
+// syncForwards reconciles the query forwarding entries with splitDNS, and
+// reports whether anything changed. Domains with forwarding entries tsddns
+// doesn't manage are left alone.
+func (c *opnsenseClient) syncForwards(ctx context.Context, splitDNS map[string][]string) (bool, error) {
+	var existing []opnsenseForward
+	if err := c.search(ctx, "/api/unbound/settings/searchForward", &existing); err != nil {
+		return false, fmt.Errorf("listing query forwarding: %w", err)
+	}
+	var owned []record
+	taken := make(map[string]bool)
+	for _, f := range existing {
+		if f.Type != "" && f.Type != "forward" {
+			continue
+		}
+		rec := record{ID: f.UUID, Name: normalizeDomain(f.Domain), Type: "forward", Value: f.Server}
+		if f.Description == recordOwner {
+			owned = append(owned, rec)
+		} else {
+			taken[rec.Name] = true
+		}
+	}
+	var desired []record
+	domains, addrs := exportDomains(splitDNS)
+	for _, domain := range domains {
+		name := normalizeDomain(domain)
+		if taken[name] {
+			slog.WarnContext(ctx, "Domain has OPNsense query forwarding tsddns doesn't manage, leaving it untouched", "domain", name)
+			continue
+		}
+		for _, addr := range addrs[domain] {
+			desired = append(desired, record{Name: name, Type: "forward", Value: addr})
+		}
+	}
+
+	create, remove := planRecords(desired, owned, nil)
+	for _, rec := range remove {
+		if taken[rec.Name] {
+			continue
+		}
+		slog.InfoContext(ctx, "Deleting OPNsense query forwarding", "domain", rec.Name, "server", rec.Value)
+		if err := c.call(ctx, "/api/unbound/settings/delForward/"+rec.ID, struct{}{}, nil); err != nil {
+			return false, fmt.Errorf("deleting %s: %w", rec.Name, err)
+		}
+	}
+	for _, rec := range create {
+		slog.InfoContext(ctx, "Creating OPNsense query forwarding", "domain", rec.Name, "server", rec.Value)
+		add := map[string]opnsenseForward{"dot": {
+			Enabled:     "1",
+			Type:        "forward",
+			Domain:      rec.Name,
+			Server:      rec.Value,
+			Port:        "53",
+			Description: recordOwner,
+		}}
+		if err := c.call(ctx, "/api/unbound/settings/addForward", add, nil); err != nil {
+			return false, fmt.Errorf("creating %s: %w", rec.Name, err)
+		}
+	}
+	return len(create) > 0 || len(remove) > 0, nil
+}
+
+// opnsenseClient talks to the OPNsense API.
+type opnsenseClient struct {
+	url    string
+	key    string
+	secret string
+	http   *http.Client
+}
+
+func newOPNsenseClient(o *OPNsense) (*opnsenseClient, error) {
+	c := &opnsenseClient{
+		url:    strings.TrimSuffix(o.URL, "/"),
+		key:    os.Getenv(cmp.Or(o.KeyEnv, "OPNSENSE_API_KEY")),
+		secret: os.Getenv(cmp.Or(o.SecretEnv, "OPNSENSE_API_SECRET")),
+	}
+	var err error
+	c.http, err = httpClientWithCA(o.CAFile)
+	return c, err
+}
+
+// search returns every row of a search endpoint.
+func (c *opnsenseClient) search(ctx context.Context, path string, rows any) error {
+	var resp struct {
+		Rows json.RawMessage `json:"rows"`
+	}
+	if err := c.call(ctx, path, map[string]any{"current": 1, "rowCount": -1, "searchPhrase": ""}, &resp); err != nil {
+		return err
+	}
+	if len(resp.Rows) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Rows, rows)
+}
+
+// call POSTs in to an API endpoint and decodes the response into out.
+// Changes the firewall rejects come back as a failed result.
+func (c *opnsenseClient) call(ctx context.Context, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.key, c.secret)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+	var data json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	var result struct {
+		Result      string         `json:"result"`
+		Validations map[string]any `json:"validations"`
+	}
+	json.Unmarshal(data, &result)
+	if result.Result == "failed" {
+		return fmt.Errorf("API rejected the change: %v", result.Validations)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
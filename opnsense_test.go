@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSyncOPNsense(t *testing.T) {
+	hosts := []opnsenseHostOverride{
+		{UUID: "h1", Hostname: "ns", Domain: "internal.example.com", RR: "A", Server: "100.64.0.1", Description: recordOwner},
+		{UUID: "h2", Hostname: "old", Domain: "internal.example.com", RR: "A", Server: "100.64.0.2", Description: recordOwner},
+		{UUID: "h3", Hostname: "nas", Domain: "internal.example.com", RR: "A", Server: "192.168.1.10", Description: "NAS"},
+		{UUID: "h4", Hostname: "mail", Domain: "internal.example.com", RR: "MX", Server: "", Description: ""},
+	}
+	forwards := []opnsenseForward{
+		{UUID: "f1", Type: "forward", Domain: "corp.example.com", Server: "100.64.0.9", Port: "53", Description: recordOwner},
+		{UUID: "f2", Type: "forward", Domain: "lab.example.com", Server: "10.0.0.53", Port: "53", Description: "lab"},
+	}
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, secret, ok := r.BasicAuth(); !ok || key != "key" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/api/unbound/")
+		switch {
+		case path == "settings/searchHostOverride":
+			json.NewEncoder(w).Encode(map[string]any{"rows": hosts})
+		case path == "settings/searchForward":
+			json.NewEncoder(w).Encode(map[string]any{"rows": forwards})
+		case strings.HasPrefix(path, "settings/add"):
+			var body map[string]map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			for _, entry := range body {
+				if entry["description"] != recordOwner {
+					t.Errorf("%s without the tsddns description", path)
+				}
+				if entry["server"] == "100.64.0.66" {
+					w.Write([]byte(`{"result": "failed", "validations": {"host.server": "bad"}}`))
+					return
+				}
+				path += " " + entry["hostname"] + " " + entry["domain"] + " " + entry["server"]
+			}
+			calls = append(calls, path)
+			w.Write([]byte(`{"result": "saved"}`))
+		default:
+			calls = append(calls, path)
+			w.Write([]byte(`{"result": "deleted", "status": "ok"}`))
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("OPNSENSE_API_KEY", "key")
+	t.Setenv("OPNSENSE_API_SECRET", "secret")
+
+	o := &OPNsense{
+		URL:     srv.URL,
+		Forward: true,
+		Records: Config{
+			"ns.internal.example.com":  {"100.64.0.1"},
+			"new.internal.example.com": {"100.64.0.5"},
+			"nas.internal.example.com": {"100.64.0.6"},
+		},
+	}
+	splitDNS := map[string][]string{
+		"corp.example.com": {"100.64.0.10"},
+		"lab.example.com":  {"100.64.0.11"},
+	}
+	if err := o.sync(context.Background(), newResolver(nil, Defaults{}), splitDNS); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+	want := []string{
+		"settings/delHostOverride/h2",
+		"settings/addHostOverride new internal.example.com 100.64.0.5",
+		"settings/delForward/f1",
+		"settings/addForward  corp.example.com 100.64.0.10",
+		"service/reconfigure",
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	o.Records = Config{"bad.internal.example.com": {"100.64.0.66"}}
+	if err := o.sync(context.Background(), newResolver(nil, Defaults{}), nil); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("sync() error = %v, want the rejected change", err)
+	}
+}
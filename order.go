@@ -0,0 +1,50 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// orderNameservers reorders each domain's nameservers in splitDNS according
+// to order, so query load spreads across resolvers:
+//
+//   - "rotate" moves the list one step on from the order currently applied
+//     in the tailnet, so every apply puts a different nameserver first.
+//   - "shuffle" shuffles the list using a seed derived from now and the
+//     domain, so the order is fixed for the whole run. Nameservers with a
+//     higher weight are more likely to come first.
+//
+// Any other order leaves the lists as resolved.
+func orderNameservers(order string, splitDNS, current map[string][]string, weights map[string]map[string]int, now time.Time) {
+	for domain, nameservers := range splitDNS {
+		if len(nameservers) < 2 {
+			continue
+		}
+		switch order {
+		case "rotate":
+			splitDNS[domain] = rotateFrom(nameservers, current[domain])
+		case "shuffle":
+			h := fnv.New64a()
+			h.Write([]byte(domain))
+			rng := rand.New(rand.NewPCG(uint64(now.UnixNano()), h.Sum64()))
+			splitDNS[domain] = weightedShuffle(nameservers, weights[domain], rng)
+		}
+	}
+}
+
+// rotateFrom returns nameservers rotated to start just after the entry
+// that current starts with. If current doesn't start with one of them, the
+// list is returned unchanged.
+func rotateFrom(nameservers, current []string) []string {
+	if len(current) == 0 {
+		return nameservers
+	}
+	i := slices.Index(nameservers, current[0])
+	if i < 0 {
+		return nameservers
+	}
+	i = (i + 1) % len(nameservers)
+	return append(slices.Clone(nameservers[i:]), nameservers[:i]...)
+}
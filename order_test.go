@@ -0,0 +1,63 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOrderNameservers(t *testing.T) {
+	nameservers := []string{"100.64.0.1", "100.64.0.2", "100.64.0.3"}
+
+	tests := []struct {
+		name    string
+		order   string
+		current []string
+		want    []string
+	}{
+		{name: "no order", current: []string{"100.64.0.2"}, want: nameservers},
+		{name: "rotate from first", order: "rotate", current: []string{"100.64.0.1", "100.64.0.2", "100.64.0.3"}, want: []string{"100.64.0.2", "100.64.0.3", "100.64.0.1"}},
+		{name: "rotate wraps around", order: "rotate", current: []string{"100.64.0.3", "100.64.0.1", "100.64.0.2"}, want: nameservers},
+		{name: "rotate new domain", order: "rotate", want: nameservers},
+		{name: "rotate after change", order: "rotate", current: []string{"10.0.0.1"}, want: nameservers},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			splitDNS := map[string][]string{"example.com": slices.Clone(nameservers)}
+			orderNameservers(tt.order, splitDNS, map[string][]string{"example.com": tt.current}, nil, time.Now())
+			if got := splitDNS["example.com"]; !slices.Equal(got, tt.want) {
+				t.Errorf("example.com = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderNameserversShuffle(t *testing.T) {
+	nameservers := []string{"100.64.0.1", "100.64.0.2", "100.64.0.3", "100.64.0.4", "100.64.0.5"}
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	shuffle := func(now time.Time) []string {
+		splitDNS := map[string][]string{"example.com": slices.Clone(nameservers)}
+		orderNameservers("shuffle", splitDNS, nil, nil, now)
+		return splitDNS["example.com"]
+	}
+
+	first := shuffle(now)
+	if !slices.Equal(shuffle(now), first) {
+		t.Error("shuffle isn't deterministic within a run")
+	}
+	if sorted := slices.Sorted(slices.Values(first)); !slices.Equal(sorted, nameservers) {
+		t.Errorf("shuffle changed the nameservers: %v", first)
+	}
+
+	changed := false
+	for i := range 10 {
+		if !slices.Equal(shuffle(now.Add(time.Duration(i+1)*time.Minute)), first) {
+			changed = true
+		}
+	}
+	if !changed {
+		t.Error("shuffle gave the same order on every run")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+)
+
+// stateFile, when set, records which split DNS domains tsddns owns. With
+// it, domains tsddns doesn't own are left untouched instead of being
+// replaced or deleted.
+var stateFile string
+
+// takeOwnership lets tsddns claim configured domains that already exist in
+// the tailnet but that it doesn't own yet.
+var takeOwnership bool
+
+// ownershipState is the content of the state file.
+type ownershipState struct {
+	Domains []string `json:"domains"`
+
+	// Records are the record names tsddns wrote, by backend, for
+	// backends that can't mark records as its own.
+	Records map[string][]string `json:"records,omitempty"`
+
+	// Applied is what the last successful update applied.
+	Applied *appliedState `json:"applied,omitempty"`
+}
+
+// loadState reads the state file. A missing file is an empty state.
+func loadState(path string) (ownershipState, error) {
+	var state ownershipState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("reading state file: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("parsing state file: %w", err)
+	}
+	return state, nil
+}
+
+func saveState(path string, state ownershipState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0o644)
+}
+
+// loadOwnedDomains reads the domains tsddns owns from the state file. A
+// missing file means it owns nothing yet.
+func loadOwnedDomains(path string) (map[string]bool, error) {
+	state, err := loadState(path)
+	if err != nil {
+		return nil, err
+	}
+	owned := make(map[string]bool)
+	for _, domain := range state.Domains {
+		owned[normalizeDomain(domain)] = true
+	}
+	return owned, nil
+}
+
+// saveOwnedDomains writes the domains tsddns owns to the state file.
+func saveOwnedDomains(path string, domains []string) error {
+	state, err := loadState(path)
+	if err != nil {
+		return err
+	}
+	sort.Strings(domains)
+	state.Domains = domains
+	return saveState(path, state)
+}
+
+// loadOwnedRecords returns the record names tsddns wrote to backend, from
+// the state file if there is one.
+func loadOwnedRecords(backend string) (map[string]bool, error) {
+	owned := make(map[string]bool)
+	if stateFile == "" {
+		return owned, nil
+	}
+	state, err := loadState(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range state.Records[backend] {
+		owned[normalizeDomain(name)] = true
+	}
+	return owned, nil
+}
+
+// saveOwnedRecords records the names tsddns wrote to backend in the state
+// file, if there is one.
+func saveOwnedRecords(backend string, names []string) error {
+	if stateFile == "" {
+		return nil
+	}
+	state, err := loadState(stateFile)
+	if err != nil {
+		return err
+	}
+	if state.Records == nil {
+		state.Records = make(map[string][]string)
+	}
+	sort.Strings(names)
+	state.Records[backend] = names
+	if len(names) == 0 {
+		delete(state.Records, backend)
+	}
+	return saveState(stateFile, state)
+}
+
+// mergeUnowned limits desired to the domains tsddns owns, so that applying
+// it leaves everyone else's domains alone:
+//
+//   - domains in current that tsddns doesn't own are copied into desired
+//     unchanged, taking precedence over the config unless claim is set
+//   - owned domains missing from desired are deleted
+//
+// It returns the domains tsddns owns once desired is applied.
+func mergeUnowned(desired, current map[string][]string, owned map[string]bool, claim bool) []string {
+	unowned := make(map[string]bool)
+	for domain, nameservers := range current {
+		if owned[normalizeDomain(domain)] {
+			continue
+		}
+		if _, wanted := desired[domain]; wanted {
+			if claim {
+				slog.Info("Taking ownership of domain", "domain", domain)
+				continue
+			}
+			slog.Warn("Domain already exists and isn't managed by tsddns, leaving it untouched (use -take-ownership to manage it)", "domain", domain)
+		}
+		desired[domain] = nameservers
+		unowned[domain] = true
+	}
+
+	var nowOwned []string
+	for domain := range desired {
+		if !unowned[domain] {
+			nowOwned = append(nowOwned, domain)
+		}
+	}
+	return nowOwned
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestMergeUnowned(t *testing.T) {
+	current := map[string][]string{
+		"ours.example.com":    {"100.64.0.1"},
+		"removed.example.com": {"100.64.0.2"},
+		"theirs.example.com":  {"10.0.0.1"},
+		"shared.example.com":  {"10.0.0.2"},
+	}
+	owned := map[string]bool{"ours.example.com": true, "removed.example.com": true}
+
+	tests := []struct {
+		name      string
+		claim     bool
+		want      map[string][]string
+		wantOwned []string
+	}{
+		{
+			name: "leave unowned alone",
+			want: map[string][]string{
+				"ours.example.com":   {"100.64.0.9"},
+				"new.example.com":    {"100.64.0.3"},
+				"theirs.example.com": {"10.0.0.1"},
+				"shared.example.com": {"10.0.0.2"},
+			},
+			wantOwned: []string{"new.example.com", "ours.example.com"},
+		},
+		{
+			name:  "take ownership",
+			claim: true,
+			want: map[string][]string{
+				"ours.example.com":   {"100.64.0.9"},
+				"new.example.com":    {"100.64.0.3"},
+				"theirs.example.com": {"10.0.0.1"},
+				"shared.example.com": {"100.64.0.4"},
+			},
+			wantOwned: []string{"new.example.com", "ours.example.com", "shared.example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desired := map[string][]string{
+				"ours.example.com":   {"100.64.0.9"},
+				"new.example.com":    {"100.64.0.3"},
+				"shared.example.com": {"100.64.0.4"},
+			}
+			got := mergeUnowned(desired, current, owned, tt.claim)
+			sort.Strings(got)
+			if !slices.Equal(got, tt.wantOwned) {
+				t.Errorf("mergeUnowned() owned = %v, want %v", got, tt.wantOwned)
+			}
+			if !maps.EqualFunc(desired, tt.want, slices.Equal) {
+				t.Errorf("mergeUnowned() desired = %v, want %v", desired, tt.want)
+			}
+		})
+	}
+}
+
+func TestOwnedDomainsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	owned, err := loadOwnedDomains(path)
+	if err != nil || len(owned) != 0 {
+		t.Fatalf("loadOwnedDomains() on a missing file = %v, %v, want nothing", owned, err)
+	}
+
+	if err := saveOwnedDomains(path, []string{"b.example.com", "A.example.com."}); err != nil {
+		t.Fatalf("saveOwnedDomains() unexpected error: %v", err)
+	}
+	owned, err = loadOwnedDomains(path)
+	if err != nil {
+		t.Fatalf("loadOwnedDomains() unexpected error: %v", err)
+	}
+	if want := map[string]bool{"a.example.com": true, "b.example.com": true}; !maps.Equal(owned, want) {
+		t.Errorf("loadOwnedDomains() = %v, want %v", owned, want)
+	}
+}
+
+func TestOwnedRecordsFile(t *testing.T) {
+	stateFile = filepath.Join(t.TempDir(), "state.json")
+	defer func() { stateFile = "" }()
+
+	if err := saveOwnedDomains(stateFile, []string{"example.com"}); err != nil {
+		t.Fatalf("saveOwnedDomains() unexpected error: %v", err)
+	}
+	if err := saveOwnedRecords("nextdns", []string{"foo.example.com"}); err != nil {
+		t.Fatalf("saveOwnedRecords() unexpected error: %v", err)
+	}
+	// saving the domains again keeps the records, and the other way round
+	if err := saveOwnedDomains(stateFile, []string{"example.com", "example.org"}); err != nil {
+		t.Fatalf("saveOwnedDomains() unexpected error: %v", err)
+	}
+
+	domains, err := loadOwnedDomains(stateFile)
+	if err != nil || len(domains) != 2 {
+		t.Errorf("loadOwnedDomains() = %v, %v, want two domains", domains, err)
+	}
+	records, err := loadOwnedRecords("nextdns")
+	if err != nil || !maps.Equal(records, map[string]bool{"foo.example.com": true}) {
+		t.Errorf("loadOwnedRecords() = %v, %v, want foo.example.com", records, err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// PiHole configures a Pi-hole (v6 or later) kept in sync through its API.
+// tsddns writes its entries as a marked block of the Pi-hole's extra
+// dnsmasq lines and never touches lines outside of it.
+type PiHole struct {
+	// URL is the Pi-hole's web address, like "http://pi.hole".
+	URL string `json:"url"`
+
+	// PasswordEnv names the environment variable holding the Pi-hole's
+	// password or an app password. Defaults to PIHOLE_PASSWORD; if it's
+	// empty, no login is attempted.
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+
+	// Records are served as local DNS records.
+	Records RecordSet `json:"records,omitempty"`
+
+	// Forward, if set, forwards every applied split DNS domain to its
+	// nameservers, like conditional forwarding.
+	Forward bool `json:"forward,omitempty"`
+}
+
+func (p *PiHole) validate() error {
+	if p.URL == "" {
+		return fmt.Errorf("needs a url")
+	}
+	if len(p.Records) == 0 && !p.Forward {
+		return fmt.Errorf("needs records or forward")
+	}
+	return nil
+}
+
+// piHoleLines returns tsddns's block of dnsmasq lines: a host-record per
+// address and, with forwarding, a server line per split DNS nameserver.
+func piHoleLines(records []record, splitDNS map[string][]string) []string {
+	var lines []string
+	for _, rec := range records {
+		lines = append(lines, fmt.Sprintf("host-record=%s,%s", rec.Name, rec.Value))
+	}
+	domains, addrs := exportDomains(splitDNS)
+	for _, domain := range domains {
+		for _, addr := range addrs[domain] {
+			lines = append(lines, fmt.Sprintf("server=/%s/%s", domain, addr))
+		}
+	}
+	return managedBlock(lines)
+}
+
+func (p *PiHole) forwards() bool { return p.Forward }
+
+// sync resolves the configured records and writes them, with the
+// split DNS domains if forwarding, to the Pi-hole. splitDNS is nil when
+// split DNS isn't managed.
+func (p *PiHole) sync(ctx context.Context, r *resolver, splitDNS map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, p.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	records, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+	if !p.Forward {
+		splitDNS = nil
+	}
+
+	c := &piHoleClient{url: strings.TrimSuffix(p.URL, "/")}
+	if password := os.Getenv(cmp.Or(p.PasswordEnv, "PIHOLE_PASSWORD")); password != "" {
+		if err := c.login(ctx, password); err != nil {
+			return fmt.Errorf("logging in: %w", err)
+		}
+		defer c.logout(context.WithoutCancel(ctx))
+	}
+
+	var current struct {
+		Config struct {
+			Misc struct {
+				DnsmasqLines []string `json:"dnsmasq_lines"`
+			} `json:"misc"`
+		} `json:"config"`
+	}
+	if err := c.do(ctx, "GET", "/api/config/misc/dnsmasq_lines", nil, &current); err != nil {
+		return fmt.Errorf("reading dnsmasq lines: %w", err)
+	}
+	lines := replaceManagedBlock(current.Config.Misc.DnsmasqLines, piHoleLines(records, splitDNS))
+	if slices.Equal(lines, current.Config.Misc.DnsmasqLines) {
+		slog.InfoContext(ctx, "Pi-hole is up to date")
+		return nil
+	}
+
+	slog.InfoContext(ctx, "Updating Pi-hole", "records", len(records), "domains", len(splitDNS))
+	update := map[string]any{"config": map[string]any{"misc": map[string]any{"dnsmasq_lines": lines}}}
+	if err := c.do(ctx, "PATCH", "/api/config", update, nil); err != nil {
+		return fmt.Errorf("writing dnsmasq lines: %w", err)
+	}
+	return nil
+}
+
+// piHoleClient talks to the Pi-hole API, with a session once logged in.
+type piHoleClient struct {
+	url string
+	sid string
+}
+
+func (c *piHoleClient) login(ctx context.Context, password string) error {
+	var resp struct {
+		Session struct {
+			Valid   bool   `json:"valid"`
+			SID     string `json:"sid"`
+			Message string `json:"message"`
+		} `json:"session"`
+	}
+	if err := c.do(ctx, "POST", "/api/auth", map[string]string{"password": password}, &resp); err != nil {
+		return err
+	}
+	if !resp.Session.Valid {
+		return fmt.Errorf("login rejected: %s", resp.Session.Message)
+	}
+	c.sid = resp.Session.SID
+	return nil
+}
+
+// logout ends the session, since the Pi-hole only allows a few at once.
+func (c *piHoleClient) logout(ctx context.Context) {
+	if err := c.do(ctx, "DELETE", "/api/auth", nil, nil); err != nil {
+		slog.WarnContext(ctx, "Logging out of Pi-hole failed", "error", err)
+	}
+}
+
+func (c *piHoleClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.sid != "" {
+		req.Header.Set("X-FTL-SID", c.sid)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+				Hint    string `json:"hint"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		msg := apiErr.Error.Message
+		if apiErr.Error.Hint != "" {
+			msg += ": " + apiErr.Error.Hint
+		}
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
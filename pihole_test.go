@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestSyncPiHole(t *testing.T) {
+	lines := []string{"local-ttl=60"}
+	var patched []string
+	loggedOut := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/auth" && r.Method == "POST" {
+			var login struct{ Password string }
+			json.NewDecoder(r.Body).Decode(&login)
+			json.NewEncoder(w).Encode(map[string]any{"session": map[string]any{"valid": login.Password == "hunter2", "sid": "s1"}})
+			return
+		}
+		if got := r.Header.Get("X-FTL-SID"); got != "s1" {
+			t.Errorf("%s %s without session (X-FTL-SID %q)", r.Method, r.URL.Path, got)
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/config/misc/dnsmasq_lines":
+			json.NewEncoder(w).Encode(map[string]any{"config": map[string]any{"misc": map[string]any{"dnsmasq_lines": lines}}})
+		case "PATCH /api/config":
+			var update struct {
+				Config struct {
+					Misc struct {
+						DnsmasqLines []string `json:"dnsmasq_lines"`
+					} `json:"misc"`
+				} `json:"config"`
+			}
+			json.NewDecoder(r.Body).Decode(&update)
+			patched = update.Config.Misc.DnsmasqLines
+			w.Write([]byte(`{}`))
+		case "DELETE /api/auth":
+			loggedOut = true
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("PIHOLE_PASSWORD", "hunter2")
+
+	p := &PiHole{
+		URL:     srv.URL,
+		Records: Config{"ns.internal.example.com": {"100.64.0.1"}},
+		Forward: true,
+	}
+	splitDNS := map[string][]string{"corp.example.com": {"100.64.0.53"}}
+	if err := p.sync(context.Background(), newResolver(nil, Defaults{}), splitDNS); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+	want := []string{
+		"local-ttl=60",
+		managedBlockStart,
+		"host-record=ns.internal.example.com,100.64.0.1",
+		"server=/corp.example.com/100.64.0.53",
+		managedBlockEnd,
+	}
+	if !slices.Equal(patched, want) {
+		t.Errorf("dnsmasq lines = %q, want %q", patched, want)
+	}
+	if !loggedOut {
+		t.Error("session was not ended")
+	}
+
+	// an unchanged block is not written again
+	lines, patched = want, nil
+	if err := p.sync(context.Background(), newResolver(nil, Defaults{}), splitDNS); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+	if patched != nil {
+		t.Errorf("unchanged block was written: %q", patched)
+	}
+
+	t.Setenv("PIHOLE_PASSWORD", "wrong")
+	if err := p.sync(context.Background(), newResolver(nil, Defaults{}), splitDNS); err == nil {
+		t.Error("sync() succeeded with a rejected login")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// pingURL is a dead man's switch, such as a healthchecks.io check, pinged
+// after every update. Set by -ping-url.
+var pingURL string
+
+// validatePingURL checks that raw is an http or https URL.
+func validatePingURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q isn't an http or https URL", raw)
+	}
+	return nil
+}
+
+// pingOutcome pings pingURL after an update: the URL itself if the update
+// succeeded, or its /fail variant with the error as the body if it failed.
+// The monitor alerts when updates fail and when pings stop, for instance
+// because tsddns is down or paused. Dry runs don't ping, and a failed ping
+// is only logged.
+func pingOutcome(ctx context.Context, err error) {
+	if pingURL == "" || dryRun {
+		return
+	}
+	u, _ := url.Parse(pingURL)
+	var body io.Reader
+	if err != nil {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/fail"
+		body = strings.NewReader(err.Error())
+	}
+
+	// a cancelled update is still worth reporting
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), hookTimeout)
+	defer cancel()
+	if err := ping(ctx, u.String(), body); err != nil {
+		slog.WarnContext(ctx, "Ping failed", "error", err)
+	}
+}
+
+func ping(ctx context.Context, url string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("ping returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPingOutcome(t *testing.T) {
+	type pinged struct{ path, body string }
+	var pings []pinged
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		pings = append(pings, pinged{r.URL.Path, string(body)})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		err      error
+		dryRun   bool
+		wantPing *pinged
+	}{
+		{name: "success", url: server.URL + "/ping/abc", wantPing: &pinged{path: "/ping/abc"}},
+		{name: "failure", url: server.URL + "/ping/abc", err: errors.New("listing devices: API returned status 500"),
+			wantPing: &pinged{path: "/ping/abc/fail", body: "listing devices: API returned status 500"}},
+		{name: "trailing slash", url: server.URL + "/ping/abc/", err: errors.New("boom"), wantPing: &pinged{path: "/ping/abc/fail", body: "boom"}},
+		{name: "dry run", url: server.URL + "/ping/abc", dryRun: true},
+		{name: "no URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(url string, dry bool) { pingURL, dryRun = url, dry }(pingURL, dryRun)
+			pingURL, dryRun = tt.url, tt.dryRun
+			pings = nil
+
+			pingOutcome(context.Background(), tt.err)
+			if tt.wantPing == nil {
+				if len(pings) != 0 {
+					t.Errorf("pinged %+v, want no ping", pings)
+				}
+				return
+			}
+			if len(pings) != 1 || pings[0] != *tt.wantPing {
+				t.Errorf("pinged %+v, want %+v", pings, *tt.wantPing)
+			}
+		})
+	}
+}
+
+func TestValidatePingURL(t *testing.T) {
+	for url, wantErr := range map[string]bool{
+		"https://hc-ping.com/5f0e2c4a-1b2c-4d5e-8f90-123456789abc": false,
+		"http://healthchecks.internal:8000/ping/abc":               false,
+		"hc-ping.com/abc":        true,
+		"ftp://example.com/ping": true,
+	} {
+		if err := validatePingURL(url); (err != nil) != wantErr {
+			t.Errorf("validatePingURL(%q) error = %v, wantErr %v", url, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// postureOptions are the options, and Defaults.Posture keys, that a device
+// must satisfy to be picked.
+var postureOptions = []string{"os", "minversion", "uptodate", "attr"}
+
+// posture is what a device must satisfy to be eligible for an entry.
+type posture struct {
+	os         string            // device OS, e.g. "linux"
+	minVersion string            // oldest acceptable Tailscale version
+	minParts   []int             // minVersion, parsed
+	upToDate   bool              // no Tailscale update available
+	attrs      map[string]string // posture attributes, e.g. custom:tier=prod
+}
+
+func (p posture) empty() bool {
+	return p.os == "" && p.minVersion == "" && !p.upToDate && len(p.attrs) == 0
+}
+
+// parsePosture reads posture requirements from options. attr values are
+// key=value, and may be comma-separated or repeated.
+func parsePosture(get func(string) []string) (posture, error) {
+	var p posture
+	if v := get("os"); len(v) > 0 {
+		p.os = v[0]
+	}
+	if v := get("minversion"); len(v) > 0 {
+		parts, err := parseVersion(v[0])
+		if err != nil {
+			return posture{}, err
+		}
+		p.minVersion, p.minParts = v[0], parts
+	}
+	if v := get("uptodate"); len(v) > 0 && v[0] != "" {
+		b, err := strconv.ParseBool(v[0])
+		if err != nil {
+			return posture{}, fmt.Errorf("bad uptodate option %q: %w", v[0], err)
+		}
+		p.upToDate = b
+	}
+	for _, v := range get("attr") {
+		for _, attr := range strings.Split(v, ",") {
+			key, value, ok := strings.Cut(attr, "=")
+			if !ok || key == "" {
+				return posture{}, fmt.Errorf("bad attr option %q, want key=value", attr)
+			}
+			if p.attrs == nil {
+				p.attrs = make(map[string]string)
+			}
+			p.attrs[key] = value
+		}
+	}
+	return p, nil
+}
+
+// posture returns the default posture requirements.
+func (d Defaults) posture() (posture, error) {
+	return parsePosture(func(key string) []string {
+		if v, ok := d.Posture[key]; ok {
+			return []string{v}
+		}
+		return nil
+	})
+}
+
+// posture returns the entry's posture requirements. Each option the entry
+// sets replaces the configured default for that option.
+func (r *resolver) posture(e entry) (posture, error) {
+	return parsePosture(func(key string) []string {
+		if v, ok := e.options[key]; ok {
+			return v
+		}
+		if v, ok := r.defaults.Posture[key]; ok {
+			return []string{v}
+		}
+		return nil
+	})
+}
+
+// checkPosture returns why the device doesn't satisfy p, or "" if it does.
+func (r *resolver) checkPosture(ctx context.Context, device tailscale.Device, p posture) (string, error) {
+	if p.os != "" && !strings.EqualFold(device.OS, p.os) {
+		return fmt.Sprintf("runs %s, not %s", device.OS, p.os), nil
+	}
+	if p.minVersion != "" {
+		have, err := parseVersion(device.ClientVersion)
+		if err != nil || compareVersions(have, p.minParts) < 0 {
+			return fmt.Sprintf("runs Tailscale %s, older than %s", device.ClientVersion, p.minVersion), nil
+		}
+	}
+	if p.upToDate && device.UpdateAvailable {
+		return "has a Tailscale update available", nil
+	}
+	if len(p.attrs) > 0 {
+		attrs, err := r.deviceAttributes(ctx, device)
+		if err != nil {
+			return "", err
+		}
+		for key, want := range p.attrs {
+			if have, ok := attrs[key]; !ok || fmt.Sprint(have) != want {
+				return fmt.Sprintf("doesn't have posture attribute %s=%s", key, want), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// deviceAttributes returns the device's posture attributes, fetching them
+// at most once per update.
+func (r *resolver) deviceAttributes(ctx context.Context, device tailscale.Device) (map[string]any, error) {
+	r.mu.Lock()
+	attrs, ok := r.attributes[device.ID]
+	r.mu.Unlock()
+	if ok {
+		return attrs, nil
+	}
+
+	resp, err := r.client.Devices().GetPostureAttributes(ctx, device.ID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching posture attributes of %s: %w", device.Name, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.attributes == nil {
+		r.attributes = make(map[string]map[string]any)
+	}
+	r.attributes[device.ID] = resp.Attributes
+	return resp.Attributes, nil
+}
+
+// parseVersion parses the numeric part of a Tailscale version such as
+// "1.62.0-t1234abcd-g5678ef90".
+func parseVersion(v string) ([]int, error) {
+	v, _, _ = strings.Cut(v, "-")
+	var parts []int
+	for _, s := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad version %q", v)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// compareVersions compares versions from parseVersion, treating missing
+// trailing parts as zero.
+func compareVersions(a, b []int) int {
+	for i := range max(len(a), len(b)) {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if c := cmp.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
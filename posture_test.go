@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestResolveDevicePosture(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/device/1000/attributes":
+			w.Write([]byte(`{"attributes": {"custom:tier": "prod", "custom:patched": true}}`))
+		case "/api/v2/device/2000/attributes":
+			w.Write([]byte(`{"attributes": {"custom:tier": "dev"}}`))
+		case "/api/v2/device/3000/attributes":
+			w.Write([]byte(`{"attributes": {}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	client := &tailscale.Client{BaseURL: baseURL, APIKey: "test-key", Tailnet: "example.com"}
+
+	devices := []tailscale.Device{
+		{Name: "ns1.example.ts.net", ID: "1000", Tags: []string{"tag:dns"}, OS: "linux", ClientVersion: "1.62.0-t1234abcd-g5678ef90", Addresses: []string{"100.64.0.1"}},
+		{Name: "ns2.example.ts.net", ID: "2000", Tags: []string{"tag:dns"}, OS: "linux", ClientVersion: "1.58.2", UpdateAvailable: true, Addresses: []string{"100.64.0.2"}},
+		{Name: "ns3.example.ts.net", ID: "3000", Tags: []string{"tag:dns"}, OS: "windows", ClientVersion: "1.64.0", Addresses: []string{"100.64.0.3"}},
+	}
+
+	tests := []struct {
+		name     string
+		defaults Defaults
+		entry    string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "no requirements", entry: "tag:dns", want: []string{"100.64.0.1", "100.64.0.2", "100.64.0.3"}},
+		{name: "os", entry: "tag:dns?os=linux", want: []string{"100.64.0.1", "100.64.0.2"}},
+		{name: "min version", entry: "tag:dns?minversion=1.60", want: []string{"100.64.0.1", "100.64.0.3"}},
+		{name: "up to date", entry: "tag:dns?uptodate=true", want: []string{"100.64.0.1", "100.64.0.3"}},
+		{name: "attribute", entry: "tag:dns?attr=custom:tier=prod", want: []string{"100.64.0.1"}},
+		{name: "bool attribute", entry: "tag:dns?attr=custom:patched=true", want: []string{"100.64.0.1"}},
+		{name: "default posture", defaults: Defaults{Posture: map[string]string{"os": "linux", "uptodate": "true"}}, entry: "tag:dns", want: []string{"100.64.0.1"}},
+		{name: "entry overrides default", defaults: Defaults{Posture: map[string]string{"os": "linux"}}, entry: "tag:dns?os=windows", want: []string{"100.64.0.3"}},
+		{name: "nothing eligible", entry: "device:ns2?uptodate=true", wantErr: true},
+		{name: "bad version", entry: "tag:dns?minversion=latest", wantErr: true},
+		{name: "bad attr", entry: "tag:dns?attr=custom:tier", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolver{client: client, defaults: tt.defaults, devices: devices, now: time.Now()}
+			ips, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(ips, tt.want) {
+				t.Errorf("resolve() = %v, want %v", ips, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.62.0", "1.60", 1},
+		{"1.60", "1.60.0", 0},
+		{"1.58.2-t1234abcd", "1.60.0", -1},
+		{"1.100.0", "1.99.9", 1},
+	}
+	for _, tt := range tests {
+		a, _ := parseVersion(tt.a)
+		b, _ := parseVersion(tt.b)
+		if got := compareVersions(a, b); got != tt.want {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
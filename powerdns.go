@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PowerDNS configures A and AAAA records kept in PowerDNS Authoritative
+// zones through its HTTP API. Each record goes in the server's zone with
+// the longest name it's in.
+type PowerDNS struct {
+	// URL is the API's address, like "http://pdns:8081".
+	URL string `json:"url"`
+
+	// ServerID is the server to manage. Defaults to "localhost".
+	ServerID string `json:"serverId,omitempty"`
+
+	// APIKeyEnv names the environment variable holding the API key.
+	// Defaults to PDNS_API_KEY.
+	APIKeyEnv string `json:"apiKeyEnv,omitempty"`
+
+	// Zones are the zones tsddns manages records in. Defaults to the zones
+	// the records are in; list a zone to have its records cleaned up once
+	// none of the configured records are in it any more.
+	Zones []string `json:"zones,omitempty"`
+
+	// TTL is the records' TTL. Defaults to 5m.
+	TTL Duration `json:"ttl,omitempty"`
+
+	// Records each go in the server's zone with the longest name they're
+	// in.
+	Records RecordSet `json:"records"`
+}
+
+func (p *PowerDNS) validate() error {
+	if p.URL == "" {
+		return fmt.Errorf("needs a url")
+	}
+	return nil
+}
+
+// powerDNSRRset is an RRset in the PowerDNS API.
+type powerDNSRRset struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	TTL        int               `json:"ttl,omitempty"`
+	ChangeType string            `json:"changetype,omitempty"`
+	Records    []powerDNSRecord  `json:"records"`
+	Comments   []powerDNSComment `json:"comments"`
+}
+
+type powerDNSRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+type powerDNSComment struct {
+	Content string `json:"content"`
+	Account string `json:"account"`
+}
+
+// owned reports whether tsddns manages the RRset, which it marks with a
+// comment.
+func (set powerDNSRRset) owned() bool {
+	for _, c := range set.Comments {
+		if c.Content == recordOwner {
+			return true
+		}
+	}
+	return false
+}
+
+// sync resolves the configured records and reconciles the zones
+// they're in with them, with one change per zone. Only RRsets carrying the
+// tsddns comment are changed or deleted.
+func (p *PowerDNS) sync(ctx context.Context, r *resolver, _ map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, p.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	desired, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+
+	c := &powerDNSClient{
+		url:    strings.TrimSuffix(p.URL, "/") + "/api/v1/servers/" + url.PathEscape(cmp.Or(p.ServerID, "localhost")),
+		apiKey: os.Getenv(cmp.Or(p.APIKeyEnv, "PDNS_API_KEY")),
+	}
+	var zones []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := c.do(ctx, "GET", "/zones", nil, &zones); err != nil {
+		return fmt.Errorf("listing zones: %w", err)
+	}
+	zoneIDs := make(map[string]string)
+	for _, z := range zones {
+		zoneIDs[normalizeDomain(z.Name)] = z.ID
+	}
+
+	// group the records by zone
+	byZone := make(map[string][]record)
+	for _, zone := range p.Zones {
+		if _, ok := zoneIDs[normalizeDomain(zone)]; !ok {
+			return fmt.Errorf("zone %s not found", zone)
+		}
+		byZone[normalizeDomain(zone)] = nil
+	}
+	for _, rec := range desired {
+		zone, ok := longestZone(rec.Name, zoneIDs)
+		if !ok {
+			return fmt.Errorf("no zone on the server contains %s", rec.Name)
+		}
+		byZone[zone] = append(byZone[zone], rec)
+	}
+	var names []string
+	for zone := range byZone {
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+
+	ttl := int(time.Duration(cmp.Or(p.TTL, Duration(defaultRecordTTL))).Seconds())
+	changed := false
+	for _, zone := range names {
+		zoneChanged, err := c.syncZone(ctx, zoneIDs[zone], byZone[zone], ttl)
+		if err != nil {
+			return fmt.Errorf("zone %s: %w", zone, err)
+		}
+		changed = changed || zoneChanged
+	}
+	if !changed {
+		slog.InfoContext(ctx, "PowerDNS records are up to date")
+	}
+	return nil
+}
+
+// longestZone returns the longest of zones that name is in.
+func longestZone(name string, zones map[string]string) (string, bool) {
+	for candidate := name; ; {
+		if _, ok := zones[candidate]; ok {
+			return candidate, true
+		}
+		dot := strings.IndexByte(candidate, '.')
+		if dot < 0 {
+			return "", false
+		}
+		candidate = candidate[dot+1:]
+	}
+}
+
+// syncZone reconciles one zone with the desired records in it, and reports
+// whether anything changed.
+func (c *powerDNSClient) syncZone(ctx context.Context, zoneID string, desired []record, ttl int) (bool, error) {
+	var zone struct {
+		RRsets []powerDNSRRset `json:"rrsets"`
+	}
+	path := "/zones/" + url.PathEscape(zoneID)
+	if err := c.do(ctx, "GET", path, nil, &zone); err != nil {
+		return false, fmt.Errorf("reading zone: %w", err)
+	}
+	var owned, foreign []record
+	for _, set := range zone.RRsets {
+		if set.Type != "A" && set.Type != "AAAA" {
+			continue
+		}
+		for _, rr := range set.Records {
+			rec := record{Name: normalizeDomain(set.Name), Type: set.Type, Value: rr.Content}
+			if set.owned() {
+				owned = append(owned, rec)
+			} else {
+				foreign = append(foreign, rec)
+			}
+		}
+	}
+
+	create, remove := planRecords(desired, owned, foreign)
+	var patch struct {
+		RRsets []powerDNSRRset `json:"rrsets"`
+	}
+	for _, set := range changedRRsets(desired, create, remove) {
+		change := powerDNSRRset{Name: set.Name + ".", Type: set.Type, ChangeType: "DELETE", Records: []powerDNSRecord{}, Comments: []powerDNSComment{}}
+		if len(set.Values) == 0 {
+			slog.InfoContext(ctx, "Deleting PowerDNS records", "name", set.Name, "type", set.Type)
+		} else {
+			slog.InfoContext(ctx, "Setting PowerDNS records", "name", set.Name, "type", set.Type, "values", set.Values)
+			change.ChangeType, change.TTL = "REPLACE", ttl
+			change.Comments = []powerDNSComment{{Content: recordOwner, Account: "tsddns"}}
+			for _, v := range set.Values {
+				change.Records = append(change.Records, powerDNSRecord{Content: v})
+			}
+		}
+		patch.RRsets = append(patch.RRsets, change)
+	}
+	if len(patch.RRsets) == 0 {
+		return false, nil
+	}
+	if err := c.do(ctx, "PATCH", path, patch, nil); err != nil {
+		return false, fmt.Errorf("changing records: %w", err)
+	}
+	return true, nil
+}
+
+// powerDNSClient talks to one server of the PowerDNS API.
+type powerDNSClient struct {
+	url    string
+	apiKey string
+}
+
+func (c *powerDNSClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-Key", c.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, apiErr.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
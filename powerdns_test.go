@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSyncPowerDNS(t *testing.T) {
+	owner := []powerDNSComment{{Content: recordOwner, Account: "tsddns"}}
+	zone := map[string]any{"rrsets": []powerDNSRRset{
+		{Name: "ns.internal.example.com.", Type: "A", TTL: 300, Records: []powerDNSRecord{{Content: "100.64.0.1"}}, Comments: owner},
+		{Name: "old.internal.example.com.", Type: "AAAA", TTL: 300, Records: []powerDNSRecord{{Content: "fd7a:115c:a1e0::2"}}, Comments: owner},
+		{Name: "manual.internal.example.com.", Type: "A", TTL: 300, Records: []powerDNSRecord{{Content: "100.64.0.3"}}},
+		{Name: "internal.example.com.", Type: "SOA", TTL: 3600, Records: []powerDNSRecord{{Content: "ns1. hostmaster. 1 3600 600 86400 60"}}},
+	}}
+	var patched []powerDNSRRset
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v1/servers/localhost/zones":
+			json.NewEncoder(w).Encode([]map[string]string{
+				{"id": "example.com.", "name": "example.com."},
+				{"id": "internal.example.com.", "name": "internal.example.com."},
+			})
+		case "GET /api/v1/servers/localhost/zones/internal.example.com.":
+			json.NewEncoder(w).Encode(zone)
+		case "PATCH /api/v1/servers/localhost/zones/internal.example.com.":
+			var patch struct{ RRsets []powerDNSRRset }
+			json.NewDecoder(r.Body).Decode(&patch)
+			patched = patch.RRsets
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "not found"}`))
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("PDNS_API_KEY", "secret")
+
+	p := &PowerDNS{
+		URL: srv.URL,
+		Records: Config{
+			"ns.internal.example.com":     {"100.64.0.1", "100.64.0.4"},
+			"manual.internal.example.com": {"100.64.0.5"},
+		},
+	}
+	if err := p.sync(context.Background(), newResolver(nil, Defaults{}), nil); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, set := range patched {
+		var values []string
+		for _, rr := range set.Records {
+			values = append(values, rr.Content)
+		}
+		got = append(got, set.ChangeType+" "+set.Name+" "+set.Type+" "+strings.Join(values, ","))
+		if set.ChangeType == "REPLACE" && !set.owned() {
+			t.Errorf("%s %s was replaced without the tsddns comment", set.Name, set.Type)
+		}
+	}
+	want := "REPLACE ns.internal.example.com. A 100.64.0.1,100.64.0.4; DELETE old.internal.example.com. AAAA "
+	if strings.Join(got, "; ") != want {
+		t.Errorf("patch = %q, want %q", strings.Join(got, "; "), want)
+	}
+
+	p.Records = Config{"ns.example.org": {"100.64.0.1"}}
+	if err := p.sync(context.Background(), newResolver(nil, Defaults{}), nil); err == nil {
+		t.Error("sync() accepted a record outside every zone")
+	}
+}
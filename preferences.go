@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// Preferences are tailnet DNS settings reconciled on every update. A field
+// that isn't set is left alone.
+type Preferences struct {
+	// MagicDNS turns MagicDNS on or off.
+	MagicDNS *bool `json:"magicDNS,omitempty"`
+
+	// OverrideLocalDNS makes clients use the tailnet's global nameservers
+	// instead of their local DNS settings.
+	OverrideLocalDNS *bool `json:"overrideLocalDNS,omitempty"`
+}
+
+// preferences returns the preferences to reconcile. The global mode always
+// overrides local DNS, since clients otherwise keep using their own DNS
+// settings.
+func (c *ConfigFile) preferences() *Preferences {
+	if c.Mode != "global" {
+		return c.Preferences
+	}
+	var p Preferences
+	if c.Preferences != nil {
+		p = *c.Preferences
+	}
+	override := true
+	p.OverrideLocalDNS = &override
+	return &p
+}
+
+// getOverrideLocalDNS returns the tailnet's "Override local DNS" setting.
+func getOverrideLocalDNS(ctx context.Context, client *tailscale.Client) (bool, error) {
+	// TODO: use the official client once it covers the DNS configuration API
+	var config struct {
+		Preferences struct {
+			OverrideLocalDNS bool `json:"overrideLocalDNS"`
+		} `json:"preferences"`
+	}
+	if err := apiGet(ctx, client, "dns/configuration", &config); err != nil {
+		return false, err
+	}
+	return config.Preferences.OverrideLocalDNS, nil
+}
+
+// setOverrideLocalDNS changes the tailnet's "Override local DNS" setting.
+// The DNS configuration API only replaces the whole configuration, so the
+// current one is written back with just that setting changed.
+func setOverrideLocalDNS(ctx context.Context, client *tailscale.Client, override bool) error {
+	var config map[string]json.RawMessage
+	if err := apiGet(ctx, client, "dns/configuration", &config); err != nil {
+		return err
+	}
+	prefs := make(map[string]any)
+	if raw, ok := config["preferences"]; ok {
+		if err := json.Unmarshal(raw, &prefs); err != nil {
+			return fmt.Errorf("parsing DNS preferences: %w", err)
+		}
+	}
+	prefs["overrideLocalDNS"] = override
+
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		config = make(map[string]json.RawMessage)
+	}
+	config["preferences"] = data
+	return apiPost(ctx, client, "dns/configuration", config)
+}
+
+// addPreferenceSteps queues a write for every preference in p that differs
+// from the tailnet's current setting, with a rollback to that setting.
+func addPreferenceSteps(ctx context.Context, client *tailscale.Client, tx *transaction, p *Preferences) error {
+	if p.MagicDNS != nil {
+		current, err := client.DNS().Preferences(ctx)
+		if err != nil {
+			return fmt.Errorf("fetching current DNS preferences: %w", err)
+		}
+		if current.MagicDNS != *p.MagicDNS {
+			slog.InfoContext(ctx, "Updating MagicDNS", "old", current.MagicDNS, "new", *p.MagicDNS)
+			want, old := *current, *current
+			want.MagicDNS = *p.MagicDNS
+			tx.add("MagicDNS preference",
+				func(ctx context.Context) error { return client.DNS().SetPreferences(ctx, want) },
+				func(ctx context.Context) error { return client.DNS().SetPreferences(ctx, old) },
+			)
+		}
+	}
+
+	if p.OverrideLocalDNS != nil {
+		current, err := getOverrideLocalDNS(ctx, client)
+		if err != nil {
+			return fmt.Errorf("fetching current override local DNS setting: %w", err)
+		}
+		if current != *p.OverrideLocalDNS {
+			slog.InfoContext(ctx, "Updating override local DNS", "old", current, "new", *p.OverrideLocalDNS)
+			want := *p.OverrideLocalDNS
+			tx.add("override local DNS preference",
+				func(ctx context.Context) error { return setOverrideLocalDNS(ctx, client, want) },
+				func(ctx context.Context) error { return setOverrideLocalDNS(ctx, client, current) },
+			)
+		}
+	}
+	return nil
+}
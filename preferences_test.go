@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestPreferenceSteps(t *testing.T) {
+	magicDNS := false
+	config := map[string]any{
+		"nameservers": []any{"1.1.1.1"},
+		"preferences": map[string]any{"overrideLocalDNS": false},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v2/tailnet/test/dns/preferences":
+			json.NewEncoder(w).Encode(map[string]bool{"magicDNS": magicDNS})
+		case "POST /api/v2/tailnet/test/dns/preferences":
+			var prefs map[string]bool
+			json.NewDecoder(r.Body).Decode(&prefs)
+			magicDNS = prefs["magicDNS"]
+			w.Write([]byte("{}"))
+		case "GET /api/v2/tailnet/test/dns/configuration":
+			json.NewEncoder(w).Encode(config)
+		case "POST /api/v2/tailnet/test/dns/configuration":
+			config = nil
+			json.NewDecoder(r.Body).Decode(&config)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	client := &tailscale.Client{BaseURL: baseURL, APIKey: "test-key", Tailnet: "test"}
+
+	on := true
+	ctx := context.Background()
+	var tx transaction
+	if err := addPreferenceSteps(ctx, client, &tx, &Preferences{MagicDNS: &on, OverrideLocalDNS: &on}); err != nil {
+		t.Fatalf("addPreferenceSteps() unexpected error: %v", err)
+	}
+	if len(tx.steps) != 2 {
+		t.Fatalf("got %d steps, want 2", len(tx.steps))
+	}
+	if err := tx.commit(ctx); err != nil {
+		t.Fatalf("commit() unexpected error: %v", err)
+	}
+
+	if !magicDNS {
+		t.Error("MagicDNS was not turned on")
+	}
+	if override, err := getOverrideLocalDNS(ctx, client); err != nil || !override {
+		t.Errorf("getOverrideLocalDNS() = %v, %v, want true", override, err)
+	}
+	if got, _ := config["nameservers"].([]any); !slices.Equal(got, []any{"1.1.1.1"}) {
+		t.Errorf("nameservers = %v, want them kept", config["nameservers"])
+	}
+
+	// already in the wanted state, so nothing to write
+	tx = transaction{}
+	if err := addPreferenceSteps(ctx, client, &tx, &Preferences{MagicDNS: &on, OverrideLocalDNS: &on}); err != nil {
+		t.Fatalf("addPreferenceSteps() unexpected error: %v", err)
+	}
+	if len(tx.steps) != 0 {
+		t.Errorf("got %d steps for unchanged preferences, want 0", len(tx.steps))
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultProbeTimeout = 2 * time.Second
+
+// probePort is the port probes are sent to. Split DNS nameservers are
+// always queried on 53; it's a variable for tests.
+var probePort = "53"
+
+// probeTypes are the record types a probe can ask for.
+var probeTypes = map[string]uint16{
+	"A":    1,
+	"NS":   2,
+	"SOA":  6,
+	"MX":   15,
+	"TXT":  16,
+	"AAAA": 28,
+}
+
+// Probe configures the DNS query sent to every resolved nameserver before
+// an update is applied.
+type Probe struct {
+	// Name is the name to query. Empty queries each domain itself.
+	Name string `json:"name,omitempty"`
+
+	// Type is the record type to ask for: "A" (the default), "AAAA",
+	// "NS", "SOA", "MX" or "TXT".
+	Type string `json:"type,omitempty"`
+
+	// Timeout is how long a nameserver has to answer. Defaults to 2s.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// OnFailure is "drop" (the default) to leave out nameservers that
+	// don't answer, or "fail" to abort the update.
+	OnFailure string `json:"onFailure,omitempty"`
+}
+
+func (p *Probe) validate() error {
+	if _, ok := probeTypes[strings.ToUpper(cmp.Or(p.Type, "A"))]; !ok {
+		return fmt.Errorf("unsupported record type %q", p.Type)
+	}
+	switch p.OnFailure {
+	case "", "drop", "fail":
+	default:
+		return fmt.Errorf("unknown onFailure %q", p.OnFailure)
+	}
+	return nil
+}
+
+func (p *Probe) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return defaultProbeTimeout
+	}
+	return time.Duration(p.Timeout)
+}
+
+// probeTarget is one query to send: a name to a nameserver.
+type probeTarget struct {
+	server string
+	name   string
+}
+
+// probeNameservers queries every nameserver in splitDNS that is an IP
+// address and handles the ones that don't answer according to p. Other
+// entries, such as DoH URLs, can't be probed and are kept. A domain whose
+// nameservers all fail fails the update even with "drop", since dropping
+// them all would remove the domain's route instead of keeping the current
+// one.
+func probeNameservers(ctx context.Context, p *Probe, splitDNS map[string][]string) error {
+	seen := make(map[probeTarget]bool)
+	var targets []probeTarget
+	for domain, nameservers := range splitDNS {
+		for _, ns := range nameservers {
+			t := probeTarget{server: ns, name: cmp.Or(p.Name, domain)}
+			if _, err := netip.ParseAddr(ns); err == nil && !seen[t] {
+				seen[t] = true
+				targets = append(targets, t)
+			}
+		}
+	}
+
+	results := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = p.query(ctx, t)
+		}()
+	}
+	wg.Wait()
+	probed := make(map[probeTarget]error, len(targets))
+	for i, t := range targets {
+		probed[t] = results[i]
+	}
+
+	var failures []string
+	for domain, nameservers := range splitDNS {
+		var healthy []string
+		for _, ns := range nameservers {
+			err := probed[probeTarget{server: ns, name: cmp.Or(p.Name, domain)}]
+			if err == nil {
+				healthy = append(healthy, ns)
+				continue
+			}
+			slog.WarnContext(ctx, "Nameserver failed its probe", "domain", domain, "nameserver", ns, "error", err)
+			failures = append(failures, fmt.Sprintf("%s (%s)", ns, domain))
+		}
+		if len(healthy) == 0 && len(nameservers) > 0 {
+			return fmt.Errorf("no nameserver for %s answered its probe", domain)
+		}
+		if p.OnFailure != "fail" {
+			splitDNS[domain] = healthy
+		}
+	}
+
+	if len(failures) > 0 && p.OnFailure == "fail" {
+		sort.Strings(failures)
+		return fmt.Errorf("nameservers failed their probe: %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// query sends the probe to one nameserver over UDP. Any answer other than
+// SERVFAIL or REFUSED counts, since even NXDOMAIN shows the nameserver is
+// serving.
+func (p *Probe) query(ctx context.Context, t probeTarget) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout())
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(t.server, probePort))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	msg, id, err := buildQuery(t.name, probeTypes[strings.ToUpper(cmp.Or(p.Type, "A"))])
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		// ignore stray packets that aren't a response to this query
+		if n < 12 || binary.BigEndian.Uint16(buf) != id || buf[2]&0x80 == 0 {
+			continue
+		}
+		switch rcode := buf[3] & 0x0f; rcode {
+		case 2:
+			return fmt.Errorf("SERVFAIL")
+		case 5:
+			return fmt.Errorf("REFUSED")
+		}
+		return nil
+	}
+}
+
+// buildQuery returns a recursive DNS query for name and its random ID.
+func buildQuery(name string, qtype uint16) ([]byte, uint16, error) {
+	var idBytes [2]byte
+	rand.Read(idBytes[:])
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = append(msg, 0x01, 0x00) // recursion desired
+	msg = binary.BigEndian.AppendUint16(msg, 1)
+	msg = append(msg, 0, 0, 0, 0, 0, 0)
+	msg, err := appendName(msg, name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad probe name: %w", err)
+	}
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // class IN
+	return msg, id, nil
+}
+
+// appendName appends name to msg in DNS wire format, uncompressed.
+func appendName(msg []byte, name string) ([]byte, error) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("bad name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestProbeNameservers(t *testing.T) {
+	server := startTestDNS(t, testDNS{a: map[string]net.IP{"example.com": net.ParseIP("192.0.2.1")}})
+	_, port, _ := net.SplitHostPort(server)
+	oldPort := probePort
+	probePort = port
+	defer func() { probePort = oldPort }()
+
+	// 127.0.0.1 runs the test server; nothing listens on 127.0.0.2
+	tests := []struct {
+		name    string
+		probe   Probe
+		in      map[string][]string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{
+			name: "all healthy",
+			in:   map[string][]string{"example.com": {"127.0.0.1"}},
+			want: map[string][]string{"example.com": {"127.0.0.1"}},
+		},
+		{
+			name: "negative answer counts",
+			in:   map[string][]string{"other.com": {"127.0.0.1"}},
+			want: map[string][]string{"other.com": {"127.0.0.1"}},
+		},
+		{
+			name:  "drop failing nameserver",
+			probe: Probe{Name: "example.com", Type: "AAAA"},
+			in:    map[string][]string{"example.com": {"127.0.0.2", "127.0.0.1", "https://dns.example.com/dns-query"}},
+			want:  map[string][]string{"example.com": {"127.0.0.1", "https://dns.example.com/dns-query"}},
+		},
+		{
+			name:    "fail on failing nameserver",
+			probe:   Probe{OnFailure: "fail"},
+			in:      map[string][]string{"example.com": {"127.0.0.2", "127.0.0.1"}},
+			wantErr: true,
+		},
+		{
+			// dropping them all would remove the domain's route
+			name:    "no healthy nameservers left",
+			probe:   Probe{OnFailure: "drop"},
+			in:      map[string][]string{"example.com": {"127.0.0.2"}, "other.com": {"127.0.0.1"}},
+			want:    map[string][]string{"example.com": {"127.0.0.2"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.probe.Timeout = Duration(500 * time.Millisecond)
+			err := probeNameservers(context.Background(), &tt.probe, tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("probeNameservers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && tt.want == nil {
+				return
+			}
+			for domain, want := range tt.want {
+				if got := tt.in[domain]; !slices.Equal(got, want) {
+					t.Errorf("%s = %v, want %v", domain, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"sort"
+)
+
+// Profile is a named environment, such as staging or prod, in a config
+// file that is shared across tailnets.
+type Profile struct {
+	Tailnet string `json:"tailnet,omitempty"`
+
+	// APIKeyEnv, ClientIDEnv and ClientSecretEnv name the environment
+	// variables that hold this profile's credentials.
+	APIKeyEnv       string `json:"apiKeyEnv,omitempty"`
+	ClientIDEnv     string `json:"clientIdEnv,omitempty"`
+	ClientSecretEnv string `json:"clientSecretEnv,omitempty"`
+
+	// Domains are added to the top-level domains, replacing any with the
+	// same name.
+	Domains Config `json:"domains,omitempty"`
+}
+
+// forProfile returns the effective config for the named profile. An empty
+// name is only allowed when the config defines no profiles, so that a
+// forgotten -profile flag can't push the shared domains alone to a tailnet.
+func (c *ConfigFile) forProfile(name string) (*ConfigFile, *Profile, error) {
+	if name == "" {
+		if len(c.Profiles) > 0 {
+			return nil, nil, fmt.Errorf("config defines profiles %v; pick one with -profile", c.profileNames())
+		}
+		return c, nil, nil
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("profile %q not found (have %v)", name, c.profileNames())
+	}
+
+	effective := *c
+	effective.Profiles = nil
+	effective.Domains = maps.Clone(c.Domains)
+	if effective.Domains == nil {
+		effective.Domains = Config{}
+	}
+	maps.Copy(effective.Domains, p.Domains)
+	return &effective, p, nil
+}
+
+func (c *ConfigFile) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// credentials returns the profile's credentials from the environment. ok is
+// false if the profile doesn't reference any, in which case the global ones
+// apply.
+func (p *Profile) credentials() (apiKey, clientID, clientSecret string, ok bool) {
+	if p.APIKeyEnv == "" && p.ClientIDEnv == "" && p.ClientSecretEnv == "" {
+		return "", "", "", false
+	}
+	return getenvIfSet(p.APIKeyEnv), getenvIfSet(p.ClientIDEnv), getenvIfSet(p.ClientSecretEnv), true
+}
+
+func getenvIfSet(name string) string {
+	if name == "" {
+		return ""
+	}
+	return os.Getenv(name)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// apiRate and apiBurst limit Tailscale API calls to apiRate a second on
+// average, with bursts of up to apiBurst. An apiRate of 0 turns the limit
+// off. They're set by the -api-rate and -api-burst flags.
+var (
+	apiRate  = 10.0
+	apiBurst = 10
+)
+
+// tokenBucket is a token bucket rate limiter: it holds up to burst tokens,
+// refills at rate tokens a second, and every call takes one.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	burst = max(burst, 1)
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		now:    time.Now,
+		sleep:  sleepContext,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait takes a token, waiting until one is free. Callers that have to wait
+// queue up in order, each reserving the next token to refill.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	b.mu.Lock()
+	now := b.now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	return b.sleep(ctx, delay)
+}
+
+// rateLimitTransport holds every request until the bucket has a token for
+// it, so that one update's burst of lookups stays under the API's rate
+// limits. Sharing one bucket across every caller of the client keeps the
+// limit global.
+type rateLimitTransport struct {
+	next   http.RoundTripper
+	bucket *tokenBucket
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.bucket.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
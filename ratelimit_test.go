@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	var delays []time.Duration
+	b := newTokenBucket(2, 3)
+	b.now = func() time.Time { return now }
+	b.last = now
+	b.sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	// the burst goes through, then calls queue half a second apart
+	for range 5 {
+		b.wait(context.Background())
+	}
+	want := []time.Duration{500 * time.Millisecond, time.Second}
+	if len(delays) != len(want) || delays[0] != want[0] || delays[1] != want[1] {
+		t.Fatalf("delays = %v, want %v", delays, want)
+	}
+
+	// after a long pause the bucket refills to the burst, not beyond it
+	delays = nil
+	now = now.Add(time.Hour)
+	for range 4 {
+		b.wait(context.Background())
+	}
+	if len(delays) != 1 || delays[0] != 500*time.Millisecond {
+		t.Errorf("delays after refilling = %v, want [500ms]", delays)
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	var waited time.Duration
+	bucket := newTokenBucket(1, 1)
+	bucket.sleep = func(_ context.Context, d time.Duration) error {
+		waited += d
+		return nil
+	}
+	client := &http.Client{Transport: &rateLimitTransport{next: http.DefaultTransport, bucket: bucket}}
+	for range 3 {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if calls != 3 {
+		t.Errorf("server got %d calls, want 3", calls)
+	}
+	// the second and third calls each wait for about a second's refill
+	if waited < 1900*time.Millisecond {
+		t.Errorf("waited %v for 3 calls at 1/s, want about 2s", waited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bucket.sleep = sleepContext
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if _, err := client.Do(req); err == nil {
+		t.Error("request with a cancelled context went through while waiting for a token")
+	}
+	if calls != 3 {
+		t.Errorf("server got %d calls, want the cancelled one held back", calls)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	// recordOwner marks DNS records tsddns manages in backends that can
+	// tag records, so records added by hand are never touched.
+	recordOwner = "managed by tsddns"
+
+	// ownerMarkerPrefix is prepended to a record name to get the name of
+	// the TXT record marking it as managed by tsddns, in backends whose
+	// records can't carry a comment.
+	ownerMarkerPrefix = "_tsddns."
+
+	// ownerMarkerValue is the marker TXT record's value.
+	ownerMarkerValue = `"heritage=tsddns"`
+
+	// managedBlockStart and managedBlockEnd surround the lines tsddns
+	// manages in backends configured with a list of lines.
+	managedBlockStart = "# BEGIN tsddns"
+	managedBlockEnd   = "# END tsddns"
+)
+
+// RecordSet maps the names of the records a backend keeps to entries
+// resolving to their addresses, written like a domain's nameservers in
+// Config: IPs, svc:, tag: and the other selectors. Every address an entry
+// resolves to becomes an A or AAAA record of the name.
+type RecordSet = Config
+
+// record is an A or AAAA record in a DNS record backend.
+type record struct {
+	ID    string // the backend's ID for the record, if it has one
+	Name  string
+	Type  string // "A" or "AAAA"
+	Value string
+}
+
+func (rec record) key() string {
+	return normalizeDomain(rec.Name) + " " + rec.Type + " " + rec.Value
+}
+
+// addressRecords returns the A and AAAA records for resolved, which maps
+// record names to addresses, sorted by name.
+func addressRecords(resolved map[string][]string) ([]record, error) {
+	var records []record
+	for name, addrs := range resolved {
+		for _, a := range addrs {
+			addr, err := netip.ParseAddr(a)
+			if err != nil {
+				return nil, fmt.Errorf("record %s: %q is not an IP address", name, a)
+			}
+			typ := "AAAA"
+			if addr.Is4() {
+				typ = "A"
+			}
+			records = append(records, record{Name: normalizeDomain(name), Type: typ, Value: addr.String()})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].key() < records[j].key() })
+	return records, nil
+}
+
+// planRecords returns the records to create and remove so that the records
+// tsddns owns become desired. foreign are address records tsddns doesn't
+// own; names that have any are left alone.
+func planRecords(desired, owned, foreign []record) (create, remove []record) {
+	taken := make(map[string]bool)
+	for _, rec := range foreign {
+		taken[normalizeDomain(rec.Name)] = true
+	}
+
+	want := make(map[string]bool)
+	warned := make(map[string]bool)
+	for _, rec := range desired {
+		if taken[rec.Name] {
+			if !warned[rec.Name] {
+				slog.Warn("Name has address records tsddns doesn't manage, leaving it untouched", "name", rec.Name)
+				warned[rec.Name] = true
+			}
+			continue
+		}
+		want[rec.key()] = true
+	}
+
+	have := make(map[string]bool)
+	for _, rec := range owned {
+		have[rec.key()] = true
+		if !want[rec.key()] && !taken[normalizeDomain(rec.Name)] {
+			remove = append(remove, rec)
+		}
+	}
+	for _, rec := range desired {
+		if want[rec.key()] && !have[rec.key()] {
+			create = append(create, rec)
+		}
+	}
+	return create, remove
+}
+
+// hasName reports whether any of records is at name.
+func hasName(records []record, name string) bool {
+	for _, rec := range records {
+		if rec.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rrset is a record set: the values of all records of one type at one
+// name.
+type rrset struct {
+	Name   string
+	Type   string
+	Values []string
+}
+
+// changedRRsets returns the record sets create and remove touch, sorted, each
+// with the values it should end up with from desired. A set with no values
+// should be deleted.
+func changedRRsets(desired, create, remove []record) []rrset {
+	type key struct{ name, typ string }
+	touched := make(map[key]bool)
+	var sets []rrset
+	for _, rec := range slices.Concat(create, remove) {
+		k := key{normalizeDomain(rec.Name), rec.Type}
+		if touched[k] {
+			continue
+		}
+		touched[k] = true
+		set := rrset{Name: k.name, Type: k.typ}
+		for _, d := range desired {
+			if d.Name == set.Name && d.Type == set.Type {
+				set.Values = append(set.Values, d.Value)
+			}
+		}
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Name+" "+sets[i].Type < sets[j].Name+" "+sets[j].Type
+	})
+	return sets
+}
+
+// managedBlock returns lines surrounded by the managed block markers, or
+// nil if there are none.
+func managedBlock(lines []string) []string {
+	if len(lines) == 0 {
+		return nil
+	}
+	return slices.Concat([]string{managedBlockStart}, lines, []string{managedBlockEnd})
+}
+
+// findManagedBlock returns tsddns's block in lines, markers included, or
+// nil if there is none.
+func findManagedBlock(lines []string) []string {
+	start := slices.Index(lines, managedBlockStart)
+	if start < 0 {
+		return nil
+	}
+	end := slices.Index(lines[start:], managedBlockEnd)
+	if end < 0 {
+		return nil
+	}
+	return lines[start : start+end+1]
+}
+
+// replaceManagedBlock returns lines with tsddns's block replaced by block,
+// which is appended if lines had none.
+func replaceManagedBlock(lines, block []string) []string {
+	var out []string
+	inBlock := false
+	for _, line := range lines {
+		switch {
+		case line == managedBlockStart:
+			inBlock = true
+		case line == managedBlockEnd:
+			inBlock = false
+		case !inBlock:
+			out = append(out, line)
+		}
+	}
+	return append(out, block...)
+}
+
+// relativeName returns the normalized name relative to zone, with "@" for
+// the apex.
+func relativeName(name, zone string) string {
+	zone = normalizeDomain(zone)
+	if name == zone {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+zone)
+}
+
+// httpClientWithCA returns an HTTP client that verifies servers with the CA
+// bundle in caFile, or the default client if caFile is empty.
+func httpClientWithCA(caFile string) (*http.Client, error) {
+	if caFile == "" {
+		return http.DefaultClient, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}, nil
+}
+
+// recordBackend is a DNS record backend: a server or file the configured
+// records are written to, as opposed to the split DNS Backend.
+type recordBackend interface {
+	validate() error
+
+	// sync resolves the backend's records and reconciles it with them.
+	// splitDNS is the applied split DNS config, or nil if split DNS isn't
+	// managed, for backends that can forward its domains.
+	sync(ctx context.Context, r *resolver, splitDNS map[string][]string) error
+}
+
+// forwarder is a recordBackend that can also forward the split DNS domains
+// to their nameservers.
+type forwarder interface {
+	forwards() bool
+}
+
+// namedRecordBackend is a configured recordBackend and its config key.
+type namedRecordBackend struct {
+	name    string
+	backend recordBackend
+}
+
+// recordBackends returns the DNS record backends c configures, in the
+// order they're synced. A new backend is a config field and an entry here.
+func (c *ConfigFile) recordBackends() []namedRecordBackend {
+	all := []namedRecordBackend{
+		{"rfc2136", configured(c.RFC2136)},
+		{"cloudflare", configured(c.Cloudflare)},
+		{"route53", configured(c.Route53)},
+		{"cloudDNS", configured(c.CloudDNS)},
+		{"azureDNS", configured(c.AzureDNS)},
+		{"powerdns", configured(c.PowerDNS)},
+		{"nextdns", configured(c.NextDNS)},
+		{"pihole", configured(c.PiHole)},
+		{"adguard", configured(c.AdGuard)},
+		{"technitium", configured(c.Technitium)},
+		{"opnsense", configured(c.OPNsense)},
+		{"mikrotik", configured(c.MikroTik)},
+		{"hostsFile", configured(c.HostsFile)},
+		{"zoneFile", configured(c.ZoneFile)},
+	}
+	return slices.DeleteFunc(all, func(b namedRecordBackend) bool { return b.backend == nil })
+}
+
+// configured returns b as a recordBackend, or nil if it isn't set, so an
+// unset config field doesn't become a non-nil interface.
+func configured[T any, P interface {
+	*T
+	recordBackend
+}](b P) recordBackend {
+	if b == nil {
+		return nil
+	}
+	return b
+}
+
+// hasRecordBackends reports whether any DNS record backend is configured.
+func (c *ConfigFile) hasRecordBackends() bool {
+	return len(c.recordBackends()) > 0
+}
+
+// validateRecordBackends checks every configured DNS record backend.
+func (c *ConfigFile) validateRecordBackends() error {
+	for _, b := range c.recordBackends() {
+		if err := b.backend.validate(); err != nil {
+			return fmt.Errorf("%s: %w", b.name, err)
+		}
+		if f, ok := b.backend.(forwarder); ok && f.forwards() && c.Mode == "records" {
+			return fmt.Errorf("%s: forward needs split DNS, which records mode doesn't manage", b.name)
+		}
+	}
+	return nil
+}
+
+// syncRecords pushes records to every configured DNS record backend. A
+// failing backend doesn't stop the others. splitDNS is the applied split
+// DNS config, or nil if split DNS isn't managed.
+func syncRecords(ctx context.Context, r *resolver, c *ConfigFile, splitDNS map[string][]string) error {
+	var errs []error
+	for _, b := range c.recordBackends() {
+		if err := b.backend.sync(ctx, r, splitDNS); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
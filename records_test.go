@@ -0,0 +1,158 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAddressRecords(t *testing.T) {
+	got, err := addressRecords(map[string][]string{
+		"NS.example.com.": {"fd7a:115c:a1e0::1", "100.64.0.1"},
+	})
+	if err != nil {
+		t.Fatalf("addressRecords() unexpected error: %v", err)
+	}
+	want := []record{
+		{Name: "ns.example.com", Type: "A", Value: "100.64.0.1"},
+		{Name: "ns.example.com", Type: "AAAA", Value: "fd7a:115c:a1e0::1"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("addressRecords() = %v, want %v", got, want)
+	}
+
+	if _, err := addressRecords(map[string][]string{"ns.example.com": {"ns1"}}); err == nil {
+		t.Error("addressRecords() accepted a hostname")
+	}
+}
+
+func TestPlanRecords(t *testing.T) {
+	a := func(name, value string) record { return record{Name: name, Type: "A", Value: value} }
+
+	tests := []struct {
+		name       string
+		desired    []record
+		owned      []record
+		foreign    []record
+		wantCreate []record
+		wantRemove []record
+	}{
+		{
+			name:       "create missing",
+			desired:    []record{a("ns.example.com", "100.64.0.1")},
+			wantCreate: []record{a("ns.example.com", "100.64.0.1")},
+		},
+		{
+			name:    "up to date",
+			desired: []record{a("ns.example.com", "100.64.0.1")},
+			owned:   []record{a("ns.example.com", "100.64.0.1")},
+		},
+		{
+			name:       "address changed",
+			desired:    []record{a("ns.example.com", "100.64.0.2")},
+			owned:      []record{a("ns.example.com", "100.64.0.1")},
+			wantCreate: []record{a("ns.example.com", "100.64.0.2")},
+			wantRemove: []record{a("ns.example.com", "100.64.0.1")},
+		},
+		{
+			name:       "name dropped",
+			owned:      []record{a("old.example.com", "100.64.0.1")},
+			wantRemove: []record{a("old.example.com", "100.64.0.1")},
+		},
+		{
+			name:    "foreign name left alone",
+			desired: []record{a("www.example.com", "100.64.0.1")},
+			owned:   []record{a("www.example.com", "100.64.0.9")},
+			foreign: []record{a("www.example.com", "203.0.113.1")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			create, remove := planRecords(tt.desired, tt.owned, tt.foreign)
+			if !slices.Equal(create, tt.wantCreate) {
+				t.Errorf("create = %v, want %v", create, tt.wantCreate)
+			}
+			if !slices.Equal(remove, tt.wantRemove) {
+				t.Errorf("remove = %v, want %v", remove, tt.wantRemove)
+			}
+		})
+	}
+}
+
+func TestChangedRRsets(t *testing.T) {
+	desired := []record{
+		{Name: "ns.example.com", Type: "A", Value: "100.64.0.1"},
+		{Name: "ns.example.com", Type: "A", Value: "100.64.0.4"},
+		{Name: "ns.example.com", Type: "AAAA", Value: "fd7a:115c:a1e0::1"},
+	}
+	create := []record{{Name: "ns.example.com", Type: "A", Value: "100.64.0.4"}}
+	remove := []record{{Name: "old.example.com", Type: "A", Value: "100.64.0.2"}}
+
+	got := changedRRsets(desired, create, remove)
+	if len(got) != 2 {
+		t.Fatalf("changedRRsets() = %v, want 2 sets", got)
+	}
+	if got[0].Name != "ns.example.com" || got[0].Type != "A" || !slices.Equal(got[0].Values, []string{"100.64.0.1", "100.64.0.4"}) {
+		t.Errorf("first set = %v, want ns.example.com A with both addresses", got[0])
+	}
+	if got[1].Name != "old.example.com" || len(got[1].Values) != 0 {
+		t.Errorf("second set = %v, want old.example.com with no values", got[1])
+	}
+}
+
+func TestReplaceManagedBlock(t *testing.T) {
+	block := []string{managedBlockStart, "host-record=ns.example.com,100.64.0.1", managedBlockEnd}
+	tests := []struct {
+		name  string
+		lines []string
+		block []string
+		want  []string
+	}{
+		{
+			name:  "no block yet",
+			lines: []string{"local-ttl=60"},
+			block: block,
+			want:  slices.Concat([]string{"local-ttl=60"}, block),
+		},
+		{
+			name:  "replace block",
+			lines: []string{"local-ttl=60", managedBlockStart, "host-record=old.example.com,100.64.0.9", managedBlockEnd, "log-queries"},
+			block: block,
+			want:  slices.Concat([]string{"local-ttl=60", "log-queries"}, block),
+		},
+		{
+			name:  "remove block",
+			lines: []string{managedBlockStart, "host-record=old.example.com,100.64.0.9", managedBlockEnd},
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replaceManagedBlock(tt.lines, tt.block); !slices.Equal(got, tt.want) {
+				t.Errorf("replaceManagedBlock() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordBackends(t *testing.T) {
+	cfg := &ConfigFile{
+		Mode:      "records",
+		PiHole:    &PiHole{URL: "http://pi.hole", Forward: true},
+		HostsFile: &HostsFile{Path: "/etc/hosts", Records: Config{"nas.home.arpa": {"svc:nas"}}},
+	}
+	var names []string
+	for _, b := range cfg.recordBackends() {
+		names = append(names, b.name)
+	}
+	if !slices.Equal(names, []string{"pihole", "hostsFile"}) {
+		t.Errorf("recordBackends() = %v, want just the configured pihole and hostsFile", names)
+	}
+	if err := cfg.validateRecordBackends(); err == nil || err.Error() != "pihole: forward needs split DNS, which records mode doesn't manage" {
+		t.Errorf("validateRecordBackends() error = %v, want pihole's forward rejected in records mode", err)
+	}
+	if (&ConfigFile{}).hasRecordBackends() {
+		t.Error("hasRecordBackends() = true with none configured")
+	}
+}
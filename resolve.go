@@ -0,0 +1,881 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// selectorKinds are the entry prefixes that are resolved to addresses.
+// Anything else in a domain's nameserver list is used as is.
+var selectorKinds = map[string]struct {
+	// needsDevices is set for selectors that match against the device
+	// list, which is only fetched when something uses it.
+	needsDevices bool
+
+	// options are the ?key=value options the selector accepts.
+	options []string
+}{
+	"svc":           {options: []string{"tag", "family"}},
+	"device":        {needsDevices: true, options: deviceOptions},
+	"nodeid":        {needsDevices: true, options: deviceOptions},
+	"tag":           {needsDevices: true, options: deviceOptions},
+	"user":          {needsDevices: true, options: append([]string{"host"}, deviceOptions...)},
+	"group":         {needsDevices: true, options: append([]string{"host", "tag"}, deviceOptions...)},
+	"exit-node":     {needsDevices: true, options: deviceOptions},
+	"subnet-router": {needsDevices: true, options: deviceOptions},
+	"lan":           {needsDevices: true, options: append([]string{"route"}, deviceOptions...)},
+	"app-connector": {needsDevices: true, options: deviceOptions},
+	"latest-seen":   {needsDevices: true},
+	"local":         {options: []string{"online", "family"}},
+	"self":          {},
+	"dns":           {options: []string{"server", "family"}},
+	"k8s":           {options: []string{"ip", "family"}},
+	"docker":        {options: []string{"network", "family", "max"}},
+	"consul-svc":    {options: []string{"tag", "family", "max"}},
+	"srv":           {options: []string{"server", "family", "max"}},
+	"exec":          {options: []string{"timeout", "family", "max"}},
+	"4via6":         {},
+}
+
+// deviceOptions are the options every device list selector accepts.
+var deviceOptions = append([]string{"online", "family", "max"}, postureOptions...)
+
+// entry is one nameserver entry from the config, such as
+// "user:alice@example.com?host=ns-*".
+type entry struct {
+	raw     string     // as written in the config
+	kind    string     // selector prefix, or "" for a literal nameserver
+	value   string     // what follows the prefix, up to any options
+	options url.Values // parsed from after the "?"
+	optErr  error      // set if the options didn't parse
+}
+
+// optionsRE matches a query string of options. Only a trailing "?" followed
+// by one starts the options, so globs and regexes can still contain "?".
+var optionsRE = regexp.MustCompile(`^[a-z]+=[^&]*(&[a-z]+=[^&]*)*$`)
+
+func parseEntry(s string) entry {
+	if s == "self" {
+		return entry{raw: s, kind: "self"}
+	}
+	kind, value, ok := strings.Cut(s, ":")
+	if _, known := selectorKinds[kind]; !ok || !known {
+		return entry{raw: s}
+	}
+	e := entry{raw: s, kind: kind, value: value}
+	if i := strings.LastIndex(value, "?"); i >= 0 && optionsRE.MatchString(value[i+1:]) {
+		e.value = value[:i]
+		e.options, e.optErr = url.ParseQuery(value[i+1:])
+	}
+	return e
+}
+
+// validate checks that the entry only uses options its selector knows.
+func (e entry) validate() error {
+	if e.kind == "" {
+		return nil
+	}
+	if e.optErr != nil {
+		return fmt.Errorf("bad options: %w", e.optErr)
+	}
+	if e.kind == "latest-seen" {
+		_, err := e.unwrap()
+		return err
+	}
+	for key := range e.options {
+		if !slices.Contains(selectorKinds[e.kind].options, key) {
+			return fmt.Errorf("%s: selector doesn't take option %q", e.kind, key)
+		}
+	}
+	return nil
+}
+
+// unwrap returns the device selector a latest-seen: entry wraps, carrying
+// the entry's options.
+func (e entry) unwrap() (entry, error) {
+	inner := parseEntry(e.value)
+	if !inner.needsDevices() || inner.kind == "latest-seen" {
+		return entry{}, fmt.Errorf("latest-seen: wants a device selector, got %q", e.value)
+	}
+	if inner.options != nil {
+		return entry{}, fmt.Errorf("latest-seen: put options after the whole entry")
+	}
+	inner.raw = e.raw
+	inner.options = e.options
+	return inner, inner.validate()
+}
+
+func (e entry) needsDevices() bool {
+	return selectorKinds[e.kind].needsDevices
+}
+
+// resolveWorkers is how many entries resolveAll resolves at once. It's set
+// by the -resolve-workers flag.
+var resolveWorkers = 8
+
+// resolver resolves entries for a single update. resolveAll resolves
+// entries concurrently, so fetchMu serializes the lookups made at most once
+// per update (devices, local, acl and k8s) and mu guards the maps filled in
+// as entries resolve.
+type resolver struct {
+	client   *tailscale.Client
+	defaults Defaults
+	devices  []tailscale.Device
+	now      time.Time
+
+	fetchMu sync.Mutex
+	mu      sync.Mutex
+
+	// local is tailscaled's status, fetched on first use.
+	local *localStatus
+
+	// k8s is the Kubernetes API client, set up on first use.
+	k8s *k8sClient
+
+	// attributes caches posture attributes by device ID.
+	attributes map[string]map[string]any
+
+	// routes caches subnet routes by device ID.
+	routes map[string]*tailscale.DeviceRoutes
+
+	// nodeIDs maps stable node IDs to device IDs, fetched on first use.
+	nodeIDs map[string]string
+
+	// acl is the tailnet policy file, fetched on first use.
+	acl *policyFile
+
+	// weights are the weights of each domain's weighted nameservers, set
+	// by resolveAll.
+	weights map[string]map[string]int
+
+	// results are this update's results by entry, and cache keeps them
+	// across updates. See cached.
+	results map[string][]string
+	cache   *resolveCache
+}
+
+func newResolver(client *tailscale.Client, defaults Defaults) *resolver {
+	return &resolver{client: client, defaults: defaults, now: time.Now(), cache: resultCache}
+}
+
+func resolveSplitDNS(ctx context.Context, client *tailscale.Client, cfg Config) (tailscale.SplitDNSRequest, error) {
+	return newResolver(client, Defaults{}).resolveAll(ctx, cfg)
+}
+
+func (r *resolver) resolveAll(ctx context.Context, cfg Config) (tailscale.SplitDNSRequest, error) {
+	for _, nameservers := range cfg {
+		if err := r.prefetchDevices(ctx, nameservers); err != nil {
+			return nil, err
+		}
+	}
+
+	// each distinct entry is resolved once, for the first domain using it
+	results := make(map[string][]string)
+	var jobs []resolveJob
+	for domain, nameservers := range cfg {
+		for _, ns := range nameservers {
+			ns, _, err := parseWeight(ns)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := results[ns]; !ok {
+				results[ns] = nil
+				jobs = append(jobs, resolveJob{domain: domain, ns: ns})
+			}
+		}
+	}
+	if err := r.resolveEntries(ctx, jobs, results); err != nil {
+		return nil, err
+	}
+
+	splitDNS := make(tailscale.SplitDNSRequest)
+	for domain, nameservers := range cfg {
+		var resolved []string
+		weights := make(map[string]int)
+		weighted := false
+		for _, ns := range nameservers {
+			ns, weight, _ := parseWeight(ns)
+			ips := results[ns]
+			for _, ip := range ips {
+				weights[ip] = max(weights[ip], weight)
+			}
+			weighted = weighted || weight != 1
+			resolved = append(resolved, ips...)
+		}
+		for _, ip := range duplicates(resolved) {
+			slog.WarnContext(ctx, "Domain resolves to an address more than once", "domain", domain, "ip", ip)
+		}
+		if weighted {
+			sortByWeight(resolved, weights)
+			if r.weights == nil {
+				r.weights = make(map[string]map[string]int)
+			}
+			r.weights[domain] = weights
+		}
+		splitDNS[domain] = resolved
+	}
+
+	return splitDNS, nil
+}
+
+// resolveJob is one entry for resolveEntries to resolve, and the domain it
+// was found in.
+type resolveJob struct {
+	domain string
+	ns     string
+}
+
+// resolveEntries resolves jobs with up to resolveWorkers of them at once,
+// storing what each entry resolves to in results. The first failure cancels
+// the jobs still running and is returned.
+func (r *resolver) resolveEntries(ctx context.Context, jobs []resolveJob, results map[string][]string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan resolveJob)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	for range min(max(resolveWorkers, 1), len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer reportPanic(ctx)
+			for job := range queue {
+				ips, err := r.resolveEntry(ctx, job.domain, job.ns)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				results[job.ns] = ips
+				mu.Unlock()
+			}
+		}()
+	}
+
+send:
+	for _, job := range jobs {
+		select {
+		case queue <- job:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	// the update was cancelled before every job was handed out
+	return ctx.Err()
+}
+
+// prefetchDevices lists the tailnet's devices if any uncached entry in
+// nameservers matches against them. The list is only fetched if something
+// actually needs it.
+func (r *resolver) prefetchDevices(ctx context.Context, nameservers []string) error {
+	for _, ns := range nameservers {
+		ns, _, _ := parseWeight(ns)
+		for _, alt := range strings.Split(ns, fallbackSep) {
+			e := parseEntry(alt)
+			if _, ok := r.cached(e); e.needsDevices() && !ok {
+				return r.listDevices(ctx)
+			}
+		}
+	}
+	return nil
+}
+
+// listDevices fetches the tailnet's devices, unless an earlier step of this
+// update already did or, with a device list TTL, an earlier update did
+// recently enough.
+func (r *resolver) listDevices(ctx context.Context) error {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+	if r.devices != nil {
+		return nil
+	}
+	ttl := time.Duration(r.defaults.DeviceListTTL)
+	if r.cache != nil && ttl > 0 {
+		if devs, ok := r.cache.getDevices(r.now); ok {
+			slog.DebugContext(ctx, "Using cached device list", "devices", len(devs))
+			r.devices = devs
+			return nil
+		}
+	}
+	devs, err := r.client.Devices().List(ctx)
+	if err != nil {
+		return fmt.Errorf("listing devices: %w", err)
+	}
+	if devs == nil {
+		devs = []tailscale.Device{}
+	}
+	r.devices = devs
+	if r.cache != nil && ttl > 0 {
+		r.cache.putDevices(devs, r.now.Add(ttl))
+	}
+	return nil
+}
+
+// resolveEntry resolves one nameserver entry from the config. An entry may
+// be a fallback chain, in which case the first alternative that resolves is
+// used.
+func (r *resolver) resolveEntry(ctx context.Context, domain, ns string) ([]string, error) {
+	var entries []entry
+	for _, alt := range strings.Split(ns, fallbackSep) {
+		e := parseEntry(alt)
+		if err := e.validate(); err != nil {
+			return nil, fmt.Errorf("resolving %s: %w", alt, err)
+		}
+		entries = append(entries, e)
+	}
+
+	var errs []error
+	for _, e := range entries {
+		if e.kind == "" {
+			return []string{e.raw}, nil
+		}
+
+		if ips, ok := r.cached(e); ok {
+			slog.InfoContext(ctx, "Resolved selector from cache", "domain", domain, "selector", e.raw, "ips", ips)
+			return ips, nil
+		}
+
+		start := time.Now()
+		spanCtx, sp := startSpan(ctx, "resolve selector", "domain", domain, "selector", e.raw)
+		ips, err := r.resolve(spanCtx, e)
+		sp.set("ips", strings.Join(ips, ","))
+		sp.finish(err)
+		if err == nil {
+			slog.InfoContext(ctx, "Resolved selector", "domain", domain, "selector", e.raw, "ips", ips, "duration", time.Since(start))
+			r.remember(e, ips)
+			return ips, nil
+		}
+		if len(entries) == 1 {
+			return nil, fmt.Errorf("resolving %s: %w", e.raw, err)
+		}
+		slog.WarnContext(ctx, "Failed to resolve selector, trying the next fallback", "domain", domain, "selector", e.raw, "duration", time.Since(start), "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", e.raw, err))
+	}
+	return nil, fmt.Errorf("resolving %s: every fallback failed: %w", ns, errors.Join(errs...))
+}
+
+// resolve returns the addresses a selector entry currently stands for.
+func (r *resolver) resolve(ctx context.Context, e entry) ([]string, error) {
+	if e.needsDevices() {
+		return r.resolveDevice(ctx, e)
+	}
+
+	switch e.kind {
+	case "svc":
+		return r.resolveService(ctx, e)
+	case "local":
+		return r.resolveLocal(ctx, e)
+	case "self":
+		return r.resolveSelf(ctx, e)
+	case "dns":
+		addrs, err := lookupHost(ctx, e.value, r.dnsServer(e))
+		if err != nil {
+			return nil, err
+		}
+		return filterFamily(addrs, r.family(e))
+	case "srv":
+		return r.resolveSRV(ctx, e)
+	case "k8s":
+		return r.resolveK8s(ctx, e)
+	case "docker":
+		return r.resolveDocker(ctx, e)
+	case "consul-svc":
+		return r.resolveConsul(ctx, e)
+	case "exec":
+		return r.resolveExec(ctx, e)
+	case "4via6":
+		return resolveVia(e.value)
+	}
+	return nil, fmt.Errorf("unknown selector %q", e.kind)
+}
+
+// resolveService resolves a Tailscale Service to its addresses. With ?tag=
+// it instead resolves to the addresses of the service's hosts that carry
+// the tag, for services hosted in more than one segment of the tailnet.
+func (r *resolver) resolveService(ctx context.Context, e entry) ([]string, error) {
+	name := "svc:" + e.value
+	tag := e.options.Get("tag")
+	if tag == "" {
+		addrs, err := getServiceAddrs(ctx, r.client, name)
+		if err != nil {
+			return nil, err
+		}
+		return filterFamily(addrs, r.family(e))
+	}
+
+	nodeIDs, err := getServiceHosts(ctx, r.client, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.listDevices(ctx); err != nil {
+		return nil, err
+	}
+	deviceIDs, err := r.deviceIDsByNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []tailscale.Device
+	for _, device := range r.devices {
+		if slices.ContainsFunc(nodeIDs, func(nodeID string) bool { return deviceIDs[nodeID] == device.ID }) {
+			hosts = append(hosts, device)
+		}
+	}
+	tagged, err := findTaggedDevices(tag, hosts)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", name, err)
+	}
+
+	var ips []string
+	for _, device := range tagged {
+		addrs, err := filterFamily(device.Addresses, r.family(e))
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %w", device.Name, err)
+		}
+		ips = append(ips, addrs...)
+	}
+	return ips, nil
+}
+
+// localStatus returns the local tailscaled's status, fetching it at most
+// once per update.
+func (r *resolver) localStatus(ctx context.Context) (*localStatus, error) {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+	if r.local == nil {
+		status, err := getLocalStatus(ctx, localAPISocket)
+		if err != nil {
+			return nil, err
+		}
+		r.local = status
+	}
+	return r.local, nil
+}
+
+// resolveSelf resolves to the tailnet address of the node tsddns runs on.
+// The entry's value, if any, is the address family.
+func (r *resolver) resolveSelf(ctx context.Context, e entry) ([]string, error) {
+	status, err := r.localStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status.Self == nil {
+		return nil, fmt.Errorf("tailscaled status has no self node")
+	}
+	family := e.value
+	if family == "" {
+		family = r.defaults.Family
+	}
+	return filterFamily(status.Self.TailscaleIPs, family)
+}
+
+// resolveLocal resolves a node from the status of the local tailscaled,
+// which needs no API access.
+func (r *resolver) resolveLocal(ctx context.Context, e entry) ([]string, error) {
+	status, err := r.localStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	peer, err := status.find(e.value)
+	if err != nil {
+		return nil, err
+	}
+	onlineOnly, err := r.onlineOnly(e)
+	if err != nil {
+		return nil, err
+	}
+	if onlineOnly && !peer.Online && peer != status.Self {
+		return nil, fmt.Errorf("node %s is offline", e.value)
+	}
+	return filterFamily(peer.TailscaleIPs, r.family(e))
+}
+
+// resolveDevice resolves the selectors that pick from the device list,
+// after dropping the devices the entry's options make ineligible.
+func (r *resolver) resolveDevice(ctx context.Context, e entry) ([]string, error) {
+	latestSeen := e.kind == "latest-seen"
+	if latestSeen {
+		var err error
+		if e, err = e.unwrap(); err != nil {
+			return nil, err
+		}
+	}
+
+	devices, skipped, err := r.eligibleDevices(ctx, e)
+	if err != nil {
+		return nil, err
+	}
+
+	matched, err := r.match(ctx, e, devices)
+	for _, device := range r.expiredMatches(ctx, e) {
+		slog.WarnContext(ctx, "Skipping device whose node key expired", "selector", e.raw, "device", device.Name, "expired", device.Expires)
+	}
+	if err != nil {
+		if skipped > 0 {
+			return nil, fmt.Errorf("%w (%d offline or ineligible devices were skipped)", err, skipped)
+		}
+		return nil, err
+	}
+
+	if latestSeen {
+		matched = []tailscale.Device{latestSeenDevice(matched)}
+	}
+
+	limit, err := r.maxDevices(e)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(matched) > limit {
+		matched = matched[:limit]
+	}
+
+	var ips []string
+	for _, device := range matched {
+		addrs := device.Addresses
+		if e.kind == "lan" {
+			addrs, err = r.lanAddrs(ctx, device, e.options.Get("route"))
+			if err != nil {
+				return nil, err
+			}
+		}
+		addrs, err = filterFamily(addrs, r.family(e))
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %w", device.Name, err)
+		}
+		ips = append(ips, addrs...)
+	}
+	return ips, nil
+}
+
+// match returns the devices the entry's selector picks out of devices.
+func (r *resolver) match(ctx context.Context, e entry, devices []tailscale.Device) ([]tailscale.Device, error) {
+	routes := func(device tailscale.Device) (*tailscale.DeviceRoutes, error) {
+		return r.deviceRoutes(ctx, device)
+	}
+	switch e.kind {
+	case "device":
+		return findDevices(e.value, devices)
+	case "nodeid":
+		ids, err := r.deviceIDsByNode(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return findNodeDevice(e.value, ids, devices)
+	case "tag":
+		return findTaggedDevices(e.value, devices)
+	case "user":
+		return findUserDevices(e.value, e.options.Get("host"), devices)
+	case "group":
+		members, err := r.groupMembers(ctx, e.value)
+		if err != nil {
+			return nil, err
+		}
+		return findGroupDevices(e.value, members, e.options.Get("tag"), e.options.Get("host"), devices)
+	case "exit-node":
+		return findExitNodes(e.value, devices, routes)
+	case "subnet-router":
+		return findSubnetRouters(e.value, devices, routes)
+	case "lan":
+		candidates, err := findDevices(e.value, devices)
+		if err != nil {
+			return nil, err
+		}
+		return lanRouters(e.value, candidates, routes)
+	case "app-connector":
+		policy, err := r.policy(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return findAppConnectors(e.value, policy.appConnectors(), devices)
+	}
+	return nil, fmt.Errorf("unknown selector %q", e.kind)
+}
+
+// expiredMatches returns the devices with expired keys the entry would
+// otherwise have picked, so they can be reported.
+func (r *resolver) expiredMatches(ctx context.Context, e entry) []tailscale.Device {
+	var expired []tailscale.Device
+	for _, device := range r.devices {
+		if keyExpired(device, r.now) {
+			expired = append(expired, device)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	matched, _ := r.match(ctx, e, expired)
+	return matched
+}
+
+// resolveK8s resolves a namespace/name entry to the addresses of a
+// Kubernetes Service.
+func (r *resolver) resolveK8s(ctx context.Context, e entry) ([]string, error) {
+	namespace, name, ok := strings.Cut(e.value, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("want namespace/service, got %q", e.value)
+	}
+	k, err := r.kube()
+	if err != nil {
+		return nil, err
+	}
+	svc, err := k.getService(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := svc.addrs(e.options.Get("ip"))
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", e.value, err)
+	}
+	return filterFamily(addrs, r.family(e))
+}
+
+// kube returns the Kubernetes API client, setting it up on first use.
+func (r *resolver) kube() (*k8sClient, error) {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+	if r.k8s == nil {
+		k, err := newInClusterK8s()
+		if err != nil {
+			return nil, err
+		}
+		r.k8s = k
+	}
+	return r.k8s, nil
+}
+
+// resolveDocker resolves a container name or label to the addresses of the
+// matching running containers.
+func (r *resolver) resolveDocker(ctx context.Context, e entry) ([]string, error) {
+	containers, err := findContainers(ctx, dockerSocket, e.value)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := r.maxDevices(e)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(containers) > limit {
+		containers = containers[:limit]
+	}
+
+	var ips []string
+	for _, c := range containers {
+		addrs, err := filterFamily(c.addrs(e.options.Get("network")), r.family(e))
+		if err != nil {
+			return nil, fmt.Errorf("container %s: %w", c.name(), err)
+		}
+		ips = append(ips, addrs...)
+	}
+	return ips, nil
+}
+
+// resolveConsul resolves a service in the Consul catalog to the addresses
+// of its healthy instances, one address per instance.
+func (r *resolver) resolveConsul(ctx context.Context, e entry) ([]string, error) {
+	addrs, err := getConsulService(ctx, consulAddr, consulToken, e.value, e.options.Get("tag"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []string
+	for _, addr := range addrs {
+		// an instance without an address of the wanted family is skipped
+		kept, err := filterFamily([]string{addr}, r.family(e))
+		if err != nil {
+			continue
+		}
+		ips = append(ips, kept...)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no healthy instances of %s with a usable address", e.value)
+	}
+
+	limit, err := r.maxDevices(e)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(ips) > limit {
+		ips = ips[:limit]
+	}
+	return ips, nil
+}
+
+// resolveExec runs an exec: entry's command and uses the addresses it
+// prints.
+func (r *resolver) resolveExec(ctx context.Context, e entry) ([]string, error) {
+	timeout := defaultExecTimeout
+	if v := e.options.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("bad timeout option %q", v)
+		}
+		timeout = d
+	}
+	addrs, err := runResolverCommand(ctx, e.value, timeout)
+	if err != nil {
+		return nil, err
+	}
+	// every printed address is used unless a family narrows them down
+	ips := addrs
+	if f := r.family(e); f != "" {
+		if ips, err = filterFamily(addrs, f); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.value, err)
+		}
+	}
+
+	limit, err := r.maxDevices(e)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(ips) > limit {
+		ips = ips[:limit]
+	}
+	return ips, nil
+}
+
+// resolveSRV resolves the targets of an SRV record, in priority order.
+func (r *resolver) resolveSRV(ctx context.Context, e entry) ([]string, error) {
+	targets, err := lookupSRV(ctx, e.value, r.dnsServer(e))
+	if err != nil {
+		return nil, err
+	}
+	limit, err := r.maxDevices(e)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(targets) > limit {
+		targets = targets[:limit]
+	}
+
+	var ips []string
+	for _, target := range targets {
+		addrs, err := lookupHost(ctx, target, r.dnsServer(e))
+		if err != nil {
+			return nil, err
+		}
+		addrs, err = filterFamily(addrs, r.family(e))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", target, err)
+		}
+		ips = append(ips, addrs...)
+	}
+	return ips, nil
+}
+
+// dnsServer is the DNS server the entry asks for, falling back to the
+// configured default.
+func (r *resolver) dnsServer(e entry) string {
+	if s := e.options.Get("server"); s != "" {
+		return s
+	}
+	return r.defaults.Resolver
+}
+
+// maxDevices is how many matching devices a selector may use, from the
+// entry's ?max= or the configured default. Zero means no limit.
+func (r *resolver) maxDevices(e entry) (int, error) {
+	v := e.options.Get("max")
+	if v == "" {
+		return r.defaults.MaxDevices, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad max option %q", v)
+	}
+	return n, nil
+}
+
+// family is the address family the entry asks for, falling back to the
+// configured default.
+func (r *resolver) family(e entry) string {
+	if f := e.options.Get("family"); f != "" {
+		return f
+	}
+	return r.defaults.Family
+}
+
+// eligibleDevices returns the devices the entry may pick from and how many
+// were filtered out.
+func (r *resolver) eligibleDevices(ctx context.Context, e entry) ([]tailscale.Device, int, error) {
+	onlineOnly, err := r.onlineOnly(e)
+	if err != nil {
+		return nil, 0, err
+	}
+	p, err := r.posture(e)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// devices with expired keys are never eligible; expiredMatches
+	// reports them
+	var devices []tailscale.Device
+	for _, device := range r.devices {
+		if !keyExpired(device, r.now) {
+			devices = append(devices, device)
+		}
+	}
+	if onlineOnly {
+		devices = onlineDevices(devices, r.defaults.onlineWindow(), r.now)
+	}
+	if !p.empty() {
+		var kept []tailscale.Device
+		for _, device := range devices {
+			reason, err := r.checkPosture(ctx, device, p)
+			if err != nil {
+				return nil, 0, err
+			}
+			if reason != "" {
+				slog.InfoContext(ctx, "Skipping device", "selector", e.raw, "device", device.Name, "reason", reason)
+				continue
+			}
+			kept = append(kept, device)
+		}
+		devices = kept
+	}
+	return devices, len(r.devices) - len(devices), nil
+}
+
+// onlineOnly reports whether the entry may only use online devices, from
+// its ?online= or the configured default.
+func (r *resolver) onlineOnly(e entry) (bool, error) {
+	v := e.options.Get("online")
+	if v == "" {
+		return r.defaults.OnlineOnly, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("bad online option %q: %w", v, err)
+	}
+	return b, nil
+}
+
+// duplicates returns the values that appear more than once in list.
+func duplicates(list []string) []string {
+	seen := make(map[string]int)
+	var dups []string
+	for _, v := range list {
+		seen[v]++
+		if seen[v] == 2 {
+			dups = append(dups, v)
+		}
+	}
+	return dups
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestParseEntry(t *testing.T) {
+	tests := []struct {
+		in        string
+		wantKind  string
+		wantValue string
+	}{
+		{in: "192.168.1.1"},
+		{in: "fd7a:115c:a1e0::53"},
+		{in: "https://dns.example.com/dns-query"},
+		{in: "svc:dns", wantKind: "svc", wantValue: "dns"},
+		{in: "device:ns1", wantKind: "device", wantValue: "ns1"},
+		{in: "tag:dns-server", wantKind: "tag", wantValue: "dns-server"},
+		{in: "user:alice@example.com?host=ns-*", wantKind: "user", wantValue: "alice@example.com"},
+		{in: "device:ns-?", wantKind: "device", wantValue: "ns-?"},
+		{in: "self", wantKind: "self"},
+		{in: "self:ipv4", wantKind: "self", wantValue: "ipv4"},
+		{in: `device:~^ns-?\d+$?max=2`, wantKind: "device", wantValue: `~^ns-?\d+$`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			e := parseEntry(tt.in)
+			if e.kind != tt.wantKind || e.value != tt.wantValue {
+				t.Errorf("parseEntry(%q) = %q, %q, want %q, %q", tt.in, e.kind, e.value, tt.wantKind, tt.wantValue)
+			}
+			if e.raw != tt.in {
+				t.Errorf("parseEntry(%q).raw = %q", tt.in, e.raw)
+			}
+		})
+	}
+}
+
+func TestEntryValidate(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{in: "192.168.1.1"},
+		{in: "user:alice@example.com?host=ns-*"},
+		{in: "user:alice@example.com?hots=ns-*", wantErr: true},
+		{in: "device:ns1?host=ns-*", wantErr: true},
+		{in: "user:alice@example.com?host=%zz", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			err := parseEntry(tt.in).validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveDeviceOnlineOnly(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	seen := func(ago time.Duration) tailscale.Time {
+		return tailscale.Time{Time: now.Add(-ago)}
+	}
+	devices := []tailscale.Device{
+		{Name: "laptop.example.ts.net", Hostname: "laptop", Tags: []string{"tag:dns"}, LastSeen: seen(7 * 24 * time.Hour), Addresses: []string{"100.64.0.1"}},
+		{Name: "server.example.ts.net", Hostname: "server", Tags: []string{"tag:dns"}, LastSeen: seen(time.Minute), Addresses: []string{"100.64.0.2"}},
+		{Name: "never.example.ts.net", Hostname: "never", Tags: []string{"tag:dns"}},
+	}
+
+	tests := []struct {
+		name     string
+		defaults Defaults
+		entry    string
+		wantIP   string
+		wantErr  bool
+	}{
+		{name: "filter off", entry: "tag:dns", wantIP: "100.64.0.1"},
+		{name: "global filter", defaults: Defaults{OnlineOnly: true}, entry: "tag:dns", wantIP: "100.64.0.2"},
+		{name: "entry enables filter", entry: "tag:dns?online=true", wantIP: "100.64.0.2"},
+		{name: "entry disables filter", defaults: Defaults{OnlineOnly: true}, entry: "tag:dns?online=false", wantIP: "100.64.0.1"},
+		{name: "wider window", defaults: Defaults{OnlineOnly: true, OnlineWindow: Duration(30 * 24 * time.Hour)}, entry: "device:laptop", wantIP: "100.64.0.1"},
+		{name: "offline device", defaults: Defaults{OnlineOnly: true}, entry: "device:laptop", wantErr: true},
+		{name: "bad option", entry: "device:laptop?online=maybe", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolver{defaults: tt.defaults, devices: devices, now: now}
+			ips, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && ips[0] != tt.wantIP {
+				t.Errorf("resolve() = %v, want %s", ips, tt.wantIP)
+			}
+		})
+	}
+}
+
+func TestResolveDeviceMax(t *testing.T) {
+	devices := []tailscale.Device{
+		{Name: "ns1.example.ts.net", Tags: []string{"tag:dns"}, Addresses: []string{"100.64.0.1", "fd7a:115c:a1e0::1"}},
+		{Name: "ns2.example.ts.net", Tags: []string{"tag:dns"}, Addresses: []string{"100.64.0.2", "fd7a:115c:a1e0::2"}},
+		{Name: "ns3.example.ts.net", Tags: []string{"tag:dns"}, Addresses: []string{"100.64.0.3", "fd7a:115c:a1e0::3"}},
+	}
+
+	tests := []struct {
+		name     string
+		defaults Defaults
+		entry    string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "every device", entry: "tag:dns", want: []string{"100.64.0.1", "100.64.0.2", "100.64.0.3"}},
+		{name: "entry cap", entry: "tag:dns?max=2", want: []string{"100.64.0.1", "100.64.0.2"}},
+		{name: "default cap", defaults: Defaults{MaxDevices: 1}, entry: "tag:dns", want: []string{"100.64.0.1"}},
+		{name: "entry lifts default cap", defaults: Defaults{MaxDevices: 1}, entry: "tag:dns?max=0", want: []string{"100.64.0.1", "100.64.0.2", "100.64.0.3"}},
+		{name: "cap with both families", entry: "tag:dns?max=1&family=both", want: []string{"100.64.0.1", "fd7a:115c:a1e0::1"}},
+		{name: "bad cap", entry: "tag:dns?max=-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolver{defaults: tt.defaults, devices: devices, now: time.Now()}
+			ips, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(ips, tt.want) {
+				t.Errorf("resolve() = %v, want %v", ips, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveFallbackChain(t *testing.T) {
+	devices := []tailscale.Device{
+		{Name: "ns-backup.example.ts.net", Hostname: "ns-backup", Addresses: []string{"100.64.0.2"}},
+	}
+
+	tests := []struct {
+		name    string
+		ns      string
+		want    []string
+		wantErr bool
+	}{
+		{name: "first alternative resolves", ns: "device:ns-backup || 10.0.0.53", want: []string{"100.64.0.2"}},
+		{name: "falls back to device", ns: "device:ns-primary || device:ns-backup || 10.0.0.53", want: []string{"100.64.0.2"}},
+		{name: "falls back to literal", ns: "device:ns-primary || 10.0.0.53", want: []string{"10.0.0.53"}},
+		{name: "every fallback fails", ns: "device:ns-primary || tag:dns", wantErr: true},
+		{name: "invalid alternative", ns: "device:ns-primary || device:ns-backup?bogus=1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolver{devices: devices, now: time.Now()}
+			ips, err := r.resolveEntry(context.Background(), "example.com", tt.ns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(ips, tt.want) {
+				t.Errorf("resolveEntry() = %v, want %v", ips, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveDeviceKeyExpired(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	devices := []tailscale.Device{
+		{Name: "ns1.example.ts.net", Hostname: "ns1", Tags: []string{"tag:dns"}, Expires: tailscale.Time{Time: now.Add(-24 * time.Hour)}, Addresses: []string{"100.64.0.1"}},
+		{Name: "ns2.example.ts.net", Hostname: "ns2", Tags: []string{"tag:dns"}, Expires: tailscale.Time{Time: now.Add(24 * time.Hour)}, Addresses: []string{"100.64.0.2"}},
+		{Name: "ns3.example.ts.net", Hostname: "ns3", Tags: []string{"tag:dns"}, Expires: tailscale.Time{Time: now.Add(-24 * time.Hour)}, KeyExpiryDisabled: true, Addresses: []string{"100.64.0.3"}},
+	}
+
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "expired devices skipped", entry: "tag:dns", want: []string{"100.64.0.2", "100.64.0.3"}},
+		{name: "expired device", entry: "device:ns1", wantErr: true},
+		{name: "expiry disabled", entry: "device:ns3", want: []string{"100.64.0.3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolver{devices: devices, now: now}
+			ips, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(ips, tt.want) {
+				t.Errorf("resolve() = %v, want %v", ips, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveLatestSeen(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	seen := func(ago time.Duration) tailscale.Time {
+		return tailscale.Time{Time: now.Add(-ago)}
+	}
+	devices := []tailscale.Device{
+		{Name: "desktop.example.ts.net", Hostname: "desktop", Tags: []string{"tag:resolver"}, LastSeen: seen(time.Hour), Addresses: []string{"100.64.0.1"}},
+		{Name: "laptop.example.ts.net", Hostname: "laptop", Tags: []string{"tag:resolver"}, LastSeen: seen(time.Minute), Addresses: []string{"100.64.0.2", "fd7a:115c:a1e0::2"}},
+		{Name: "spare.example.ts.net", Hostname: "spare", Tags: []string{"tag:resolver"}, Addresses: []string{"100.64.0.3"}},
+	}
+
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "tag", entry: "latest-seen:tag:resolver", want: []string{"100.64.0.2"}},
+		{name: "device glob with options", entry: "latest-seen:device:*top?family=both", want: []string{"100.64.0.2", "fd7a:115c:a1e0::2"}},
+		{name: "never seen", entry: "latest-seen:device:spare", want: []string{"100.64.0.3"}},
+		{name: "not a device selector", entry: "latest-seen:svc:dns", wantErr: true},
+		{name: "option the inner selector doesn't take", entry: "latest-seen:tag:resolver?host=laptop", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolver{devices: devices, now: now}
+			ips, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(ips, tt.want) {
+				t.Errorf("resolve() = %v, want %v", ips, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveAllConcurrently(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v2/tailnet/test/services/svc:"), "/")
+		if name == "broken" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(ServiceInfo{Name: "svc:" + name, Addrs: []string{"100.64.0." + strings.TrimPrefix(name, "ns")}})
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	client := &tailscale.Client{BaseURL: baseURL, APIKey: "test-key", Tailnet: "test"}
+
+	defer func(n int) { resolveWorkers = n }(resolveWorkers)
+	resolveWorkers = 3
+
+	cfg := Config{
+		"a.example.com": {"svc:ns1", "svc:ns2", "10.0.0.53", "svc:ns3"},
+		"b.example.com": {"svc:ns4", "svc:ns5", "svc:ns6"},
+		"c.example.com": {"svc:ns6", "svc:ns1"},
+	}
+	splitDNS, err := newResolver(client, Defaults{}).resolveAll(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("resolveAll() unexpected error: %v", err)
+	}
+	want := map[string][]string{
+		"a.example.com": {"100.64.0.1", "100.64.0.2", "10.0.0.53", "100.64.0.3"},
+		"b.example.com": {"100.64.0.4", "100.64.0.5", "100.64.0.6"},
+		"c.example.com": {"100.64.0.6", "100.64.0.1"},
+	}
+	for domain, nameservers := range want {
+		if !slices.Equal(splitDNS[domain], nameservers) {
+			t.Errorf("%s = %v, want %v in config order", domain, splitDNS[domain], nameservers)
+		}
+	}
+	if got := peak.Load(); got != 3 {
+		t.Errorf("peak concurrent lookups = %d, want 3", got)
+	}
+
+	cfg["b.example.com"] = []string{"svc:broken"}
+	if _, err := newResolver(client, Defaults{}).resolveAll(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "svc:broken") {
+		t.Errorf("resolveAll() error = %v, want svc:broken's failure", err)
+	}
+}
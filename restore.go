@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// errRolledBack marks an update that failed after writing split DNS and
+// put the domains it changed back to their last known good nameservers.
+var errRolledBack = errors.New("restored the last known good split DNS")
+
+// errRestoreFailed marks an update that failed after writing split DNS and
+// couldn't put it back either, so the tailnet may be left half updated.
+var errRestoreFailed = errors.New("restoring the last known good split DNS failed")
+
+// verifyApplied reads split DNS back after an update and checks that every
+// domain of changes has the nameservers it was changed to.
+func verifyApplied(ctx context.Context, backend Backend, changes []domainChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	current, err := backend.Current(ctx)
+	if err != nil {
+		return fmt.Errorf("verifying split DNS: %w", err)
+	}
+	var wrong []string
+	for _, c := range changes {
+		got := current[c.Domain]
+		if !slices.Equal(slices.Sorted(slices.Values(got)), slices.Sorted(slices.Values(c.New))) {
+			wrong = append(wrong, fmt.Sprintf("%s has %v instead of %v", c.Domain, got, c.New))
+		}
+	}
+	if len(wrong) > 0 {
+		return fmt.Errorf("verifying split DNS: %s", strings.Join(wrong, ", "))
+	}
+	return nil
+}
+
+// lastKnownGood returns what the domains of changes should be put back to:
+// what the last successful update applied to them, as recorded in the
+// state file, or else what they had before this update. A nil entry means
+// the domain shouldn't exist.
+func lastKnownGood(changes []domainChange, applied *appliedState) map[string][]string {
+	good := make(map[string][]string)
+	for _, c := range changes {
+		good[c.Domain] = c.Old
+		if applied != nil {
+			if d, ok := applied.Domains[c.Domain]; ok {
+				good[c.Domain] = d.Nameservers
+			}
+		}
+	}
+	return good
+}
+
+// restoreLastKnownGood handles an update whose split DNS changes failed to
+// apply or to verify, after its transaction was rolled back. It re-applies
+// the last known good nameservers of the changed domains, in case the
+// rollback missed some or split DNS was changed outside tsddns before,
+// and returns err marked with errRolledBack, or errRestoreFailed if that
+// failed too. wrote is whether the update had written anything before it
+// failed; if it hadn't and nothing needed restoring, err is returned as it
+// is.
+func restoreLastKnownGood(ctx context.Context, backend Backend, changes []domainChange, applied *appliedState, wrote bool, err error) error {
+	if len(changes) == 0 {
+		return err
+	}
+	// a cancelled update still needs to be put back
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	restored, rErr := applyLastKnownGood(ctx, backend, lastKnownGood(changes, applied))
+	if rErr != nil {
+		slog.ErrorContext(ctx, "Restoring the last known good split DNS failed, the tailnet may be half updated", "error", rErr)
+		return fmt.Errorf("%w; %w: %w", err, errRestoreFailed, rErr)
+	}
+	if !wrote && restored == 0 {
+		return err
+	}
+	slog.WarnContext(ctx, "Restored the last known good split DNS", "domains", restored)
+	return fmt.Errorf("%w; %w", err, errRolledBack)
+}
+
+// applyLastKnownGood writes good over the domains it lists and returns how
+// many of them needed it.
+func applyLastKnownGood(ctx context.Context, backend Backend, good map[string][]string) (int, error) {
+	current, err := backend.Current(ctx)
+	if err != nil {
+		return 0, err
+	}
+	desired := maps.Clone(current)
+	if desired == nil {
+		desired = make(map[string][]string)
+	}
+	for domain, ns := range good {
+		if ns == nil {
+			delete(desired, domain)
+		} else {
+			desired[domain] = ns
+		}
+	}
+	changes := backend.Plan(current, desired)
+	if len(changes) == 0 {
+		return 0, nil
+	}
+	var tx transaction
+	if err := backend.Apply(ctx, &tx, changes); err != nil {
+		return 0, err
+	}
+	if err := tx.commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(changes), nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// memBackend is a Backend holding split DNS in memory. Writes fail once
+// failWrites is set.
+type memBackend struct {
+	splitDNS   map[string][]string
+	failWrites bool
+}
+
+func (b *memBackend) Current(context.Context) (map[string][]string, error) {
+	return maps.Clone(b.splitDNS), nil
+}
+
+func (b *memBackend) Plan(current, desired map[string][]string) []domainChange {
+	return diffSplitDNS(current, desired)
+}
+
+func (b *memBackend) Apply(_ context.Context, tx *transaction, changes []domainChange) error {
+	tx.add("memory", func(context.Context) error {
+		if b.failWrites {
+			return errors.New("write failed")
+		}
+		for _, c := range changes {
+			if c.New == nil {
+				delete(b.splitDNS, c.Domain)
+			} else {
+				b.splitDNS[c.Domain] = c.New
+			}
+		}
+		return nil
+	}, nil)
+	return nil
+}
+
+func TestVerifyApplied(t *testing.T) {
+	b := &memBackend{splitDNS: map[string][]string{
+		"a.example.com": {"100.64.0.1", "100.64.0.2"},
+		"b.example.com": {"100.64.0.3"},
+	}}
+	tests := []struct {
+		name    string
+		changes []domainChange
+		wantErr bool
+	}{
+		{name: "no changes"},
+		{name: "applied", changes: []domainChange{{Domain: "b.example.com", New: []string{"100.64.0.3"}}}},
+		{name: "reordered", changes: []domainChange{{Domain: "a.example.com", New: []string{"100.64.0.2", "100.64.0.1"}}}},
+		{name: "removed", changes: []domainChange{{Domain: "c.example.com", Old: []string{"100.64.0.4"}}}},
+		{name: "not applied", changes: []domainChange{{Domain: "b.example.com", Old: []string{"100.64.0.3"}, New: []string{"100.64.0.5"}}}, wantErr: true},
+		{name: "not removed", changes: []domainChange{{Domain: "b.example.com", Old: []string{"100.64.0.3"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyApplied(context.Background(), b, tt.changes); (err != nil) != tt.wantErr {
+				t.Errorf("verifyApplied() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestoreLastKnownGood(t *testing.T) {
+	failed := errors.New("applying search paths: 500")
+	changes := []domainChange{
+		{Domain: "a.example.com", Old: []string{"100.64.0.1"}, New: []string{"100.64.0.2"}},
+		{Domain: "new.example.com", New: []string{"100.64.0.3"}},
+	}
+	tests := []struct {
+		name    string
+		current map[string][]string
+		applied *appliedState
+		wrote   bool
+		want    map[string][]string
+		wantErr error
+	}{
+		{
+			name:    "half applied",
+			current: map[string][]string{"a.example.com": {"100.64.0.2"}, "other.example.com": {"100.64.0.9"}},
+			wrote:   true,
+			want:    map[string][]string{"a.example.com": {"100.64.0.1"}, "other.example.com": {"100.64.0.9"}},
+			wantErr: errRolledBack,
+		},
+		{
+			name:    "back to what was applied last",
+			current: map[string][]string{"a.example.com": {"100.64.0.1"}, "new.example.com": {"100.64.0.3"}},
+			applied: &appliedState{Domains: map[string]appliedDomain{"a.example.com": {Nameservers: []string{"100.64.0.7"}}}},
+			wrote:   true,
+			want:    map[string][]string{"a.example.com": {"100.64.0.7"}},
+			wantErr: errRolledBack,
+		},
+		{
+			name:    "rolled back already",
+			current: map[string][]string{"a.example.com": {"100.64.0.1"}},
+			wrote:   true,
+			want:    map[string][]string{"a.example.com": {"100.64.0.1"}},
+			wantErr: errRolledBack,
+		},
+		{
+			name:    "nothing written",
+			current: map[string][]string{"a.example.com": {"100.64.0.1"}},
+			want:    map[string][]string{"a.example.com": {"100.64.0.1"}},
+			wantErr: failed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &memBackend{splitDNS: tt.current}
+			err := restoreLastKnownGood(context.Background(), b, changes, tt.applied, tt.wrote, failed)
+			if !errors.Is(err, failed) || !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == failed && errors.Is(err, errRolledBack) {
+				t.Errorf("error = %v, want it not marked rolled back", err)
+			}
+			if !maps.EqualFunc(b.splitDNS, tt.want, slices.Equal) {
+				t.Errorf("split DNS = %v, want %v", b.splitDNS, tt.want)
+			}
+		})
+	}
+
+	t.Run("restore fails", func(t *testing.T) {
+		b := &memBackend{splitDNS: map[string][]string{"a.example.com": {"100.64.0.2"}}, failWrites: true}
+		err := restoreLastKnownGood(context.Background(), b, changes, nil, true, failed)
+		if !errors.Is(err, failed) || !errors.Is(err, errRestoreFailed) || errors.Is(err, errRolledBack) {
+			t.Errorf("error = %v, want it marked as a failed restore", err)
+		}
+	})
+}
+
+func TestUpdateDNSRollsBackUnverified(t *testing.T) {
+	// the API accepts the write but doesn't apply it
+	var patches int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PATCH" {
+			patches++
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"corp.example.com": {"100.64.0.1"}})
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	client := &tailscale.Client{BaseURL: serverURL, Tailnet: "test", APIKey: "test-key"}
+	notify, payloads, _ := notificationServer(t)
+	cfg := &ConfigFile{
+		Domains: Config{"corp.example.com": {"100.64.0.2"}},
+		Notify:  []Notification{{URL: notify.URL}},
+	}
+
+	err := updateDNS(context.Background(), client, cfg)
+	if !errors.Is(err, errRolledBack) {
+		t.Fatalf("updateDNS() error = %v, want it rolled back", err)
+	}
+	// the write, then the transaction's rollback; the last known good
+	// mapping is already in place
+	if patches != 2 {
+		t.Errorf("made %d patches, want 2", patches)
+	}
+	if len(*payloads) != 1 || (*payloads)[0].Event != "rolledBack" {
+		t.Errorf("sent %+v, want one rolledBack notification", *payloads)
+	}
+}
+
+func TestEmailRollback(t *testing.T) {
+	addr, messages := smtpServer(t)
+	e := &Email{Server: addr, From: "tsddns@example.com", To: []string{"ops@example.com"}}
+	consecutiveFailures = 0
+	defer func() { consecutiveFailures = 0 }()
+
+	emailOutcome(context.Background(), "example.com", e, errors.Join(errors.New("verifying split DNS"), errRolledBack))
+	select {
+	case m := <-messages:
+		if want := "Subject: [tsddns] Rolled back split DNS for example.com"; !strings.Contains(m, want) {
+			t.Errorf("email doesn't contain %q:\n%s", want, m)
+		}
+	default:
+		t.Fatal("no email after a rollback")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Retry policy for Tailscale API calls. A failed call is retried up to
+// apiRetries times, waiting apiRetryBase and then twice as long each time,
+// up to apiRetryMax.
+const (
+	apiRetries   = 4
+	apiRetryBase = time.Second
+	apiRetryMax  = 30 * time.Second
+)
+
+// retryTransport retries requests that failed in a way that may succeed on
+// a second try: network errors, rate limiting and server errors. Other
+// responses, like 401 and 403 for bad credentials, are returned as they
+// are.
+type retryTransport struct {
+	next  http.RoundTripper
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newRetryTransport(next http.RoundTripper) *retryTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &retryTransport{next: next, sleep: sleepContext}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	ctx := req.Context()
+	_, sp := startSpanKind(ctx, "Tailscale API "+req.Method, spanKindClient, "http.request.method", req.Method, "url.path", req.URL.Path)
+	defer func() {
+		if resp != nil {
+			sp.set("http.response.status_code", resp.StatusCode)
+		}
+		sp.finish(err)
+	}()
+
+	for attempt := 0; ; attempt++ {
+		try := req
+		if attempt > 0 && req.Body != nil {
+			try = req.Clone(ctx)
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			try.Body = body
+		}
+		resp, err := t.next.RoundTrip(try)
+
+		// a body that can't be replayed can't be retried either
+		if attempt == apiRetries || !retryable(resp, err) || ctx.Err() != nil || req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+		delay := retryDelay(attempt, resp)
+		sp.set("retries", attempt+1)
+		if err != nil {
+			slog.WarnContext(ctx, "Tailscale API call failed, retrying", "method", req.Method, "path", req.URL.Path, "delay", delay, "error", err)
+		} else {
+			slog.WarnContext(ctx, "Tailscale API call failed, retrying", "method", req.Method, "path", req.URL.Path, "delay", delay, "status", resp.StatusCode)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := t.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryable reports whether a request that got resp or err is worth
+// retrying.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay is how long to wait before retry attempt+1: the server's
+// Retry-After if it sent one, otherwise exponential backoff with jitter,
+// capped at apiRetryMax either way.
+func retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			return min(time.Duration(secs)*time.Second, apiRetryMax)
+		}
+	}
+	d := min(apiRetryBase<<attempt, apiRetryMax)
+	// half fixed, half random, so instances failing together spread out
+	return d/2 + rand.N(d/2+1)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		wantStatus int
+		wantCalls  int
+	}{
+		{name: "success", statuses: []int{200}, wantStatus: 200, wantCalls: 1},
+		{name: "transient 502", statuses: []int{502, 503, 200}, wantStatus: 200, wantCalls: 3},
+		{name: "rate limited", statuses: []int{429, 200}, wantStatus: 200, wantCalls: 2},
+		{name: "unauthorized is fatal", statuses: []int{401, 200}, wantStatus: 401, wantCalls: 1},
+		{name: "forbidden is fatal", statuses: []int{403, 200}, wantStatus: 403, wantCalls: 1},
+		{name: "gives up", statuses: []int{500, 500, 500, 500, 500, 500}, wantStatus: 500, wantCalls: apiRetries + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				w.WriteHeader(tt.statuses[calls])
+				calls++
+			}))
+			defer server.Close()
+
+			var delays []time.Duration
+			transport := newRetryTransport(nil)
+			transport.sleep = func(_ context.Context, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			}
+			req, _ := http.NewRequest("PATCH", server.URL, strings.NewReader(`{"corp.example.com":["100.64.0.1"]}`))
+			resp, err := (&http.Client{Transport: transport}).Do(req)
+			if err != nil {
+				t.Fatalf("Do() unexpected error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus || calls != tt.wantCalls {
+				t.Errorf("status %d after %d calls, want %d after %d", resp.StatusCode, calls, tt.wantStatus, tt.wantCalls)
+			}
+			for i, body := range bodies {
+				if body != bodies[0] {
+					t.Errorf("attempt %d sent body %q, want %q", i+1, body, bodies[0])
+				}
+			}
+			for i, d := range delays {
+				if limit := min(apiRetryBase<<i, apiRetryMax); d < limit/2 || d > limit {
+					t.Errorf("delay %d = %v, want between %v and %v", i+1, d, limit/2, limit)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryTransportNetworkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	var calls int
+	transport := newRetryTransport(nil)
+	transport.sleep = func(context.Context, time.Duration) error {
+		calls++
+		return nil
+	}
+	if _, err := (&http.Client{Transport: transport}).Get(url); err == nil {
+		t.Fatal("Get() of a closed server succeeded")
+	}
+	if calls != apiRetries {
+		t.Errorf("retried %d times, want %d", calls, apiRetries)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"Retry-After": {"7"}}}
+	if got := retryDelay(0, resp); got != 7*time.Second {
+		t.Errorf("retryDelay() with Retry-After = %v, want 7s", got)
+	}
+	resp.Header.Set("Retry-After", "3600")
+	if got := retryDelay(0, resp); got != apiRetryMax {
+		t.Errorf("retryDelay() with a long Retry-After = %v, want %v", got, apiRetryMax)
+	}
+	if got := retryDelay(20, nil); got < apiRetryMax/2 || got > apiRetryMax {
+		t.Errorf("retryDelay() after many attempts = %v, want at most %v", got, apiRetryMax)
+	}
+}
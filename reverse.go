@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// ReverseZones configures reverse DNS zones generated from address ranges.
+type ReverseZones struct {
+	// Nameservers are resolved like a domain's nameservers and used for
+	// every generated zone.
+	Nameservers Nameservers `json:"nameservers"`
+
+	// Prefixes are the address ranges to generate zones for. Defaults to
+	// the ranges Tailscale assigns node addresses from.
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+func (rz *ReverseZones) validate() error {
+	if len(rz.Nameservers) == 0 {
+		return fmt.Errorf("no nameservers")
+	}
+	_, err := rz.zones()
+	return err
+}
+
+// zones returns the reverse zones covering every prefix.
+func (rz *ReverseZones) zones() ([]string, error) {
+	prefixes := tailnetPrefixes
+	if len(rz.Prefixes) > 0 {
+		prefixes = nil
+		for _, s := range rz.Prefixes {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("bad prefix: %w", err)
+			}
+			prefixes = append(prefixes, p)
+		}
+	}
+
+	var zones []string
+	for _, p := range prefixes {
+		zones = append(zones, reverseZones(p)...)
+	}
+	return zones, nil
+}
+
+// reverseZones returns the in-addr.arpa or ip6.arpa zones that together
+// cover p. Zones are delegated per octet for IPv4 and per nibble for IPv6,
+// so a prefix that doesn't end on such a boundary expands to several zones,
+// such as 100.64.0.0/10 to 64.100.in-addr.arpa through 127.100.in-addr.arpa.
+func reverseZones(p netip.Prefix) []string {
+	p = p.Masked()
+	step := 8
+	if p.Addr().Is6() {
+		step = 4
+	}
+	bits := (p.Bits() + step - 1) / step * step
+
+	var zones []string
+	for addr := p.Addr(); p.Contains(addr); {
+		zones = append(zones, reverseZoneName(addr, bits))
+		next, ok := nextPrefixAddr(addr, bits)
+		if !ok {
+			break
+		}
+		addr = next
+	}
+	return zones
+}
+
+// reverseZoneName returns the reverse zone for the first bits of addr,
+// which end on an octet boundary for IPv4 and a nibble boundary for IPv6.
+func reverseZoneName(addr netip.Addr, bits int) string {
+	var labels []string
+	if addr.Is4() {
+		a := addr.As4()
+		for i := 0; i < bits/8; i++ {
+			labels = append(labels, fmt.Sprint(a[i]))
+		}
+		slices.Reverse(labels)
+		return strings.Join(append(labels, "in-addr.arpa"), ".")
+	}
+	a := addr.As16()
+	for i := 0; i < bits/4; i++ {
+		nibble := a[i/2] >> 4
+		if i%2 == 1 {
+			nibble = a[i/2] & 0xf
+		}
+		labels = append(labels, fmt.Sprintf("%x", nibble))
+	}
+	slices.Reverse(labels)
+	return strings.Join(append(labels, "ip6.arpa"), ".")
+}
+
+// nextPrefixAddr returns the first address of the /bits prefix after the
+// one addr starts, or false if there is none.
+func nextPrefixAddr(addr netip.Addr, bits int) (netip.Addr, bool) {
+	b := addr.AsSlice()
+	// add one at the last bit of the prefix, carrying upwards
+	bit := bits - 1
+	for bit >= 0 {
+		i, mask := bit/8, byte(1)<<(7-bit%8)
+		b[i] ^= mask
+		if b[i]&mask != 0 {
+			next, _ := netip.AddrFromSlice(b)
+			return next, true
+		}
+		bit--
+	}
+	return netip.Addr{}, false
+}
+
+// addReverseZones adds a domain for every zone rz generates to domains and
+// returns the result. Zones the config already lists, or that isExcluded
+// reports, are left alone.
+func addReverseZones(rz *ReverseZones, domains Config, isExcluded func(string) bool) (Config, error) {
+	zones, err := rz.zones()
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make(map[string]bool, len(domains))
+	merged := make(Config, len(domains)+len(zones))
+	for domain, nameservers := range domains {
+		listed[normalizeDomain(domain)] = true
+		merged[domain] = nameservers
+	}
+	for _, zone := range zones {
+		if listed[zone] || isExcluded(zone) {
+			slog.Info("Not generating reverse zone, it is configured or excluded", "domain", zone)
+			continue
+		}
+		merged[zone] = rz.Nameservers
+	}
+	return merged, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestReverseZones(t *testing.T) {
+	cgnat := make([]string, 0, 64)
+	for i := 64; i < 128; i++ {
+		cgnat = append(cgnat, fmt.Sprintf("%d.100.in-addr.arpa", i))
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{name: "octet boundary", prefix: "10.20.0.0/16", want: []string{"20.10.in-addr.arpa"}},
+		{name: "cgnat range", prefix: "100.64.0.0/10", want: cgnat},
+		{name: "unmasked", prefix: "192.168.1.7/23", want: []string{"0.168.192.in-addr.arpa", "1.168.192.in-addr.arpa"}},
+		{name: "tailscale ula", prefix: "fd7a:115c:a1e0::/48", want: []string{"0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa"}},
+		{name: "nibble rounding", prefix: "fd00::/7", want: []string{"c.f.ip6.arpa", "d.f.ip6.arpa"}},
+		{name: "single address", prefix: "10.1.2.3/32", want: []string{"3.2.1.10.in-addr.arpa"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := reverseZones(netip.MustParsePrefix(tt.prefix))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("reverseZones() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddReverseZones(t *testing.T) {
+	rz := &ReverseZones{
+		Nameservers: Nameservers{"tag:dns"},
+		Prefixes:    []string{"10.1.0.0/16", "10.2.0.0/16", "10.3.0.0/16"},
+	}
+	domains := Config{
+		"example.com":        {"10.0.0.53"},
+		"1.10.in-addr.arpa.": {"10.0.0.1"},
+	}
+	isExcluded := func(domain string) bool { return domain == "3.10.in-addr.arpa" }
+
+	got, err := addReverseZones(rz, domains, isExcluded)
+	if err != nil {
+		t.Fatalf("addReverseZones() unexpected error: %v", err)
+	}
+	want := Config{
+		"example.com":        {"10.0.0.53"},
+		"1.10.in-addr.arpa.": {"10.0.0.1"},
+		"2.10.in-addr.arpa":  {"tag:dns"},
+	}
+	if !maps.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("addReverseZones() = %v, want %v", got, want)
+	}
+}
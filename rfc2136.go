@@ -0,0 +1,266 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRecordTTL = 5 * time.Minute
+	rfc2136Timeout   = 10 * time.Second
+	tsigFudge        = 300
+)
+
+// tsigAlgorithms are the TSIG algorithms updates can be signed with.
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1":   sha1.New,
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+}
+
+// RFC2136 configures A and AAAA records pushed to a nameserver with
+// dynamic updates.
+type RFC2136 struct {
+	// Server is the primary nameserver for Zone, as host or host:port.
+	Server string `json:"server"`
+
+	// Zone is the zone the records are in.
+	Zone string `json:"zone"`
+
+	// TTL is the records' TTL. Defaults to 5m.
+	TTL Duration `json:"ttl,omitempty"`
+
+	// TSIG, if set, signs the updates.
+	TSIG *TSIG `json:"tsig,omitempty"`
+
+	// Records replace the A and AAAA records their names had.
+	Records RecordSet `json:"records"`
+}
+
+// TSIG is a TSIG key for signing dynamic updates.
+type TSIG struct {
+	Name string `json:"name"`
+
+	// Algorithm is "hmac-sha256" (the default), "hmac-sha512" or
+	// "hmac-sha1".
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// SecretEnv names the environment variable holding the base64 secret.
+	SecretEnv string `json:"secretEnv"`
+}
+
+func (u *RFC2136) validate() error {
+	if u.Server == "" || u.Zone == "" {
+		return fmt.Errorf("needs a server and a zone")
+	}
+	zone := normalizeDomain(u.Zone)
+	for name := range u.Records {
+		if n := normalizeDomain(name); n != zone && !strings.HasSuffix(n, "."+zone) {
+			return fmt.Errorf("record %s is not in zone %s", name, u.Zone)
+		}
+	}
+	if u.TSIG != nil {
+		if _, ok := tsigAlgorithms[cmp.Or(u.TSIG.Algorithm, "hmac-sha256")]; !ok {
+			return fmt.Errorf("unsupported TSIG algorithm %q", u.TSIG.Algorithm)
+		}
+		if u.TSIG.Name == "" || u.TSIG.SecretEnv == "" {
+			return fmt.Errorf("TSIG needs a name and a secretEnv")
+		}
+	}
+	return nil
+}
+
+// sync resolves the configured records and replaces them on the
+// server in one update.
+func (u *RFC2136) sync(ctx context.Context, r *resolver, _ map[string][]string) error {
+	records, err := r.resolveAll(ctx, u.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+
+	msg, id, err := buildUpdate(u.Zone, records, time.Duration(cmp.Or(u.TTL, Duration(defaultRecordTTL))))
+	if err != nil {
+		return err
+	}
+	if u.TSIG != nil {
+		secret, err := base64.StdEncoding.DecodeString(os.Getenv(u.TSIG.SecretEnv))
+		if err != nil || len(secret) == 0 {
+			return fmt.Errorf("TSIG secret in %s is not set or not base64", u.TSIG.SecretEnv)
+		}
+		msg, err = signTSIG(msg, u.TSIG.Name, cmp.Or(u.TSIG.Algorithm, "hmac-sha256"), secret, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	server := u.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	slog.InfoContext(ctx, "Updating records with RFC2136", "zone", u.Zone, "server", server, "records", len(records))
+	if err := sendUpdate(ctx, server, msg, id); err != nil {
+		return fmt.Errorf("updating %s on %s: %w", u.Zone, server, err)
+	}
+	slog.InfoContext(ctx, "Successfully updated records with RFC2136", "zone", u.Zone)
+	return nil
+}
+
+// buildUpdate returns a dynamic update message that replaces the A and
+// AAAA records of every name in records, and its ID.
+func buildUpdate(zone string, records map[string][]string, ttl time.Duration) ([]byte, uint16, error) {
+	var idBytes [2]byte
+	rand.Read(idBytes[:])
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	names := make([]string, 0, len(records))
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var updates []byte
+	count := 0
+	for _, name := range names {
+		owner, err := appendName(nil, name)
+		if err != nil {
+			return nil, 0, err
+		}
+		// delete the name's address RRsets, then add the new addresses
+		for _, rrtype := range []uint16{1, 28} {
+			updates = append(updates, owner...)
+			updates = binary.BigEndian.AppendUint16(updates, rrtype)
+			updates = binary.BigEndian.AppendUint16(updates, 255) // class ANY
+			updates = append(updates, 0, 0, 0, 0, 0, 0)           // TTL and RDLENGTH 0
+			count++
+		}
+		for _, a := range records[name] {
+			addr, err := netip.ParseAddr(a)
+			if err != nil {
+				return nil, 0, fmt.Errorf("record %s: %q is not an IP address", name, a)
+			}
+			rrtype := uint16(28)
+			if addr.Is4() {
+				rrtype = 1
+			}
+			updates = append(updates, owner...)
+			updates = binary.BigEndian.AppendUint16(updates, rrtype)
+			updates = binary.BigEndian.AppendUint16(updates, 1) // class IN
+			updates = binary.BigEndian.AppendUint32(updates, uint32(ttl/time.Second))
+			updates = binary.BigEndian.AppendUint16(updates, uint16(addr.BitLen()/8))
+			updates = append(updates, addr.AsSlice()...)
+			count++
+		}
+	}
+
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = append(msg, 5<<3, 0)                              // opcode UPDATE
+	msg = binary.BigEndian.AppendUint16(msg, 1)             // zone
+	msg = binary.BigEndian.AppendUint16(msg, 0)             // prerequisites
+	msg = binary.BigEndian.AppendUint16(msg, uint16(count)) // updates
+	msg = binary.BigEndian.AppendUint16(msg, 0)             // additional
+	msg, err := appendName(msg, zone)
+	if err != nil {
+		return nil, 0, err
+	}
+	msg = binary.BigEndian.AppendUint16(msg, 6) // SOA
+	msg = binary.BigEndian.AppendUint16(msg, 1) // class IN
+	return append(msg, updates...), id, nil
+}
+
+// signTSIG appends a TSIG record signing msg, as described in RFC 8945.
+func signTSIG(msg []byte, keyName, algorithm string, secret []byte, now time.Time) ([]byte, error) {
+	key, err := appendName(nil, strings.ToLower(keyName))
+	if err != nil {
+		return nil, err
+	}
+	alg, err := appendName(nil, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	signed := uint64(now.Unix())
+	timeSigned := []byte{byte(signed >> 40), byte(signed >> 32), byte(signed >> 24), byte(signed >> 16), byte(signed >> 8), byte(signed)}
+
+	mac := hmac.New(tsigAlgorithms[algorithm], secret)
+	mac.Write(msg)
+	mac.Write(key)
+	mac.Write([]byte{0, 255, 0, 0, 0, 0}) // class ANY, TTL 0
+	mac.Write(alg)
+	mac.Write(timeSigned)
+	mac.Write(binary.BigEndian.AppendUint16(nil, tsigFudge))
+	mac.Write([]byte{0, 0, 0, 0}) // no error or other data
+	sum := mac.Sum(nil)
+
+	rdata := append(alg, timeSigned...)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, msg[0], msg[1]) // original ID
+	rdata = append(rdata, 0, 0, 0, 0)     // no error or other data
+
+	out := append([]byte{}, msg...)
+	binary.BigEndian.PutUint16(out[10:], binary.BigEndian.Uint16(msg[10:])+1)
+	out = append(out, key...)
+	out = binary.BigEndian.AppendUint16(out, 250) // TSIG
+	out = binary.BigEndian.AppendUint16(out, 255) // class ANY
+	out = append(out, 0, 0, 0, 0)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(rdata)))
+	return append(out, rdata...), nil
+}
+
+// rcodeNames are the response codes an update can fail with.
+var rcodeNames = map[byte]string{
+	1: "FORMERR", 2: "SERVFAIL", 3: "NXDOMAIN", 4: "NOTIMP", 5: "REFUSED",
+	6: "YXDOMAIN", 7: "YXRRSET", 8: "NXRRSET", 9: "NOTAUTH", 10: "NOTZONE",
+}
+
+// sendUpdate sends an update over TCP and checks the server accepted it.
+func sendUpdate(ctx context.Context, server string, msg []byte, id uint16) error {
+	ctx, cancel := context.WithTimeout(ctx, rfc2136Timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)); err != nil {
+		return err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	if len(resp) < 12 || binary.BigEndian.Uint16(resp) != id {
+		return fmt.Errorf("malformed response")
+	}
+	if rcode := resp[3] & 0x0f; rcode != 0 {
+		return fmt.Errorf("server answered %s", cmp.Or(rcodeNames[rcode], fmt.Sprintf("rcode %d", rcode)))
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTestUpdateServer accepts dynamic updates over TCP, answering each
+// with rcode and sending the received message on the returned channel.
+func startTestUpdateServer(t *testing.T, rcode byte) (string, <-chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan []byte, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			io.ReadFull(conn, length[:])
+			msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+			io.ReadFull(conn, msg)
+			received <- msg
+
+			resp := append([]byte{}, msg[:12]...)
+			resp[2] |= 0x80
+			resp[3] = rcode
+			conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestPushRecords(t *testing.T) {
+	t.Setenv("TSDDNS_TEST_TSIG", base64.StdEncoding.EncodeToString([]byte("secret")))
+	server, received := startTestUpdateServer(t, 0)
+	u := &RFC2136{
+		Server: server,
+		Zone:   "internal.example.com",
+		TSIG:   &TSIG{Name: "tsddns", SecretEnv: "TSDDNS_TEST_TSIG"},
+		Records: Config{
+			"ns.internal.example.com":  {"100.64.0.1", "fd7a:115c:a1e0::1"},
+			"web.internal.example.com": {"100.64.0.2"},
+		},
+	}
+	if err := u.validate(); err != nil {
+		t.Fatalf("validate() unexpected error: %v", err)
+	}
+
+	if err := u.sync(context.Background(), newResolver(nil, Defaults{}), nil); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+	msg := <-received
+	if opcode := msg[2] >> 3 & 0xf; opcode != 5 {
+		t.Errorf("opcode = %d, want UPDATE", opcode)
+	}
+	// two RRset deletions per name plus one record per address
+	if updates := binary.BigEndian.Uint16(msg[8:]); updates != 7 {
+		t.Errorf("got %d updates, want 7", updates)
+	}
+	if additional := binary.BigEndian.Uint16(msg[10:]); additional != 1 {
+		t.Errorf("got %d additional records, want the TSIG record", additional)
+	}
+
+	server, _ = startTestUpdateServer(t, 5)
+	u.Server = server
+	if err := u.sync(context.Background(), newResolver(nil, Defaults{}), nil); err == nil {
+		t.Error("sync() didn't report a REFUSED update")
+	}
+
+	u.Records = Config{"ns.internal.example.com": {"https://dns.example.com/dns-query"}}
+	if err := u.sync(context.Background(), newResolver(nil, Defaults{}), nil); err == nil {
+		t.Error("sync() accepted a record that isn't an address")
+	}
+}
+
+func TestSignTSIG(t *testing.T) {
+	msg, _, err := buildUpdate("example.com", map[string][]string{"ns.example.com": {"100.64.0.1"}}, time.Minute)
+	if err != nil {
+		t.Fatalf("buildUpdate() unexpected error: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	signed, err := signTSIG(msg, "Key.Example", "hmac-sha256", []byte("secret"), now)
+	if err != nil {
+		t.Fatalf("signTSIG() unexpected error: %v", err)
+	}
+
+	if !bytes.HasPrefix(signed[12:], msg[12:]) {
+		t.Fatal("signTSIG() changed the message")
+	}
+	if got := binary.BigEndian.Uint16(signed[10:]); got != 1 {
+		t.Errorf("additional count = %d, want 1", got)
+	}
+
+	// the MAC covers the message, then the key name, class, TTL, algorithm,
+	// time signed, fudge, error and other length
+	vars := []byte("\x03key\x07example\x00\x00\xff\x00\x00\x00\x00\x0bhmac-sha256\x00")
+	vars = append(vars, 0, 0, 0x65, 0x53, 0xf1, 0x00, 0x01, 0x2c, 0, 0, 0, 0)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(msg)
+	mac.Write(vars)
+	if !bytes.Contains(signed[len(msg):], mac.Sum(nil)) {
+		t.Error("signTSIG() MAC doesn't match")
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// route53API is the Route53 API endpoint. It's a variable for tests.
+var route53API = "https://route53.amazonaws.com"
+
+// Route53 configures A and AAAA records kept in a Route53 hosted zone.
+type Route53 struct {
+	// HostedZoneID is the ID of the hosted zone, usually private.
+	HostedZoneID string `json:"hostedZoneId"`
+
+	// TTL is the records' TTL. Defaults to 5m.
+	TTL Duration `json:"ttl,omitempty"`
+
+	// Records are fully qualified names in the hosted zone.
+	Records RecordSet `json:"records"`
+}
+
+func (r *Route53) validate() error {
+	if r.HostedZoneID == "" {
+		return fmt.Errorf("needs a hostedZoneId")
+	}
+	return nil
+}
+
+// awsCredentials are AWS access keys, read from the standard environment
+// variables.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+func awsCredentialsFromEnv() (awsCredentials, error) {
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return creds, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return creds, nil
+}
+
+// route53RecordSet is a ResourceRecordSet in the Route53 API.
+type route53RecordSet struct {
+	Name   string          `xml:"Name"`
+	Type   string          `xml:"Type"`
+	TTL    int             `xml:"TTL"`
+	Values []route53Record `xml:"ResourceRecords>ResourceRecord"`
+}
+
+type route53Record struct {
+	Value string `xml:"Value"`
+}
+
+type route53Change struct {
+	Action string           `xml:"Action"`
+	Set    route53RecordSet `xml:"ResourceRecordSet"`
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Comment string          `xml:"ChangeBatch>Comment"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+// sync resolves the configured records and reconciles the hosted
+// zone with them in a single change batch. Only names with a tsddns marker
+// TXT record are changed or deleted.
+func (z *Route53) sync(ctx context.Context, r *resolver, _ map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, z.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	desired, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return err
+	}
+
+	existing, err := listRoute53RecordSets(ctx, creds, z.HostedZoneID)
+	if err != nil {
+		return fmt.Errorf("listing records: %w", err)
+	}
+	sets := make(map[string]route53RecordSet)
+	markers := make(map[string]route53RecordSet)
+	for _, set := range existing {
+		name := normalizeDomain(set.Name)
+		switch {
+		case set.Type == "TXT" && strings.HasPrefix(name, ownerMarkerPrefix):
+			markers[strings.TrimPrefix(name, ownerMarkerPrefix)] = set
+		case set.Type == "A" || set.Type == "AAAA":
+			sets[name+" "+set.Type] = set
+		}
+	}
+	var owned, foreign []record
+	for _, set := range existing {
+		if set.Type != "A" && set.Type != "AAAA" {
+			continue
+		}
+		name := normalizeDomain(set.Name)
+		for _, v := range set.Values {
+			rec := record{Name: name, Type: set.Type, Value: v.Value}
+			if _, ok := markers[name]; ok {
+				owned = append(owned, rec)
+			} else {
+				foreign = append(foreign, rec)
+			}
+		}
+	}
+
+	create, remove := planRecords(desired, owned, foreign)
+	ttl := int(time.Duration(cmp.Or(z.TTL, Duration(defaultRecordTTL))).Seconds())
+	var changes []route53Change
+	for _, set := range changedRRsets(desired, create, remove) {
+		if len(set.Values) == 0 {
+			slog.InfoContext(ctx, "Deleting Route53 records", "name", set.Name, "type", set.Type)
+			changes = append(changes, route53Change{Action: "DELETE", Set: sets[set.Name+" "+set.Type]})
+			continue
+		}
+		slog.InfoContext(ctx, "Setting Route53 records", "name", set.Name, "type", set.Type, "values", set.Values)
+		changes = append(changes, route53Change{Action: "UPSERT", Set: route53Set(set.Name, set.Type, ttl, set.Values)})
+	}
+
+	// Mark the names tsddns starts managing, and unmark the ones it's done
+	// with. Names with unmarked records are never in create.
+	for _, rec := range create {
+		if _, ok := markers[rec.Name]; !ok {
+			markers[rec.Name] = route53Set(ownerMarkerPrefix+rec.Name, "TXT", ttl, []string{ownerMarkerValue})
+			changes = append(changes, route53Change{Action: "CREATE", Set: markers[rec.Name]})
+		}
+	}
+	var marked []string
+	for name := range markers {
+		marked = append(marked, name)
+	}
+	sort.Strings(marked)
+	for _, name := range marked {
+		if !hasName(desired, name) {
+			changes = append(changes, route53Change{Action: "DELETE", Set: markers[name]})
+		}
+	}
+
+	if len(changes) == 0 {
+		slog.InfoContext(ctx, "Route53 records are up to date")
+		return nil
+	}
+	req := route53ChangeRequest{Comment: "tsddns update", Changes: changes}
+	body, err := xml.Marshal(req)
+	if err != nil {
+		return err
+	}
+	path := "/2013-04-01/hostedzone/" + strings.TrimPrefix(z.HostedZoneID, "/hostedzone/") + "/rrset/"
+	if err := route53Do(ctx, creds, "POST", path, nil, append([]byte(xml.Header), body...), nil); err != nil {
+		return fmt.Errorf("changing records: %w", err)
+	}
+	return nil
+}
+
+func route53Set(name, typ string, ttl int, values []string) route53RecordSet {
+	set := route53RecordSet{Name: name + ".", Type: typ, TTL: ttl}
+	for _, v := range values {
+		set.Values = append(set.Values, route53Record{Value: v})
+	}
+	return set
+}
+
+// listRoute53RecordSets returns every record set in the hosted zone.
+func listRoute53RecordSets(ctx context.Context, creds awsCredentials, zoneID string) ([]route53RecordSet, error) {
+	path := "/2013-04-01/hostedzone/" + strings.TrimPrefix(zoneID, "/hostedzone/") + "/rrset"
+	query := url.Values{}
+	var all []route53RecordSet
+	for {
+		var page struct {
+			Sets           []route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+			IsTruncated    bool               `xml:"IsTruncated"`
+			NextRecordName string             `xml:"NextRecordName"`
+			NextRecordType string             `xml:"NextRecordType"`
+		}
+		if err := route53Do(ctx, creds, "GET", path, query, nil, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Sets...)
+		if !page.IsTruncated {
+			return all, nil
+		}
+		query = url.Values{"name": {page.NextRecordName}, "type": {page.NextRecordType}}
+	}
+}
+
+// route53Do makes a signed Route53 API request and decodes the XML
+// response into out.
+func route53Do(ctx context.Context, creds awsCredentials, method, path string, query url.Values, body []byte, out any) error {
+	u := route53API + path
+	if len(query) > 0 {
+		u += "?" + awsQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	signAWS(req, body, creds, "us-east-1", "route53", time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message  string   `xml:"Error>Message"`
+			Messages []string `xml:"Messages>Message"`
+		}
+		xml.Unmarshal(data, &apiErr)
+		msg := strings.Join(append([]string{apiErr.Message}, apiErr.Messages...), "; ")
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, strings.Trim(msg, "; "))
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, out)
+}
+
+// signAWS signs req with AWS Signature Version 4.
+func signAWS(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(headers[name]))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		cmp.Or(req.URL.EscapedPath(), "/"),
+		awsQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{day, region, service, "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		creds.AccessKeyID, scope, signedHeaders, key))
+}
+
+// awsQuery encodes query the way Signature Version 4 expects: sorted, with
+// spaces as %20 rather than +.
+func awsQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
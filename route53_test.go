@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAWS(t *testing.T) {
+	// The get-vanilla case from the AWS Signature Version 4 test suite.
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signAWS(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+func TestSyncRoute53(t *testing.T) {
+	const list = `<ListResourceRecordSetsResponse>
+<ResourceRecordSets>
+<ResourceRecordSet><Name>ns.internal.example.com.</Name><Type>A</Type><TTL>300</TTL><ResourceRecords><ResourceRecord><Value>100.64.0.1</Value></ResourceRecord></ResourceRecords></ResourceRecordSet>
+<ResourceRecordSet><Name>_tsddns.ns.internal.example.com.</Name><Type>TXT</Type><TTL>300</TTL><ResourceRecords><ResourceRecord><Value>"heritage=tsddns"</Value></ResourceRecord></ResourceRecords></ResourceRecordSet>
+<ResourceRecordSet><Name>old.internal.example.com.</Name><Type>A</Type><TTL>300</TTL><ResourceRecords><ResourceRecord><Value>100.64.0.2</Value></ResourceRecord></ResourceRecords></ResourceRecordSet>
+<ResourceRecordSet><Name>_tsddns.old.internal.example.com.</Name><Type>TXT</Type><TTL>300</TTL><ResourceRecords><ResourceRecord><Value>"heritage=tsddns"</Value></ResourceRecord></ResourceRecords></ResourceRecordSet>
+<ResourceRecordSet><Name>manual.internal.example.com.</Name><Type>A</Type><TTL>300</TTL><ResourceRecords><ResourceRecord><Value>100.64.0.3</Value></ResourceRecord></ResourceRecords></ResourceRecordSet>
+</ResourceRecordSets>
+<IsTruncated>false</IsTruncated>
+</ListResourceRecordSetsResponse>`
+
+	var batch route53ChangeRequest
+	posts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			t.Errorf("request not signed: %q", r.Header.Get("Authorization"))
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/2013-04-01/hostedzone/Z1/rrset":
+			io.WriteString(w, list)
+		case r.Method == "POST" && r.URL.Path == "/2013-04-01/hostedzone/Z1/rrset/":
+			posts++
+			if err := xml.NewDecoder(r.Body).Decode(&batch); err != nil {
+				t.Errorf("decoding change batch: %v", err)
+			}
+			io.WriteString(w, `<ChangeResourceRecordSetsResponse/>`)
+		default:
+			http.Error(w, "<ErrorResponse><Error><Message>not found</Message></Error></ErrorResponse>", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	route53API = srv.URL
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	z := &Route53{
+		HostedZoneID: "Z1",
+		Records: Config{
+			"ns.internal.example.com":     {"100.64.0.1", "100.64.0.4"},
+			"new.internal.example.com":    {"fd7a:115c:a1e0::5"},
+			"manual.internal.example.com": {"100.64.0.5"},
+		},
+	}
+	if err := z.sync(context.Background(), newResolver(nil, Defaults{}), nil); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+	if posts != 1 {
+		t.Fatalf("got %d change batches, want 1", posts)
+	}
+
+	var got []string
+	for _, c := range batch.Changes {
+		var values []string
+		for _, v := range c.Set.Values {
+			values = append(values, v.Value)
+		}
+		got = append(got, c.Action+" "+c.Set.Name+" "+c.Set.Type+" "+strings.Join(values, ","))
+	}
+	want := []string{
+		"UPSERT new.internal.example.com. AAAA fd7a:115c:a1e0::5",
+		"UPSERT ns.internal.example.com. A 100.64.0.1,100.64.0.4",
+		"DELETE old.internal.example.com. A 100.64.0.2",
+		`CREATE _tsddns.new.internal.example.com. TXT "heritage=tsddns"`,
+		`DELETE _tsddns.old.internal.example.com. TXT "heritage=tsddns"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("changes =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Schedule updates a group of domains on its own interval in daemon mode,
+// instead of on every -interval with the rest of the config.
+type Schedule struct {
+	Interval Duration `json:"interval"`
+	Domains  []string `json:"domains"`
+}
+
+func (s *Schedule) validate() error {
+	if s.Interval <= 0 {
+		return fmt.Errorf("needs a positive interval")
+	}
+	if len(s.Domains) == 0 {
+		return fmt.Errorf("needs domains")
+	}
+	return nil
+}
+
+// validateSchedules checks that every scheduled domain is configured, in
+// the domain map or a profile's, and in only one schedule.
+func (c *ConfigFile) validateSchedules() error {
+	if len(c.Schedules) > 0 && c.Mode != "" && c.Mode != "split" {
+		return fmt.Errorf("schedules group split DNS domains, which %s mode doesn't apply", c.Mode)
+	}
+	configured := make(map[string]bool)
+	for domain := range c.Domains {
+		configured[normalizeDomain(domain)] = true
+	}
+	for _, p := range c.Profiles {
+		for domain := range p.Domains {
+			configured[normalizeDomain(domain)] = true
+		}
+	}
+	scheduled := make(map[string]string)
+	for _, name := range slices.Sorted(maps.Keys(c.Schedules)) {
+		if err := c.Schedules[name].validate(); err != nil {
+			return fmt.Errorf("schedules: %s: %w", name, err)
+		}
+		for _, domain := range c.Schedules[name].Domains {
+			domain = normalizeDomain(domain)
+			if !configured[domain] {
+				return fmt.Errorf("schedules: %s: %s isn't in domains", name, domain)
+			}
+			if other, ok := scheduled[domain]; ok {
+				return fmt.Errorf("schedules: %s is in both %s and %s", domain, other, name)
+			}
+			scheduled[domain] = name
+		}
+	}
+	return nil
+}
+
+// updateScope limits an update to some of the configured domains. Every
+// other domain keeps its current split DNS route, ownership and applied
+// state, as if it were excluded.
+type updateScope struct {
+	domains map[string]bool
+
+	// only is set to update just domains, or unset to update everything
+	// but them.
+	only bool
+}
+
+// inScope reports whether an update of c may change domain.
+func (c *ConfigFile) inScope(domain string) bool {
+	return c.scope == nil || c.scope.domains[normalizeDomain(domain)] == c.scope.only
+}
+
+// scheduledDomains returns the domains of the named schedules, or of every
+// schedule if names is nil.
+func (c *ConfigFile) scheduledDomains(names []string) map[string]bool {
+	domains := make(map[string]bool)
+	for name, s := range c.Schedules {
+		if names != nil && !slices.Contains(names, name) {
+			continue
+		}
+		for _, domain := range s.Domains {
+			domains[normalizeDomain(domain)] = true
+		}
+	}
+	return domains
+}
+
+// forSchedules returns c limited to the domains of the named schedules, for
+// an update of just those. It leaves out the global nameservers, search
+// paths, preferences, reverse zones, discovered domains and records, which
+// are updated with the rest of the config.
+func (c *ConfigFile) forSchedules(names []string) *ConfigFile {
+	scoped := *c
+	scoped.scope = &updateScope{domains: c.scheduledDomains(names), only: true}
+	scoped.Global, scoped.SearchPaths, scoped.Preferences = nil, nil, nil
+	scoped.ReverseZones, scoped.Discover = nil, nil
+	return &scoped
+}
+
+// withoutSchedules returns c without the domains of any schedule, for the
+// updates run every -interval.
+func (c *ConfigFile) withoutSchedules() *ConfigFile {
+	if len(c.Schedules) == 0 {
+		return c
+	}
+	scoped := *c
+	scoped.scope = &updateScope{domains: c.scheduledDomains(nil)}
+	return &scoped
+}
+
+// scheduledOnly reports whether c is limited to some schedules' domains.
+func (c *ConfigFile) scheduledOnly() bool {
+	return c.scope != nil && c.scope.only
+}
+
+// ownedOutOfScope returns the domains of current that tsddns owns but an
+// update of c mustn't touch, so they stay owned.
+func (c *ConfigFile) ownedOutOfScope(current map[string][]string, owned map[string]bool) []string {
+	var domains []string
+	for domain := range current {
+		if owned[normalizeDomain(domain)] && !c.inScope(domain) {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// keepOutOfScope copies what prev recorded for the domains outside c's
+// scope into next, since the update didn't apply them.
+func (c *ConfigFile) keepOutOfScope(prev, next *appliedState) {
+	if prev == nil {
+		return
+	}
+	for domain, d := range prev.Domains {
+		if _, ok := next.Domains[domain]; ok && !c.inScope(domain) {
+			next.Domains[domain] = d
+		}
+	}
+}
+
+// scheduler tracks when each schedule is next due.
+type scheduler struct {
+	schedules map[string]*Schedule
+	jitter    time.Duration
+	next      map[string]time.Time
+}
+
+func newScheduler(schedules map[string]*Schedule, jitter time.Duration, now time.Time) *scheduler {
+	s := &scheduler{schedules: schedules, jitter: jitter, next: make(map[string]time.Time)}
+	for name, sched := range schedules {
+		s.next[name] = now.Add(jitteredInterval(time.Duration(sched.Interval), jitter))
+	}
+	return s
+}
+
+// wait returns how long until the next schedule is due, or false if there
+// are no schedules.
+func (s *scheduler) wait(now time.Time) (time.Duration, bool) {
+	if len(s.next) == 0 {
+		return 0, false
+	}
+	earliest := slices.MinFunc(slices.Collect(maps.Values(s.next)), time.Time.Compare)
+	return max(earliest.Sub(now), 0), true
+}
+
+// due returns the schedules that are due at now, sorted, and sets when
+// each is due next.
+func (s *scheduler) due(now time.Time) []string {
+	var names []string
+	for name, next := range s.next {
+		if !next.After(now) {
+			names = append(names, name)
+			s.next[name] = now.Add(jitteredInterval(time.Duration(s.schedules[name].Interval), s.jitter))
+		}
+	}
+	slices.Sort(names)
+	return names
+}
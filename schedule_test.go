@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestValidateSchedules(t *testing.T) {
+	domains := Config{"svc.example.com": {"svc:dns"}, "office.example.com": {"10.0.0.53"}}
+	tests := []struct {
+		name      string
+		mode      string
+		schedules map[string]*Schedule
+		wantErr   bool
+	}{
+		{name: "none"},
+		{name: "valid", schedules: map[string]*Schedule{
+			"fast": {Interval: Duration(time.Minute), Domains: []string{"svc.example.com"}},
+			"slow": {Interval: Duration(6 * time.Hour), Domains: []string{"Office.Example.com."}},
+		}},
+		{name: "profile domain", schedules: map[string]*Schedule{"fast": {Interval: Duration(time.Minute), Domains: []string{"staging.example.com"}}}},
+		{name: "no interval", schedules: map[string]*Schedule{"fast": {Domains: []string{"svc.example.com"}}}, wantErr: true},
+		{name: "no domains", schedules: map[string]*Schedule{"fast": {Interval: Duration(time.Minute)}}, wantErr: true},
+		{name: "unknown domain", schedules: map[string]*Schedule{"fast": {Interval: Duration(time.Minute), Domains: []string{"nope.example.com"}}}, wantErr: true},
+		{name: "in two schedules", schedules: map[string]*Schedule{
+			"fast": {Interval: Duration(time.Minute), Domains: []string{"svc.example.com"}},
+			"slow": {Interval: Duration(time.Hour), Domains: []string{"svc.example.com"}},
+		}, wantErr: true},
+		{name: "records mode", mode: "records", schedules: map[string]*Schedule{"fast": {Interval: Duration(time.Minute), Domains: []string{"svc.example.com"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &ConfigFile{
+				Domains:   domains,
+				Mode:      tt.mode,
+				Schedules: tt.schedules,
+				Profiles:  map[string]*Profile{"staging": {Domains: Config{"staging.example.com": {"10.0.0.54"}}}},
+			}
+			if err := cfg.validateSchedules(); (err != nil) != tt.wantErr {
+				t.Errorf("validateSchedules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	s := newScheduler(map[string]*Schedule{
+		"fast": {Interval: Duration(time.Minute)},
+		"slow": {Interval: Duration(time.Hour)},
+	}, 0, start)
+
+	if d, ok := s.wait(start); !ok || d != time.Minute {
+		t.Errorf("wait() = %v, %v, want 1m", d, ok)
+	}
+	if due := s.due(start.Add(30 * time.Second)); len(due) != 0 {
+		t.Errorf("due after 30s = %v, want none", due)
+	}
+	if due := s.due(start.Add(time.Minute)); !slices.Equal(due, []string{"fast"}) {
+		t.Errorf("due after 1m = %v, want [fast]", due)
+	}
+	if due := s.due(start.Add(time.Hour)); !slices.Equal(due, []string{"fast", "slow"}) {
+		t.Errorf("due after 1h = %v, want [fast slow]", due)
+	}
+	if d, ok := s.wait(start.Add(time.Hour)); !ok || d != time.Minute {
+		t.Errorf("wait() after 1h = %v, %v, want 1m", d, ok)
+	}
+	if _, ok := newScheduler(nil, 0, start).wait(start); ok {
+		t.Error("wait() without schedules = true, want false")
+	}
+}
+
+func TestUpdateDNSScoped(t *testing.T) {
+	dir := t.TempDir()
+	defer func(path string) { stateFile = path }(stateFile)
+	stateFile = filepath.Join(dir, "state.json")
+
+	backend := &fileBackend{path: filepath.Join(dir, "splitdns.json")}
+	start := map[string][]string{
+		"svc.example.com":    {"10.0.0.1"},
+		"office.example.com": {"10.0.0.2"},
+		"manual.example.com": {"10.0.0.3"},
+	}
+	if err := backend.write(start); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveOwnedDomains(stateFile, []string{"svc.example.com", "office.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	applied := newAppliedState(nil, start, []string{"svc.example.com", "office.example.com"}, nil, time.Now())
+	if err := saveApplied(stateFile, applied); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ConfigFile{
+		Backend:     "file",
+		BackendFile: backend.path,
+		Domains: Config{
+			"svc.example.com":    {"10.0.1.1"},
+			"office.example.com": {"10.0.1.2"},
+		},
+		Schedules: map[string]*Schedule{"fast": {Interval: Duration(time.Minute), Domains: []string{"svc.example.com"}}},
+	}
+	client := &tailscale.Client{Tailnet: "example.com"}
+
+	// the schedule's update only changes its own domain
+	if err := updateDNS(context.Background(), client, cfg.forSchedules([]string{"fast"})); err != nil {
+		t.Fatalf("updateDNS() for the schedule: %v", err)
+	}
+	current, _ := backend.Current(context.Background())
+	want := map[string][]string{
+		"svc.example.com":    {"10.0.1.1"},
+		"office.example.com": {"10.0.0.2"},
+		"manual.example.com": {"10.0.0.3"},
+	}
+	for domain, ns := range want {
+		if !slices.Equal(current[domain], ns) {
+			t.Errorf("after the schedule's update %s = %v, want %v", domain, current[domain], ns)
+		}
+	}
+	owned, _ := loadOwnedDomains(stateFile)
+	if !owned["office.example.com"] || !owned["svc.example.com"] || owned["manual.example.com"] {
+		t.Errorf("owned = %v, want svc and office still owned", owned)
+	}
+	applied, _ = loadApplied(stateFile)
+	if got := applied.Domains["office.example.com"].Nameservers; !slices.Equal(got, []string{"10.0.0.2"}) {
+		t.Errorf("applied office.example.com = %v, want what was applied before", got)
+	}
+
+	// the regular update leaves the schedule's domain alone
+	if err := backend.write(map[string][]string{
+		"svc.example.com":    {"10.0.9.9"},
+		"office.example.com": {"10.0.0.2"},
+		"manual.example.com": {"10.0.0.3"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateDNS(context.Background(), client, cfg.withoutSchedules()); err != nil {
+		t.Fatalf("updateDNS() without schedules: %v", err)
+	}
+	current, _ = backend.Current(context.Background())
+	if !slices.Equal(current["office.example.com"], []string{"10.0.1.2"}) || !slices.Equal(current["svc.example.com"], []string{"10.0.9.9"}) {
+		t.Errorf("after the regular update split DNS = %v, want only office.example.com updated", current)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// reporter is where update errors and panics are reported to. nil turns
+// reporting off.
+var reporter *sentryReporter
+
+// sentryTimeout bounds how long reporting an event may take, so a down
+// Sentry doesn't hold up updates.
+const sentryTimeout = 10 * time.Second
+
+// sentryReporter sends events to Sentry through its envelope endpoint.
+type sentryReporter struct {
+	dsn         string
+	url         string
+	auth        string
+	environment string
+	release     string
+	client      *http.Client
+}
+
+// newSentryReporter reports to the project of dsn, which looks like
+// https://<key>@o0.ingest.sentry.io/<project>, tagging events with
+// environment and release if they're set.
+func newSentryReporter(dsn, environment, release string) (*sentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Sentry DSN: %w", err)
+	}
+	// a self-hosted Sentry may be served under a path before the project
+	prefix, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.User.Username() == "" || project == "" {
+		return nil, fmt.Errorf("Sentry DSN must look like https://<key>@<host>/<project>")
+	}
+	return &sentryReporter{
+		dsn:         dsn,
+		url:         fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+		auth:        "Sentry sentry_version=7, sentry_client=tsddns, sentry_key=" + u.User.Username(),
+		environment: environment,
+		release:     release,
+		client:      &http.Client{Timeout: sentryTimeout},
+	}, nil
+}
+
+// reportError reports a failed update, tagged with ctx's run ID, trigger
+// and trace and with attrs, key/value pairs like slog's. Updates cancelled
+// by shutdown aren't reported.
+func reportError(ctx context.Context, err error, attrs ...any) {
+	if reporter == nil || err == nil || errors.Is(err, context.Canceled) || errors.Is(err, errChangesPending) {
+		return
+	}
+	// the innermost error's type groups events better than the wrappers'
+	inner := err
+	for errors.Unwrap(inner) != nil {
+		inner = errors.Unwrap(inner)
+	}
+	event := reporter.event(ctx, "error", attrs)
+	event["exception"] = map[string]any{"values": []any{map[string]any{
+		"type":  fmt.Sprintf("%T", inner),
+		"value": err.Error(),
+	}}}
+	if err := reporter.send(event); err != nil {
+		slog.WarnContext(ctx, "Reporting error to Sentry failed", "error", err)
+	}
+}
+
+// reportPanic, deferred, reports a panic with its stack trace and then
+// panics again, so tsddns still crashes as it would otherwise.
+func reportPanic(ctx context.Context) {
+	if reporter == nil {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	event := reporter.event(ctx, "fatal", nil)
+	event["exception"] = map[string]any{"values": []any{map[string]any{
+		"type":       "panic",
+		"value":      fmt.Sprint(r),
+		"mechanism":  map[string]any{"type": "panic", "handled": false},
+		"stacktrace": map[string]any{"frames": stackFrames()},
+	}}}
+	if err := reporter.send(event); err != nil {
+		slog.WarnContext(ctx, "Reporting panic to Sentry failed", "error", err)
+	}
+	panic(r)
+}
+
+// event returns a Sentry event at level with ctx's run context.
+func (s *sentryReporter) event(ctx context.Context, level string, attrs []any) map[string]any {
+	var eventID [16]byte
+	rand.Read(eventID[:])
+	host, _ := os.Hostname()
+	tags := map[string]string{}
+	if id := runID(ctx); id != "" {
+		tags["run_id"] = id
+	}
+	if trigger, ok := ctx.Value(triggerKey{}).(string); ok {
+		tags["trigger"] = trigger
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if k, ok := attrs[i].(string); ok {
+			tags[k] = fmt.Sprint(attrs[i+1])
+		}
+	}
+	event := map[string]any{
+		"event_id":    hex.EncodeToString(eventID[:]),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339Nano),
+		"level":       level,
+		"platform":    "go",
+		"logger":      "tsddns",
+		"server_name": host,
+		"tags":        tags,
+	}
+	if s.environment != "" {
+		event["environment"] = s.environment
+	}
+	if s.release != "" {
+		event["release"] = s.release
+	}
+	// link the event to the update's trace, if it's being traced
+	if sp, _ := ctx.Value(spanKey{}).(*span); sp != nil {
+		event["contexts"] = map[string]any{"trace": map[string]any{
+			"trace_id": hex.EncodeToString(sp.trace.id[:]),
+			"span_id":  hex.EncodeToString(sp.id[:]),
+		}}
+	}
+	return event
+}
+
+// stackFrames returns the panicking goroutine's stack in Sentry's format,
+// outermost call first, leaving out the runtime's panic handling.
+func stackFrames() []map[string]any {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	var out []map[string]any
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "runtime.") {
+			out = append(out, map[string]any{
+				"function": frame.Function,
+				"abs_path": frame.File,
+				"lineno":   frame.Line,
+				"in_app":   strings.HasPrefix(frame.Function, "main."),
+			})
+		}
+		if !more {
+			break
+		}
+	}
+	slices.Reverse(out)
+	return out
+}
+
+// send posts event to Sentry as an envelope.
+func (s *sentryReporter) send(event map[string]any) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	header, _ := json.Marshal(map[string]any{
+		"event_id": event["event_id"],
+		"dsn":      s.dsn,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	item, _ := json.Marshal(map[string]any{"type": "event", "length": len(payload)})
+	var body bytes.Buffer
+	for _, line := range [][]byte{header, item, payload} {
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest("POST", s.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Sentry returned status %d", resp.StatusCode)
+	}
+	return nil
+}
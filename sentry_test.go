@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// sentryServer records the events posted to it, and returns a DSN for it.
+func sentryServer(t *testing.T) (string, *[]map[string]any) {
+	var events []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/42/envelope/" || !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+			t.Errorf("posted to %s with auth %q", r.URL.Path, r.Header.Get("X-Sentry-Auth"))
+		}
+		// an envelope header, an item header, then the event
+		lines := bufio.NewScanner(r.Body)
+		for range 3 {
+			lines.Scan()
+		}
+		var event map[string]any
+		if err := json.Unmarshal(lines.Bytes(), &event); err != nil {
+			t.Errorf("decoding event: %v", err)
+		}
+		events = append(events, event)
+	}))
+	t.Cleanup(server.Close)
+	return strings.Replace(server.URL, "http://", "http://public@", 1) + "/42", &events
+}
+
+func TestNewSentryReporter(t *testing.T) {
+	tests := []struct {
+		dsn     string
+		wantURL string
+		wantErr bool
+	}{
+		{dsn: "https://abc123@o1.ingest.sentry.io/4505", wantURL: "https://o1.ingest.sentry.io/api/4505/envelope/"},
+		{dsn: "https://abc123@sentry.example.com/sentry/7", wantURL: "https://sentry.example.com/sentry/api/7/envelope/"},
+		{dsn: "https://o1.ingest.sentry.io/4505", wantErr: true},
+		{dsn: "https://abc123@o1.ingest.sentry.io/", wantErr: true},
+		{dsn: "abc123@o1.ingest.sentry.io/4505", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, err := newSentryReporter(tt.dsn, "", "")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newSentryReporter(%q) error = %v, wantErr %v", tt.dsn, err, tt.wantErr)
+			continue
+		}
+		if err == nil && r.url != tt.wantURL {
+			t.Errorf("newSentryReporter(%q) url = %q, want %q", tt.dsn, r.url, tt.wantURL)
+		}
+	}
+}
+
+func TestReportError(t *testing.T) {
+	dsn, events := sentryServer(t)
+	defer func(r *sentryReporter) { reporter = r }(reporter)
+	var err error
+	if reporter, err = newSentryReporter(dsn, "production", "tsddns@1.2.3"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withTrigger(withRunID(context.Background()), triggerInterval)
+	reportError(ctx, nil)
+	reportError(ctx, fmt.Errorf("updating: %w", context.Canceled))
+	reportError(ctx, fmt.Errorf("listing devices: %w", errors.New("API returned status 500")), "tailnet", "example.com")
+	if len(*events) != 1 {
+		t.Fatalf("reported %d events, want just the failure", len(*events))
+	}
+
+	event := (*events)[0]
+	tags, _ := event["tags"].(map[string]any)
+	if tags["run_id"] != runID(ctx) || tags["trigger"] != triggerInterval || tags["tailnet"] != "example.com" {
+		t.Errorf("tags = %v, want the run's context", tags)
+	}
+	if event["environment"] != "production" || event["release"] != "tsddns@1.2.3" || event["level"] != "error" {
+		t.Errorf("event = %v, want an error in production for tsddns@1.2.3", event)
+	}
+	exception := event["exception"].(map[string]any)["values"].([]any)[0].(map[string]any)
+	if exception["value"] != "listing devices: API returned status 500" || exception["type"] != "*errors.errorString" {
+		t.Errorf("exception = %v, want the error and its innermost type", exception)
+	}
+}
+
+func TestReportPanic(t *testing.T) {
+	dsn, events := sentryServer(t)
+	defer func(r *sentryReporter) { reporter = r }(reporter)
+	var err error
+	if reporter, err = newSentryReporter(dsn, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("recovered %v, want the panic raised again", r)
+			}
+		}()
+		defer reportPanic(context.Background())
+		panic("boom")
+	}()
+
+	if len(*events) != 1 {
+		t.Fatalf("reported %d events, want the panic", len(*events))
+	}
+	exception := (*events)[0]["exception"].(map[string]any)["values"].([]any)[0].(map[string]any)
+	frames, _ := exception["stacktrace"].(map[string]any)["frames"].([]any)
+	if exception["value"] != "boom" || len(frames) == 0 {
+		t.Errorf("exception = %v, want the panic with its stack", exception)
+	}
+	if (*events)[0]["level"] != "fatal" {
+		t.Errorf("level = %v, want fatal", (*events)[0]["level"])
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+type ServiceInfo struct {
+	Name  string   `json:"name"`
+	Addrs []string `json:"addrs"`
+}
+
+func getServiceIP(ctx context.Context, client *tailscale.Client, serviceName string) (string, error) {
+	addrs, err := getServiceAddrs(ctx, client, serviceName)
+	if err != nil {
+		return "", err
+	}
+	return addrs[0], nil
+}
+
+// getServiceAddrs returns every address of a Tailscale Service.
+func getServiceAddrs(ctx context.Context, client *tailscale.Client, serviceName string) ([]string, error) {
+	// TODO: use the official client once services API is added
+	var svcInfo ServiceInfo
+	if err := apiGet(ctx, client, "services/"+serviceName+"/", &svcInfo); err != nil {
+		return nil, err
+	}
+
+	if len(svcInfo.Addrs) == 0 {
+		return nil, fmt.Errorf("service %s has no addresses", serviceName)
+	}
+
+	return svcInfo.Addrs, nil
+}
+
+// getServiceHosts returns the node IDs of the devices hosting a Tailscale
+// Service.
+func getServiceHosts(ctx context.Context, client *tailscale.Client, serviceName string) ([]string, error) {
+	var hosts struct {
+		Devices []struct {
+			NodeID string `json:"nodeId"`
+		} `json:"devices"`
+	}
+	if err := apiGet(ctx, client, "services/"+serviceName+"/devices", &hosts); err != nil {
+		return nil, err
+	}
+
+	var nodeIDs []string
+	for _, d := range hosts.Devices {
+		nodeIDs = append(nodeIDs, d.NodeID)
+	}
+	if len(nodeIDs) == 0 {
+		return nil, fmt.Errorf("service %s has no hosts", serviceName)
+	}
+	return nodeIDs, nil
+}
+
+// apiGet fetches a tailnet API path the client library doesn't cover yet and
+// decodes the JSON response into out.
+func apiGet(ctx context.Context, client *tailscale.Client, path string, out any) error {
+	url := fmt.Sprintf("%s/api/v2/tailnet/%s/%s", client.BaseURL.String(), client.Tailnet, path)
+	return apiGetURL(ctx, client, url, out)
+}
+
+// apiGetURL is apiGet for API URLs outside the tailnet, such as per-device
+// endpoints.
+func apiGetURL(ctx context.Context, client *tailscale.Client, url string, out any) error {
+	return apiDo(ctx, client, "GET", url, nil, out)
+}
+
+// apiPost sends in as JSON to a tailnet API path the client library
+// doesn't cover yet.
+func apiPost(ctx context.Context, client *tailscale.Client, path string, in any) error {
+	url := fmt.Sprintf("%s/api/v2/tailnet/%s/%s", client.BaseURL.String(), client.Tailnet, path)
+	return apiDo(ctx, client, "POST", url, in, nil)
+}
+
+// apiDo makes an authenticated API request. in, if set, is sent as JSON and
+// out, if set, receives the decoded JSON response.
+func apiDo(ctx context.Context, client *tailscale.Client, method, url string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := client.HTTP
+	if client.APIKey != "" {
+		req.SetBasicAuth(client.APIKey, "")
+		if httpClient == nil {
+			httpClient = &http.Client{}
+		}
+	} else if httpClient == nil {
+		return fmt.Errorf("no auth configured")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+func TestResolveService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v2/tailnet/test/services/svc:dns/":
+			json.NewEncoder(w).Encode(ServiceInfo{Name: "svc:dns", Addrs: []string{"100.100.0.1", "fd7a:115c:a1e0::53"}})
+		case "/api/v2/tailnet/test/services/svc:dns/devices":
+			w.Write([]byte(`{"devices": [{"nodeId": "nPROD2"}, {"nodeId": "nPROD1"}, {"nodeId": "nSTAGE"}]}`))
+		case "/api/v2/tailnet/test/devices":
+			w.Write([]byte(`{"devices": [{"id": "1", "nodeId": "nPROD1"}, {"id": "2", "nodeId": "nPROD2"}, {"id": "3", "nodeId": "nSTAGE"}, {"id": "4", "nodeId": "nPROD3"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	baseURL, _ := url.Parse(server.URL)
+	client := &tailscale.Client{BaseURL: baseURL, APIKey: "test-key", Tailnet: "test"}
+
+	devices := []tailscale.Device{
+		{Name: "dns-prod-2.example.ts.net", ID: "2", Tags: []string{"tag:prod"}, Addresses: []string{"100.64.0.2"}},
+		{Name: "dns-prod-1.example.ts.net", ID: "1", Tags: []string{"tag:prod"}, Addresses: []string{"100.64.0.1"}},
+		{Name: "dns-stage.example.ts.net", ID: "3", Tags: []string{"tag:staging"}, Addresses: []string{"100.64.0.3"}},
+		{Name: "dns-prod-3.example.ts.net", ID: "4", Tags: []string{"tag:prod"}, Addresses: []string{"100.64.0.4"}},
+	}
+
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "service address", entry: "svc:dns", want: []string{"100.100.0.1"}},
+		{name: "hosts with tag", entry: "svc:dns?tag=prod", want: []string{"100.64.0.1", "100.64.0.2"}},
+		{name: "tag prefix optional", entry: "svc:dns?tag=tag:staging", want: []string{"100.64.0.3"}},
+		{name: "no host with tag", entry: "svc:dns?tag=dev", wantErr: true},
+		{name: "unknown service", entry: "svc:other?tag=prod", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &resolver{client: client, devices: devices, now: time.Now()}
+			got, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownGrace is how long an update in flight at shutdown gets to finish
+// before it's cancelled, which rolls back what it already wrote.
+const shutdownGrace = 30 * time.Second
+
+// notifyShutdown catches SIGINT and SIGTERM. stopping is done at the first
+// signal, after which no new update should start. updates, for running
+// updates, is cancelled grace later or at a second signal, so an update in
+// flight can finish instead of being killed halfway through its writes.
+func notifyShutdown(grace time.Duration) (stopping, updates context.Context) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	stopping, stop := context.WithCancel(context.Background())
+	updates, abort := context.WithCancel(context.Background())
+	go func() {
+		sig := <-sigs
+		slog.Info("Shutting down once the current update finishes", "signal", sig.String())
+		stop()
+		select {
+		case sig = <-sigs:
+			slog.Warn("Signalled again, aborting the current update", "signal", sig.String())
+		case <-time.After(grace):
+			slog.Warn("Update still running after the grace period, aborting it", "grace", grace)
+		}
+		abort()
+	}()
+	return stopping, updates
+}
+
+// notifyReconcile sends on kick whenever the process gets SIGUSR1, asking
+// the daemon to update now. kick should be buffered so that signals sent
+// while an update is queued collapse into it.
+func notifyReconcile(kick chan<- struct{}) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	go func() {
+		for range sigs {
+			select {
+			case kick <- struct{}{}:
+			default:
+			}
+		}
+	}()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNotifyShutdown(t *testing.T) {
+	stopping, updates := notifyShutdown(time.Hour)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	select {
+	case <-stopping.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopping not done after SIGTERM")
+	}
+	if updates.Err() != nil {
+		t.Fatal("updates cancelled at the first signal, want the update in flight to finish")
+	}
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	select {
+	case <-updates.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("updates not cancelled after a second SIGTERM")
+	}
+}
+
+func TestNotifyShutdownGrace(t *testing.T) {
+	stopping, updates := notifyShutdown(10 * time.Millisecond)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	<-stopping.Done()
+	select {
+	case <-updates.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("updates not cancelled after the grace period")
+	}
+}
+
+func TestNotifyReconcile(t *testing.T) {
+	kicks := make(chan struct{}, 1)
+	notifyReconcile(kicks)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+	select {
+	case <-kicks:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no kick after SIGUSR1")
+	}
+}
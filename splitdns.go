@@ -0,0 +1,18 @@
+package main
+
+import (
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// splitDNSPatches returns a partial split DNS update that applies changes
+// and one that reverts them. Only the changed domains are included; a
+// domain with no nameservers is removed.
+func splitDNSPatches(changes []domainChange) (apply, revert tailscale.SplitDNSRequest) {
+	apply = make(tailscale.SplitDNSRequest, len(changes))
+	revert = make(tailscale.SplitDNSRequest, len(changes))
+	for _, c := range changes {
+		apply[c.Domain] = c.New
+		revert[c.Domain] = c.Old
+	}
+	return apply, revert
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSplitDNSPatches(t *testing.T) {
+	current := map[string][]string{
+		"same.example.com":    {"100.64.0.1"},
+		"changed.example.com": {"100.64.0.2"},
+		"removed.example.com": {"100.64.0.3"},
+	}
+	desired := map[string][]string{
+		"same.example.com":    {"100.64.0.1"},
+		"changed.example.com": {"100.64.0.4"},
+		"added.example.com":   {"100.64.0.5"},
+	}
+
+	apply, revert := splitDNSPatches(diffSplitDNS(current, desired))
+
+	got, _ := json.Marshal(apply)
+	if want := `{"added.example.com":["100.64.0.5"],"changed.example.com":["100.64.0.4"],"removed.example.com":null}`; string(got) != want {
+		t.Errorf("apply = %s, want %s", got, want)
+	}
+	got, _ = json.Marshal(revert)
+	if want := `{"added.example.com":null,"changed.example.com":["100.64.0.2"],"removed.example.com":["100.64.0.3"]}`; string(got) != want {
+		t.Errorf("revert = %s, want %s", got, want)
+	}
+}
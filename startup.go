@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	tailscale "github.com/tailscale/tailscale-client-go/v2"
+)
+
+// Delays between startup checks while waiting for what tsddns depends on to
+// come up, doubling from the first to the most.
+const (
+	startupRetryFirst = time.Second
+	startupRetryMost  = 30 * time.Second
+)
+
+// startupSleep waits between startup checks, and is replaced in tests.
+var startupSleep = sleepContext
+
+// waitUntilUp runs check until it succeeds, retrying with backoff while it
+// fails because something isn't up yet, such as the network, tailscaled or a
+// config file on a volume that hasn't been mounted. It gives up at deadline,
+// and right away on any other error, returning the last error.
+func waitUntilUp(ctx context.Context, what string, deadline time.Time, check func(context.Context) error) error {
+	delay := startupRetryFirst
+	for {
+		err := check(ctx)
+		if err == nil || !notUpYet(err) || time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		slog.WarnContext(ctx, "Waiting for "+what, "delay", delay, "error", err)
+		if err := startupSleep(ctx, delay); err != nil {
+			return err
+		}
+		delay = min(2*delay, startupRetryMost)
+	}
+}
+
+// notUpYet reports whether err could go away by waiting: a network error
+// such as a refused connection or failed DNS lookup, or a missing file.
+// Mistakes like a config that doesn't parse or a rejected API key won't.
+func notUpYet(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, fs.ErrNotExist) || errors.As(err, &netErr)
+}
+
+// usesLocalAPI reports whether cfg has local: or self entries, which
+// resolve through tailscaled.
+func usesLocalAPI(cfg *ConfigFile) bool {
+	var nameservers []string
+	for _, ns := range cfg.Domains {
+		nameservers = append(nameservers, ns...)
+	}
+	nameservers = append(nameservers, cfg.Global...)
+	for _, ns := range nameservers {
+		ns, _, _ := parseWeight(ns)
+		for _, alt := range strings.Split(ns, fallbackSep) {
+			if kind := parseEntry(alt).kind; kind == "local" || kind == "self" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// waitForDependencies waits until split DNS can be read from cfg's backend
+// and, if cfg has local: or self entries, tailscaled answers, so that the
+// first update doesn't fail just because tsddns started first.
+func waitForDependencies(ctx context.Context, client *tailscale.Client, cfg *ConfigFile, deadline time.Time) error {
+	backend, err := newBackend(client, cfg)
+	if err != nil {
+		return err
+	}
+	if err := waitUntilUp(ctx, "split DNS backend", deadline, func(ctx context.Context) error {
+		_, err := backend.Current(ctx)
+		return err
+	}); err != nil {
+		return fmt.Errorf("reading split DNS: %w", err)
+	}
+	if !usesLocalAPI(cfg) {
+		return nil
+	}
+	if err := waitUntilUp(ctx, "tailscaled", deadline, func(ctx context.Context) error {
+		_, err := getLocalStatus(ctx, localAPISocket)
+		return err
+	}); err != nil {
+		return fmt.Errorf("reaching tailscaled: %w", err)
+	}
+	return nil
+}
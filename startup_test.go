@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWaitUntilUp(t *testing.T) {
+	defer func(sleep func(context.Context, time.Duration) error) { startupSleep = sleep }(startupSleep)
+	var slept []time.Duration
+	startupSleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	missing := fmt.Errorf("reading config file: %w", fs.ErrNotExist)
+	tests := []struct {
+		name      string
+		window    time.Duration
+		errs      []error
+		wantErr   bool
+		wantTries int
+	}{
+		{name: "up", window: time.Minute, wantTries: 1},
+		{name: "network comes up", window: time.Minute, errs: []error{refused, refused}, wantTries: 3},
+		{name: "file appears", window: time.Minute, errs: []error{missing}, wantTries: 2},
+		{name: "bad config", window: time.Minute, errs: []error{errors.New("parsing config: unexpected EOF")}, wantErr: true, wantTries: 1},
+		{name: "no window", errs: []error{refused}, wantErr: true, wantTries: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slept = nil
+			tries := 0
+			err := waitUntilUp(context.Background(), "test", time.Now().Add(tt.window), func(context.Context) error {
+				tries++
+				if tries <= len(tt.errs) {
+					return tt.errs[tries-1]
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("waitUntilUp() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tries != tt.wantTries {
+				t.Errorf("tried %d times, want %d", tries, tt.wantTries)
+			}
+			for i := 1; i < len(slept); i++ {
+				if slept[i] != 2*slept[i-1] {
+					t.Errorf("delays = %v, want each double the last", slept)
+				}
+			}
+		})
+	}
+}
+
+func TestUsesLocalAPI(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *ConfigFile
+		want bool
+	}{
+		{name: "none", cfg: &ConfigFile{Domains: Config{"corp.example.com": {"svc:dns", "10.0.0.53"}}}},
+		{name: "local", cfg: &ConfigFile{Domains: Config{"corp.example.com": {"local:ns-*"}}}, want: true},
+		{name: "self in a fallback", cfg: &ConfigFile{Domains: Config{"corp.example.com": {"tag:dns || self"}}}, want: true},
+		{name: "global", cfg: &ConfigFile{Global: Nameservers{"local:ns-1?weight=2"}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := usesLocalAPI(tt.cfg); got != tt.want {
+				t.Errorf("usesLocalAPI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// metrics is where update counters and timings are sent. nil turns
+// metrics off, which makes every method a no-op.
+var metrics *statsdClient
+
+// statsdClient sends metrics to a statsd server over UDP, one packet per
+// metric, so a down server costs nothing but the metrics.
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+	format string
+	tags   []string // key, value, ...
+}
+
+// newStatsdClient sends to the statsd server at addr, prefixing metric
+// names with prefix and tagging every metric with tags, given as
+// comma-separated key=value pairs. format is how tags are written:
+// "dogstatsd" as |#key:value, "graphite" as ;key=value after the name, or
+// "none" to leave them out for servers that don't support tags.
+func newStatsdClient(addr, prefix, tags, format string) (*statsdClient, error) {
+	switch format {
+	case "dogstatsd", "graphite", "none":
+	default:
+		return nil, fmt.Errorf("invalid statsd tag format %q: want dogstatsd, graphite or none", format)
+	}
+	c := &statsdClient{prefix: strings.TrimSuffix(prefix, "."), format: format}
+	for _, pair := range strings.Split(tags, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("statsd tag %q isn't key=value", pair)
+		}
+		c.tags = append(c.tags, strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+	c.conn = conn
+	return c, nil
+}
+
+// count adds n to the counter name. tags are key/value pairs added to the
+// client's.
+func (c *statsdClient) count(name string, n int, tags ...string) {
+	c.send(name, fmt.Sprint(n), "c", tags)
+}
+
+// timing records d, in milliseconds, for the timer name.
+func (c *statsdClient) timing(name string, d time.Duration, tags ...string) {
+	c.send(name, fmt.Sprint(d.Milliseconds()), "ms", tags)
+}
+
+// gauge sets the gauge name to v.
+func (c *statsdClient) gauge(name string, v int, tags ...string) {
+	c.send(name, fmt.Sprint(v), "g", tags)
+}
+
+func (c *statsdClient) send(name, value, kind string, tags []string) {
+	if c == nil {
+		return
+	}
+	if _, err := c.conn.Write([]byte(c.line(name, value, kind, tags))); err != nil {
+		slog.Debug("Sending metric failed", "metric", name, "error", err)
+	}
+}
+
+// line formats one metric in the statsd line protocol.
+func (c *statsdClient) line(name, value, kind string, tags []string) string {
+	if c.prefix != "" {
+		name = c.prefix + "." + name
+	}
+	tags = append(c.tags[:len(c.tags):len(c.tags)], tags...)
+	var b strings.Builder
+	b.WriteString(name)
+	if c.format == "graphite" {
+		for i := 0; i+1 < len(tags); i += 2 {
+			fmt.Fprintf(&b, ";%s=%s", tagEscaper.Replace(tags[i]), tagEscaper.Replace(tags[i+1]))
+		}
+	}
+	fmt.Fprintf(&b, ":%s|%s", value, kind)
+	if c.format == "dogstatsd" && len(tags) > 0 {
+		for i := 0; i+1 < len(tags); i += 2 {
+			sep := ","
+			if i == 0 {
+				sep = "|#"
+			}
+			fmt.Fprintf(&b, "%s%s:%s", sep, tagEscaper.Replace(tags[i]), tagEscaper.Replace(tags[i+1]))
+		}
+	}
+	return b.String()
+}
+
+// tagEscaper replaces what would break a tag in either format, like the
+// space in the "admin request" trigger.
+var tagEscaper = strings.NewReplacer(" ", "_", ",", "_", "|", "_", "#", "_", ":", "_", ";", "_", "=", "_")
+
+// recordOutcome sends the metrics of a finished update: it's counted, and
+// timed, by its trigger and result, and the number of updates failed in a
+// row is set. Dry runs aren't recorded.
+func recordOutcome(ctx context.Context, duration time.Duration, err error) {
+	if metrics == nil || dryRun || errors.Is(err, errChangesPending) {
+		return
+	}
+	result := "success"
+	if errors.Is(err, errRolledBack) {
+		result = "rolled_back"
+	} else if err != nil {
+		result = "failure"
+	}
+	trigger, _ := ctx.Value(triggerKey{}).(string)
+	metrics.count("reconcile", 1, "trigger", trigger, "result", result)
+	metrics.timing("reconcile.duration", duration, "trigger", trigger, "result", result)
+	metrics.gauge("reconcile.consecutive_failures", consecutiveFailures)
+}
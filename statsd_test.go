@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStatsdLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		tags   string
+		format string
+		want   string
+	}{
+		{name: "dogstatsd", prefix: "tsddns", tags: "env=prod", format: "dogstatsd", want: "tsddns.reconcile:1|c|#env:prod,trigger:admin_request"},
+		{name: "graphite", prefix: "infra.tsddns.", tags: "env=prod", format: "graphite", want: "infra.tsddns.reconcile;env=prod;trigger=admin_request:1|c"},
+		{name: "no tags", prefix: "tsddns", tags: "env=prod", format: "none", want: "tsddns.reconcile:1|c"},
+		{name: "no prefix", format: "dogstatsd", want: "reconcile:1|c|#trigger:admin_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newStatsdClient("127.0.0.1:8125", tt.prefix, tt.tags, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.conn.Close()
+			if got := c.line("reconcile", "1", "c", []string{"trigger", triggerAdmin}); got != tt.want {
+				t.Errorf("line() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []struct{ tags, format string }{{"env", "dogstatsd"}, {"", "influx"}} {
+		if _, err := newStatsdClient("127.0.0.1:8125", "tsddns", bad.tags, bad.format); err == nil {
+			t.Errorf("newStatsdClient(tags %q, format %q) = nil error, want one", bad.tags, bad.format)
+		}
+	}
+}
+
+func TestRecordOutcome(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	defer func(m *statsdClient, failures int) { metrics, consecutiveFailures = m, failures }(metrics, consecutiveFailures)
+	if metrics, err = newStatsdClient(pc.LocalAddr().String(), "tsddns", "", "dogstatsd"); err != nil {
+		t.Fatal(err)
+	}
+	consecutiveFailures = 2
+
+	ctx := withTrigger(context.Background(), triggerInterval)
+	recordOutcome(ctx, 1500*time.Millisecond, fmt.Errorf("updating: %w", errRolledBack))
+
+	var got []string
+	buf := make([]byte, 512)
+	for range 3 {
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("reading metric: %v", err)
+		}
+		got = append(got, string(buf[:n]))
+	}
+	want := []string{
+		"tsddns.reconcile:1|c|#trigger:interval,result:rolled_back",
+		"tsddns.reconcile.duration:1500|ms|#trigger:interval,result:rolled_back",
+		"tsddns.reconcile.consecutive_failures:2|g",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("metrics = %q, want %q", got, want)
+	}
+
+	// dry runs send nothing
+	recordOutcome(ctx, time.Second, errChangesPending)
+	pc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	var netErr net.Error
+	if n, _, err := pc.ReadFrom(buf); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("got metric %q after a dry run, want none", buf[:n])
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogFacilities are the facilities -syslog-facility accepts.
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3, "auth": 4, "syslog": 5,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSockets are where a local syslog daemon usually listens.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogTimeout bounds connecting to and writing to a remote syslog server.
+const syslogTimeout = 5 * time.Second
+
+// syslogWriter sends log lines to a syslog daemon as RFC 5424 messages.
+type syslogWriter struct {
+	network  string // "" for the local daemon
+	addr     string
+	facility int
+	hostname string
+
+	// mu is held while a record is written, with the record's severity
+	// and time set for Write.
+	mu       sync.Mutex
+	conn     net.Conn
+	severity int
+	time     time.Time
+}
+
+// newSyslogWriter writes to target: "local" for the local syslog daemon,
+// or udp://host[:port] or tcp://host[:port] for a remote one, port 514 by
+// default. It connects on the first write, so a daemon that's down at
+// startup only drops what's logged until it's up.
+func newSyslogWriter(target, facility string) (*syslogWriter, error) {
+	f, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog facility %q", facility)
+	}
+	w := &syslogWriter{facility: f, hostname: "-"}
+	if host, _ := os.Hostname(); host != "" {
+		w.hostname = host
+	}
+	if target == "local" {
+		return w, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid syslog target %q: want local, udp://host:port or tcp://host:port", target)
+	}
+	w.network, w.addr = u.Scheme, u.Host
+	if u.Port() == "" {
+		w.addr = net.JoinHostPort(u.Hostname(), "514")
+	}
+	return w, nil
+}
+
+func (w *syslogWriter) dial() (net.Conn, error) {
+	if w.network != "" {
+		return net.DialTimeout(w.network, w.addr, syslogTimeout)
+	}
+	var errs []error
+	for _, path := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			conn, err := net.Dial(network, path)
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+	}
+	return nil, fmt.Errorf("no local syslog daemon: %w", errors.Join(errs...))
+}
+
+// Write sends line as one message, reconnecting once if the connection was
+// lost. The caller holds w.mu.
+func (w *syslogWriter) Write(line []byte) (int, error) {
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	msg := fmt.Sprintf("<%d>1 %s %s tsddns %d - - %s", w.facility*8+w.severity,
+		w.time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), w.hostname, os.Getpid(),
+		strings.TrimSuffix(string(line), "\n"))
+	if w.network == "tcp" {
+		// octet counting framing, from RFC 6587
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	var err error
+	for range 2 {
+		if w.conn == nil {
+			if w.conn, err = w.dial(); err != nil {
+				return 0, err
+			}
+		}
+		if w.network != "" {
+			w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		}
+		if _, err = w.conn.Write([]byte(msg)); err == nil {
+			return len(line), nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	return 0, err
+}
+
+// syslogSeverity maps a slog level to a syslog severity.
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // err
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	case level >= slog.LevelInfo:
+		return 6 // info
+	}
+	return 7 // debug
+}
+
+// syslogHandler formats records with Handler, which writes to w, and sends
+// them with their level as the syslog severity.
+type syslogHandler struct {
+	slog.Handler
+	w *syslogWriter
+}
+
+// newSyslogHandler returns a handler writing format to w at level. The time
+// and level are left out of the message since the syslog header has them.
+func newSyslogHandler(w *syslogWriter, format, level string) (slog.Handler, error) {
+	h, err := newLogHandlerWith(w, format, level, func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+			return slog.Attr{}
+		}
+		return a
+	})
+	if err != nil {
+		return nil, err
+	}
+	return syslogHandler{h, w}, nil
+}
+
+func (h syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.w.mu.Lock()
+	defer h.w.mu.Unlock()
+	h.w.severity = syslogSeverity(r.Level)
+	h.w.time = r.Time
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return syslogHandler{h.Handler.WithAttrs(attrs), h.w}
+}
+
+func (h syslogHandler) WithGroup(name string) slog.Handler {
+	return syslogHandler{h.Handler.WithGroup(name), h.w}
+}
+
+// teeHandler sends every record to each of its handlers that wants it.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
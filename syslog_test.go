@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSyslogWriter(t *testing.T) {
+	tests := []struct {
+		target, facility string
+		wantAddr         string
+		wantErr          bool
+	}{
+		{target: "local", facility: "daemon"},
+		{target: "udp://logs.example.com", facility: "local3", wantAddr: "logs.example.com:514"},
+		{target: "tcp://10.0.0.5:601", facility: "user", wantAddr: "10.0.0.5:601"},
+		{target: "logs.example.com:514", facility: "daemon", wantErr: true},
+		{target: "http://logs.example.com", facility: "daemon", wantErr: true},
+		{target: "local", facility: "local9", wantErr: true},
+	}
+	for _, tt := range tests {
+		w, err := newSyslogWriter(tt.target, tt.facility)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newSyslogWriter(%q, %q) error = %v, wantErr %v", tt.target, tt.facility, err, tt.wantErr)
+			continue
+		}
+		if err == nil && w.addr != tt.wantAddr {
+			t.Errorf("newSyslogWriter(%q) addr = %q, want %q", tt.target, w.addr, tt.wantAddr)
+		}
+	}
+}
+
+// rfc5424RE matches the header tsddns sends, capturing the priority and
+// the message.
+var rfc5424RE = regexp.MustCompile(`^<(\d+)>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z \S+ tsddns \d+ - - (.*)$`)
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	w, err := newSyslogWriter("udp://"+pc.LocalAddr().String(), "local0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := newSyslogHandler(w, "text", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(h)
+	ctx := withRunID(context.Background())
+	logger.WarnContext(ctx, "Update failed", "domain", "corp.example.com")
+	logger.Debug("dropped")
+	logger.Info("Update finished")
+
+	want := []struct {
+		priority int
+		msg      string
+	}{
+		{16*8 + 4, `msg="Update failed" domain=corp.example.com run_id=` + runID(ctx)},
+		{16*8 + 6, `msg="Update finished"`},
+	}
+	buf := make([]byte, 2048)
+	for _, want := range want {
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("reading syslog message: %v", err)
+		}
+		m := rfc5424RE.FindStringSubmatch(string(buf[:n]))
+		if m == nil {
+			t.Fatalf("message %q isn't RFC 5424", buf[:n])
+		}
+		if m[1] != strconv.Itoa(want.priority) || m[2] != want.msg {
+			t.Errorf("message = <%s> %q, want <%d> %q", m[1], m[2], want.priority, want.msg)
+		}
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		length, _ := r.ReadString(' ')
+		n, _ := strconv.Atoi(strings.TrimSpace(length))
+		msg := make([]byte, n)
+		io.ReadFull(r, msg)
+		got <- string(msg)
+	}()
+
+	w, err := newSyslogWriter("tcp://"+ln.Addr().String(), "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := newSyslogHandler(w, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slog.New(h).Error("Update failed")
+
+	select {
+	case msg := <-got:
+		m := rfc5424RE.FindStringSubmatch(msg)
+		if m == nil || m[1] != "27" || m[2] != `{"msg":"Update failed"}` {
+			t.Errorf("message = %q, want an octet-counted RFC 5424 error", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestTeeHandler(t *testing.T) {
+	var debug, info bytes.Buffer
+	hDebug, _ := newLogHandler(&debug, "text", "debug")
+	hInfo, _ := newLogHandler(&info, "text", "info")
+	logger := slog.New(teeHandler{hDebug, hInfo}).With("domain", "corp.example.com")
+
+	logger.Debug("resolving")
+	logger.Info("updated")
+	if !strings.Contains(debug.String(), "msg=resolving") || !strings.Contains(debug.String(), "msg=updated") {
+		t.Errorf("debug handler got %q, want both records", debug.String())
+	}
+	if strings.Contains(info.String(), "resolving") || !strings.Contains(info.String(), "msg=updated domain=corp.example.com") {
+		t.Errorf("info handler got %q, want only the info record with its attributes", info.String())
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Technitium configures a Technitium DNS Server kept in sync through its
+// HTTP API.
+type Technitium struct {
+	// URL is the server's web console address, like
+	// "http://technitium:5380".
+	URL string `json:"url"`
+
+	// TokenEnv names the environment variable holding an API token.
+	// Defaults to TECHNITIUM_TOKEN.
+	TokenEnv string `json:"tokenEnv,omitempty"`
+
+	// TTL is the records' TTL. Defaults to 5m.
+	TTL Duration `json:"ttl,omitempty"`
+
+	// Records each go in the server's zone with the longest name they're
+	// in.
+	Records RecordSet `json:"records,omitempty"`
+
+	// Forward, if set, keeps a conditional forwarder zone for every
+	// applied split DNS domain, forwarding to its nameservers.
+	Forward bool `json:"forward,omitempty"`
+}
+
+func (t *Technitium) validate() error {
+	if t.URL == "" {
+		return fmt.Errorf("needs a url")
+	}
+	if len(t.Records) == 0 && !t.Forward {
+		return fmt.Errorf("needs records or forward")
+	}
+	return nil
+}
+
+// technitiumRecord is a record in the Technitium API.
+type technitiumRecord struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Comments string `json:"comments"`
+	RData    struct {
+		IPAddress string `json:"ipAddress"`
+		Protocol  string `json:"protocol"`
+		Forwarder string `json:"forwarder"`
+	} `json:"rData"`
+}
+
+func (t *Technitium) forwards() bool { return t.Forward }
+
+// sync resolves the configured records and writes them, with
+// conditional forwarder zones for the split DNS domains if forwarding, to
+// the server. splitDNS is nil when split DNS isn't managed.
+func (t *Technitium) sync(ctx context.Context, r *resolver, splitDNS map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, t.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	desired, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+
+	c := &technitiumClient{
+		url:   strings.TrimSuffix(t.URL, "/"),
+		token: os.Getenv(cmp.Or(t.TokenEnv, "TECHNITIUM_TOKEN")),
+	}
+	var list struct {
+		Zones []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"zones"`
+	}
+	if err := c.call(ctx, "/api/zones/list", nil, &list); err != nil {
+		return fmt.Errorf("listing zones: %w", err)
+	}
+	zones := make(map[string]string) // name to type
+	for _, z := range list.Zones {
+		zones[normalizeDomain(z.Name)] = z.Type
+	}
+
+	ttl := int(time.Duration(cmp.Or(t.TTL, Duration(defaultRecordTTL))).Seconds())
+	changed, err := c.syncRecords(ctx, zones, desired, ttl)
+	if err != nil {
+		return err
+	}
+	if t.Forward {
+		forwardersChanged, err := c.syncForwarders(ctx, zones, splitDNS)
+		if err != nil {
+			return err
+		}
+		changed = changed || forwardersChanged
+	}
+	if !changed {
+		slog.InfoContext(ctx, "Technitium is up to date")
+	}
+	return nil
+}
+
+// syncRecords reconciles the zones the desired records are in with them.
+// Only records carrying the tsddns comment are changed or deleted. It
+// reports whether anything changed.
+func (c *technitiumClient) syncRecords(ctx context.Context, zones map[string]string, desired []record, ttl int) (bool, error) {
+	byZone := make(map[string][]record)
+	for _, rec := range desired {
+		zone, ok := longestZone(rec.Name, zones)
+		if !ok {
+			return false, fmt.Errorf("no zone on the server contains %s", rec.Name)
+		}
+		byZone[zone] = append(byZone[zone], rec)
+	}
+	var names []string
+	for zone := range byZone {
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+
+	changed := false
+	for _, zone := range names {
+		records, err := c.zoneRecords(ctx, zone)
+		if err != nil {
+			return false, fmt.Errorf("zone %s: %w", zone, err)
+		}
+		var owned, foreign []record
+		for _, tr := range records {
+			if tr.Type != "A" && tr.Type != "AAAA" {
+				continue
+			}
+			rec := record{Name: normalizeDomain(tr.Name), Type: tr.Type, Value: tr.RData.IPAddress}
+			if tr.Comments == recordOwner {
+				owned = append(owned, rec)
+			} else {
+				foreign = append(foreign, rec)
+			}
+		}
+
+		create, remove := planRecords(byZone[zone], owned, foreign)
+		for _, rec := range remove {
+			slog.InfoContext(ctx, "Deleting Technitium record", "name", rec.Name, "type", rec.Type, "value", rec.Value)
+			params := url.Values{"zone": {zone}, "domain": {rec.Name}, "type": {rec.Type}, "ipAddress": {rec.Value}}
+			if err := c.call(ctx, "/api/zones/records/delete", params, nil); err != nil {
+				return false, fmt.Errorf("deleting %s: %w", rec.Name, err)
+			}
+		}
+		for _, rec := range create {
+			slog.InfoContext(ctx, "Creating Technitium record", "name", rec.Name, "type", rec.Type, "value", rec.Value)
+			params := url.Values{
+				"zone":      {zone},
+				"domain":    {rec.Name},
+				"type":      {rec.Type},
+				"ipAddress": {rec.Value},
+				"ttl":       {strconv.Itoa(ttl)},
+				"comments":  {recordOwner},
+			}
+			if err := c.call(ctx, "/api/zones/records/add", params, nil); err != nil {
+				return false, fmt.Errorf("creating %s: %w", rec.Name, err)
+			}
+		}
+		changed = changed || len(create) > 0 || len(remove) > 0
+	}
+	return changed, nil
+}
+
+// syncForwarders keeps a conditional forwarder zone for every split DNS
+// domain. Zones can't be marked, so tsddns manages the forwarder zones of
+// the split DNS domains, and deletes the ones it created before according
+// to the state file once their domain is gone. It reports whether anything
+// changed.
+func (c *technitiumClient) syncForwarders(ctx context.Context, zones map[string]string, splitDNS map[string][]string) (bool, error) {
+	domains, addrs := exportDomains(splitDNS)
+	managed, err := loadOwnedRecords("technitium")
+	if err != nil {
+		return false, err
+	}
+
+	var names []string
+	wanted := make(map[string][]string)
+	for _, domain := range domains {
+		name := normalizeDomain(domain)
+		wanted[name] = addrs[domain]
+		managed[name] = true
+		names = append(names, name)
+	}
+	var all []string
+	for name := range managed {
+		all = append(all, name)
+	}
+	sort.Strings(all)
+
+	changed := false
+	for _, zone := range all {
+		typ, exists := zones[zone]
+		forwarders := wanted[zone]
+		switch {
+		case !exists && len(forwarders) == 0:
+			continue
+		case exists && typ != "Forwarder":
+			slog.WarnContext(ctx, "Domain is another type of zone on the Technitium server, not forwarding it", "domain", zone, "type", typ)
+			continue
+		case len(forwarders) == 0:
+			slog.InfoContext(ctx, "Deleting Technitium forwarder zone", "domain", zone)
+			if err := c.call(ctx, "/api/zones/delete", url.Values{"zone": {zone}}, nil); err != nil {
+				return false, fmt.Errorf("deleting zone %s: %w", zone, err)
+			}
+			changed = true
+			continue
+		}
+
+		var current []string
+		if exists {
+			records, err := c.zoneRecords(ctx, zone)
+			if err != nil {
+				return false, fmt.Errorf("zone %s: %w", zone, err)
+			}
+			for _, tr := range records {
+				if tr.Type == "FWD" && normalizeDomain(tr.Name) == zone {
+					current = append(current, tr.RData.Forwarder)
+				}
+			}
+		} else {
+			// the zone is created with its first forwarder
+			slog.InfoContext(ctx, "Creating Technitium forwarder zone", "domain", zone)
+			params := url.Values{"zone": {zone}, "type": {"Forwarder"}, "protocol": {"Udp"}, "forwarder": {forwarders[0]}}
+			if err := c.call(ctx, "/api/zones/create", params, nil); err != nil {
+				return false, fmt.Errorf("creating zone %s: %w", zone, err)
+			}
+			current, changed = forwarders[:1], true
+		}
+		zoneChanged, err := c.setForwarders(ctx, zone, forwarders, current)
+		if err != nil {
+			return false, fmt.Errorf("zone %s: %w", zone, err)
+		}
+		changed = changed || zoneChanged
+	}
+	return changed, saveOwnedRecords("technitium", names)
+}
+
+// setForwarders adds and deletes a forwarder zone's FWD records so that it
+// forwards to exactly forwarders, and reports whether anything changed.
+func (c *technitiumClient) setForwarders(ctx context.Context, zone string, forwarders, current []string) (bool, error) {
+	changed := false
+	want := make(map[string]bool)
+	for _, f := range forwarders {
+		want[f] = true
+	}
+	have := make(map[string]bool)
+	for _, f := range current {
+		have[f] = true
+		if !want[f] {
+			slog.InfoContext(ctx, "Removing Technitium forwarder", "domain", zone, "server", f)
+			params := url.Values{"zone": {zone}, "domain": {zone}, "type": {"FWD"}, "protocol": {"Udp"}, "forwarder": {f}}
+			if err := c.call(ctx, "/api/zones/records/delete", params, nil); err != nil {
+				return false, err
+			}
+			changed = true
+		}
+	}
+	for _, f := range forwarders {
+		if !have[f] {
+			slog.InfoContext(ctx, "Adding Technitium forwarder", "domain", zone, "server", f)
+			params := url.Values{"zone": {zone}, "domain": {zone}, "type": {"FWD"}, "protocol": {"Udp"}, "forwarder": {f}}
+			if err := c.call(ctx, "/api/zones/records/add", params, nil); err != nil {
+				return false, err
+			}
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// technitiumClient talks to the Technitium HTTP API.
+type technitiumClient struct {
+	url   string
+	token string
+}
+
+// zoneRecords returns every record in zone.
+func (c *technitiumClient) zoneRecords(ctx context.Context, zone string) ([]technitiumRecord, error) {
+	var resp struct {
+		Records []technitiumRecord `json:"records"`
+	}
+	params := url.Values{"zone": {zone}, "domain": {zone}, "listZone": {"true"}}
+	if err := c.call(ctx, "/api/zones/records/get", params, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Records, nil
+}
+
+// call makes an API call and decodes its response into out.
+func (c *technitiumClient) call(ctx context.Context, path string, params url.Values, out any) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("token", c.token)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url+path, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var envelope struct {
+		Status       string          `json:"status"`
+		ErrorMessage string          `json:"errorMessage"`
+		Response     json.RawMessage `json:"response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("API returned status %d", resp.StatusCode)
+	}
+	if envelope.Status != "ok" {
+		return fmt.Errorf("API returned %s: %s", envelope.Status, envelope.ErrorMessage)
+	}
+	if out == nil || len(envelope.Response) == 0 {
+		return nil
+	}
+	return json.Unmarshal(envelope.Response, out)
+}
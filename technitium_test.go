@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSyncTechnitium(t *testing.T) {
+	zones := []map[string]string{
+		{"name": "internal.example.com", "type": "Primary"},
+		{"name": "old.ts.net", "type": "Forwarder"},
+		{"name": "lab.ts.net", "type": "Forwarder"},
+		{"name": "corp.ts.net", "type": "Primary"},
+	}
+	record := func(name, typ, ip, comments string) map[string]any {
+		return map[string]any{"name": name, "type": typ, "comments": comments, "rData": map[string]string{"ipAddress": ip}}
+	}
+	zoneRecords := map[string][]map[string]any{
+		"internal.example.com": {
+			record("internal.example.com", "SOA", "", ""),
+			record("ns.internal.example.com", "A", "100.64.0.1", recordOwner),
+			record("old.internal.example.com", "A", "100.64.0.2", recordOwner),
+			record("manual.internal.example.com", "A", "100.64.0.3", ""),
+		},
+		"lab.ts.net": {
+			{"name": "lab.ts.net", "type": "FWD", "rData": map[string]string{"protocol": "Udp", "forwarder": "100.64.0.9"}},
+		},
+	}
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("token") != "secret" {
+			w.Write([]byte(`{"status": "invalid-token", "errorMessage": "Invalid token."}`))
+			return
+		}
+		var response any
+		switch r.URL.Path {
+		case "/api/zones/list":
+			response = map[string]any{"zones": zones}
+		case "/api/zones/records/get":
+			response = map[string]any{"records": zoneRecords[r.Form.Get("zone")]}
+		default:
+			call := strings.TrimPrefix(r.URL.Path, "/api/zones/") + " " + r.Form.Get("zone")
+			for _, key := range []string{"domain", "type", "ipAddress", "forwarder", "comments"} {
+				if v := r.Form.Get(key); v != "" {
+					call += " " + v
+				}
+			}
+			calls = append(calls, call)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"status": "ok", "response": response})
+	}))
+	defer srv.Close()
+	t.Setenv("TECHNITIUM_TOKEN", "secret")
+
+	stateFile = filepath.Join(t.TempDir(), "state.json")
+	defer func() { stateFile = "" }()
+	if err := saveOwnedRecords("technitium", []string{"old.ts.net", "lab.ts.net"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tc := &Technitium{
+		URL:     srv.URL + "/",
+		Forward: true,
+		Records: Config{
+			"ns.internal.example.com":  {"100.64.0.1"},
+			"new.internal.example.com": {"100.64.0.5"},
+		},
+	}
+	splitDNS := map[string][]string{
+		"lab.ts.net":  {"100.64.0.10"},
+		"new.ts.net":  {"100.64.0.11", "100.64.0.12"},
+		"corp.ts.net": {"100.64.0.13"},
+		"doh.ts.net":  {"https://dns.example.com/dns-query"},
+	}
+	if err := tc.sync(context.Background(), newResolver(nil, Defaults{}), splitDNS); err != nil {
+		t.Fatalf("sync() unexpected error: %v", err)
+	}
+	want := []string{
+		"records/delete internal.example.com old.internal.example.com A 100.64.0.2",
+		"records/add internal.example.com new.internal.example.com A 100.64.0.5 " + recordOwner,
+		"records/delete lab.ts.net lab.ts.net FWD 100.64.0.9",
+		"records/add lab.ts.net lab.ts.net FWD 100.64.0.10",
+		"create new.ts.net Forwarder 100.64.0.11",
+		"records/add new.ts.net new.ts.net FWD 100.64.0.12",
+		"delete old.ts.net",
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	owned, err := loadOwnedRecords("technitium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owned) != 3 || owned["old.ts.net"] || !owned["new.ts.net"] {
+		t.Errorf("owned zones = %v, want the three forwarded domains", owned)
+	}
+
+	t.Setenv("TECHNITIUM_TOKEN", "wrong")
+	if err := tc.sync(context.Background(), newResolver(nil, Defaults{}), splitDNS); err == nil || !strings.Contains(err.Error(), "Invalid token") {
+		t.Errorf("sync() error = %v, want the API's error", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+// runExport implements `tsddns export`, which resolves the config and
+// prints the DNS settings it would apply instead of applying them, so they
+// can be fed to another tool.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := fs.String("config", "/config.json", "Path to config.json")
+	format := fs.String("format", "terraform", "Output format: terraform")
+	out := fs.String("out", "", "Where to write the export (default: stdout)")
+	tailnet := fs.String("tailnet", "-", "Tailscale tailnet name")
+	apiKey := fs.String("api-key", os.Getenv("TAILSCALE_API_KEY"), "Tailscale API key")
+	clientID := fs.String("client-id", os.Getenv("TAILSCALE_CLIENT_ID"), "OAuth client ID")
+	clientSecret := fs.String("client-secret", os.Getenv("TAILSCALE_CLIENT_SECRET"), "OAuth client secret")
+	baseURL := fs.String("base-url", "https://api.tailscale.com", "API base URL")
+	profileName := fs.String("profile", "", "Config profile to use")
+	fs.Parse(args)
+
+	if *format != "terraform" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	cfg, profile, err := cfg.forProfile(*profileName)
+	if err != nil {
+		return err
+	}
+	if profile != nil {
+		applyProfile(fs, profile, tailnet, apiKey, clientID, clientSecret)
+	}
+	if cfg.Mode == "records" || cfg.Mode == "server" {
+		return fmt.Errorf("%s mode doesn't manage tailnet DNS, so there is nothing to export", cfg.Mode)
+	}
+	client, err := createClient(*tailnet, *apiKey, *clientID, *clientSecret, *baseURL)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	r := newResolver(client, cfg.Defaults)
+	var splitDNS map[string][]string
+	if cfg.Mode != "global" {
+		if splitDNS, _, err = desiredSplitDNS(ctx, r, cfg); err != nil {
+			return err
+		}
+	}
+	var global []string
+	if len(cfg.Global) > 0 {
+		if global, err = r.resolveGlobal(ctx, cfg.Global); err != nil {
+			return fmt.Errorf("resolving global nameservers: %w", err)
+		}
+	}
+
+	data := renderTerraform(splitDNS, global, cfg.SearchPaths, cfg.preferences())
+	if *out == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := writeFileAtomic(*out, data, 0o644); err != nil {
+		return err
+	}
+	slog.Info("Wrote export", "type", *format, "path", *out)
+	return nil
+}
+
+// renderTerraform renders resources of the Tailscale Terraform provider for
+// the resolved DNS settings. Only the settings the config manages are
+// rendered.
+func renderTerraform(splitDNS map[string][]string, global, searchPaths []string, prefs *Preferences) []byte {
+	var b strings.Builder
+	b.WriteString("# Generated by tsddns from the resolved config. Do not edit.\n")
+
+	var domains []string
+	for domain := range splitDNS {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		fmt.Fprintf(&b, "\nresource \"tailscale_dns_split_nameservers\" %q {\n", terraformName(domain))
+		fmt.Fprintf(&b, "  domain      = %q\n", domain)
+		fmt.Fprintf(&b, "  nameservers = %s\n}\n", terraformList(splitDNS[domain]))
+	}
+	if global != nil {
+		fmt.Fprintf(&b, "\nresource \"tailscale_dns_nameservers\" \"tsddns\" {\n  nameservers = %s\n}\n", terraformList(global))
+	}
+	if searchPaths != nil {
+		fmt.Fprintf(&b, "\nresource \"tailscale_dns_search_paths\" \"tsddns\" {\n  search_paths = %s\n}\n", terraformList(searchPaths))
+	}
+	if prefs != nil && prefs.MagicDNS != nil {
+		fmt.Fprintf(&b, "\nresource \"tailscale_dns_preferences\" \"tsddns\" {\n  magic_dns = %t\n}\n", *prefs.MagicDNS)
+	}
+	if prefs != nil && prefs.OverrideLocalDNS != nil {
+		// the provider only has this setting on tailscale_dns_configuration,
+		// which replaces every other DNS resource
+		fmt.Fprintf(&b, "\n# override_local_dns = %t has no standalone resource; set it on tailscale_dns_configuration if you use that instead.\n", *prefs.OverrideLocalDNS)
+	}
+	return []byte(b.String())
+}
+
+// terraformName turns a domain into a resource name.
+func terraformName(domain string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, normalizeDomain(domain))
+	if name == "" || name[0] >= '0' && name[0] <= '9' || name[0] == '-' {
+		name = "_" + name
+	}
+	return name
+}
+
+// terraformList renders a list of strings.
+func terraformList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
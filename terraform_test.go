@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestRenderTerraform(t *testing.T) {
+	on, off := true, false
+	splitDNS := map[string][]string{
+		"lab.example.com":     {"10.0.0.53"},
+		"corp.example.com":    {"100.64.0.1", "https://dns.example.com/dns-query"},
+		"64.100.in-addr.arpa": {"100.64.0.53"},
+	}
+	got := string(renderTerraform(splitDNS, []string{"1.1.1.1"}, []string{"corp.example.com"}, &Preferences{MagicDNS: &on, OverrideLocalDNS: &off}))
+	want := `# Generated by tsddns from the resolved config. Do not edit.
+
+resource "tailscale_dns_split_nameservers" "_64_100_in-addr_arpa" {
+  domain      = "64.100.in-addr.arpa"
+  nameservers = ["100.64.0.53"]
+}
+
+resource "tailscale_dns_split_nameservers" "corp_example_com" {
+  domain      = "corp.example.com"
+  nameservers = ["100.64.0.1", "https://dns.example.com/dns-query"]
+}
+
+resource "tailscale_dns_split_nameservers" "lab_example_com" {
+  domain      = "lab.example.com"
+  nameservers = ["10.0.0.53"]
+}
+
+resource "tailscale_dns_nameservers" "tsddns" {
+  nameservers = ["1.1.1.1"]
+}
+
+resource "tailscale_dns_search_paths" "tsddns" {
+  search_paths = ["corp.example.com"]
+}
+
+resource "tailscale_dns_preferences" "tsddns" {
+  magic_dns = true
+}
+
+# override_local_dns = false has no standalone resource; set it on tailscale_dns_configuration if you use that instead.
+`
+	if got != want {
+		t.Errorf("renderTerraform() = %s, want %s", got, want)
+	}
+
+	// only what the config manages is rendered
+	got = string(renderTerraform(map[string][]string{"Corp.Example.com": {"100.64.0.1"}}, nil, nil, nil))
+	want = `# Generated by tsddns from the resolved config. Do not edit.
+
+resource "tailscale_dns_split_nameservers" "corp_example_com" {
+  domain      = "Corp.Example.com"
+  nameservers = ["100.64.0.1"]
+}
+`
+	if got != want {
+		t.Errorf("renderTerraform() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracer is where finished traces are exported to. nil turns tracing off,
+// which makes every span a no-op.
+var tracer *otlpExporter
+
+// OTLP span kinds and status codes.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+
+	spanStatusOK    = 1
+	spanStatusError = 2
+)
+
+// otlpExportTimeout bounds how long exporting a trace may take, so a down
+// collector doesn't hold up updates.
+const otlpExportTimeout = 10 * time.Second
+
+// otlpExporter sends traces to an OpenTelemetry collector with OTLP over
+// HTTP, JSON encoded.
+type otlpExporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// newOTLPExporter exports to the collector at endpoint, like
+// http://localhost:4318, adding headers, given as comma-separated
+// key=value pairs like OTEL_EXPORTER_OTLP_HEADERS.
+func newOTLPExporter(endpoint, headers string) (*otlpExporter, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("OTLP endpoint %q must start with http:// or https://", endpoint)
+	}
+	e := &otlpExporter{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers: make(map[string]string),
+		client:  &http.Client{Timeout: otlpExportTimeout},
+	}
+	for _, pair := range strings.Split(headers, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("OTLP header %q isn't key=value", pair)
+		}
+		e.headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return e, nil
+}
+
+// trace collects the spans of one trace until its root span ends.
+type trace struct {
+	id       [16]byte
+	exporter *otlpExporter
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+// span is one timed operation of a trace. A nil span, which startSpan
+// returns with tracing off, is valid and does nothing.
+type span struct {
+	trace  *trace
+	id     [8]byte
+	parent [8]byte
+	name   string
+	kind   int
+	start  time.Time
+	end    time.Time
+	attrs  map[string]any
+	err    error
+}
+
+type spanKey struct{}
+
+// startSpan starts a span named name, as a child of ctx's span or as the
+// root of a new trace. attrs are key/value pairs, like slog's. The returned
+// context carries the span for children to find.
+func startSpan(ctx context.Context, name string, attrs ...any) (context.Context, *span) {
+	return startSpanKind(ctx, name, spanKindInternal, attrs...)
+}
+
+func startSpanKind(ctx context.Context, name string, kind int, attrs ...any) (context.Context, *span) {
+	parent, _ := ctx.Value(spanKey{}).(*span)
+	if parent == nil && tracer == nil {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: kind, start: time.Now(), attrs: make(map[string]any)}
+	rand.Read(s.id[:])
+	if parent != nil {
+		s.trace = parent.trace
+		s.parent = parent.id
+	} else {
+		s.trace = &trace{exporter: tracer}
+		rand.Read(s.trace.id[:])
+	}
+	s.set(attrs...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// set adds key/value attributes to the span.
+func (s *span) set(attrs ...any) {
+	if s == nil {
+		return
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if k, ok := attrs[i].(string); ok {
+			s.attrs[k] = attrs[i+1]
+		}
+	}
+}
+
+// finish ends the span, failed if err isn't nil. Finishing the root span
+// exports the whole trace.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+
+	t := s.trace
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	spans := t.spans
+	t.mu.Unlock()
+	if s.parent == [8]byte{} {
+		if err := t.exporter.export(spans); err != nil {
+			slog.Warn("Exporting trace failed", "error", err)
+		}
+	}
+}
+
+// export sends spans to the collector as one OTLP request.
+func (e *otlpExporter) export(spans []*span) error {
+	otlpSpans := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		otlpSpans = append(otlpSpans, s.otlp())
+	}
+	host, _ := os.Hostname()
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttributes(map[string]any{
+				"service.name": "tsddns",
+				"host.name":    host,
+			})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "tsddns"},
+				"spans": otlpSpans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// otlp returns the span in OTLP's JSON encoding.
+func (s *span) otlp() map[string]any {
+	m := map[string]any{
+		"traceId":           hex.EncodeToString(s.trace.id[:]),
+		"spanId":            hex.EncodeToString(s.id[:]),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attrs),
+		"status":            map[string]any{"code": spanStatusOK},
+	}
+	if s.parent != [8]byte{} {
+		m["parentSpanId"] = hex.EncodeToString(s.parent[:])
+	}
+	if s.err != nil {
+		m["status"] = map[string]any{"code": spanStatusError, "message": s.err.Error()}
+	}
+	return m
+}
+
+// otlpAttributes encodes attributes as OTLP key/value pairs. Values other
+// than strings, ints and bools are sent as their string form.
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]any
+		switch v := v.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": k, "value": value})
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// otlpRequest is the part of an OTLP/HTTP JSON trace export the tests look
+// at.
+type otlpRequest struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID      string `json:"traceId"`
+				SpanID       string `json:"spanId"`
+				ParentSpanID string `json:"parentSpanId"`
+				Name         string `json:"name"`
+				Kind         int    `json:"kind"`
+				Attributes   []struct {
+					Key   string            `json:"key"`
+					Value map[string]string `json:"value"`
+				} `json:"attributes"`
+				Status struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func TestTraceExport(t *testing.T) {
+	var requests []otlpRequest
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with content type %q", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding export: %v", err)
+		}
+		requests = append(requests, req)
+		headers = append(headers, r.Header)
+	}))
+	defer server.Close()
+
+	exporter, err := newOTLPExporter(server.URL+"/", "x-honeycomb-team=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer = exporter
+	defer func() { tracer = nil }()
+
+	ctx, root := startSpan(context.Background(), "reconcile", "mode", "split")
+	childCtx, child := startSpan(ctx, "resolve selector", "domain", "example.com", "selector", "tag:dns")
+	_, grandchild := startSpanKind(childCtx, "Tailscale API GET", spanKindClient)
+	grandchild.set("http.response.status_code", 200)
+	grandchild.finish(nil)
+	child.finish(errors.New("no device"))
+	if len(requests) != 0 {
+		t.Fatalf("exported %d times before the root span finished", len(requests))
+	}
+	root.finish(nil)
+
+	if len(requests) != 1 {
+		t.Fatalf("exported %d times, want 1", len(requests))
+	}
+	if got := headers[0].Get("X-Honeycomb-Team"); got != "secret" {
+		t.Errorf("header = %q, want secret", got)
+	}
+	spans := requests[0].ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	byName := make(map[string]int)
+	for i, s := range spans {
+		byName[s.Name] = i
+		if s.TraceID != spans[0].TraceID || len(s.TraceID) != 32 || len(s.SpanID) != 16 {
+			t.Errorf("span %s has trace %q and ID %q", s.Name, s.TraceID, s.SpanID)
+		}
+	}
+	r, c, g := spans[byName["reconcile"]], spans[byName["resolve selector"]], spans[byName["Tailscale API GET"]]
+	if r.ParentSpanID != "" || c.ParentSpanID != r.SpanID || g.ParentSpanID != c.SpanID {
+		t.Errorf("parents are %q, %q, %q, want none, %q, %q", r.ParentSpanID, c.ParentSpanID, g.ParentSpanID, r.SpanID, c.SpanID)
+	}
+	if r.Status.Code != spanStatusOK || c.Status.Code != spanStatusError || c.Status.Message != "no device" {
+		t.Errorf("statuses are %+v and %+v", r.Status, c.Status)
+	}
+	if g.Kind != spanKindClient {
+		t.Errorf("API span kind = %d, want %d", g.Kind, spanKindClient)
+	}
+	attrs := make(map[string]map[string]string)
+	for _, a := range c.Attributes {
+		attrs[a.Key] = a.Value
+	}
+	if attrs["domain"]["stringValue"] != "example.com" || attrs["selector"]["stringValue"] != "tag:dns" {
+		t.Errorf("resolve span attributes = %v", attrs)
+	}
+	for _, a := range g.Attributes {
+		if a.Key == "http.response.status_code" && a.Value["intValue"] != "200" {
+			t.Errorf("status code attribute = %v", a.Value)
+		}
+	}
+}
+
+func TestTracingOff(t *testing.T) {
+	ctx, s := startSpan(context.Background(), "reconcile")
+	if s != nil {
+		t.Fatalf("got span %v with tracing off", s)
+	}
+	_, child := startSpan(ctx, "resolve selector")
+	child.set("domain", "example.com")
+	child.finish(nil)
+	s.finish(errors.New("failed"))
+}
+
+func TestNewOTLPExporter(t *testing.T) {
+	tests := []struct {
+		endpoint, headers string
+		wantURL           string
+		wantHeaders       int
+		wantErr           bool
+	}{
+		{endpoint: "http://localhost:4318", wantURL: "http://localhost:4318/v1/traces"},
+		{endpoint: "https://otel.example.com/", headers: "a=1, b = 2", wantURL: "https://otel.example.com/v1/traces", wantHeaders: 2},
+		{endpoint: "localhost:4318", wantErr: true},
+		{endpoint: "http://localhost:4318", headers: "novalue", wantErr: true},
+	}
+	for _, tt := range tests {
+		e, err := newOTLPExporter(tt.endpoint, tt.headers)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newOTLPExporter(%q, %q) error = %v, wantErr %v", tt.endpoint, tt.headers, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (e.url != tt.wantURL || len(e.headers) != tt.wantHeaders) {
+			t.Errorf("newOTLPExporter(%q, %q) = %s with %v", tt.endpoint, tt.headers, e.url, e.headers)
+		}
+	}
+}
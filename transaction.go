@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const rollbackTimeout = time.Minute
+
+// transaction applies the writes of one update cycle as a unit. If a write
+// fails, the writes before it are rolled back in reverse order to the
+// snapshot taken before the cycle, so the tailnet is never left half
+// applied. It covers the tailnet's DNS settings and the split DNS backend
+// only: DNS record backends are synced best-effort after it commits, and a
+// failing one doesn't roll it back.
+type transaction struct {
+	steps []txStep
+
+	// applied is how many steps commit applied before it finished or
+	// failed, whether or not they were rolled back since.
+	applied int
+}
+
+type txStep struct {
+	name     string
+	apply    func(context.Context) error
+	rollback func(context.Context) error
+}
+
+// add queues a write. rollback restores what was there before apply ran and
+// may be nil if the write has nothing to undo.
+func (t *transaction) add(name string, apply, rollback func(context.Context) error) {
+	t.steps = append(t.steps, txStep{name: name, apply: apply, rollback: rollback})
+}
+
+// commit runs every step in order, rolling back the completed ones if a
+// step fails.
+func (t *transaction) commit(ctx context.Context) error {
+	for i, s := range t.steps {
+		stepCtx, sp := startSpan(ctx, "write", "step", s.name)
+		err := s.apply(stepCtx)
+		sp.finish(err)
+		if err != nil {
+			err = fmt.Errorf("applying %s: %w", s.name, err)
+			if i == 0 {
+				return err
+			}
+			if rbErr := t.rollback(ctx, i); rbErr != nil {
+				return fmt.Errorf("%w; rollback incomplete, tailnet may be partially updated: %w", err, rbErr)
+			}
+			return fmt.Errorf("%w; rolled back %d earlier writes", err, i)
+		}
+		t.applied++
+	}
+	return nil
+}
+
+// rollback undoes the first n steps, newest first. It keeps going past
+// failures so that as much as possible is restored.
+func (t *transaction) rollback(ctx context.Context, n int) error {
+	// a cancelled cycle still needs to be undone
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	var errs []error
+	for i := n - 1; i >= 0; i-- {
+		s := t.steps[i]
+		if s.rollback == nil {
+			continue
+		}
+		slog.WarnContext(ctx, "Rolling back", "step", s.name)
+		stepCtx, sp := startSpan(ctx, "rollback", "step", s.name)
+		err := s.rollback(stepCtx)
+		sp.finish(err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTransactionCommit(t *testing.T) {
+	tests := []struct {
+		name         string
+		failApply    string
+		failRollback string
+		wantErr      string
+		wantLog      []string
+	}{
+		{
+			name:    "all steps succeed",
+			wantLog: []string{"apply a", "apply b", "apply c"},
+		},
+		{
+			name:      "first step fails",
+			failApply: "a",
+			wantErr:   "applying a",
+			wantLog:   []string{"apply a"},
+		},
+		{
+			name:      "later step fails",
+			failApply: "c",
+			wantErr:   "rolled back 2 earlier writes",
+			wantLog:   []string{"apply a", "apply b", "apply c", "rollback b", "rollback a"},
+		},
+		{
+			name:         "rollback fails",
+			failApply:    "c",
+			failRollback: "b",
+			wantErr:      "rollback incomplete",
+			wantLog:      []string{"apply a", "apply b", "apply c", "rollback b", "rollback a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			step := func(action, name, fail string) func(context.Context) error {
+				return func(context.Context) error {
+					got = append(got, action+" "+name)
+					if name == fail {
+						return errors.New("boom")
+					}
+					return nil
+				}
+			}
+
+			var tx transaction
+			for _, name := range []string{"a", "b", "c"} {
+				tx.add(name, step("apply", name, tt.failApply), step("rollback", name, tt.failRollback))
+			}
+
+			err := tx.commit(context.Background())
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("commit() unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("commit() error = %v, want containing %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.wantLog) {
+				t.Errorf("steps = %v, want %v", got, tt.wantLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// via6Prefix is the range Tailscale maps 4via6 addresses into. The site ID
+// goes in the 32 bits after it and the IPv4 address in the last 32.
+var via6Prefix = netip.MustParsePrefix("fd7a:115c:a1e0:b1a::/64")
+
+// maxViaSiteID is the largest site ID Tailscale accepts.
+const maxViaSiteID = 0xffff
+
+// resolveVia resolves a 4via6: entry, written as site/ipv4, to the 4via6
+// address of that IPv4 address at that site.
+func resolveVia(value string) ([]string, error) {
+	site, ip, ok := strings.Cut(value, "/")
+	if !ok {
+		return nil, fmt.Errorf("want site/ipv4, got %q", value)
+	}
+	siteID, err := strconv.ParseUint(site, 10, 32)
+	if err != nil || siteID > maxViaSiteID {
+		return nil, fmt.Errorf("site ID %q must be between 0 and %d", site, maxViaSiteID)
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is4() {
+		return nil, fmt.Errorf("%q is not an IPv4 address", ip)
+	}
+	return []string{via6Addr(uint32(siteID), addr).String()}, nil
+}
+
+// via6Addr returns the 4via6 address of the IPv4 address v4 at siteID.
+func via6Addr(siteID uint32, v4 netip.Addr) netip.Addr {
+	a := via6Prefix.Addr().As16()
+	a[8], a[9], a[10], a[11] = byte(siteID>>24), byte(siteID>>16), byte(siteID>>8), byte(siteID)
+	b := v4.As4()
+	copy(a[12:], b[:])
+	return netip.AddrFrom16(a)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestResolveVia(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "site 7", entry: "4via6:7/10.1.1.53", want: []string{"fd7a:115c:a1e0:b1a:0:7:a01:135"}},
+		{name: "site 0", entry: "4via6:0/192.168.1.1", want: []string{"fd7a:115c:a1e0:b1a::c0a8:101"}},
+		{name: "largest site", entry: "4via6:65535/10.0.0.1", want: []string{"fd7a:115c:a1e0:b1a:0:ffff:a00:1"}},
+		{name: "site too large", entry: "4via6:65536/10.0.0.1", wantErr: true},
+		{name: "negative site", entry: "4via6:-1/10.0.0.1", wantErr: true},
+		{name: "ipv6 address", entry: "4via6:7/fd00::1", wantErr: true},
+		{name: "no site", entry: "4via6:10.1.1.53", wantErr: true},
+	}
+
+	r := newResolver(nil, Defaults{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.resolve(context.Background(), parseEntry(tt.entry))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
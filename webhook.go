@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webhookTolerance is how far a webhook signature's timestamp may be from
+// now before the request is rejected as a replay.
+const webhookTolerance = 5 * time.Minute
+
+// ignoredWebhookEvents are Tailscale webhook events that can't change what
+// any entry resolves to: reminders, alerts about machines' own config, and
+// the test event the admin console sends.
+var ignoredWebhookEvents = map[string]bool{
+	"test":                           true,
+	"nodeNeedsApproval":              true,
+	"nodeNeedsSignature":             true,
+	"nodeKeyExpiringInOneDay":        true,
+	"userNeedsApproval":              true,
+	"subnetIPForwardingNotEnabled":   true,
+	"exitNodeIPForwardingNotEnabled": true,
+}
+
+// webhookEvent is one event of a Tailscale webhook delivery.
+type webhookEvent struct {
+	Type    string `json:"type"`
+	Tailnet string `json:"tailnet"`
+	Message string `json:"message"`
+}
+
+// webhookHandler receives Tailscale webhook deliveries and triggers an
+// update for those with an event that can change the tailnet's devices,
+// users, services or policy.
+type webhookHandler struct {
+	secret  []byte
+	trigger chan<- struct{}
+	now     func() time.Time
+}
+
+// startWebhookListener serves the webhook endpoint on addr. Relevant
+// events send on trigger, which should be buffered so a burst of events
+// collapses into one update.
+func startWebhookListener(addr, secret string, trigger chan<- struct{}) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	h := &webhookHandler{secret: []byte(secret), trigger: trigger, now: time.Now}
+	go func() {
+		if err := http.Serve(ln, h); err != nil {
+			slog.Error("Webhook listener stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "reading body", http.StatusBadRequest)
+		return
+	}
+	if err := verifyWebhookSignature(r.Header.Get("Tailscale-Webhook-Signature"), body, h.secret, h.now()); err != nil {
+		slog.Warn("Rejected webhook", "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	var events []webhookEvent
+	if err := json.Unmarshal(body, &events); err != nil {
+		http.Error(w, "bad body", http.StatusBadRequest)
+		return
+	}
+
+	relevant := false
+	for _, e := range events {
+		if ignoredWebhookEvents[e.Type] {
+			continue
+		}
+		slog.Info("Webhook event", "event", e.Type, "message", e.Message)
+		relevant = true
+	}
+	if relevant {
+		select {
+		case h.trigger <- struct{}{}:
+		default:
+			// an update is already pending and will see this change too
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// verifyWebhookSignature checks a Tailscale-Webhook-Signature header,
+// "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">", against
+// secret.
+func verifyWebhookSignature(header string, body, secret []byte, now time.Time) error {
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+	if ts == "" || len(sigs) == 0 {
+		return fmt.Errorf("missing signature")
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad timestamp %q", ts)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return fmt.Errorf("timestamp is %v off", age.Round(time.Second))
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	want := mac.Sum(nil)
+	for _, sig := range sigs {
+		if got, err := hex.DecodeString(sig); err == nil && hmac.Equal(got, want) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature mismatch")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signWebhook(body string, secret string, ts time.Time) string {
+	t := fmt.Sprint(ts.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "." + body))
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := `[{"type":"nodeCreated"}]`
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{name: "valid", header: signWebhook(body, "secret", now)},
+		{name: "one of several signatures", header: signWebhook(body, "secret", now) + ",v1=00ff"},
+		{name: "wrong secret", header: signWebhook(body, "other", now), wantErr: true},
+		{name: "too old", header: signWebhook(body, "secret", now.Add(-10*time.Minute)), wantErr: true},
+		{name: "missing", header: "", wantErr: true},
+		{name: "bad timestamp", header: "t=soon,v1=00", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyWebhookSignature(tt.header, []byte(body), []byte("secret"), now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyWebhookSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookHandler(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name        string
+		body        string
+		sign        bool
+		wantStatus  int
+		wantTrigger bool
+	}{
+		{name: "device added", body: `[{"type":"nodeCreated","message":"Node ns1 created"}]`, sign: true, wantStatus: http.StatusOK, wantTrigger: true},
+		{name: "policy updated", body: `[{"type":"test"},{"type":"policyUpdate"}]`, sign: true, wantStatus: http.StatusOK, wantTrigger: true},
+		{name: "test event", body: `[{"type":"test"}]`, sign: true, wantStatus: http.StatusOK},
+		{name: "unsigned", body: `[{"type":"nodeDeleted"}]`, wantStatus: http.StatusUnauthorized},
+		{name: "bad body", body: `{"type":"nodeDeleted"}`, sign: true, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trigger := make(chan struct{}, 1)
+			h := &webhookHandler{secret: []byte("secret"), trigger: trigger, now: func() time.Time { return now }}
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if tt.sign {
+				req.Header.Set("Tailscale-Webhook-Signature", signWebhook(tt.body, "secret", now))
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := len(trigger) == 1; got != tt.wantTrigger {
+				t.Errorf("triggered = %v, want %v", got, tt.wantTrigger)
+			}
+		})
+	}
+
+	// a burst of events collapses into one pending update
+	trigger := make(chan struct{}, 1)
+	h := &webhookHandler{secret: []byte("secret"), trigger: trigger, now: func() time.Time { return now }}
+	body := `[{"type":"nodeDeleted"}]`
+	for range 3 {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Tailscale-Webhook-Signature", signWebhook(body, "secret", now))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if len(trigger) != 1 {
+		t.Errorf("pending updates = %d, want 1", len(trigger))
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseWeight splits the weight option off a nameserver entry, as in
+// "device:ns1?weight=3" or "10.0.0.53?weight=10", keeping any other
+// options. Entries without one have weight 1. Weights only order the
+// nameservers; they never repeat one.
+func parseWeight(ns string) (string, int, error) {
+	i := strings.LastIndex(ns, "?")
+	if i < 0 || !optionsRE.MatchString(ns[i+1:]) {
+		return ns, 1, nil
+	}
+	var kept []string
+	weight := 0
+	for _, opt := range strings.Split(ns[i+1:], "&") {
+		value, ok := strings.CutPrefix(opt, "weight=")
+		if !ok {
+			kept = append(kept, opt)
+			continue
+		}
+		w, err := strconv.Atoi(value)
+		if err != nil || w < 1 || weight != 0 {
+			return "", 0, fmt.Errorf("bad weight in %s: must be a whole number of at least 1, given once", ns)
+		}
+		weight = w
+	}
+	if weight == 0 {
+		return ns, 1, nil
+	}
+	entry := ns[:i]
+	if len(kept) > 0 {
+		entry += "?" + strings.Join(kept, "&")
+	}
+	return entry, weight, nil
+}
+
+// sortByWeight orders nameservers by descending weight, keeping the
+// resolved order between nameservers of equal weight. It only reorders:
+// every nameserver stays in the list once.
+func sortByWeight(nameservers []string, weights map[string]int) {
+	slices.SortStableFunc(nameservers, func(a, b string) int {
+		return weights[b] - weights[a]
+	})
+}
+
+// weightedShuffle returns nameservers in a random order in which those with
+// a higher weight are proportionally more likely to come first.
+func weightedShuffle(nameservers []string, weights map[string]int, rng *rand.Rand) []string {
+	keys := make(map[string]float64, len(nameservers))
+	for _, ns := range nameservers {
+		w := max(weights[ns], 1)
+		keys[ns] = math.Pow(rng.Float64(), 1/float64(w))
+	}
+	shuffled := slices.Clone(nameservers)
+	slices.SortStableFunc(shuffled, func(a, b string) int {
+		switch {
+		case keys[a] > keys[b]:
+			return -1
+		case keys[a] < keys[b]:
+			return 1
+		}
+		return 0
+	})
+	return shuffled
+}
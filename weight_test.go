@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestParseWeight(t *testing.T) {
+	tests := []struct {
+		in         string
+		wantEntry  string
+		wantWeight int
+		wantErr    bool
+	}{
+		{in: "device:ns1", wantEntry: "device:ns1", wantWeight: 1},
+		{in: "device:ns1?weight=3", wantEntry: "device:ns1", wantWeight: 3},
+		{in: "10.0.0.53?weight=10", wantEntry: "10.0.0.53", wantWeight: 10},
+		{in: "tag:dns?max=2&weight=2", wantEntry: "tag:dns?max=2", wantWeight: 2},
+		{in: "tag:dns?weight=2&max=2", wantEntry: "tag:dns?max=2", wantWeight: 2},
+		{in: "device:ns*12", wantEntry: "device:ns*12", wantWeight: 1},
+		{in: "device:ns-*", wantEntry: "device:ns-*", wantWeight: 1},
+		{in: "device:*-dns", wantEntry: "device:*-dns", wantWeight: 1},
+		{in: "device:ns?", wantEntry: "device:ns?", wantWeight: 1},
+		{in: "device:ns1?weight=0", wantErr: true},
+		{in: "device:ns1?weight=high", wantErr: true},
+		{in: "device:ns1?weight=2&weight=3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			entry, weight, err := parseWeight(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWeight() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if entry != tt.wantEntry || weight != tt.wantWeight {
+				t.Errorf("parseWeight() = %q, %d, want %q, %d", entry, weight, tt.wantEntry, tt.wantWeight)
+			}
+		})
+	}
+}
+
+func TestResolveWeighted(t *testing.T) {
+	r := newResolver(nil, Defaults{})
+
+	splitDNS, err := r.resolveAll(context.Background(), Config{
+		"example.com": {"10.0.0.1", "10.0.0.2?weight=2", "10.0.0.3?weight=10"},
+		"other.com":   {"10.0.0.2", "10.0.0.1"},
+	})
+	if err != nil {
+		t.Fatalf("resolveAll() unexpected error: %v", err)
+	}
+
+	if want := []string{"10.0.0.3", "10.0.0.2", "10.0.0.1"}; !slices.Equal(splitDNS["example.com"], want) {
+		t.Errorf("example.com = %v, want %v", splitDNS["example.com"], want)
+	}
+	if want := []string{"10.0.0.2", "10.0.0.1"}; !slices.Equal(splitDNS["other.com"], want) {
+		t.Errorf("other.com = %v, want unweighted order %v", splitDNS["other.com"], want)
+	}
+	if got := r.weights["example.com"]["10.0.0.3"]; got != 10 {
+		t.Errorf("weight of 10.0.0.3 = %d, want 10", got)
+	}
+}
+
+func TestWeightedShuffle(t *testing.T) {
+	nameservers := []string{"backup", "primary"}
+	weights := map[string]int{"primary": 10}
+
+	first := 0
+	for i := range 1000 {
+		rng := rand.New(rand.NewPCG(uint64(i), 0))
+		if weightedShuffle(nameservers, weights, rng)[0] == "primary" {
+			first++
+		}
+	}
+	// primary should come first about 10 times in 11
+	if first < 850 || first > 970 {
+		t.Errorf("primary came first %d times in 1000, want about 909", first)
+	}
+}
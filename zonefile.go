@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ZoneFile configures a zone kept in an RFC 1035 zone file, for BIND, NSD
+// or any other nameserver that loads zones from files.
+type ZoneFile struct {
+	// Path is the file to write. tsddns owns it entirely; it is replaced
+	// atomically and only when the records change.
+	Path string `json:"path"`
+
+	// Zone is the zone's name, like "internal.example.com".
+	Zone string `json:"zone"`
+
+	// Nameservers are the host names of the zone's nameservers, for its
+	// NS records. The first is the SOA's primary nameserver.
+	Nameservers []string `json:"nameservers"`
+
+	// Email is the zone's contact address for its SOA. Defaults to
+	// hostmaster@<zone>.
+	Email string `json:"email,omitempty"`
+
+	// TTL is the records' TTL and the SOA's negative caching TTL.
+	// Defaults to 5m.
+	TTL Duration `json:"ttl,omitempty"`
+
+	// Records must be in Zone.
+	Records RecordSet `json:"records"`
+
+	// Reload is the argv to run after the file changed, like
+	// ["rndc", "reload", "internal.example.com"].
+	Reload []string `json:"reload,omitempty"`
+}
+
+func (z *ZoneFile) validate() error {
+	if z.Path == "" || z.Zone == "" {
+		return fmt.Errorf("needs a path and zone")
+	}
+	if len(z.Nameservers) == 0 {
+		return fmt.Errorf("needs at least one nameserver")
+	}
+	if z.Email != "" && !strings.Contains(z.Email, "@") {
+		return fmt.Errorf("email %q is not an email address", z.Email)
+	}
+	zone := normalizeDomain(z.Zone)
+	for name := range z.Records {
+		if n := normalizeDomain(name); n != zone && !strings.HasSuffix(n, "."+zone) {
+			return fmt.Errorf("record %s is not in zone %s", name, z.Zone)
+		}
+	}
+	return nil
+}
+
+// sync resolves the configured records and writes the zone file if
+// they changed, with the SOA serial bumped so secondaries pick it up.
+func (z *ZoneFile) sync(ctx context.Context, r *resolver, _ map[string][]string) error {
+	resolved, err := r.resolveAll(ctx, z.Records)
+	if err != nil {
+		return fmt.Errorf("resolving records: %w", err)
+	}
+	records, err := addressRecords(resolved)
+	if err != nil {
+		return err
+	}
+
+	old, err := os.ReadFile(z.Path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	serial, hasSerial := zoneSerial(old)
+	if hasSerial && bytes.Equal(old, renderZone(z, records, serial)) {
+		slog.InfoContext(ctx, "Zone file is up to date", "path", z.Path)
+		return nil
+	}
+	serial = nextSerial(serial, time.Now())
+	if err := writeFileAtomic(z.Path, renderZone(z, records, serial), 0o644); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Wrote zone file", "zone", normalizeDomain(z.Zone), "serial", serial, "path", z.Path)
+	return runReload(ctx, z.Reload)
+}
+
+// renderZone renders the zone file for records with the given SOA serial.
+func renderZone(z *ZoneFile, records []record, serial uint32) []byte {
+	zone := normalizeDomain(z.Zone)
+	ttl := int(time.Duration(cmp.Or(z.TTL, Duration(defaultRecordTTL))).Seconds())
+	email := cmp.Or(z.Email, "hostmaster@"+zone)
+	local, domain, _ := strings.Cut(email, "@")
+	rname := strings.ReplaceAll(local, ".", `\.`) + "." + normalizeDomain(domain) + "."
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "; Generated by tsddns. Do not edit.\n$ORIGIN %s.\n$TTL %d\n", zone, ttl)
+	fmt.Fprintf(&b, "@\tIN\tSOA\t%s. %s %d 3600 600 1209600 %d\n", normalizeDomain(z.Nameservers[0]), rname, serial, ttl)
+	for _, ns := range z.Nameservers {
+		fmt.Fprintf(&b, "@\tIN\tNS\t%s.\n", normalizeDomain(ns))
+	}
+	for _, rec := range records {
+		fmt.Fprintf(&b, "%s\tIN\t%s\t%s\n", relativeName(rec.Name, zone), rec.Type, rec.Value)
+	}
+	return []byte(b.String())
+}
+
+// zoneSerial returns the SOA serial of a zone file tsddns wrote, and
+// whether it has one.
+func zoneSerial(data []byte) (uint32, bool) {
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 10 && fields[2] == "SOA" {
+			serial, err := strconv.ParseUint(fields[5], 10, 32)
+			return uint32(serial), err == nil
+		}
+	}
+	return 0, false
+}
+
+// nextSerial returns the serial after old, in the YYYYMMDDnn convention:
+// the first change of a day gets that day's first serial, and later ones
+// count up from the last.
+func nextSerial(old uint32, now time.Time) uint32 {
+	y, m, d := now.UTC().Date()
+	today := uint32(y*1000000 + int(m)*10000 + d*100)
+	return max(today, old+1)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextSerial(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		old  uint32
+		want uint32
+	}{
+		{0, 2026101600},
+		{2026091503, 2026101600},
+		{2026101600, 2026101601},
+		{2026101699, 2026101700}, // runs into tomorrow rather than going back
+		{2027010100, 2027010101},
+	}
+	for _, tt := range tests {
+		if got := nextSerial(tt.old, now); got != tt.want {
+			t.Errorf("nextSerial(%d) = %d, want %d", tt.old, got, tt.want)
+		}
+	}
+}
+
+func TestRenderZone(t *testing.T) {
+	z := &ZoneFile{
+		Zone:        "Internal.Example.com.",
+		Nameservers: []string{"ns1.example.com", "ns2.example.com."},
+		Email:       "dns.admin@example.com",
+		TTL:         Duration(time.Minute),
+	}
+	records := []record{
+		{Name: "internal.example.com", Type: "A", Value: "100.64.0.1"},
+		{Name: "foo.internal.example.com", Type: "AAAA", Value: "fd7a:115c:a1e0::2"},
+	}
+	want := `; Generated by tsddns. Do not edit.
+$ORIGIN internal.example.com.
+$TTL 60
+@	IN	SOA	ns1.example.com. dns\.admin.example.com. 2026101601 3600 600 1209600 60
+@	IN	NS	ns1.example.com.
+@	IN	NS	ns2.example.com.
+@	IN	A	100.64.0.1
+foo	IN	AAAA	fd7a:115c:a1e0::2
+`
+	got := renderZone(z, records, 2026101601)
+	if string(got) != want {
+		t.Errorf("renderZone() = %q, want %q", got, want)
+	}
+	if serial, ok := zoneSerial(got); !ok || serial != 2026101601 {
+		t.Errorf("zoneSerial() = %d, %v, want 2026101601", serial, ok)
+	}
+}
+
+func TestSyncZoneFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "internal.example.com.zone")
+	z := &ZoneFile{
+		Path:        path,
+		Zone:        "internal.example.com",
+		Nameservers: []string{"ns1.example.com"},
+		Records:     Config{"foo.internal.example.com": {"100.64.0.1"}},
+	}
+	sync := func() uint32 {
+		t.Helper()
+		if err := z.sync(context.Background(), newResolver(nil, Defaults{}), nil); err != nil {
+			t.Fatalf("sync() unexpected error: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serial, ok := zoneSerial(data)
+		if !ok {
+			t.Fatalf("zone file has no serial: %q", data)
+		}
+		return serial
+	}
+
+	first := sync()
+	if got := sync(); got != first {
+		t.Errorf("serial changed from %d to %d although the records didn't", first, got)
+	}
+	z.Records["bar.internal.example.com"] = []string{"100.64.0.2"}
+	if got := sync(); got != first+1 {
+		t.Errorf("serial = %d after a change, want %d", got, first+1)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "bar\tIN\tA\t100.64.0.2\n") {
+		t.Errorf("zone file is missing the new record: %q", data)
+	}
+}